@@ -0,0 +1,82 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package audit writes a record of every bpm command to an append-only log
+// which is shared by all of the jobs on a machine.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The results a command can have.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Entry is the record of one bpm command.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	DurationMS int64     `json:"duration_ms"`
+	PID        int       `json:"pid"`
+
+	// UID and User are the user who ran bpm. SudoUser is the user who ran
+	// sudo to become them, if they did.
+	UID      int    `json:"uid"`
+	User     string `json:"user,omitempty"`
+	SudoUser string `json:"sudo_user,omitempty"`
+
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Job     string   `json:"job,omitempty"`
+	Process string   `json:"process,omitempty"`
+
+	Result     string `json:"result"`
+	ExitStatus int    `json:"exit_status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Append writes the entry to the end of the log at path as a line of JSON,
+// creating the log if it does not exist. The log is locked while the entry is
+// written so that the entries of commands which finish at the same time are
+// not interleaved.
+func Append(path string, entry Entry) error {
+	var line bytes.Buffer
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entry); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+	defer unix.Flock(int(f.Fd()), unix.LOCK_UN)
+
+	_, err = f.Write(line.Bytes())
+	return err
+}
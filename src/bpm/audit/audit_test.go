@@ -0,0 +1,143 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package audit_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/audit"
+)
+
+var _ = Describe("Append", func() {
+	var (
+		tmpdir  string
+		logPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+
+		logPath = filepath.Join(tmpdir, "audit.log")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpdir)).To(Succeed())
+	})
+
+	readEntries := func() []audit.Entry {
+		data, err := ioutil.ReadFile(logPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		var entries []audit.Entry
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			var entry audit.Entry
+			Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+			entries = append(entries, entry)
+		}
+
+		return entries
+	}
+
+	It("writes each entry as a line of JSON", func() {
+		when := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+		Expect(audit.Append(logPath, audit.Entry{
+			Time:    when,
+			UID:     0,
+			User:    "root",
+			Command: "bpm start",
+			Args:    []string{"start", "web"},
+			Job:     "web",
+			Process: "web",
+			Result:  audit.ResultSuccess,
+		})).To(Succeed())
+		Expect(audit.Append(logPath, audit.Entry{
+			Time:       when,
+			Command:    "bpm stop",
+			Args:       []string{"stop", "web"},
+			Result:     audit.ResultFailure,
+			ExitStatus: 1,
+			Error:      "failed to stop job-process",
+		})).To(Succeed())
+
+		entries := readEntries()
+		Expect(entries).To(HaveLen(2))
+
+		Expect(entries[0].Time).To(BeTemporally("==", when))
+		Expect(entries[0].User).To(Equal("root"))
+		Expect(entries[0].Command).To(Equal("bpm start"))
+		Expect(entries[0].Args).To(Equal([]string{"start", "web"}))
+		Expect(entries[0].Job).To(Equal("web"))
+		Expect(entries[0].Result).To(Equal(audit.ResultSuccess))
+
+		Expect(entries[1].Result).To(Equal(audit.ResultFailure))
+		Expect(entries[1].ExitStatus).To(Equal(1))
+		Expect(entries[1].Error).To(Equal("failed to stop job-process"))
+	})
+
+	It("keeps the entries which are already in the log", func() {
+		Expect(ioutil.WriteFile(logPath, []byte(`{"command":"bpm list"}`+"\n"), 0600)).To(Succeed())
+
+		Expect(audit.Append(logPath, audit.Entry{Command: "bpm start"})).To(Succeed())
+
+		entries := readEntries()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Command).To(Equal("bpm list"))
+		Expect(entries[1].Command).To(Equal("bpm start"))
+	})
+
+	It("creates the log so that only its owner can read it", func() {
+		Expect(audit.Append(logPath, audit.Entry{Command: "bpm start"})).To(Succeed())
+
+		fi, err := os.Stat(logPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("does not interleave entries which are written at the same time", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				args := []string{"start", strings.Repeat("x", 4096)}
+				Expect(audit.Append(logPath, audit.Entry{Command: "bpm start", Args: args})).To(Succeed())
+			}()
+		}
+		wg.Wait()
+
+		Expect(readEntries()).To(HaveLen(20))
+	})
+
+	Context("when the directory of the log does not exist", func() {
+		It("returns an error", func() {
+			err := audit.Append(filepath.Join(tmpdir, "missing", "audit.log"), audit.Entry{})
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
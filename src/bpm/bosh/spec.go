@@ -0,0 +1,47 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bosh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// InstanceSpec is the part of the spec which the BOSH agent writes to
+// /var/vcap/bosh/spec.json that says which instance a machine is.
+type InstanceSpec struct {
+	Deployment    string `json:"deployment"`
+	InstanceGroup string `json:"name"`
+	AZ            string `json:"az"`
+	Index         *int   `json:"index"`
+}
+
+// ReadInstanceSpec reads the instance spec from the BOSH spec file at path.
+// Any other fields in the file are ignored.
+func ReadInstanceSpec(path string) (*InstanceSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec InstanceSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("invalid BOSH spec %s: %s", path, err)
+	}
+
+	return &spec, nil
+}
@@ -0,0 +1,94 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package bosh_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/bosh"
+)
+
+var _ = Describe("ReadInstanceSpec", func() {
+	var (
+		dir      string
+		specPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bosh-spec")
+		Expect(err).NotTo(HaveOccurred())
+
+		specPath = filepath.Join(dir, "spec.json")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("reads the instance from the spec", func() {
+		contents := `{
+			"deployment": "cf",
+			"name": "router",
+			"az": "z1",
+			"index": 0,
+			"id": "6e1b9c6c-1d51-4bf2-9a5f-2d3e3b3c0f7a",
+			"job": {"name": "router"}
+		}`
+		Expect(ioutil.WriteFile(specPath, []byte(contents), 0644)).To(Succeed())
+
+		spec, err := bosh.ReadInstanceSpec(specPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(spec.Deployment).To(Equal("cf"))
+		Expect(spec.InstanceGroup).To(Equal("router"))
+		Expect(spec.AZ).To(Equal("z1"))
+		Expect(spec.Index).NotTo(BeNil())
+		Expect(*spec.Index).To(Equal(0))
+	})
+
+	It("leaves out the fields which the spec does not have", func() {
+		Expect(ioutil.WriteFile(specPath, []byte(`{"deployment": "cf"}`), 0644)).To(Succeed())
+
+		spec, err := bosh.ReadInstanceSpec(specPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(spec.Deployment).To(Equal("cf"))
+		Expect(spec.AZ).To(BeEmpty())
+		Expect(spec.Index).To(BeNil())
+	})
+
+	Context("when the spec file does not exist", func() {
+		It("returns an error which says so", func() {
+			_, err := bosh.ReadInstanceSpec(specPath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when the spec file is not JSON", func() {
+		It("returns an error", func() {
+			Expect(ioutil.WriteFile(specPath, []byte("deployment: cf"), 0644)).To(Succeed())
+
+			_, err := bosh.ReadInstanceSpec(specPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid BOSH spec")))
+		})
+	})
+})
@@ -24,7 +24,7 @@ import (
 )
 
 func main() {
-	if err := commands.RootCmd.Execute(); err != nil {
+	if err := commands.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
 		os.Exit(exitstatus.FromError(err))
 	}
@@ -0,0 +1,115 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"bpm/audit"
+	"bpm/config"
+	"bpm/exitstatus"
+)
+
+// Execute runs the bpm command given on the command line. If BPM_AUDIT_LOG
+// is set an entry recording who ran the command, what it was and how it
+// turned out is appended to the file it names once the command is done.
+func Execute() error {
+	started := time.Now()
+	cmd, err := RootCmd.ExecuteC()
+
+	path := os.Getenv("BPM_AUDIT_LOG")
+	if path == "" {
+		return err
+	}
+
+	entry := audit.Entry{
+		Time:       started.UTC(),
+		DurationMS: int64(time.Since(started) / time.Millisecond),
+		PID:        os.Getpid(),
+		UID:        os.Getuid(),
+		SudoUser:   os.Getenv("SUDO_USER"),
+		Command:    RootCmd.Name(),
+		Args:       auditArgs(os.Args[1:]),
+		Result:     audit.ResultSuccess,
+	}
+
+	if cmd != nil {
+		entry.Command = cmd.CommandPath()
+	}
+
+	if usr, uerr := user.LookupId(strconv.Itoa(entry.UID)); uerr == nil {
+		entry.User = usr.Username
+	}
+
+	// stop --all works through the processes of every job in turn so it is
+	// not credited to any one of them.
+	if bpmCfg != nil && !stopAll {
+		entry.Job = bpmCfg.JobName()
+		entry.Process = bpmCfg.ProcName()
+	}
+
+	if err != nil {
+		entry.Result = audit.ResultFailure
+		entry.ExitStatus = exitstatus.FromError(err)
+		entry.Error = err.Error()
+	}
+
+	// The command has already happened so failing to record it is only
+	// reported rather than changing its outcome.
+	if aerr := audit.Append(path, entry); aerr != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write to audit log %s: %s\n", path, aerr)
+	}
+
+	return err
+}
+
+// auditArgs returns the command line arguments with the values of the
+// environment variables given with --env redacted as they may be secrets.
+func auditArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+
+	for i := 0; i < len(redacted); i++ {
+		arg := redacted[i]
+		if arg == "--" {
+			break
+		}
+
+		switch {
+		case arg == "-e" || arg == "--env":
+			if i+1 < len(redacted) {
+				redacted[i+1] = redactEnvArg(redacted[i+1])
+				i++
+			}
+		case strings.HasPrefix(arg, "--env="):
+			redacted[i] = "--env=" + redactEnvArg(strings.TrimPrefix(arg, "--env="))
+		case strings.HasPrefix(arg, "-e") && !strings.HasPrefix(arg, "--"):
+			redacted[i] = "-e" + redactEnvArg(strings.TrimPrefix(strings.TrimPrefix(arg, "-e"), "="))
+		}
+	}
+
+	return redacted
+}
+
+func redactEnvArg(arg string) string {
+	name := strings.SplitN(arg, "=", 2)[0]
+	return name + "=" + config.RedactedValue
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"bpm/models"
+	"bpm/runc/lifecycle"
+)
+
+var imagePath string
+
+func init() {
+	checkpointCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	checkpointCommand.Flags().StringVar(&imagePath, "image-path", "", "directory to write the checkpoint to")
+	RootCmd.AddCommand(checkpointCommand)
+}
+
+var checkpointCommand = &cobra.Command{
+	Long:     "Saves the state of a running BOSH Process with CRIU and stops it. It can be resumed with 'bpm restore'.",
+	RunE:     checkpoint,
+	Short:    "checkpoints a BOSH Process",
+	Use:      "checkpoint <job-name> --image-path <dir>",
+	PreRunE:  checkpointPre,
+	PostRunE: checkpointPost,
+}
+
+func checkpointPre(cmd *cobra.Command, args []string) error {
+	if err := validateInput(args); err != nil {
+		return err
+	}
+
+	if imagePath == "" {
+		return errors.New("must specify an image path")
+	}
+
+	cmd.SilenceUsage = true
+
+	if err := setupBpmLogs("checkpoint"); err != nil {
+		return err
+	}
+
+	return acquireLifecycleLock()
+}
+
+func checkpointPost(cmd *cobra.Command, args []string) error {
+	return releaseLifecycleLock()
+}
+
+func checkpoint(cmd *cobra.Command, _ []string) error {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	jobCfg, err := bpmCfg.ParseJobConfig()
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, procName)
+	if err != nil {
+		logger.Error("process-not-defined", err)
+		return fmt.Errorf("process %q not present in job configuration (%s)", procName, bpmCfg.JobConfig())
+	}
+
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	process, err := runcLifecycle.StatProcess(bpmCfg)
+	if err != nil && !lifecycle.IsNotExist(err) {
+		return fmt.Errorf("failed to get job-process status: %s", err)
+	} else if lifecycle.IsNotExist(err) || process.Status != models.ProcessStateRunning {
+		return errors.New("process is not running or could not be found")
+	}
+
+	if err := runcLifecycle.CheckpointProcess(logger, bpmCfg, procCfg, imagePath); err != nil {
+		logger.Error("failed-to-checkpoint", err)
+		return fmt.Errorf("failed to checkpoint job-process: %s", err)
+	}
+
+	return nil
+}
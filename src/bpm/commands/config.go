@@ -0,0 +1,90 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+
+	"bpm/config"
+)
+
+var configFile string
+
+func init() {
+	configCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	configCommand.Flags().StringVarP(&configFile, "config", "c", "", "optional path to a bpm.yml to use instead of the job's")
+	RootCmd.AddCommand(configCommand)
+}
+
+var configCommand = &cobra.Command{
+	Long:    "Prints the configuration of a BOSH Process as YAML once the environment from pass_env, links and properties_file has been merged in. The env_from commands are shown but not run and nothing is started.",
+	RunE:    printConfig,
+	Short:   "prints the effective configuration of a BOSH Process",
+	Use:     "config <job-name>",
+	PreRunE: configPre,
+}
+
+func configPre(cmd *cobra.Command, args []string) error {
+	return validateInput(args)
+}
+
+func printConfig(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	jobCfg, err := parseJobConfigFile(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, procName)
+	if err != nil {
+		return fmt.Errorf("process %q not present in job configuration", procName)
+	}
+
+	procCfg.AddPassEnv(os.LookupEnv)
+
+	if err := procCfg.AddLinksEnv(); err != nil {
+		return fmt.Errorf("failed to read links: %s", err)
+	}
+
+	if err := procCfg.AddPropertiesEnv(bpmCfg.JobConfigDir().External()); err != nil {
+		return fmt.Errorf("failed to read properties: %s", err)
+	}
+
+	// The env_from commands may fetch secrets and are printed as they are
+	// declared rather than run.
+	redactSensitiveEnv(procCfg)
+
+	out, err := yaml.Marshal(procCfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = cmd.OutOrStdout().Write(out)
+	return err
+}
+
+func redactSensitiveEnv(procCfg *config.ProcessConfig) {
+	for _, name := range procCfg.SensitiveEnv {
+		if _, ok := procCfg.Env[name]; ok {
+			procCfg.Env[name] = config.RedactedValue
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+
+	"bpm/config"
+)
+
+var diffConfig string
+
+func init() {
+	diffCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	diffCommand.Flags().StringVarP(&diffConfig, "config", "c", "", "optional path to a bpm.yml to use instead of the job's")
+	RootCmd.AddCommand(diffCommand)
+}
+
+var diffCommand = &cobra.Command{
+	Long:    "Compares the configuration a BOSH Process was started with against its current configuration and fails if they differ, i.e. if the process needs to be restarted to pick up its configuration.",
+	RunE:    diffConfigs,
+	Short:   "shows how the configuration of a BOSH Process has changed since it was started",
+	Use:     "diff <job-name>",
+	PreRunE: diffPre,
+}
+
+var errConfigChanged = errors.New("the configuration has changed since the process was started")
+
+func diffPre(cmd *cobra.Command, args []string) error {
+	return validateInput(args)
+}
+
+func diffConfigs(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	startedData, err := ioutil.ReadFile(bpmCfg.StartedConfigPath())
+	if os.IsNotExist(err) {
+		return errors.New("no configuration was recorded when the process was started: it is not running or was started by an older bpm")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read started configuration: %s", err)
+	}
+
+	var started config.ProcessConfig
+	if err := yaml.Unmarshal(startedData, &started); err != nil {
+		return fmt.Errorf("failed to parse started configuration: %s", err)
+	}
+
+	jobCfg, err := parseJobConfigFile(diffConfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	current, err := processByNameFromJobConfig(jobCfg, procName)
+	if err != nil {
+		return fmt.Errorf("process %q not present in job configuration", procName)
+	}
+
+	startedYAML, err := yaml.Marshal(&started)
+	if err != nil {
+		return err
+	}
+
+	currentYAML, err := yaml.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	if string(startedYAML) == string(currentYAML) {
+		return nil
+	}
+
+	// The values of sensitive environment variables are left out of the
+	// output like they are from bpm config.
+	redactSensitiveEnv(&started)
+	redactSensitiveEnv(current)
+
+	startedYAML, err = yaml.Marshal(&started)
+	if err != nil {
+		return err
+	}
+
+	currentYAML, err = yaml.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	printLineDiff(cmd.OutOrStdout(), lines(startedYAML), lines(currentYAML))
+	if string(startedYAML) == string(currentYAML) {
+		fmt.Fprintln(cmd.OutOrStdout(), "the values of sensitive environment variables have changed")
+	}
+
+	return errConfigChanged
+}
+
+func lines(data []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// printLineDiff writes the lines which have been removed from old, prefixed
+// with a -, and added in new, prefixed with a +, in the order they appear.
+// Lines which are in both are left out.
+func printLineDiff(w io.Writer, old, new []string) {
+	fmt.Fprintln(w, "--- started")
+	fmt.Fprintln(w, "+++ current")
+
+	// common[i][j] is the length of the longest common subsequence of
+	// old[i:] and new[j:].
+	common := make([][]int, len(old)+1)
+	for i := range common {
+		common[i] = make([]int, len(new)+1)
+	}
+
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i] == new[j]:
+			i++
+			j++
+		case j == len(new) || (i < len(old) && common[i+1][j] >= common[i][j+1]):
+			fmt.Fprintf(w, "-%s\n", old[i])
+			i++
+		default:
+			fmt.Fprintf(w, "+%s\n", new[j])
+			j++
+		}
+	}
+}
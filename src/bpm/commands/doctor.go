@@ -0,0 +1,204 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"github.com/spf13/cobra"
+
+	"bpm/config"
+	"bpm/sysfeat"
+)
+
+func init() {
+	RootCmd.AddCommand(doctorCommand)
+}
+
+var doctorCommand = &cobra.Command{
+	Long: `checks whether this machine is set up correctly to run bpm
+
+  Each check is printed with PASS, WARN or FAIL. The command exits with a
+  non-zero exit code if any check fails. No containers are started.
+`,
+	RunE:  doctor,
+	Short: "checks whether this machine is set up correctly to run bpm",
+	Use:   "doctor",
+	// doctor must not change the machine it is inspecting so it skips the
+	// setup (creating directories and mounting cgroups) done by rootPre.
+	PersistentPreRunE: func(*cobra.Command, []string) error { return nil },
+}
+
+type doctorCheck struct {
+	name     string
+	critical bool
+	hint     string
+	check    func() error
+}
+
+func doctor(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	var failed int
+	for _, c := range doctorChecks() {
+		err := c.check()
+		if err == nil {
+			fmt.Fprintf(cmd.OutOrStdout(), "PASS %s\n", c.name)
+			continue
+		}
+
+		status := "WARN"
+		if c.critical {
+			status = "FAIL"
+			failed++
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "%s %s: %s\n", status, c.name, err)
+		fmt.Fprintf(cmd.OutOrStdout(), "     %s\n", c.hint)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d critical check(s) failed", failed)
+	}
+
+	return nil
+}
+
+func doctorChecks() []doctorCheck {
+	runcPath := config.RuncPath(boshEnv)
+	tiniPath := boshEnv.PackageDir().Join("bpm", "bin", "tini").External()
+	dataDir := boshEnv.Root().Join("data").External()
+	logDir := boshEnv.Root().Join("sys", "log").External()
+	runDir := boshEnv.Root().Join("sys", "run").External()
+
+	return []doctorCheck{
+		{
+			name:     "running as root",
+			critical: true,
+			hint:     "bpm must be run as root. Please run 'sudo -i' to become the root user.",
+			check:    checkRoot,
+		},
+		{
+			name:     "runc is installed",
+			critical: true,
+			hint:     fmt.Sprintf("ensure the bpm release is deployed to this machine so that runc is available at %s", runcPath),
+			check:    func() error { return checkExecutable(runcPath) },
+		},
+		{
+			name:     "tini is installed",
+			critical: true,
+			hint:     fmt.Sprintf("ensure the bpm release is deployed to this machine so that tini is available at %s", tiniPath),
+			check:    func() error { return checkExecutable(tiniPath) },
+		},
+		{
+			name:     "data directory is writable",
+			critical: true,
+			hint:     fmt.Sprintf("ensure the ephemeral disk is mounted at %s and is writable by root", dataDir),
+			check:    func() error { return checkWritableDir(dataDir) },
+		},
+		{
+			name:     "log directory is writable",
+			critical: true,
+			hint:     fmt.Sprintf("ensure %s exists and is writable by root", logDir),
+			check:    func() error { return checkWritableDir(logDir) },
+		},
+		{
+			name:     "run directory is writable",
+			critical: true,
+			hint:     fmt.Sprintf("ensure %s exists and is writable by root", runDir),
+			check:    func() error { return checkWritableDir(runDir) },
+		},
+		{
+			name:     "memory cgroup is mounted",
+			critical: true,
+			hint:     "bpm mounts the cgroup hierarchy itself unless systemd manages it; check the mounts under /sys/fs/cgroup",
+			check:    checkMemoryCgroup,
+		},
+		{
+			name:     "swap accounting is enabled",
+			critical: false,
+			hint:     "memory_swap limits cannot be used; boot the kernel with swapaccount=1 to enable them",
+			check:    checkSwapAccounting,
+		},
+	}
+}
+
+func checkRoot() error {
+	usr, err := user.Current()
+	if err != nil {
+		return err
+	}
+
+	if usr.Uid != "0" {
+		return fmt.Errorf("running as uid %s", usr.Uid)
+	}
+
+	return nil
+}
+
+func checkExecutable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() || fi.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not an executable file", path)
+	}
+
+	return nil
+}
+
+func checkWritableDir(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+
+	f, err := ioutil.TempFile(path, ".bpm-doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+func checkMemoryCgroup() error {
+	_, err := sysfeat.Fetch()
+	return err
+}
+
+func checkSwapAccounting() error {
+	features, err := sysfeat.Fetch()
+	if err != nil {
+		return err
+	}
+
+	if !features.SwapLimitSupported {
+		return errors.New("the kernel does not support limiting swap")
+	}
+
+	return nil
+}
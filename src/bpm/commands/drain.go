@@ -0,0 +1,105 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"bpm/models"
+	"bpm/runc/lifecycle"
+)
+
+var drainConfig string
+
+func init() {
+	drainCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	drainCommand.Flags().StringVarP(&drainConfig, "config", "c", "", "optional path to a bpm.yml to use instead of the job's")
+	RootCmd.AddCommand(drainCommand)
+}
+
+var drainCommand = &cobra.Command{
+	Long:     "Runs the drain hook of a BOSH Process inside its container and waits for the drain to complete without stopping the process. It prints 0 once drained so that it can be used as the drain script of a job.",
+	RunE:     drain,
+	Short:    "drains a BOSH Process",
+	Use:      "drain <job-name>",
+	PreRunE:  drainPre,
+	PostRunE: drainPost,
+}
+
+func drainPre(cmd *cobra.Command, args []string) error {
+	if err := validateInput(args); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	if err := setupBpmLogs("drain"); err != nil {
+		return err
+	}
+
+	return acquireLifecycleLock()
+}
+
+func drainPost(cmd *cobra.Command, args []string) error {
+	return releaseLifecycleLock()
+}
+
+func drain(cmd *cobra.Command, _ []string) error {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	jobCfg, err := parseJobConfigFile(drainConfig)
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, procName)
+	if err != nil {
+		logger.Error("process-not-defined", err)
+		return fmt.Errorf("process %q not present in job configuration (%s)", procName, bpmCfg.JobConfig())
+	}
+
+	if procCfg.Hooks == nil || procCfg.Hooks.Drain == "" {
+		return fmt.Errorf("process %q has no drain hook", procCfg.Name)
+	}
+
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	process, err := runcLifecycle.StatProcess(bpmCfg)
+	if err != nil && !lifecycle.IsNotExist(err) {
+		return fmt.Errorf("failed to get job-process status: %s", err)
+	} else if lifecycle.IsNotExist(err) || process.Status != models.ProcessStateRunning {
+		return errors.New("process is not running or could not be found")
+	}
+
+	if err := runcLifecycle.DrainProcess(logger, bpmCfg, procCfg.Hooks, cmd.ErrOrStderr()); err != nil {
+		logger.Error("failed-to-drain", err)
+		return fmt.Errorf("failed to drain job-process: %s", err)
+	}
+
+	// BOSH reads the number of seconds left to wait from the output of a
+	// drain script. There are none left.
+	fmt.Fprintln(cmd.OutOrStdout(), 0)
+
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	ensureStartedCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	RootCmd.AddCommand(ensureStartedCommand)
+}
+
+// ensureStartedCommand shares its implementation with start, which leaves
+// running processes alone. It exists so that automation can rely on that
+// behavior explicitly rather than checking the process state first.
+var ensureStartedCommand = &cobra.Command{
+	RunE:     start,
+	Short:    "starts a BOSH Process if it is not already running",
+	Use:      "ensure-started <job-name>",
+	PreRunE:  ensureStartedPre,
+	PostRunE: startPost,
+}
+
+func ensureStartedPre(cmd *cobra.Command, args []string) error {
+	if err := validateInput(args); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	if err := setupBpmLogs("ensure-started"); err != nil {
+		return err
+	}
+
+	return acquireLifecycleLock()
+}
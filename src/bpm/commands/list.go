@@ -16,8 +16,12 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"text/template"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -25,23 +29,109 @@ import (
 	"bpm/jobid"
 	"bpm/models"
 	"bpm/presenters"
+	"bpm/runc/lifecycle"
+)
+
+// DefaultStateRetries is the number of times list and pid retry a failed
+// query of the container state before giving up.
+const DefaultStateRetries = 3
+
+// DefaultListWatchInterval is how often list --watch redraws the table when
+// no interval is given.
+const DefaultListWatchInterval = 2 * time.Second
+
+// clearScreen moves the cursor to the top left of the terminal and clears
+// it so that each redraw of list --watch replaces the last one.
+const clearScreen = "\033[H\033[2J"
+
+var (
+	listJSON     bool
+	listJob      string
+	listProcess  string
+	listTemplate string
+	listWatch    time.Duration
+
+	// listTmpl is listTemplate once it has been parsed by listPre.
+	listTmpl *template.Template
 )
 
 func init() {
+	listCommandCommand.Flags().BoolVar(&listJSON, "json", false, "print the state of the processes as JSON")
+	listCommandCommand.Flags().StringVar(&listJob, "job", "", "only list the processes of this job")
+	listCommandCommand.Flags().StringVar(&listProcess, "process", "", "only list processes with this name")
+	listCommandCommand.Flags().StringVar(&listTemplate, "template", "", "print each process with this Go template, e.g. '{{.Name}}={{.Pid}}'")
+	listCommandCommand.Flags().IntVar(&stateRetries, "retries", DefaultStateRetries, "number of times to retry a failed process state query")
+	listCommandCommand.Flags().DurationVar(&listWatch, "watch", 0, "redraw the list at this interval until interrupted")
+	listCommandCommand.Flags().Lookup("watch").NoOptDefVal = DefaultListWatchInterval.String()
 	RootCmd.AddCommand(listCommandCommand)
 }
 
 var listCommandCommand = &cobra.Command{
-	RunE:  listContainers,
-	Short: "list the state of bpm containers",
-	Use:   "list",
+	RunE:    listContainers,
+	Short:   "list the state of bpm containers",
+	Use:     "list",
+	PreRunE: listPre,
+}
+
+func listPre(cmd *cobra.Command, _ []string) error {
+	if cmd.Flags().Changed("watch") && listWatch <= 0 {
+		return fmt.Errorf("invalid watch interval: %s must be positive", listWatch)
+	}
+
+	if listTemplate != "" {
+		if listJSON {
+			return errors.New("--template cannot be used with --json")
+		}
+
+		tmpl, err := template.New("list").Parse(listTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid template: %s", err)
+		}
+		listTmpl = tmpl
+	}
+
+	return nil
 }
 
 func listContainers(cmd *cobra.Command, _ []string) error {
 	cmd.SilenceUsage = true
 
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	if listWatch == 0 {
+		return printProcesses(cmd.OutOrStdout(), cmd.OutOrStderr(), runcLifecycle)
+	}
+
+	done := closeOnDetachSignal()
+	ticker := time.NewTicker(listWatch)
+	defer ticker.Stop()
+
+	for {
+		// A failed redraw has already been reported and the next one may
+		// well succeed so the watch carries on regardless.
+		fmt.Fprint(cmd.OutOrStdout(), clearScreen)
+		_ = printProcesses(cmd.OutOrStdout(), cmd.OutOrStderr(), runcLifecycle)
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// printProcesses prints the state of every process which bpm knows about,
+// or of those of the job and process passed to list.
+func printProcesses(stdout, stderr io.Writer, runcLifecycle *lifecycle.RuncLifecycle) error {
 	processes := []*models.Process{}
 	for _, job := range boshEnv.JobNames() {
+		if listJob != "" && job != listJob {
+			continue
+		}
+
 		bpmCfg := config.NewBPMConfig(boshEnv, job, "")
 		jobCfg, err := bpmCfg.ParseJobConfig()
 		if os.IsNotExist(err) {
@@ -49,11 +139,15 @@ func listContainers(cmd *cobra.Command, _ []string) error {
 		}
 
 		if err != nil {
-			fmt.Fprintf(cmd.OutOrStderr(), "invalid config for %s: %s", job, err.Error())
+			fmt.Fprintf(stderr, "invalid config for %s: %s", job, err.Error())
 			continue
 		}
 
 		for _, process := range jobCfg.Processes {
+			if listProcess != "" && process.Name != listProcess {
+				continue
+			}
+
 			procCfg := config.NewBPMConfig(boshEnv, job, process.Name)
 			processes = append(processes, &models.Process{
 				Name:   procCfg.ContainerID(),
@@ -62,32 +156,51 @@ func listContainers(cmd *cobra.Command, _ []string) error {
 		}
 	}
 
-	runcLifecycle, err := newRuncLifecycle()
+	runningProcesses, err := runcLifecycle.ListProcessesWithRetries(stateRetries)
 	if err != nil {
-		return err
-	}
-	runningProcesses, err := runcLifecycle.ListProcesses()
-	if err != nil {
-		fmt.Fprintf(cmd.OutOrStderr(), "failed to list jobs: %s\n", err.Error())
+		fmt.Fprintf(stderr, "failed to list jobs: %s\n", err.Error())
 		return err
 	}
 
+	filtered := listJob != "" || listProcess != ""
 	for _, process := range runningProcesses {
+		// Running processes which are not part of the filtered jobs cannot
+		// be told apart from extra processes so they are left out entirely.
+		if filtered && !containsProcess(processes, process.Name) {
+			continue
+		}
+
 		processes, err = updateProcess(processes, process)
 		if err != nil {
-			fmt.Fprintf(cmd.OutOrStderr(), "extra process running: %s", err.Error())
+			fmt.Fprintf(stderr, "extra process running: %s", err.Error())
 		}
 	}
 
-	err = presenters.PrintJobs(processes, cmd.OutOrStdout())
+	if listJSON {
+		err = presenters.PrintJobsJSON(processes, stdout)
+	} else if listTmpl != nil {
+		err = presenters.PrintJobsTemplate(processes, listTmpl, stdout)
+	} else {
+		err = presenters.PrintJobs(processes, stdout)
+	}
 	if err != nil {
-		fmt.Fprintf(cmd.OutOrStderr(), "failed to display jobs: %s\n", err.Error())
+		fmt.Fprintf(stderr, "failed to display jobs: %s\n", err.Error())
 		return err
 	}
 
 	return nil
 }
 
+func containsProcess(processes []*models.Process, name string) bool {
+	for _, process := range processes {
+		if process.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 func updateProcess(processes []*models.Process, process *models.Process) ([]*models.Process, error) {
 	for i := range processes {
 		if processes[i].Name == process.Name {
@@ -96,9 +209,10 @@ func updateProcess(processes []*models.Process, process *models.Process) ([]*mod
 		}
 	}
 
-	decodedName, err := jobid.Decode(process.Name)
+	// A container given its own ID with --container-id is reported by it.
+	name, err := jobid.Decode(process.Name)
 	if err != nil {
-		return processes, err
+		name = process.Name
 	}
-	return processes, fmt.Errorf("process (%s) not defined", decodedName)
+	return processes, fmt.Errorf("process (%s) not defined", name)
 }
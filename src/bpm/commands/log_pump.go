@@ -0,0 +1,126 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"bpm/logpump"
+	"bpm/runc/lifecycle"
+)
+
+var (
+	logPumpLineBuffered bool
+	logPumpFifo         bool
+	logPumpPrefix       string
+	logPumpFilter       string
+	logPumpRotateSize   int64
+	logPumpRotateKeep   int
+	logPumpStdoutPath   string
+	logPumpStderrPath   string
+)
+
+func init() {
+	logPumpCommand.Flags().BoolVar(&logPumpLineBuffered, "line-buffered", false, "only write whole lines to the log files")
+	logPumpCommand.Flags().BoolVar(&logPumpFifo, "fifo", false, "the log files are named pipes which must never block")
+	logPumpCommand.Flags().StringVar(&logPumpPrefix, "prefix", "", "text to write at the start of every line")
+	logPumpCommand.Flags().StringVar(&logPumpFilter, "filter", "", "a command to pass the output through before it is written")
+	logPumpCommand.Flags().Int64Var(&logPumpRotateSize, "rotate-size", 0, "rotate the log files once they would grow past this many bytes")
+	logPumpCommand.Flags().IntVar(&logPumpRotateKeep, "rotate-keep", 0, "the number of rotated log files to keep")
+	logPumpCommand.Flags().StringVar(&logPumpStdoutPath, "stdout-log", "stdout-log", "the path of the stdout log file")
+	logPumpCommand.Flags().StringVar(&logPumpStderrPath, "stderr-log", "stderr-log", "the path of the stderr log file")
+	RootCmd.AddCommand(logPumpCommand)
+}
+
+var logPumpCommand = &cobra.Command{
+	Hidden: true,
+	RunE:   logPump,
+	Short:  "copies the output of a detached process into its log files",
+	Use:    lifecycle.LogPumpCommand,
+	// The log pump is started by bpm itself with the pipes and log files
+	// already open so it needs none of the setup done by rootPre.
+	PersistentPreRunE: func(*cobra.Command, []string) error { return nil },
+}
+
+func logPump(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	stdoutPipe, stderrPipe := os.NewFile(3, "stdout-pipe"), os.NewFile(4, "stderr-pipe")
+	stdoutFile, stderrFile := os.NewFile(5, logPumpStdoutPath), os.NewFile(6, logPumpStderrPath)
+
+	var stdoutLog, stderrLog io.Writer = stdoutFile, stderrFile
+	if logPumpFifo {
+		stdoutFifo, err := logpump.NewFifoWriter(stdoutFile)
+		if err != nil {
+			return err
+		}
+		stderrFifo, err := logpump.NewFifoWriter(stderrFile)
+		if err != nil {
+			return err
+		}
+		stdoutLog, stderrLog = stdoutFifo, stderrFifo
+	}
+
+	if logPumpRotateSize > 0 {
+		stdoutRotating, err := logpump.NewRotatingWriter(stdoutFile, logPumpRotateSize, logPumpRotateKeep)
+		if err != nil {
+			return err
+		}
+		stderrRotating, err := logpump.NewRotatingWriter(stderrFile, logPumpRotateSize, logPumpRotateKeep)
+		if err != nil {
+			return err
+		}
+		stdoutLog, stderrLog = stdoutRotating, stderrRotating
+	}
+
+	if logPumpPrefix != "" {
+		stdoutLog, stderrLog = logpump.NewPrefixWriter(stdoutLog, logPumpPrefix), logpump.NewPrefixWriter(stderrLog, logPumpPrefix)
+	}
+
+	// The output is filtered before the prefix is added so that the filter
+	// sees it as the process wrote it.
+	if logPumpFilter != "" {
+		stdoutFilter, err := logpump.NewFilterWriter(stdoutLog, logPumpFilter)
+		if err != nil {
+			return err
+		}
+		defer stdoutFilter.Close()
+
+		stderrFilter, err := logpump.NewFilterWriter(stderrLog, logPumpFilter)
+		if err != nil {
+			return err
+		}
+		defer stderrFilter.Close()
+
+		stdoutLog, stderrLog = stdoutFilter, stderrFilter
+	}
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- logpump.Pump(stdoutLog, stdoutPipe, logPumpLineBuffered) }()
+	go func() { errCh <- logpump.Pump(stderrLog, stderrPipe, logPumpLineBuffered) }()
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if e := <-errCh; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
@@ -23,6 +23,8 @@ import (
 	"os/signal"
 
 	"github.com/spf13/cobra"
+
+	"bpm/config"
 )
 
 var (
@@ -62,12 +64,14 @@ func logsForJob(cmd *cobra.Command, _ []string) error {
 	var filesToTail []string
 	var tailArgs []string
 
+	stdout, stderr := logFiles()
+
 	if shouldTailStdout() {
-		filesToTail = append(filesToTail, bpmCfg.Stdout().External())
+		filesToTail = append(filesToTail, stdout)
 	}
 
 	if shouldTailStderr() {
-		filesToTail = append(filesToTail, bpmCfg.Stderr().External())
+		filesToTail = append(filesToTail, stderr)
 	}
 
 	if logsDontExist(filesToTail) {
@@ -108,7 +112,7 @@ func logsForJob(cmd *cobra.Command, _ []string) error {
 		case sig := <-signals: // Forward signal received by parent to child
 			tailCmd.Process.Signal(sig)
 		case err := <-errCh: // Signal parent when child dies
-			if err != nil && err.Error() != "signal: interrupt" {
+			if err != nil && !killedByDetachSignal(err) {
 				return err
 			}
 
@@ -118,6 +122,20 @@ func logsForJob(cmd *cobra.Command, _ []string) error {
 	}
 }
 
+// logFiles returns the paths of the stdout and stderr log files of the
+// process. The logs of a process whose configuration can no longer be read
+// are looked for under their default names.
+func logFiles() (string, string) {
+	procCfg := &config.ProcessConfig{}
+	if jobCfg, err := bpmCfg.ParseJobConfig(); err == nil {
+		if p, err := processByNameFromJobConfig(jobCfg, bpmCfg.ProcName()); err == nil {
+			procCfg = p
+		}
+	}
+
+	return bpmCfg.StdoutLog(procCfg).External(), bpmCfg.StderrLog(procCfg).External()
+}
+
 func shouldTailStdout() bool {
 	return !errLogs || allLogs
 }
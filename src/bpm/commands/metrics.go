@@ -0,0 +1,123 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"bpm/config"
+	"bpm/models"
+	"bpm/presenters"
+	"bpm/runc/lifecycle"
+)
+
+func init() {
+	RootCmd.AddCommand(metricsCommand)
+}
+
+var metricsCommand = &cobra.Command{
+	Long:  "Prints the resource usage of every process of every job in the OpenMetrics text format, labelled with the job, process and replica, e.g. for the textfile collector of a Prometheus node exporter.",
+	RunE:  metrics,
+	Short: "prints the resource usage of bpm processes as OpenMetrics",
+	Use:   "metrics",
+}
+
+func metrics(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	processes, err := sampleMetrics(runcLifecycle, cmd.OutOrStderr())
+	if err != nil {
+		return err
+	}
+
+	return presenters.PrintMetrics(processes, cmd.OutOrStdout())
+}
+
+// runningProcess names a process by its job and process.
+type runningProcess struct {
+	job     string
+	process string
+}
+
+// sampleMetrics gathers the usage of every process which is configured in a
+// job. A process without a running container is exported as not up. Running
+// containers are matched to their process through their annotations so that
+// one given a custom id is found too. Those which do not belong to any
+// configured process are left out as they cannot be labelled.
+func sampleMetrics(runcLifecycle *lifecycle.RuncLifecycle, stderr io.Writer) ([]presenters.MetricsProcess, error) {
+	runningProcesses, err := runcLifecycle.ListProcessesWithRetries(DefaultStateRetries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %s", err)
+	}
+
+	running := map[runningProcess]string{}
+	for _, process := range runningProcesses {
+		if process.Status != models.ProcessStateRunning {
+			continue
+		}
+
+		if cfg := runningProcessConfig(process); cfg != nil {
+			running[runningProcess{job: cfg.JobName(), process: cfg.ProcName()}] = process.Name
+		}
+	}
+
+	var processes []presenters.MetricsProcess
+	for _, job := range boshEnv.JobNames() {
+		jobCfg, err := config.NewBPMConfig(boshEnv, job, "").ParseJobConfig()
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid config for %s: %s\n", job, err)
+			continue
+		}
+
+		for _, procCfg := range jobCfg.Processes {
+			mp := presenters.MetricsProcess{Job: job, Process: procCfg.Name}
+			if procCfg.ReplicaOf != "" {
+				index := procCfg.ReplicaIndex
+				mp.Process = procCfg.ReplicaOf
+				mp.Replica = &index
+			}
+
+			if containerID, ok := running[runningProcess{job: job, process: procCfg.Name}]; ok {
+				// A container which has stopped since it was listed is
+				// exported as not up.
+				if stats, err := runcLifecycle.ProcessStats(containerID); err == nil {
+					mp.Running = true
+					mp.Memory = stats.Memory.Usage
+					mp.MemoryLimit = stats.Memory.Limit
+					mp.CPUTotal = stats.CPUTotal
+					mp.Pids = stats.Pids
+				}
+			}
+
+			processes = append(processes, mp)
+		}
+	}
+
+	return processes, nil
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"bpm/config"
+)
+
+var pathsConfig string
+
+func init() {
+	pathsCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	pathsCommand.Flags().StringVarP(&pathsConfig, "config", "c", "", "optional path to a bpm.yml to use instead of the job's")
+	RootCmd.AddCommand(pathsCommand)
+}
+
+var pathsCommand = &cobra.Command{
+	RunE:    paths,
+	Short:   "prints the paths bpm uses for a BOSH Process as JSON",
+	Use:     "paths <job-name>",
+	PreRunE: pathsPre,
+}
+
+type processPaths struct {
+	Bundle    string   `json:"bundle"`
+	RootFS    string   `json:"rootfs"`
+	PidFile   string   `json:"pid_file"`
+	LockFile  string   `json:"lock_file"`
+	LogDir    string   `json:"log_dir"`
+	Stdout    string   `json:"stdout"`
+	Stderr    string   `json:"stderr"`
+	BPMLog    string   `json:"bpm_log"`
+	SocketDir string   `json:"socket_dir"`
+	TempDir   string   `json:"tmp_dir"`
+	DataDir   string   `json:"data_dir,omitempty"`
+	StoreDir  string   `json:"store_dir,omitempty"`
+	Volumes   []string `json:"volumes,omitempty"`
+}
+
+func pathsPre(cmd *cobra.Command, args []string) error {
+	return validateInput(args)
+}
+
+func paths(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	jobCfg, err := parseJobConfigFile(pathsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, procName)
+	if err != nil {
+		return fmt.Errorf("process %q not present in job configuration", procName)
+	}
+
+	p := processPaths{
+		Bundle:    bpmCfg.BundlePath(),
+		RootFS:    bpmCfg.RootFSPath(),
+		PidFile:   bpmCfg.PidFile().External(),
+		LockFile:  bpmCfg.LockFile().External(),
+		LogDir:    bpmCfg.LogDir().External(),
+		Stdout:    bpmCfg.StdoutLog(procCfg).External(),
+		Stderr:    bpmCfg.StderrLog(procCfg).External(),
+		BPMLog:    bpmCfg.BPMLog(),
+		SocketDir: bpmCfg.SocketDir().External(),
+		TempDir:   bpmCfg.TempDir().External(),
+	}
+
+	if procCfg.RootFS != "" {
+		p.RootFS = procCfg.RootFS
+	}
+
+	if procCfg.EphemeralDisk {
+		p.DataDir = bpmCfg.DataDir().External()
+	}
+
+	if procCfg.PersistentDisk.Enabled() {
+		p.StoreDir = bpmCfg.StoreDir().External()
+	}
+
+	for _, vol := range procCfg.AdditionalVolumes {
+		p.Volumes = append(p.Volumes, vol.Path)
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// parseJobConfigFile parses and validates the bpm.yml at path as if it were
+// the configuration of the current job. If path is empty the job's own
+// configuration is used.
+func parseJobConfigFile(path string) (*config.JobConfig, error) {
+	if path == "" {
+		return bpmCfg.ParseJobConfig()
+	}
+
+	jobCfg, err := config.ParseJobConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jobCfg.Validate(boshEnv, bpmCfg.DefaultVolumes()); err != nil {
+		return nil, err
+	}
+
+	return jobCfg, nil
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"bpm/models"
+	"bpm/runc/lifecycle"
+)
+
+func init() {
+	pauseCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	RootCmd.AddCommand(pauseCommand)
+}
+
+var pauseCommand = &cobra.Command{
+	Long:     "Freezes a running BOSH Process without stopping it. It can be continued with 'bpm resume'.",
+	RunE:     pause,
+	Short:    "pauses a BOSH Process",
+	Use:      "pause <job-name>",
+	PreRunE:  pausePre,
+	PostRunE: pausePost,
+}
+
+func pausePre(cmd *cobra.Command, args []string) error {
+	if err := validateInput(args); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	if err := setupBpmLogs("pause"); err != nil {
+		return err
+	}
+
+	return acquireLifecycleLock()
+}
+
+func pausePost(cmd *cobra.Command, args []string) error {
+	return releaseLifecycleLock()
+}
+
+func pause(cmd *cobra.Command, _ []string) error {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	jobCfg, err := bpmCfg.ParseJobConfig()
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	if _, err := processByNameFromJobConfig(jobCfg, procName); err != nil {
+		logger.Error("process-not-defined", err)
+		return fmt.Errorf("process %q not present in job configuration (%s)", procName, bpmCfg.JobConfig())
+	}
+
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	process, err := runcLifecycle.StatProcess(bpmCfg)
+	if err != nil && !lifecycle.IsNotExist(err) {
+		return fmt.Errorf("failed to get job-process status: %s", err)
+	} else if lifecycle.IsNotExist(err) || process.Status != models.ProcessStateRunning {
+		return errors.New("process is not running or could not be found")
+	}
+
+	if err := runcLifecycle.PauseProcess(logger, bpmCfg); err != nil {
+		logger.Error("failed-to-pause", err)
+		return fmt.Errorf("failed to pause job-process: %s", err)
+	}
+
+	return nil
+}
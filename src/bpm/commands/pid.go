@@ -25,8 +25,13 @@ import (
 	"bpm/runc/lifecycle"
 )
 
+var pidStatus bool
+
 func init() {
 	pidCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	pidCommand.Flags().BoolVar(&pidStatus, "status", false, "also print the status of the process")
+	addContainerIDFlag(pidCommand)
+	pidCommand.Flags().IntVar(&stateRetries, "retries", DefaultStateRetries, "number of times to retry a failed process state query")
 	RootCmd.AddCommand(pidCommand)
 }
 
@@ -48,13 +53,18 @@ func pidForJob(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
-	process, err := runcLifecycle.StatProcess(bpmCfg)
+	process, err := runcLifecycle.StatProcessWithRetries(bpmCfg, stateRetries)
 	if err != nil && !lifecycle.IsNotExist(err) {
 		return fmt.Errorf("failed to get job: %s", err)
 	} else if lifecycle.IsNotExist(err) || process.Status == models.ProcessStateFailed {
 		return errors.New("process is not running or could not be found")
 	}
 
+	if pidStatus {
+		fmt.Fprintf(cmd.OutOrStdout(), "%d %s\n", process.Pid, process.Status)
+		return nil
+	}
+
 	fmt.Fprintf(cmd.OutOrStdout(), "%d\n", process.Pid)
 
 	return nil
@@ -0,0 +1,114 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"bpm/models"
+	"bpm/runc/lifecycle"
+)
+
+func init() {
+	restoreCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	restoreCommand.Flags().StringVar(&imagePath, "image-path", "", "directory containing a checkpoint written by 'bpm checkpoint'")
+	RootCmd.AddCommand(restoreCommand)
+}
+
+var restoreCommand = &cobra.Command{
+	Long:     "Starts a BOSH Process from a checkpoint written by 'bpm checkpoint' rather than from scratch.",
+	RunE:     restore,
+	Short:    "restores a checkpointed BOSH Process",
+	Use:      "restore <job-name> --image-path <dir>",
+	PreRunE:  restorePre,
+	PostRunE: restorePost,
+}
+
+func restorePre(cmd *cobra.Command, args []string) error {
+	if err := validateInput(args); err != nil {
+		return err
+	}
+
+	if imagePath == "" {
+		return errors.New("must specify an image path")
+	}
+
+	cmd.SilenceUsage = true
+
+	if err := setupBpmLogs("restore"); err != nil {
+		return err
+	}
+
+	return acquireLifecycleLock()
+}
+
+func restorePost(cmd *cobra.Command, args []string) error {
+	return releaseLifecycleLock()
+}
+
+func restore(cmd *cobra.Command, _ []string) error {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	jobCfg, err := bpmCfg.ParseJobConfig()
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, procName)
+	if err != nil {
+		logger.Error("process-not-defined", err)
+		return fmt.Errorf("process %q not present in job configuration (%s)", procName, bpmCfg.JobConfig())
+	}
+
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	process, err := runcLifecycle.StatProcess(bpmCfg)
+	if err != nil && !lifecycle.IsNotExist(err) {
+		return fmt.Errorf("failed to get job-process status: %s", err)
+	}
+
+	if process != nil {
+		switch process.Status {
+		case models.ProcessStateRunning, models.ProcessStatePaused:
+			return errors.New("process is already running")
+		case models.ProcessStateFailed:
+			logger.Info("removing-stopped-process")
+			if err := runcLifecycle.RemoveProcess(logger, bpmCfg); err != nil {
+				logger.Error("failed-to-cleanup", err)
+				return fmt.Errorf("failed to clean up stale job-process: %s", err)
+			}
+		}
+	}
+
+	if err := addProcessEnv(bpmCfg, procCfg); err != nil {
+		return err
+	}
+
+	if err := runcLifecycle.RestoreProcess(logger, bpmCfg, procCfg, imagePath); err != nil {
+		logger.Error("failed-to-restore", err)
+		return fmt.Errorf("failed to restore job-process: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"bpm/models"
+	"bpm/runc/lifecycle"
+)
+
+func init() {
+	resumeCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	RootCmd.AddCommand(resumeCommand)
+}
+
+var resumeCommand = &cobra.Command{
+	Long:     "Continues a BOSH Process that was frozen with 'bpm pause'.",
+	RunE:     resume,
+	Short:    "resumes a paused BOSH Process",
+	Use:      "resume <job-name>",
+	PreRunE:  resumePre,
+	PostRunE: resumePost,
+}
+
+func resumePre(cmd *cobra.Command, args []string) error {
+	if err := validateInput(args); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	if err := setupBpmLogs("resume"); err != nil {
+		return err
+	}
+
+	return acquireLifecycleLock()
+}
+
+func resumePost(cmd *cobra.Command, args []string) error {
+	return releaseLifecycleLock()
+}
+
+func resume(cmd *cobra.Command, _ []string) error {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	jobCfg, err := bpmCfg.ParseJobConfig()
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	if _, err := processByNameFromJobConfig(jobCfg, procName); err != nil {
+		logger.Error("process-not-defined", err)
+		return fmt.Errorf("process %q not present in job configuration (%s)", procName, bpmCfg.JobConfig())
+	}
+
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	process, err := runcLifecycle.StatProcess(bpmCfg)
+	if err != nil && !lifecycle.IsNotExist(err) {
+		return fmt.Errorf("failed to get job-process status: %s", err)
+	} else if lifecycle.IsNotExist(err) || process.Status != models.ProcessStatePaused {
+		return errors.New("process is not paused or could not be found")
+	}
+
+	if err := runcLifecycle.ResumeProcess(logger, bpmCfg); err != nil {
+		logger.Error("failed-to-resume", err)
+		return fmt.Errorf("failed to resume job-process: %s", err)
+	}
+
+	return nil
+}
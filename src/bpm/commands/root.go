@@ -18,18 +18,25 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"code.cloudfoundry.org/bytefmt"
 	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager"
+	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/cobra"
 
 	"bpm/bosh"
 	"bpm/cgroups"
 	"bpm/config"
+	"bpm/exitstatus"
 	"bpm/hostlock"
+	"bpm/netshape"
 	"bpm/runc/adapter"
 	"bpm/runc/client"
 	"bpm/runc/lifecycle"
@@ -39,10 +46,15 @@ import (
 )
 
 var (
-	bpmCfg      *config.BPMConfig
-	logger      lager.Logger
-	procName    string
-	showVersion bool
+	bpmCfg       *config.BPMConfig
+	containerID  string
+	logger       lager.Logger
+	logToStdout  bool
+	procName     string
+	restarts     int
+	sessionID    string
+	showVersion  bool
+	stateRetries int
 
 	userFinder = usertools.NewUserFinder()
 	boshEnv    = bosh.NewEnv(os.Getenv("BPM_BOSH_ROOT"))
@@ -53,6 +65,8 @@ var (
 
 func init() {
 	RootCmd.PersistentFlags().BoolVar(&showVersion, "version", false, "print BPM version")
+	RootCmd.PersistentFlags().BoolVar(&logToStdout, "log-to-stdout", os.Getenv("BPM_LOG_TO_STDOUT") == "true", "write the bpm log to stdout instead of bpm.log")
+	RootCmd.PersistentFlags().StringVar(&sessionID, "session-id", os.Getenv("BPM_SESSION_ID"), "an id to include in every bpm log line of this run (default random)")
 }
 
 var RootCmd = &cobra.Command{
@@ -87,7 +101,13 @@ func rootPre(cmd *cobra.Command, _ []string) error {
 
 	locks = hostlock.NewHandle(lockDir)
 
-	if !isRunningSystemd() {
+	useSystemd, err := useSystemdCgroups()
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	if !useSystemd {
 		return cgroups.Setup()
 	}
 
@@ -111,38 +131,108 @@ func validateInput(args []string) error {
 
 	bpmCfg = config.NewBPMConfig(boshEnv, jobName, procName)
 
+	if containerID != "" {
+		if err := config.ValidateContainerID(containerID); err != nil {
+			return err
+		}
+		bpmCfg.SetContainerID(containerID)
+	}
+
+	bpmCfg.SetRestarts(restarts)
+
 	return nil
 }
 
+// addContainerIDFlag lets the container of the process be named by the user
+// rather than after the job and process. Every command run for the process
+// must then be given the same name.
+func addContainerIDFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&containerID, "container-id", "", "advanced: the runc id of the container to use instead of the one derived from the job and process, which must be given to every command for the process")
+}
+
+// addRestartsFlag lets a supervisor which starts its process again after a
+// failed liveness check tell the next supervisor how many times in a row it
+// has done so. It is not meant to be given by users.
+func addRestartsFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&restarts, "restarts", 0, "internal: how many times in a row the process has been restarted by its supervisor")
+	_ = cmd.Flags().MarkHidden("restarts")
+}
+
+// errContainerIDWithReplicas is returned when a process with replicas is
+// given a container id as they cannot all be called the same.
+var errContainerIDWithReplicas = errors.New("--container-id cannot be used with a process which has replicas")
+
 func setupBpmLogs(sessionName string) error {
-	err := os.MkdirAll(bpmCfg.LogDir().External(), 0750)
+	sink, err := bpmLogSink()
 	if err != nil {
 		return err
 	}
 
+	// Every process set up during one run, such as the replicas of a job,
+	// shares the same id.
+	if sessionID == "" {
+		sessionID = uuid.NewV4().String()
+	}
+
+	logger = lager.NewLogger("bpm")
+	logger.RegisterSink(lager.NewPrettySink(sink, lager.INFO))
+	logger = logger.Session(sessionName, lager.Data{
+		"job":        bpmCfg.JobName(),
+		"process":    bpmCfg.ProcName(),
+		"session-id": sessionID,
+	})
+
+	return nil
+}
+
+func bpmLogSink() (io.Writer, error) {
+	if logToStdout {
+		return os.Stdout, nil
+	}
+
+	if err := createLogDir(bpmCfg.LogDir().External()); err != nil {
+		return nil, err
+	}
+
 	logFile, err := os.OpenFile(bpmCfg.BPMLog(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	usr, err := userFinder.Lookup(usertools.VcapUser)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = os.Chown(bpmCfg.BPMLog(), int(usr.UID), int(usr.GID))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	logger = lager.NewLogger("bpm")
-	logger.RegisterSink(lager.NewPrettySink(logFile, lager.INFO))
-	logger = logger.Session(sessionName, lager.Data{
-		"job":     bpmCfg.JobName(),
-		"process": bpmCfg.ProcName(),
-	})
+	return logFile, nil
+}
 
-	return nil
+// createLogDir makes the log directory of the job along with any of its
+// parents which are missing. A failure because a parent can't be written to
+// says which directory is at fault as the error from mkdir alone is hard to
+// act on.
+func createLogDir(dir string) error {
+	err := os.MkdirAll(dir, 0750)
+	if err == nil {
+		return nil
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && (os.IsPermission(err) || errors.Is(err, syscall.EROFS)) {
+		return fmt.Errorf(
+			"failed to create log directory %s: %s can't be written to (%s): it must be a writable directory owned by root",
+			dir,
+			filepath.Dir(pathErr.Path),
+			pathErr.Err,
+		)
+	}
+
+	return fmt.Errorf("failed to create log directory %s: %s", dir, err)
 }
 
 func acquireLifecycleLock() error {
@@ -174,17 +264,38 @@ func releaseLifecycleLock() error {
 }
 
 func newRuncLifecycle() (*lifecycle.RuncLifecycle, error) {
+	return newRuncLifecycleWithRoot(config.RuncRoot(boshEnv))
+}
+
+// newRuncLifecycleWithRoot is newRuncLifecycle with runc keeping the state of
+// its containers in runcRoot.
+func newRuncLifecycleWithRoot(runcRoot string) (*lifecycle.RuncLifecycle, error) {
+	useSystemd, err := useSystemdCgroups()
+	if err != nil {
+		return nil, err
+	}
+
 	runcClient := client.NewRuncClient(
 		config.RuncPath(boshEnv),
-		config.RuncRoot(boshEnv),
-		isRunningSystemd(),
+		runcRoot,
+		useSystemd,
 	)
+	if value := os.Getenv("BPM_RUNC_BUSY_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid BPM_RUNC_BUSY_TIMEOUT %q: must be a non-negative duration such as 30s", value)
+		}
+		runcClient.SetBusyTimeout(timeout)
+	}
+
 	features, err := sysfeat.Fetch()
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch system features: %q", err)
 	}
 
 	runcAdapter := adapter.NewRuncAdapter(*features, filepath.Glob, sharedvolume.MakeShared, locks)
+	runcAdapter.SetInstanceName(os.Getenv("BPM_INSTANCE_NAME"))
+	runcAdapter.SetBoshSpecFile(os.Getenv("BPM_BOSH_SPEC_FILE"))
 	clock := clock.NewClock()
 
 	return lifecycle.NewRuncLifecycle(
@@ -197,6 +308,18 @@ func newRuncLifecycle() (*lifecycle.RuncLifecycle, error) {
 	), nil
 }
 
+// configError describes a failure to read the job configuration. A file which
+// cannot be parsed gets its own exit status so that it can be told apart from
+// other failures.
+func configError(err error) error {
+	wrapped := fmt.Errorf("failed to parse job configuration: %s", err)
+	if _, ok := err.(*config.ParseError); ok {
+		return &exitstatus.Error{Status: exitstatus.ConfigError, Err: wrapped}
+	}
+
+	return wrapped
+}
+
 func processByNameFromJobConfig(jobCfg *config.JobConfig, procName string) (*config.ProcessConfig, error) {
 	for _, processConfig := range jobCfg.Processes {
 		if processConfig.Name == procName {
@@ -207,6 +330,101 @@ func processByNameFromJobConfig(jobCfg *config.JobConfig, procName string) (*con
 	return nil, fmt.Errorf("invalid process: %s", procName)
 }
 
+// logUnsafeOptions records the use of options which weaken the isolation of
+// a process so that they can be audited later.
+func logUnsafeOptions(procCfg *config.ProcessConfig) {
+	if procCfg.Unsafe == nil {
+		return
+	}
+
+	if procCfg.Unsafe.HostPidNamespace {
+		logger.Info("host-pid")
+	}
+
+	if procCfg.Unsafe.KeepDefaultCapabilities {
+		logger.Info("keeping-default-capabilities")
+	}
+}
+
+// limitNetBandwidth shapes the egress traffic of the process if it has a
+// net_bandwidth limit. Traffic is shaped on the interface named by
+// BPM_NET_INTERFACE or on the interface of the default route. If the limit
+// cannot be applied the process is started unthrottled with a warning.
+func limitNetBandwidth(cmd *cobra.Command, cfg *config.BPMConfig, procCfg *config.ProcessConfig) {
+	if procCfg.Limits == nil || procCfg.Limits.NetBandwidth == nil {
+		return
+	}
+
+	if err := applyNetBandwidthLimit(cfg, *procCfg.Limits.NetBandwidth); err != nil {
+		logger.Error("failed-to-limit-net-bandwidth", err)
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: not limiting network bandwidth: %s\n", err)
+	}
+}
+
+func applyNetBandwidthLimit(cfg *config.BPMConfig, limit string) error {
+	features, err := sysfeat.Fetch()
+	if err != nil {
+		return err
+	}
+
+	if !features.NetClsSupported {
+		return errors.New("the net_cls cgroup is not available")
+	}
+
+	rate, err := bytefmt.ToBytes(limit)
+	if err != nil {
+		return err
+	}
+
+	iface, err := netShapingInterface()
+	if err != nil {
+		return err
+	}
+
+	return netshape.Limit(iface, netshape.ClassID(cfg.ContainerID()), rate)
+}
+
+// removeNetBandwidthLimit removes the traffic control class of the process
+// once its container has been removed, and the bpm root qdisc along with the
+// last class. A failure is only logged as the process has already stopped.
+func removeNetBandwidthLimit(cfg *config.BPMConfig) {
+	iface, err := netShapingInterface()
+	if err != nil {
+		logger.Info("net-bandwidth-interface-not-found", lager.Data{"error": err.Error()})
+		return
+	}
+
+	if err := netshape.Unlimit(iface, netshape.ClassID(cfg.ContainerID())); err != nil {
+		logger.Error("failed-to-remove-net-bandwidth-limit", err)
+	}
+}
+
+// netShapingInterface is the interface named by BPM_NET_INTERFACE or else the
+// interface of the default route.
+func netShapingInterface() (string, error) {
+	if iface := os.Getenv("BPM_NET_INTERFACE"); iface != "" {
+		return iface, nil
+	}
+
+	return netshape.DefaultInterface()
+}
+
+// useSystemdCgroups decides whether runc should use the systemd cgroup
+// manager. This can be forced with the BPM_CGROUP_MANAGER environment
+// variable and otherwise depends on whether the host is running systemd.
+func useSystemdCgroups() (bool, error) {
+	switch manager := os.Getenv("BPM_CGROUP_MANAGER"); manager {
+	case "":
+		return isRunningSystemd(), nil
+	case "systemd":
+		return true, nil
+	case "cgroupfs":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid BPM_CGROUP_MANAGER %q: must be one of systemd or cgroupfs", manager)
+	}
+}
+
 func isRunningSystemd() bool {
 	systemdSystemDir, err := os.Lstat("/run/systemd/system")
 	if err != nil {
@@ -218,17 +436,17 @@ func isRunningSystemd() bool {
 // Occasionally RunC can get in an inconsistent state after a restart where
 // it's internal state.json file is truncated. RunC is unable to get out of
 // this state without some intervention. This is that intervention.
-func forceCleanupBrokenRuncState(logger lager.Logger, runcLifecycle *lifecycle.RuncLifecycle) error {
+func forceCleanupBrokenRuncState(logger lager.Logger, runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig) error {
 	// We compute this here rather than adding a new function to the
 	// configuration object to try and contain this hack to one place.
-	statePath := filepath.Join(config.RuncRoot(boshEnv), bpmCfg.ContainerID(), "state.json")
+	statePath := filepath.Join(config.RuncRoot(boshEnv), cfg.ContainerID(), "state.json")
 
 	if err := os.RemoveAll(statePath); err != nil {
 		logger.Error("failed-to-remove-state-file", err)
 		return fmt.Errorf("failed to clean up stale state file: %s", err)
 	}
 
-	if err := runcLifecycle.RemoveProcess(logger, bpmCfg); err != nil {
+	if err := runcLifecycle.RemoveProcess(logger, cfg); err != nil {
 		logger.Error("failed-to-cleanup", err)
 		return fmt.Errorf("failed to clean up stale job-process: %s", err)
 	}
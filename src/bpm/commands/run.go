@@ -100,7 +100,7 @@ func run(cmd *cobra.Command, _ []string) error {
 	if err != nil && !lifecycle.IsNotExist(err) {
 		logger.Error("failed-getting-job", err)
 
-		if cerr := forceCleanupBrokenRuncState(logger, runcLifecycle); cerr != nil {
+		if cerr := forceCleanupBrokenRuncState(logger, runcLifecycle, bpmCfg); cerr != nil {
 			logger.Error("failed-cleaning-up-broken-job", cerr)
 			return cerr
 		}
@@ -115,6 +115,9 @@ func run(cmd *cobra.Command, _ []string) error {
 	case models.ProcessStateRunning:
 		logger.Info("process-already-running")
 		return nil
+	case models.ProcessStatePaused:
+		logger.Info("process-paused")
+		return nil
 	case models.ProcessStateFailed:
 		logger.Info("removing-stopped-process")
 		if err := runcLifecycle.RemoveProcess(logger, bpmCfg); err != nil {
@@ -123,7 +126,17 @@ func run(cmd *cobra.Command, _ []string) error {
 		}
 		fallthrough
 	default:
-		if status, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg); err != nil {
+		logUnsafeOptions(procCfg)
+
+		if err := addProcessEnv(bpmCfg, procCfg); err != nil {
+			return err
+		}
+
+		limitNetBandwidth(cmd, bpmCfg, procCfg)
+
+		status, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg)
+		removeNetBandwidthLimit(bpmCfg)
+		if err != nil {
 			return &exitstatus.Error{
 				Status: status,
 				Err:    fmt.Errorf("failed to run job-process: %s", err),
@@ -0,0 +1,260 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/spf13/cobra"
+
+	"bpm/config"
+	"bpm/models"
+	"bpm/runc/lifecycle"
+)
+
+const (
+	// selftestJob is the name of both the job and the process started by
+	// bpm selftest. Its container is kept in a runc root of its own so that
+	// it cannot be mistaken for, or get in the way of, a real job.
+	selftestJob = "bpm-selftest"
+
+	// selftestOutput is written by the self test process to its stdout log.
+	selftestOutput = "bpm selftest ok"
+
+	// selftestTimeout is how long each step waits for the container.
+	selftestTimeout = 10 * time.Second
+)
+
+func init() {
+	RootCmd.AddCommand(selftestCommand)
+}
+
+var selftestCommand = &cobra.Command{
+	Long: `starts and stops a throwaway container to check that bpm works on this machine
+
+  The container is started and stopped in the same way as the process of a
+  job: its bundle, pid file and log files are created, it is run with runc
+  and everything is removed again once it has stopped. Each step is printed
+  with PASS or FAIL and the command exits with a non-zero exit code as soon
+  as one of them fails. The container is named bpm-selftest and only its own
+  files are touched.
+`,
+	RunE:     selftest,
+	Short:    "starts and stops a throwaway container to check that bpm works on this machine",
+	Use:      "selftest",
+	PreRunE:  selftestPre,
+	PostRunE: selftestPost,
+}
+
+func selftestPre(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	procName = selftestJob
+	bpmCfg = config.NewBPMConfig(boshEnv, selftestJob, selftestJob)
+
+	if err := setupBpmLogs("selftest"); err != nil {
+		return err
+	}
+
+	return acquireLifecycleLock()
+}
+
+func selftestPost(cmd *cobra.Command, _ []string) error {
+	return releaseLifecycleLock()
+}
+
+type selftestStep struct {
+	name string
+	run  func() error
+}
+
+func selftest(cmd *cobra.Command, _ []string) error {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	runcLifecycle, err := newRuncLifecycleWithRoot(config.SelftestRuncRoot(boshEnv))
+	if err != nil {
+		return err
+	}
+
+	procCfg := &config.ProcessConfig{
+		Name:       selftestJob,
+		Executable: "/bin/sh",
+		Args:       []string{"-c", fmt.Sprintf("echo %s; exec sleep 3600", selftestOutput)},
+	}
+
+	// A self test which was interrupted may have left its container behind.
+	// If it cannot be removed starting the container fails and says why.
+	if err := removeSelftestProcess(runcLifecycle); err != nil {
+		logger.Error("failed-to-remove-previous-selftest", err)
+	}
+
+	steps := []selftestStep{
+		{
+			name: "creating the job directory",
+			run:  func() error { return os.MkdirAll(bpmCfg.JobDir().External(), 0755) },
+		},
+		{
+			name: "starting a container",
+			run:  func() error { return runcLifecycle.StartProcess(logger, bpmCfg, procCfg) },
+		},
+		{
+			name: "container is running",
+			run:  func() error { return checkSelftestRunning(runcLifecycle) },
+		},
+		{
+			name: "output is written to the log file",
+			run:  func() error { return checkSelftestOutput(procCfg) },
+		},
+		{
+			name: "stopping the container",
+			run: func() error {
+				return runcLifecycle.StopProcess(logger, bpmCfg, selftestTimeout, DefaultKillRetries)
+			},
+		},
+		{
+			name: "removing the container",
+			run:  func() error { return runcLifecycle.RemoveProcess(logger, bpmCfg) },
+		},
+		{
+			name: "container is cleaned up",
+			run:  func() error { return checkSelftestCleanedUp(runcLifecycle) },
+		},
+	}
+
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			logger.Error("step-failed", err, lager.Data{"step": step.name})
+			fmt.Fprintf(cmd.OutOrStdout(), "FAIL %s: %s\n", step.name, err)
+
+			if rerr := removeSelftestProcess(runcLifecycle); rerr != nil {
+				logger.Error("failed-to-cleanup", rerr)
+			}
+
+			// The logs are kept so that the failure can be looked into.
+			return fmt.Errorf("self test failed: see %s", bpmCfg.LogDir().External())
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "PASS %s\n", step.name)
+	}
+
+	return removeSelftestDirs()
+}
+
+func checkSelftestRunning(runcLifecycle *lifecycle.RuncLifecycle) error {
+	process, err := runcLifecycle.StatProcess(bpmCfg)
+	if err != nil {
+		return err
+	}
+
+	if process.Status != models.ProcessStateRunning {
+		return fmt.Errorf("container is %s", process.Status)
+	}
+
+	data, err := ioutil.ReadFile(bpmCfg.PidFile().External())
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid file %s: %s", bpmCfg.PidFile().External(), err)
+	}
+
+	if pid != process.Pid {
+		return fmt.Errorf("pid file contains %d but the container has pid %d", pid, process.Pid)
+	}
+
+	return nil
+}
+
+func checkSelftestOutput(procCfg *config.ProcessConfig) error {
+	stdout := bpmCfg.StdoutLog(procCfg).External()
+
+	deadline := time.Now().Add(selftestTimeout)
+	for {
+		data, err := ioutil.ReadFile(stdout)
+		if err != nil {
+			return err
+		}
+
+		if strings.Contains(string(data), selftestOutput) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%q was not written to %s", selftestOutput, stdout)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func checkSelftestCleanedUp(runcLifecycle *lifecycle.RuncLifecycle) error {
+	_, err := runcLifecycle.StatProcess(bpmCfg)
+	if err == nil {
+		return errors.New("container still exists")
+	}
+	if !lifecycle.IsNotExist(err) {
+		return err
+	}
+
+	for _, path := range []string{bpmCfg.BundlePath(), bpmCfg.PidFile().External()} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return fmt.Errorf("%s still exists", path)
+		}
+	}
+
+	return nil
+}
+
+// removeSelftestProcess removes the container of the self test if there is
+// one along with its bundle and pid file.
+func removeSelftestProcess(runcLifecycle *lifecycle.RuncLifecycle) error {
+	_, err := runcLifecycle.StatProcess(bpmCfg)
+	if lifecycle.IsNotExist(err) {
+		return nil
+	}
+
+	return runcLifecycle.RemoveProcess(logger, bpmCfg)
+}
+
+// removeSelftestDirs removes the directories which were made for the self
+// test, which includes the bpm log it has been writing to.
+func removeSelftestDirs() error {
+	dirs := []string{
+		bpmCfg.JobDir().External(),
+		bpmCfg.DataDir().External(),
+		bpmCfg.SocketDir().External(),
+		bpmCfg.PidDir().External(),
+		bpmCfg.LogDir().External(),
+	}
+
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %s", dir, err)
+		}
+	}
+
+	return nil
+}
@@ -26,8 +26,11 @@ import (
 	"bpm/runc/lifecycle"
 )
 
+var shellTerm string
+
 func init() {
 	shellCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	shellCommand.Flags().StringVar(&shellTerm, "term", os.Getenv("TERM"), "terminal type to set as TERM inside the shell")
 	RootCmd.AddCommand(shellCommand)
 }
 
@@ -57,5 +60,15 @@ func shell(cmd *cobra.Command, _ []string) error {
 		return errors.New("process is not running or could not be found")
 	}
 
-	return runcLifecycle.OpenShell(bpmCfg, os.Stdin, cmd.OutOrStdout(), cmd.OutOrStderr())
+	jobCfg, err := bpmCfg.ParseJobConfig()
+	if err != nil {
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, procName)
+	if err != nil {
+		return fmt.Errorf("process %q not present in job configuration (%s)", procName, bpmCfg.JobConfig())
+	}
+
+	return runcLifecycle.OpenShell(bpmCfg, procCfg, shellTerm, os.Stdin, cmd.OutOrStdout(), cmd.OutOrStderr())
 }
@@ -0,0 +1,65 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// detachSignals stop the commands which stream output from a process, such as
+// when they are run under a supervisor which is shutting down. They exit
+// successfully and leave the process alone.
+var detachSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// closeOnDetachSignal returns a channel which is closed once bpm receives one
+// of the detachSignals.
+func closeOnDetachSignal() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, detachSignals...)
+
+	done := make(chan struct{})
+	go func() {
+		<-signals
+		close(done)
+	}()
+
+	return done
+}
+
+// killedByDetachSignal reports whether err is that of a command which was
+// killed by one of the detachSignals.
+func killedByDetachSignal(err error) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return false
+	}
+
+	for _, sig := range detachSignals {
+		if status.Signal() == sig {
+			return true
+		}
+	}
+
+	return false
+}
@@ -16,32 +16,83 @@
 package commands
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
+	"code.cloudfoundry.org/lager"
+	"github.com/moby/sys/mountinfo"
 	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
 
+	"bpm/config"
+	"bpm/exitstatus"
+	"bpm/hostlock"
 	"bpm/models"
 	"bpm/runc/lifecycle"
 )
 
+var (
+	startForce        bool
+	startMinUptime    time.Duration
+	startPrintEnv     bool
+	startWait         time.Duration
+	startWaitInterval time.Duration
+)
+
+// DefaultWaitInterval is how often bpm start --wait checks that the process
+// is still running.
+const DefaultWaitInterval = time.Second
+
 func init() {
 	startCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	startCommand.Flags().BoolVar(&startForce, "force", false, "overwrite a pidfile which belongs to another running container")
+	startCommand.Flags().DurationVar(&startWait, "wait", 0, "how long the process must keep running after it is started for the start to succeed")
+	startCommand.Flags().DurationVar(&startWaitInterval, "wait-interval", DefaultWaitInterval, "how often the process is checked while waiting")
+	startCommand.Flags().DurationVar(&startMinUptime, "min-uptime", 0, "how long the process must have been up without exiting or restarting for the start to succeed, overriding min_uptime")
+	addContainerIDFlag(startCommand)
+	addRestartsFlag(startCommand)
+	startCommand.Flags().BoolVar(&startPrintEnv, "print-env", false, "print the environment the process would be started with, with sensitive values redacted, instead of starting it")
 	RootCmd.AddCommand(startCommand)
 }
 
 var startCommand = &cobra.Command{
 	RunE:     start,
 	Short:    "starts a BOSH Process",
-	Use:      "start <job-name>",
+	Use:      "start <job-name> [-- <command> [args...]]",
 	PreRunE:  startPre,
 	PostRunE: startPost,
 }
 
 func startPre(cmd *cobra.Command, args []string) error {
+	if cmd.ArgsLenAtDash() == 0 {
+		return errors.New("must specify a job")
+	}
+
 	if err := validateInput(args); err != nil {
 		return err
 	}
 
+	if startWait < 0 {
+		return fmt.Errorf("invalid wait: %s must not be negative", startWait)
+	}
+
+	if startMinUptime < 0 {
+		return fmt.Errorf("invalid min uptime: %s must not be negative", startMinUptime)
+	}
+
+	if startWaitInterval <= 0 {
+		return fmt.Errorf("invalid wait interval: %s must be positive", startWaitInterval)
+	}
+
 	cmd.SilenceUsage = true
 
 	if err := setupBpmLogs("start"); err != nil {
@@ -55,14 +106,30 @@ func startPost(cmd *cobra.Command, args []string) error {
 	return releaseLifecycleLock()
 }
 
-func start(cmd *cobra.Command, _ []string) error {
+func start(cmd *cobra.Command, args []string) error {
 	logger.Info("starting")
 	defer logger.Info("complete")
 
 	jobCfg, err := bpmCfg.ParseJobConfig()
 	if err != nil {
 		logger.Error("failed-to-parse-config", err)
-		return fmt.Errorf("failed to parse job configuration: %s", err)
+		return configError(err)
+	}
+
+	var override []string
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 && dash < len(args) {
+		override = args[dash:]
+	}
+
+	if replicas := jobCfg.ReplicasOf(procName); len(replicas) > 0 {
+		if bpmCfg.HasCustomContainerID() {
+			return errContainerIDWithReplicas
+		}
+
+		for _, replica := range replicas {
+			overrideCommand(replica, override)
+		}
+		return startReplicas(cmd, replicas)
 	}
 
 	procCfg, err := processByNameFromJobConfig(jobCfg, procName)
@@ -71,15 +138,125 @@ func start(cmd *cobra.Command, _ []string) error {
 		return fmt.Errorf("process %q not present in job configuration (%s)", procName, bpmCfg.JobConfig())
 	}
 
+	overrideCommand(procCfg, override)
+
+	return startProcess(cmd, bpmCfg, procCfg)
+}
+
+// overrideCommand replaces the executable and arguments of the process with
+// the command given after -- on the command line, if there was one. The
+// configuration is only changed for this start.
+func overrideCommand(procCfg *config.ProcessConfig, override []string) {
+	if len(override) == 0 {
+		return
+	}
+
+	logger.Info("overriding-command", lager.Data{
+		"executable": override[0],
+		"args":       override[1:],
+	})
+
+	procCfg.Executable = override[0]
+	procCfg.Args = override[1:]
+}
+
+// startReplicas starts each replica of a process in turn as if it had been
+// started on its own, with the restart count given on the command line. The
+// lifecycle lock of the process is held throughout.
+func startReplicas(cmd *cobra.Command, replicas []*config.ProcessConfig) error {
+	for _, replica := range replicas {
+		if err := startProcess(cmd, bpmCfg.ForProcess(replica.Name), replica); err != nil {
+			return fmt.Errorf("failed to start replica %s: %s", replica.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// addProcessEnv adds the environment variables which come from outside of the
+// process configuration.
+func addProcessEnv(cfg *config.BPMConfig, procCfg *config.ProcessConfig) error {
+	procCfg.AddPassEnv(os.LookupEnv)
+
+	if err := procCfg.AddLinksEnv(); err != nil {
+		logger.Error("failed-to-read-links", err)
+		return err
+	}
+
+	if err := procCfg.AddPropertiesEnv(cfg.JobConfigDir().External()); err != nil {
+		logger.Error("failed-to-read-properties", err)
+		return err
+	}
+
+	if err := procCfg.AddEnvFromEnv(runEnvSource); err != nil {
+		logger.Error("failed-to-fetch-env", err)
+		return err
+	}
+
+	return nil
+}
+
+// printProcessEnv writes the environment the process would be started with,
+// one variable per line, with the values of sensitive variables redacted.
+func printProcessEnv(w io.Writer, runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig, procCfg *config.ProcessConfig) error {
+	redactSensitiveEnv(procCfg)
+
+	env, err := runcLifecycle.ProcessEnvironment(logger, cfg, procCfg)
+	if err != nil {
+		return fmt.Errorf("failed to build environment: %s", err)
+	}
+
+	for _, kv := range env {
+		fmt.Fprintln(w, kv)
+	}
+
+	return nil
+}
+
+// runEnvSource runs an env_from command on the host and returns its output.
+// Anything it writes to stderr is included in the error if it fails.
+func runEnvSource(source config.EnvSource) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(source.Command, source.Args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func startProcess(cmd *cobra.Command, cfg *config.BPMConfig, procCfg *config.ProcessConfig) error {
+	// The configuration is recorded as it was written rather than with the
+	// environment from outside of it which differs from one run to the next.
+	startedCfg, err := yaml.Marshal(procCfg)
+	if err != nil {
+		return err
+	}
+
 	runcLifecycle, err := newRuncLifecycle()
 	if err != nil {
 		return err
 	}
-	process, err := runcLifecycle.StatProcess(bpmCfg)
+
+	if startPrintEnv {
+		if err := addProcessEnv(cfg, procCfg); err != nil {
+			return err
+		}
+		return printProcessEnv(cmd.OutOrStdout(), runcLifecycle, cfg, procCfg)
+	}
+
+	process, err := runcLifecycle.StatProcess(cfg)
 	if err != nil && !lifecycle.IsNotExist(err) {
 		logger.Error("failed-getting-job", err)
 
-		if cerr := forceCleanupBrokenRuncState(logger, runcLifecycle); cerr != nil {
+		if cerr := forceCleanupBrokenRuncState(logger, runcLifecycle, cfg); cerr != nil {
 			logger.Error("failed-cleaning-up-broken-job", cerr)
 			return cerr
 		}
@@ -94,19 +271,381 @@ func start(cmd *cobra.Command, _ []string) error {
 	case models.ProcessStateRunning:
 		logger.Info("process-already-running")
 		return nil
+	case models.ProcessStatePaused:
+		logger.Info("process-paused")
+		return nil
 	case models.ProcessStateFailed:
+		if process.OOMKilled {
+			logger.Info("previous-process-was-oom-killed")
+		}
 		logger.Info("removing-stopped-process")
-		if err := runcLifecycle.RemoveProcess(logger, bpmCfg); err != nil {
+		if err := runcLifecycle.RemoveProcess(logger, cfg); err != nil {
 			logger.Error("failed-to-cleanup", err)
 			return fmt.Errorf("failed to clean up stale job-process: %s", err)
 		}
 		fallthrough
 	default:
-		if err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg); err != nil {
+		if err := checkRequiredLimits(procCfg); err != nil {
+			return err
+		}
+
+		if err := checkPidFile(runcLifecycle, cfg); err != nil {
+			return err
+		}
+
+		if procCfg.WaitForMount != nil {
+			if err := waitForMount(procCfg.WaitForMount); err != nil {
+				return err
+			}
+		}
+
+		// The environment from outside of the configuration is only fetched
+		// for a process which is going to be started.
+		if err := addProcessEnv(cfg, procCfg); err != nil {
+			return err
+		}
+
+		slot, err := acquireStartSlot()
+		if err != nil {
+			return err
+		}
+		if slot != nil {
+			defer slot.Unlock()
+		}
+
+		logUnsafeOptions(procCfg)
+		limitNetBandwidth(cmd, cfg, procCfg)
+
+		if procCfg.Oneshot {
+			return runOneshot(runcLifecycle, cfg, procCfg)
+		}
+
+		// A notify file left from an earlier start must not be mistaken for
+		// this one having succeeded.
+		if procCfg.Notify != nil && procCfg.Notify.File != "" {
+			if err := os.Remove(procCfg.Notify.File); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		started := time.Now()
+		if err := runcLifecycle.StartProcess(logger, cfg, procCfg); err != nil {
 			logger.Error("failed-to-start", err)
+			removeNetBandwidthLimit(cfg)
 			return fmt.Errorf("failed to start job-process: %s", err)
 		}
+
+		if err := ioutil.WriteFile(cfg.StartedConfigPath(), startedCfg, 0600); err != nil {
+			logger.Error("failed-to-save-config", err)
+		}
+
+		if startWait > 0 {
+			if err := waitForCrash(runcLifecycle, cfg); err != nil {
+				return err
+			}
+		}
+
+		if uptime := minUptime(cmd, procCfg); uptime > 0 {
+			if err := waitForMinUptime(runcLifecycle, cfg, started, uptime); err != nil {
+				return err
+			}
+		}
+
+		if procCfg.Notify != nil {
+			if err := notifyReady(runcLifecycle, cfg, procCfg.Notify); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// runOneshot runs a oneshot process to completion rather than leaving it
+// running. bpm start exits with the exit status of the process. The network
+// bandwidth limit of the process is removed along with its container.
+func runOneshot(runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig, procCfg *config.ProcessConfig) error {
+	status, err := runcLifecycle.RunOneshotProcess(logger, cfg, procCfg)
+	removeNetBandwidthLimit(cfg)
+	if err != nil {
+		logger.Error("oneshot-failed", err, lager.Data{"status": status})
+		return &exitstatus.Error{
+			Status: status,
+			Err:    fmt.Errorf("oneshot job-process failed: %s", err),
+		}
 	}
 
+	logger.Info("oneshot-complete")
 	return nil
 }
+
+// waitForCrash checks every wait interval that the process which has just
+// been started is still running until the wait has passed. A process which
+// exits in that time fails the start.
+func waitForCrash(runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig) error {
+	l := logger.Session("waiting-for-crash", lager.Data{"wait": startWait.String(), "interval": startWaitInterval.String()})
+	l.Info("starting")
+	defer l.Info("complete")
+
+	deadline := time.Now().Add(startWait)
+	for {
+		process, err := runcLifecycle.StatProcess(cfg)
+		if lifecycle.IsNotExist(err) {
+			l.Info("process-gone")
+			return fmt.Errorf("process exited within %s of starting", startWait)
+		} else if err != nil {
+			l.Error("failed-to-get-job", err)
+		} else if process.Status != models.ProcessStateRunning {
+			l.Info("process-not-running", lager.Data{"status": process.Status})
+			return fmt.Errorf("process exited within %s of starting: it is %s", startWait, process.Status)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+
+		if remaining > startWaitInterval {
+			remaining = startWaitInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// minUptime is how long the process must have been up for the start to
+// succeed. The --min-uptime flag takes precedence over min_uptime.
+func minUptime(cmd *cobra.Command, procCfg *config.ProcessConfig) time.Duration {
+	if cmd.Flags().Changed("min-uptime") {
+		return startMinUptime
+	}
+
+	return procCfg.MinUptimeDuration()
+}
+
+// waitForMinUptime checks every wait interval that the process which was
+// started at started is still running until it has been up for minUptime.
+// Unlike waitForCrash it also fails the start if the process is restarted in
+// the meantime, as its uptime then started over, so a process which crashes
+// and comes back cannot pass.
+func waitForMinUptime(runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig, started time.Time, minUptime time.Duration) error {
+	l := logger.Session("waiting-for-min-uptime", lager.Data{"min-uptime": minUptime.String(), "interval": startWaitInterval.String()})
+	l.Info("starting")
+	defer l.Info("complete")
+
+	var pid int
+	for {
+		process, err := runcLifecycle.StatProcess(cfg)
+		if lifecycle.IsNotExist(err) {
+			l.Info("process-gone")
+			return fmt.Errorf("process exited before it had been up for %s", minUptime)
+		} else if err != nil {
+			l.Error("failed-to-get-job", err)
+		} else if process.Status != models.ProcessStateRunning {
+			l.Info("process-not-running", lager.Data{"status": process.Status})
+			return fmt.Errorf("process exited before it had been up for %s: it is %s", minUptime, process.Status)
+		} else if pid != 0 && process.Pid != pid {
+			l.Info("process-restarted", lager.Data{"old-pid": pid, "new-pid": process.Pid})
+			return fmt.Errorf("process restarted before it had been up for %s", minUptime)
+		} else {
+			pid = process.Pid
+		}
+
+		remaining := minUptime - time.Since(started)
+		if remaining <= 0 {
+			return nil
+		}
+
+		if remaining > startWaitInterval {
+			remaining = startWaitInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// notifyReady tells a supervisor that the process is up, once its container
+// is confirmed to be running.
+func notifyReady(runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig, notify *config.Notify) error {
+	l := logger.Session("notifying-ready", lager.Data{"file": notify.File, "socket": notify.Socket})
+	l.Info("starting")
+	defer l.Info("complete")
+
+	process, err := runcLifecycle.StatProcess(cfg)
+	if err != nil {
+		l.Error("failed-to-get-job", err)
+		return fmt.Errorf("failed to get job-process status: %s", err)
+	}
+
+	if process.Status != models.ProcessStateRunning {
+		l.Info("process-not-running", lager.Data{"status": process.Status})
+		return fmt.Errorf("process is %s after starting: not notifying readiness", process.Status)
+	}
+
+	if notify.File != "" {
+		if err := ioutil.WriteFile(notify.File, nil, 0644); err != nil {
+			l.Error("failed-to-write-file", err)
+			return fmt.Errorf("failed to notify readiness: %s", err)
+		}
+	}
+
+	if notify.Socket != "" {
+		if err := sendReady(notify.Socket, process.Pid); err != nil {
+			l.Error("failed-to-notify-socket", err)
+			return fmt.Errorf("failed to notify readiness: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// sendReady sends the datagram sd_notify sends when a service is ready.
+func sendReady(socket string, pid int) error {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "READY=1\nMAINPID=%d\n", pid)
+	return err
+}
+
+// checkPidFile looks for a pidfile left behind for a process which is not
+// running. A pidfile whose pid is not that of any running container is stale
+// and is removed. One which belongs to another running container is only
+// overwritten with --force as the pidfiles have been mixed up somehow.
+func checkPidFile(runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig) error {
+	pidFile := cfg.PidFile().External()
+
+	data, err := ioutil.ReadFile(pidFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	l := logger.Session("checking-pidfile", lager.Data{"pidfile": pidFile})
+	contents := strings.TrimSpace(string(data))
+
+	owner, err := runningContainerWithPid(runcLifecycle, contents)
+	if err != nil {
+		l.Error("failed-to-list-processes", err)
+		return err
+	}
+
+	if owner == nil {
+		l.Info("removing-stale-pidfile", lager.Data{"contents": contents})
+	} else if startForce {
+		l.Info("overwriting-pidfile-of-running-container", lager.Data{"pid": owner.Pid, "container": owner.Name})
+	} else {
+		l.Info("pidfile-belongs-to-running-container", lager.Data{"pid": owner.Pid, "container": owner.Name})
+		return fmt.Errorf("%s holds the pid %d of running container %s: use --force to overwrite it", pidFile, owner.Pid, owner.Name)
+	}
+
+	return os.Remove(pidFile)
+}
+
+// runningContainerWithPid returns the running container whose pid is given
+// in contents, if there is one.
+func runningContainerWithPid(runcLifecycle *lifecycle.RuncLifecycle, contents string) (*models.Process, error) {
+	pid, err := strconv.Atoi(contents)
+	if err != nil || pid <= 0 {
+		return nil, nil
+	}
+
+	processes, err := runcLifecycle.ListProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range processes {
+		if p.Pid == pid && p.Status == models.ProcessStateRunning {
+			return p, nil
+		}
+	}
+
+	return nil, nil
+}
+
+const mountPollInterval = 500 * time.Millisecond
+
+// waitForMount waits until the path is a mount point. A path which does not
+// exist yet is waited for in the same way.
+func waitForMount(w *config.WaitForMount) error {
+	l := logger.Session("waiting-for-mount", lager.Data{"path": w.Path, "timeout": w.WaitTimeout().String()})
+	l.Info("starting")
+	defer l.Info("complete")
+
+	deadline := time.Now().Add(w.WaitTimeout())
+	for {
+		if mounted, err := mountinfo.Mounted(w.Path); err == nil && mounted {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			l.Info("timed-out")
+			return fmt.Errorf("%s was not mounted within %s", w.Path, w.WaitTimeout())
+		}
+
+		time.Sleep(mountPollInterval)
+	}
+}
+
+// checkRequiredLimits fails the start of a process which does not set every
+// limit named in the comma-separated BPM_REQUIRE_LIMITS, e.g. memory or
+// memory,processes. Any process may be started if it is unset.
+func checkRequiredLimits(procCfg *config.ProcessConfig) error {
+	value := os.Getenv("BPM_REQUIRE_LIMITS")
+	if value == "" {
+		return nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	unset, err := procCfg.UnsetLimits(names)
+	if err != nil {
+		return fmt.Errorf("invalid BPM_REQUIRE_LIMITS %q: %s", value, err)
+	}
+
+	if len(unset) > 0 {
+		logger.Info("required-limits-not-set", lager.Data{"limits": unset})
+		return fmt.Errorf("process %q does not set the limits required by BPM_REQUIRE_LIMITS: %s", procCfg.Name, strings.Join(unset, ", "))
+	}
+
+	return nil
+}
+
+// acquireStartSlot waits until fewer than BPM_MAX_CONCURRENT_STARTS other
+// processes are being started on this machine. Starts are not limited if it
+// is unset or zero, in which case no lock is returned.
+func acquireStartSlot() (hostlock.LockedLock, error) {
+	value := os.Getenv("BPM_MAX_CONCURRENT_STARTS")
+	if value == "" {
+		return nil, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("invalid BPM_MAX_CONCURRENT_STARTS %q: must be a non-negative integer", value)
+	}
+
+	if limit == 0 {
+		return nil, nil
+	}
+
+	l := logger.Session("acquiring-start-slot", lager.Data{"limit": limit})
+	l.Info("starting")
+	defer l.Info("complete")
+
+	slot, err := locks.LockSlot("start", limit)
+	if err != nil {
+		l.Error("failed-to-acquire-slot", err)
+		return nil, err
+	}
+
+	return slot, nil
+}
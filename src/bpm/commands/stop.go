@@ -16,30 +16,72 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
+	"code.cloudfoundry.org/lager"
 	"github.com/spf13/cobra"
 
+	"bpm/config"
+	"bpm/jobid"
+	"bpm/models"
+	"bpm/runc/adapter"
+	"bpm/runc/client"
 	"bpm/runc/lifecycle"
 )
 
 const DefaultStopTimeout = 15 * time.Second
 
+// DefaultKillRetries is the number of times stop retries signalling a process
+// before giving up.
+const DefaultKillRetries = 3
+
+// stopGracePeriod is how long stop waits for a process without stop_signals
+// to exit after SIGTERM. It is DefaultStopTimeout unless BPM_STOP_GRACE_PERIOD
+// overrides it.
+var (
+	stopGracePeriod           = DefaultStopTimeout
+	stopGracePeriodOverridden bool
+)
+
+var (
+	stopAll         bool
+	stopKill        bool
+	stopKillRetries int
+	stopReport      bool
+)
+
 func init() {
 	stopCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	stopCommand.Flags().BoolVar(&stopAll, "all", false, "stop every running process")
+	stopCommand.Flags().BoolVar(&stopKill, "kill", false, "send SIGKILL immediately instead of waiting for the process to exit")
+	stopCommand.Flags().IntVar(&stopKillRetries, "kill-retries", DefaultKillRetries, "number of times to retry a failed attempt to signal the process")
+	addContainerIDFlag(stopCommand)
+	stopCommand.Flags().BoolVar(&stopReport, "report", false, "report whether the process exited within the grace period or was forcefully killed")
 	RootCmd.AddCommand(stopCommand)
 }
 
 var stopCommand = &cobra.Command{
 	RunE:     stop,
 	Short:    "stops a BOSH Process",
-	Use:      "stop <job-name>",
+	Use:      "stop [--all | <job-name>]",
 	PreRunE:  stopPre,
 	PostRunE: stopPost,
 }
 
 func stopPre(cmd *cobra.Command, args []string) error {
+	if stopAll {
+		if len(args) > 0 || procName != "" || containerID != "" {
+			return errors.New("cannot specify a job when stopping all processes")
+		}
+
+		cmd.SilenceUsage = true
+		return nil
+	}
+
 	if err := validateInput(args); err != nil {
 		return err
 	}
@@ -54,19 +96,205 @@ func stopPre(cmd *cobra.Command, args []string) error {
 }
 
 func stopPost(cmd *cobra.Command, args []string) error {
+	if stopAll {
+		return nil
+	}
+
 	return releaseLifecycleLock()
 }
 
 func stop(cmd *cobra.Command, _ []string) error {
-	logger.Info("starting")
-	defer logger.Info("complete")
+	if err := loadStopGracePeriod(); err != nil {
+		return err
+	}
 
 	runcLifecycle, err := newRuncLifecycle()
 	if err != nil {
 		return err
 	}
 
-	if _, err := runcLifecycle.StatProcess(bpmCfg); lifecycle.IsNotExist(err) {
+	if stopAll {
+		return stopAllProcesses(cmd, runcLifecycle)
+	}
+
+	if replicas := configuredReplicas(); len(replicas) > 0 {
+		if bpmCfg.HasCustomContainerID() {
+			return errContainerIDWithReplicas
+		}
+
+		return stopReplicas(cmd, runcLifecycle, replicas)
+	}
+
+	return stopProcess(cmd, runcLifecycle, bpmCfg)
+}
+
+// stopReplicas stops every replica of a process in turn, waiting for the
+// replica_stop_delay of the process between each. A replica which fails to
+// stop does not prevent the others from being stopped.
+func stopReplicas(cmd *cobra.Command, runcLifecycle *lifecycle.RuncLifecycle, replicas []*config.ProcessConfig) error {
+	var failed int
+	for i, replica := range replicas {
+		if delay := replica.ReplicaStopWait(); i > 0 && delay > 0 {
+			logger.Info("waiting-before-next-replica", lager.Data{"delay": delay.String(), "replica": replica.Name})
+			time.Sleep(delay)
+		}
+
+		if err := stopProcess(cmd, runcLifecycle, bpmCfg.ForProcess(replica.Name)); err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "failed to stop replica %s: %s\n", replica.Name, err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to stop %d replica(s)", failed)
+	}
+
+	return nil
+}
+
+// configuredReplicas returns the replicas of the process being stopped, if
+// it has any. As with the stop signals a configuration which cannot be read
+// is logged and the process is stopped on its own.
+func configuredReplicas() []*config.ProcessConfig {
+	jobCfg, err := bpmCfg.ParseJobConfig()
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return nil
+	}
+
+	return jobCfg.ReplicasOf(bpmCfg.ProcName())
+}
+
+// stopAllProcesses stops every container bpm is running, as listed by list.
+// A container which can be traced back to its job and process is stopped in
+// turn under its own lifecycle lock and logs to its own job's bpm log as if
+// it had been stopped individually. Its configuration does not need to be
+// valid, or even still exist, for it to be stopped.
+func stopAllProcesses(cmd *cobra.Command, runcLifecycle *lifecycle.RuncLifecycle) error {
+	runningProcesses, err := runcLifecycle.ListProcesses()
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %s", err)
+	}
+
+	var stopped, failed int
+	for _, process := range runningProcesses {
+		name := process.Name
+
+		procCfg := runningProcessConfig(process)
+		if procCfg != nil {
+			name = fmt.Sprintf("%s/%s", procCfg.JobName(), procCfg.ProcName())
+			err = stopProcessWithLock(cmd, runcLifecycle, procCfg)
+		} else {
+			err = stopContainer(runcLifecycle, process)
+		}
+
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStderr(), "failed to stop %s: %s\n", name, err.Error())
+			failed++
+			continue
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "stopped %s\n", name)
+		stopped++
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%d stopped, %d failed\n", stopped, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("failed to stop %d process(es)", failed)
+	}
+
+	return nil
+}
+
+// runningProcessConfig returns the configuration of the process running in a
+// container. The job and process are read from the annotations of the
+// container, which also cover containers given a custom id. Containers
+// started before bpm annotated them are traced back from the name bpm gives
+// them. Nil is returned for a container whose process cannot be told.
+func runningProcessConfig(process *models.Process) *config.BPMConfig {
+	job := process.Annotations[adapter.AnnotationJob]
+	proc := process.Annotations[adapter.AnnotationProcess]
+	if job != "" && proc != "" {
+		cfg := config.NewBPMConfig(boshEnv, job, proc)
+		if cfg.ContainerID() != process.Name {
+			cfg.SetContainerID(process.Name)
+		}
+
+		return cfg
+	}
+
+	name, err := jobid.Decode(process.Name)
+	if err != nil {
+		return nil
+	}
+
+	job, proc = name, name
+	if i := strings.Index(name, "."); i >= 0 {
+		job, proc = name[:i], name[i+1:]
+	}
+
+	cfg := config.NewBPMConfig(boshEnv, job, proc)
+	if cfg.ContainerID() != process.Name {
+		return nil
+	}
+
+	return cfg
+}
+
+// stopContainer stops a container whose process cannot be told with the
+// default stop behaviour. There is no job to lock or to log to and only the
+// container itself can be removed.
+func stopContainer(runcLifecycle *lifecycle.RuncLifecycle, process *models.Process) error {
+	cfg := config.NewBPMConfig(boshEnv, "", "")
+	cfg.SetContainerID(process.Name)
+
+	logger = lager.NewLogger("bpm").Session("stop", lager.Data{"container-id": process.Name})
+
+	if process.Status == models.ProcessStatePaused {
+		if err := runcLifecycle.ResumeProcess(logger, cfg); err != nil {
+			logger.Error("failed-to-resume", err)
+		}
+	}
+
+	var stopErr error
+	if stopKill {
+		stopErr = runcLifecycle.KillProcess(logger, cfg, stopKillRetries)
+	} else {
+		stopErr = runcLifecycle.StopProcess(logger, cfg, stopGracePeriod, stopKillRetries)
+	}
+	if stopErr != nil {
+		logger.Error("failed-to-stop", stopErr)
+	}
+
+	if err := runcLifecycle.RemoveContainer(logger, cfg); err != nil {
+		return fmt.Errorf("failed to cleanup container: %s", err)
+	}
+
+	return nil
+}
+
+func stopProcessWithLock(cmd *cobra.Command, runcLifecycle *lifecycle.RuncLifecycle, procCfg *config.BPMConfig) error {
+	bpmCfg = procCfg
+
+	if err := setupBpmLogs("stop"); err != nil {
+		return err
+	}
+
+	if err := acquireLifecycleLock(); err != nil {
+		return err
+	}
+	defer releaseLifecycleLock()
+
+	return stopProcess(cmd, runcLifecycle, bpmCfg)
+}
+
+func stopProcess(cmd *cobra.Command, runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig) error {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	process, err := runcLifecycle.StatProcess(cfg)
+	if lifecycle.IsNotExist(err) {
 		logger.Info("job-already-stopped")
 		return nil
 	} else if err != nil {
@@ -74,14 +302,195 @@ func stop(cmd *cobra.Command, _ []string) error {
 		return fmt.Errorf("failed to get job-process status: %s", err)
 	}
 
-	if err := runcLifecycle.StopProcess(logger, bpmCfg, DefaultStopTimeout); err != nil {
-		logger.Error("failed-to-stop", err)
+	// A process the kernel killed for running out of memory has nothing left
+	// to signal. Its container only needs to be removed.
+	if process.OOMKilled {
+		return removeOOMKilledProcess(cmd, runcLifecycle, cfg)
+	}
+
+	// A paused process cannot react to the stop signals until it is
+	// resumed.
+	if process.Status == models.ProcessStatePaused {
+		if err := runcLifecycle.ResumeProcess(logger, cfg); err != nil {
+			logger.Error("failed-to-resume", err)
+		}
+	}
+
+	if stopKill {
+		return killProcess(cmd, runcLifecycle, cfg)
+	}
+
+	procCfg := stopProcessConfig(cfg)
+
+	signals := configuredStopSignals(procCfg)
+	if stopGracePeriodOverridden && len(signals) > 0 {
+		logger.Info("ignoring-stop-signals", lager.Data{"grace-period": stopGracePeriod.String()})
+		signals = nil
+	}
+
+	gracePeriod := stopGracePeriod
+	if len(signals) > 0 {
+		gracePeriod = 0
+		for _, s := range signals {
+			gracePeriod += s.Wait
+		}
+	}
+
+	stopStarted := time.Now()
+
+	var stopErr error
+	if procCfg.FreezeOnStop {
+		if len(signals) == 0 {
+			signals = []lifecycle.StopSignal{{Signal: client.Term, Wait: stopGracePeriod}}
+		}
+		stopErr = runcLifecycle.StopFrozenProcess(logger, cfg, signals, stopKillRetries)
+	} else if len(signals) > 0 {
+		stopErr = runcLifecycle.StopProcessWithSignals(logger, cfg, signals, stopKillRetries)
+	} else {
+		stopErr = runcLifecycle.StopProcess(logger, cfg, stopGracePeriod, stopKillRetries)
+	}
+	if stopErr != nil {
+		logger.Error("failed-to-stop", stopErr)
+	}
+
+	if err := runcLifecycle.RemoveProcess(logger, cfg); err != nil {
+		logger.Error("failed-to-cleanup", err)
+		return fmt.Errorf("failed to cleanup job-process: %s", err)
+	}
+	removeNetBandwidthLimit(cfg)
+
+	if stopReport {
+		reportStop(cmd, stopErr, gracePeriod, time.Since(stopStarted))
+	}
+
+	return nil
+}
+
+// removeOOMKilledProcess removes the container of a process which is no
+// longer running because it was killed for exceeding its memory limit.
+func removeOOMKilledProcess(cmd *cobra.Command, runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig) error {
+	logger.Info("process-was-oom-killed")
+
+	if err := runcLifecycle.RemoveProcess(logger, cfg); err != nil {
+		logger.Error("failed-to-cleanup", err)
+		return fmt.Errorf("failed to cleanup job-process: %s", err)
+	}
+	removeNetBandwidthLimit(cfg)
+
+	if stopReport {
+		logger.Info("report", lager.Data{"outcome": "oom-killed"})
+		fmt.Fprintln(cmd.OutOrStdout(), "process had already been killed for running out of memory")
+	}
+
+	return nil
+}
+
+// killProcess skips the grace period entirely. The process is sent SIGKILL
+// and its container is removed straight away.
+func killProcess(cmd *cobra.Command, runcLifecycle *lifecycle.RuncLifecycle, cfg *config.BPMConfig) error {
+	logger.Info("immediate")
+
+	stopStarted := time.Now()
+
+	if err := runcLifecycle.KillProcess(logger, cfg, stopKillRetries); err != nil {
+		logger.Error("failed-to-kill", err)
 	}
 
-	if err := runcLifecycle.RemoveProcess(logger, bpmCfg); err != nil {
+	if err := runcLifecycle.RemoveProcess(logger, cfg); err != nil {
 		logger.Error("failed-to-cleanup", err)
 		return fmt.Errorf("failed to cleanup job-process: %s", err)
 	}
+	removeNetBandwidthLimit(cfg)
+
+	if stopReport {
+		elapsed := time.Since(stopStarted).Round(time.Millisecond)
+		logger.Info("report", lager.Data{"outcome": "killed", "duration": elapsed.String()})
+		fmt.Fprintf(cmd.OutOrStdout(), "process was killed immediately after %s\n", elapsed)
+	}
 
 	return nil
 }
+
+// loadStopGracePeriod reads BPM_STOP_GRACE_PERIOD, which is meant for test
+// suites which would otherwise spend most of their time waiting for processes
+// which ignore SIGTERM to be killed. It takes the place of both the default
+// grace period and any stop_signals of the process.
+func loadStopGracePeriod() error {
+	value := os.Getenv("BPM_STOP_GRACE_PERIOD")
+	if value == "" {
+		return nil
+	}
+
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil || gracePeriod < 0 {
+		return fmt.Errorf("invalid BPM_STOP_GRACE_PERIOD %q: must be a non-negative duration such as 1s", value)
+	}
+
+	stopGracePeriod = gracePeriod
+	stopGracePeriodOverridden = true
+
+	return nil
+}
+
+// stopProcessConfig returns the configuration of the process being stopped.
+// Stopping a process must not depend on its configuration still being valid
+// so any problem is logged and an empty configuration, which gives the
+// default stop behaviour, is used instead.
+func stopProcessConfig(cfg *config.BPMConfig) *config.ProcessConfig {
+	jobCfg, err := cfg.ParseJobConfig()
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return &config.ProcessConfig{}
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, cfg.ProcName())
+	if err != nil {
+		logger.Error("process-not-defined", err)
+		return &config.ProcessConfig{}
+	}
+
+	return procCfg
+}
+
+// configuredStopSignals returns the stop_signals of the process. An invalid
+// signal is logged and the default stop behaviour is used instead.
+func configuredStopSignals(procCfg *config.ProcessConfig) []lifecycle.StopSignal {
+	var signals []lifecycle.StopSignal
+	for _, s := range procCfg.StopSignals {
+		signal, err := client.ParseSignal(s.Signal)
+		if err != nil {
+			logger.Error("invalid-stop-signal", err)
+			return nil
+		}
+
+		wait, err := time.ParseDuration(s.Wait)
+		if err != nil {
+			logger.Error("invalid-stop-signal", err)
+			return nil
+		}
+
+		signals = append(signals, lifecycle.StopSignal{Signal: signal, Wait: wait})
+	}
+
+	return signals
+}
+
+// reportStop describes how a stop went once the container has been removed.
+// If the process did not exit by itself then the container was forcefully
+// killed when it was deleted.
+func reportStop(cmd *cobra.Command, stopErr error, gracePeriod, elapsed time.Duration) {
+	elapsed = elapsed.Round(time.Millisecond)
+
+	if stopErr == nil {
+		logger.Info("report", lager.Data{"outcome": "exited", "duration": elapsed.String()})
+		fmt.Fprintf(cmd.OutOrStdout(), "process exited within the %s grace period after %s\n", gracePeriod, elapsed)
+		return
+	}
+
+	logger.Info("report", lager.Data{"outcome": "killed", "duration": elapsed.String()})
+	if lifecycle.IsTimeout(stopErr) {
+		fmt.Fprintf(cmd.OutOrStdout(), "process did not exit within the %s grace period and was forcefully killed after %s\n", gracePeriod, elapsed)
+	} else {
+		fmt.Fprintf(cmd.OutOrStdout(), "process could not be stopped gracefully (%s) and was forcefully killed after %s\n", stopErr, elapsed)
+	}
+}
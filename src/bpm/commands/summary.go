@@ -0,0 +1,291 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"bpm/config"
+)
+
+var summaryConfig string
+
+func init() {
+	summaryCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	summaryCommand.Flags().StringVarP(&summaryConfig, "config", "c", "", "optional path to a bpm.yml to use instead of the job's")
+	RootCmd.AddCommand(summaryCommand)
+}
+
+var summaryCommand = &cobra.Command{
+	Long:    "Lists the options of a BOSH Process which differ from the defaults, such as its limits, volumes, hooks and capabilities, one per line. Nothing is started.",
+	RunE:    summary,
+	Short:   "summarizes the bpm features a BOSH Process uses",
+	Use:     "summary <job-name>",
+	PreRunE: summaryPre,
+}
+
+func summaryPre(cmd *cobra.Command, args []string) error {
+	return validateInput(args)
+}
+
+func summary(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	jobCfg, err := parseJobConfigFile(summaryConfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, procName)
+	if err != nil {
+		return fmt.Errorf("process %q not present in job configuration", procName)
+	}
+
+	features := summarizeProcess(procCfg)
+	if len(features) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "no features beyond the defaults")
+		return nil
+	}
+
+	for _, f := range features {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", f.name, f.value)
+	}
+
+	return nil
+}
+
+// feature is an option of a process which is not left at its default. The
+// name is the path of the option in the bpm.yml.
+type feature struct {
+	name  string
+	value string
+}
+
+type featureList []feature
+
+func (l *featureList) add(name, format string, args ...interface{}) {
+	*l = append(*l, feature{name: name, value: fmt.Sprintf(format, args...)})
+}
+
+func (l *featureList) addIf(enabled bool, name string) {
+	if enabled {
+		l.add(name, "true")
+	}
+}
+
+func summarizeProcess(procCfg *config.ProcessConfig) []feature {
+	var features featureList
+
+	if procCfg.Replicas > 0 {
+		features.add("replicas", "%d", procCfg.Replicas)
+	}
+	if procCfg.ReplicaStopDelay != "" {
+		features.add("replica_stop_delay", "%s", procCfg.ReplicaStopDelay)
+	}
+	if procCfg.RootFS != "" {
+		features.add("rootfs", "%s", procCfg.RootFS)
+	}
+	if procCfg.WorkDir != "" {
+		features.add("workdir", "%s", procCfg.WorkDir)
+	}
+	if len(procCfg.SensitiveEnv) > 0 {
+		features.add("sensitive_env", "%s", strings.Join(procCfg.SensitiveEnv, ", "))
+	}
+
+	summarizeLimits(&features, procCfg.Limits)
+
+	for _, v := range procCfg.AdditionalVolumes {
+		features.add("additional_volumes", "%s", describeVolume(v))
+	}
+	features.addIf(procCfg.EphemeralDisk, "ephemeral_disk")
+	if len(procCfg.DataMountOptions) > 0 {
+		features.add("data_mount_options", "%s", strings.Join(procCfg.DataMountOptions, ", "))
+	}
+	if procCfg.PersistentDisk.Enabled() {
+		features.add("persistent_disk", "%s", procCfg.PersistentDisk)
+	}
+	if procCfg.WaitForMount != nil {
+		features.add("wait_for_mount", "%s", procCfg.WaitForMount.Path)
+	}
+
+	if len(procCfg.Capabilities) > 0 {
+		features.add("capabilities", "%s", strings.Join(procCfg.Capabilities, ", "))
+	}
+	features.addIf(procCfg.CoreDumps, "core_dumps")
+	features.addIf(procCfg.EnableFuse, "enable_fuse")
+	if n := procCfg.IONice; n != nil {
+		if n.Class == config.IONiceIdle {
+			features.add("ionice", "%s", n.Class)
+		} else {
+			features.add("ionice", "%s level %d", n.Class, n.IOLevel())
+		}
+	}
+	if procCfg.Hostname != "" {
+		features.add("hostname", "%s", procCfg.Hostname)
+	}
+	if procCfg.NetworkMode != "" {
+		features.add("network_mode", "%s", procCfg.NetworkMode)
+	}
+	for _, s := range procCfg.ListenSockets {
+		features.add("listen_sockets", "%s %s", s.ListenNetwork(), s.Address)
+	}
+	if ns := procCfg.UserNamespace; ns != nil {
+		features.add("user_namespace", "root is %d:%d on the host, %d ids mapped", ns.HostUID, ns.HostGID, ns.MappingSize())
+	}
+	if procCfg.NsswitchConf != "" {
+		features.add("nsswitch_conf", "%q", procCfg.NsswitchConf)
+	}
+	if procCfg.SELinuxLabel != "" {
+		features.add("selinux_label", "%s", procCfg.SELinuxLabel)
+	}
+	if procCfg.ShmSize != "" {
+		features.add("shm_size", "%s", procCfg.ShmSize)
+	}
+
+	if h := procCfg.Hooks; h != nil {
+		if h.PreStart != "" {
+			features.add("hooks.pre_start", "%s", h.PreStart)
+		}
+		if h.PostStart != "" {
+			features.add("hooks.post_start", "%s", h.PostStart)
+		}
+		if h.Drain != "" {
+			features.add("hooks.drain", "%s", h.Drain)
+		}
+	}
+
+	if l := procCfg.Liveness; l != nil {
+		features.add("liveness", "%s every %s", l.HeartbeatFile, l.Interval)
+	}
+	if procCfg.MinUptime != "" {
+		features.add("min_uptime", "%s", procCfg.MinUptime)
+	}
+	if procCfg.Notify != nil {
+		features.add("notify", "true")
+	}
+	features.addIf(procCfg.Oneshot, "oneshot")
+	if procCfg.StartupTimeout != "" {
+		features.add("startup_timeout", "%s", procCfg.StartupTimeout)
+	}
+	for _, s := range procCfg.StopSignals {
+		features.add("stop_signals", "%s then wait %s", s.Signal, s.Wait)
+	}
+	features.addIf(procCfg.FreezeOnStop, "freeze_on_stop")
+
+	if l := procCfg.Logs; l != nil {
+		features.addIf(l.LineBuffered, "logs.line_buffered")
+		features.addIf(l.Fifo, "logs.fifo")
+		features.addIf(l.MergeStderr, "logs.merge_stderr")
+		features.addIf(l.ChownExisting, "logs.chown_existing")
+		if l.Prefix != "" {
+			features.add("logs.prefix", "%q", l.Prefix)
+		}
+		if l.Filter != "" {
+			features.add("logs.filter", "%s", l.Filter)
+		}
+		if l.Rotate != nil {
+			features.add("logs.rotate", "true")
+		}
+		if l.StdoutFile != "" {
+			features.add("logs.stdout_file", "%s", l.StdoutFile)
+		}
+		if l.StderrFile != "" {
+			features.add("logs.stderr_file", "%s", l.StderrFile)
+		}
+	}
+	features.addIf(procCfg.RecordSpec, "record_spec")
+
+	if u := procCfg.Unsafe; u != nil {
+		features.addIf(u.Privileged, "unsafe.privileged")
+		features.addIf(u.HostPidNamespace, "unsafe.host_pid_namespace")
+		features.addIf(u.KeepDefaultCapabilities, "unsafe.keep_default_capabilities")
+		for _, v := range u.UnrestrictedVolumes {
+			features.add("unsafe.unrestricted_volumes", "%s", describeVolume(v))
+		}
+	}
+
+	return features
+}
+
+func summarizeLimits(features *featureList, limits *config.Limits) {
+	if limits == nil {
+		return
+	}
+
+	if limits.Memory != nil {
+		features.add("limits.memory", "%s", *limits.Memory)
+	}
+	if limits.MemorySwap != nil {
+		features.add("limits.memory_swap", "%s", *limits.MemorySwap)
+	}
+	if limits.MemoryWarnPercent != nil {
+		features.add("limits.memory_warn_percent", "%d", *limits.MemoryWarnPercent)
+	}
+	if limits.KernelMemory != nil {
+		features.add("limits.kernel_memory", "%s", *limits.KernelMemory)
+	}
+	if limits.Cpus != nil {
+		features.add("limits.cpus", "%g", *limits.Cpus)
+	}
+	if limits.Cpuset != nil {
+		features.add("limits.cpuset", "%s", limits.Cpuset.Cpus)
+	}
+	if limits.CPUCountEnv != "" {
+		features.add("limits.cpu_count_env", "%s", limits.CPUCountEnv)
+	}
+	if limits.OpenFiles != nil {
+		features.add("limits.open_files", "%d", *limits.OpenFiles)
+	}
+	if limits.Processes != nil {
+		features.add("limits.processes", "%d", *limits.Processes)
+	}
+	if limits.MaxFileSize != nil {
+		features.add("limits.max_file_size", "%s", *limits.MaxFileSize)
+	}
+	if limits.NetBandwidth != nil {
+		features.add("limits.net_bandwidth", "%s", *limits.NetBandwidth)
+	}
+}
+
+// describeVolume gives the path of the volume followed by any options which
+// are not the default.
+func describeVolume(v config.Volume) string {
+	var opts []string
+	if v.Writable {
+		opts = append(opts, "writable")
+	}
+	if v.AllowExecutions {
+		opts = append(opts, "allow_executions")
+	}
+	if v.MountOnly {
+		opts = append(opts, "mount_only")
+	}
+	if v.Shared {
+		opts = append(opts, "shared")
+	}
+	if v.Create {
+		opts = append(opts, "create")
+	}
+
+	if len(opts) == 0 {
+		return v.Path
+	}
+
+	return fmt.Sprintf("%s (%s)", v.Path, strings.Join(opts, ", "))
+}
@@ -0,0 +1,317 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/spf13/cobra"
+
+	"bpm/config"
+	"bpm/runc/lifecycle"
+)
+
+func init() {
+	superviseCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	addContainerIDFlag(superviseCommand)
+	addRestartsFlag(superviseCommand)
+	RootCmd.AddCommand(superviseCommand)
+}
+
+var superviseCommand = &cobra.Command{
+	Hidden:  true,
+	RunE:    supervise,
+	Short:   "watches over a running process",
+	Use:     lifecycle.SuperviseCommand + " <job-name>",
+	PreRunE: supervisePre,
+}
+
+func supervisePre(cmd *cobra.Command, args []string) error {
+	if err := validateInput(args); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	return setupBpmLogs("supervise")
+}
+
+// supervise is run by bpm start in the background for every process which
+// needs watching over. It runs until the process exits. A process killed for
+// failing its liveness check is started again if its restart policy asks for
+// it, at most max_restarts times in a row without a heartbeat in between when
+// that is set. On SIGHUP it reads the job configuration again and applies the
+// changes to the thresholds and restart policy which the process is watched
+// with.
+func supervise(cmd *cobra.Command, _ []string) error {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	jobCfg, err := bpmCfg.ParseJobConfig()
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return fmt.Errorf("failed to parse job configuration: %s", err)
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, bpmCfg.ProcName())
+	if err != nil {
+		logger.Error("process-not-defined", err)
+		return err
+	}
+
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	supervised := newSupervisedConfig(procCfg)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go func() {
+		for range hup {
+			supervised.reload(logger.Session("reload"))
+		}
+	}()
+
+	if procCfg.MemoryWarnPercent() > 0 {
+		warnLogger := logger.Session("warn")
+
+		if procCfg.Liveness == nil {
+			runcLifecycle.WatchMemory(warnLogger, bpmCfg, supervised.thresholds, nil)
+			return nil
+		}
+
+		done := make(chan struct{})
+		defer close(done)
+		go runcLifecycle.WatchMemory(warnLogger, bpmCfg, supervised.thresholds, done)
+	}
+
+	if procCfg.Liveness == nil {
+		return nil
+	}
+
+	heartbeatFile := procCfg.Liveness.HostHeartbeatFile(boshEnv)
+	watched := time.Now()
+
+	killed, err := runcLifecycle.WatchHeartbeat(
+		logger,
+		bpmCfg,
+		heartbeatFile,
+		supervised.thresholds,
+		DefaultKillRetries,
+	)
+	if err != nil {
+		logger.Error("failed-to-kill", err)
+		return err
+	}
+
+	if !killed {
+		return nil
+	}
+
+	settings := supervised.current()
+	if !settings.restart {
+		return nil
+	}
+
+	// Only restarts of a process which never came up healthy count towards
+	// the limit. One which has sent a heartbeat since it was restarted
+	// starts the count over.
+	restarts := bpmCfg.Restarts()
+	if restarts > 0 && heartbeatSince(heartbeatFile, watched) {
+		logger.Info("restart-count-reset", lager.Data{"restarts": restarts})
+		restarts = 0
+	}
+
+	if settings.maxRestarts > 0 && restarts >= settings.maxRestarts {
+		logger.Info("restart-limit-reached", lager.Data{"restarts": restarts, "max-restarts": settings.maxRestarts})
+		return nil
+	}
+
+	// Restarting through bpm start takes the lifecycle lock, cleans up the
+	// killed container and starts a new supervisor for the new process.
+	logger.Info("restarting", lager.Data{"restarts": restarts + 1})
+	args := []string{"start", bpmCfg.JobName(), "-p", bpmCfg.ProcName(), "--restarts", strconv.Itoa(restarts + 1)}
+	if bpmCfg.HasCustomContainerID() {
+		args = append(args, "--container-id", bpmCfg.ContainerID())
+	}
+
+	restart := exec.Command("/proc/self/exe", args...)
+	if err := restart.Run(); err != nil {
+		logger.Error("failed-to-restart", err)
+		return err
+	}
+
+	return nil
+}
+
+// heartbeatSince reports whether the heartbeat file has been touched after
+// the given time.
+func heartbeatSince(heartbeatFile string, since time.Time) bool {
+	fi, err := os.Stat(heartbeatFile)
+	return err == nil && fi.ModTime().After(since)
+}
+
+// supervisedConfig is the part of the configuration of a process which its
+// supervisor can apply without the process being started again.
+type supervisedConfig struct {
+	thresholds *lifecycle.Thresholds
+
+	mu       sync.Mutex
+	procCfg  *config.ProcessConfig
+	settings supervisedSettings
+}
+
+// supervisedSettings are the thresholds and restart policy which a process
+// is watched with.
+type supervisedSettings struct {
+	memoryWarnPercent int
+	interval          time.Duration
+	failures          int
+	restart           bool
+	maxRestarts       int
+}
+
+func newSupervisedConfig(procCfg *config.ProcessConfig) *supervisedConfig {
+	settings := supervisedSettingsOf(procCfg)
+
+	thresholds := lifecycle.NewThresholds(settings.memoryWarnPercent, settings.interval)
+	thresholds.SetHeartbeatFailures(settings.failures)
+
+	return &supervisedConfig{
+		thresholds: thresholds,
+		procCfg:    procCfg,
+		settings:   settings,
+	}
+}
+
+// supervisedSettingsOf returns the thresholds and restart policy of the
+// process. The interval has already been validated along with the rest of
+// the configuration.
+func supervisedSettingsOf(procCfg *config.ProcessConfig) supervisedSettings {
+	settings := supervisedSettings{memoryWarnPercent: procCfg.MemoryWarnPercent()}
+	if procCfg.Liveness == nil {
+		return settings
+	}
+
+	settings.interval, _ = time.ParseDuration(procCfg.Liveness.Interval)
+	settings.failures = procCfg.Liveness.Failures()
+	settings.restart = procCfg.Liveness.Restart
+	settings.maxRestarts = procCfg.Liveness.MaxRestarts
+
+	return settings
+}
+
+// current returns the settings the process is watched with, which may have
+// been changed by a reload since the watch began.
+func (s *supervisedConfig) current() supervisedSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.settings
+}
+
+// reload reads the configuration of the process again and applies the
+// changes to its thresholds and restart policy, logging each of them. A
+// configuration which cannot be read is ignored. Watches are neither started
+// nor stopped, so a memory warning or liveness check which is added or
+// removed only takes effect once the process is started again.
+func (s *supervisedConfig) reload(logger lager.Logger) {
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	jobCfg, err := bpmCfg.ParseJobConfig()
+	if err != nil {
+		logger.Error("failed-to-parse-config", err)
+		return
+	}
+
+	procCfg, err := processByNameFromJobConfig(jobCfg, bpmCfg.ProcName())
+	if err != nil {
+		logger.Error("process-not-defined", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.settings
+	settings := supervisedSettingsOf(procCfg)
+
+	if (s.procCfg.MemoryWarnPercent() > 0) != (procCfg.MemoryWarnPercent() > 0) ||
+		(s.procCfg.Liveness == nil) != (procCfg.Liveness == nil) {
+		logger.Info("change-requires-restart", lager.Data{"reason": "memory warnings or liveness were added or removed"})
+		return
+	}
+
+	if settings.memoryWarnPercent != old.memoryWarnPercent {
+		logger.Info("changed", lager.Data{"setting": "limits.memory_warn_percent", "old": old.memoryWarnPercent, "new": settings.memoryWarnPercent})
+	}
+	if settings.interval != old.interval {
+		logger.Info("changed", lager.Data{"setting": "liveness.interval", "old": old.interval.String(), "new": settings.interval.String()})
+	}
+	if settings.failures != old.failures {
+		logger.Info("changed", lager.Data{"setting": "liveness.failure_threshold", "old": old.failures, "new": settings.failures})
+	}
+	if settings.restart != old.restart {
+		logger.Info("changed", lager.Data{"setting": "liveness.restart", "old": old.restart, "new": settings.restart})
+	}
+	if settings.maxRestarts != old.maxRestarts {
+		logger.Info("changed", lager.Data{"setting": "liveness.max_restarts", "old": old.maxRestarts, "new": settings.maxRestarts})
+	}
+
+	if !reflect.DeepEqual(unsupervisedConfig(s.procCfg), unsupervisedConfig(procCfg)) {
+		logger.Info("change-requires-restart", lager.Data{"reason": "settings other than the thresholds and restart policy changed"})
+	}
+
+	s.thresholds.Set(settings.memoryWarnPercent, settings.interval)
+	s.thresholds.SetHeartbeatFailures(settings.failures)
+	s.settings = settings
+	s.procCfg = procCfg
+}
+
+// unsupervisedConfig is a copy of the configuration of the process without
+// the settings which its supervisor applies when it is reloaded.
+func unsupervisedConfig(procCfg *config.ProcessConfig) config.ProcessConfig {
+	c := *procCfg
+	if c.Limits != nil {
+		limits := *c.Limits
+		limits.MemoryWarnPercent = nil
+		c.Limits = &limits
+	}
+	if c.Liveness != nil {
+		liveness := *c.Liveness
+		liveness.Interval = ""
+		liveness.FailureThreshold = 0
+		liveness.Restart = false
+		liveness.MaxRestarts = 0
+		c.Liveness = &liveness
+	}
+
+	return c
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"bpm/models"
+	"bpm/presenters"
+	"bpm/runc/lifecycle"
+)
+
+// DefaultTopInterval is how often top samples the containers and redraws
+// its table when no interval is given.
+const DefaultTopInterval = 2 * time.Second
+
+var (
+	topInterval   time.Duration
+	topIterations int
+)
+
+func init() {
+	topCommand.Flags().DurationVar(&topInterval, "interval", DefaultTopInterval, "how often to sample the processes and redraw the table")
+	topCommand.Flags().IntVarP(&topIterations, "iterations", "n", 0, "exit after redrawing the table this many times")
+	RootCmd.AddCommand(topCommand)
+}
+
+var topCommand = &cobra.Command{
+	Long:    "Shows the CPU and memory usage of every running bpm container, the busiest first, and redraws it until interrupted.",
+	RunE:    top,
+	Short:   "shows the resource usage of bpm containers",
+	Use:     "top",
+	PreRunE: topPre,
+}
+
+func topPre(cmd *cobra.Command, _ []string) error {
+	if topInterval <= 0 {
+		return fmt.Errorf("invalid interval: %s must be positive", topInterval)
+	}
+
+	if topIterations < 0 {
+		return fmt.Errorf("invalid iterations: %d must not be negative", topIterations)
+	}
+
+	return nil
+}
+
+// cpuSample is the CPU time a container had used when it was sampled.
+type cpuSample struct {
+	total uint64
+	at    time.Time
+}
+
+func top(cmd *cobra.Command, _ []string) error {
+	cmd.SilenceUsage = true
+
+	runcLifecycle, err := newRuncLifecycle()
+	if err != nil {
+		return err
+	}
+
+	done := closeOnDetachSignal()
+	ticker := time.NewTicker(topInterval)
+	defer ticker.Stop()
+
+	samples := map[string]cpuSample{}
+	for i := 1; ; i++ {
+		processes, err := sampleProcesses(runcLifecycle, samples)
+
+		fmt.Fprint(cmd.OutOrStdout(), clearScreen)
+		if err != nil {
+			// As with list --watch the next redraw may well succeed.
+			fmt.Fprintf(cmd.OutOrStderr(), "failed to list jobs: %s\n", err)
+		} else if err := presenters.PrintTop(processes, cmd.OutOrStdout()); err != nil {
+			return err
+		}
+
+		if topIterations > 0 && i >= topIterations {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// sampleProcesses gathers the stats of every running container. The CPU usage
+// is worked out from the CPU time used since the previous sample, which is
+// replaced in samples.
+func sampleProcesses(runcLifecycle *lifecycle.RuncLifecycle, samples map[string]cpuSample) ([]presenters.TopProcess, error) {
+	processes, err := runcLifecycle.ListProcessesWithRetries(DefaultStateRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var usage []presenters.TopProcess
+	for _, process := range processes {
+		if process.Status != models.ProcessStateRunning {
+			continue
+		}
+
+		// A container which has stopped since it was listed is simply left
+		// out of this redraw.
+		stats, err := runcLifecycle.ProcessStats(process.Name)
+		if err != nil {
+			continue
+		}
+
+		now := time.Now()
+		tp := presenters.TopProcess{
+			Name:        process.Name,
+			Pid:         process.Pid,
+			Memory:      stats.Memory.Usage,
+			MemoryLimit: stats.Memory.Limit,
+			Pids:        stats.Pids,
+		}
+
+		if prev, ok := samples[process.Name]; ok && stats.CPUTotal >= prev.total {
+			percent := float64(stats.CPUTotal-prev.total) / float64(now.Sub(prev.at)) * 100
+			tp.CPUPercent = &percent
+		}
+
+		samples[process.Name] = cpuSample{total: stats.CPUTotal, at: now}
+		seen[process.Name] = true
+		usage = append(usage, tp)
+	}
+
+	for name := range samples {
+		if !seen[name] {
+			delete(samples, name)
+		}
+	}
+
+	return usage, nil
+}
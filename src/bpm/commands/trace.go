@@ -18,9 +18,12 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -34,13 +37,18 @@ traces a BOSH Process
   Executes strace with the following options:
     strace -s 100 -f -y -yy -p <process-pid>
 
-  <process-pid> is determined using the 'bpm pid' command
+  <process-pid> is determined using the 'bpm pid' command. With --pid a
+  different process in the container can be traced instead, given by its pid
+  inside the container.
 
   Note: This command may impact performance.
 `
 
+var tracePid int
+
 func init() {
 	traceCommand.Flags().StringVarP(&procName, "process", "p", "", "optional process name")
+	traceCommand.Flags().IntVar(&tracePid, "pid", 0, "pid inside the container of the process to trace")
 	RootCmd.AddCommand(traceCommand)
 }
 
@@ -53,6 +61,10 @@ var traceCommand = &cobra.Command{
 }
 
 func tracePre(cmd *cobra.Command, args []string) error {
+	if tracePid < 0 {
+		return fmt.Errorf("invalid pid: %d", tracePid)
+	}
+
 	return validateInput(args)
 }
 
@@ -71,7 +83,15 @@ func trace(cmd *cobra.Command, _ []string) error {
 		return errors.New("process is not running or could not be found")
 	}
 
-	straceCmd := exec.Command("strace", "-s", "100", "-f", "-y", "-yy", "-p", fmt.Sprintf("%d", process.Pid))
+	pid := process.Pid
+	if tracePid != 0 {
+		pid, err = containerProcessPid(runcLifecycle, tracePid)
+		if err != nil {
+			return err
+		}
+	}
+
+	straceCmd := exec.Command("strace", "-s", "100", "-f", "-y", "-yy", "-p", fmt.Sprintf("%d", pid))
 	straceCmd.Stdin = os.Stdin
 	straceCmd.Stdout = cmd.OutOrStdout()
 	straceCmd.Stderr = cmd.OutOrStderr()
@@ -106,3 +126,37 @@ func trace(cmd *cobra.Command, _ []string) error {
 		}
 	}
 }
+
+// containerProcessPid finds the host pid of the process in the container
+// whose pid inside the container is containerPid.
+func containerProcessPid(runcLifecycle *lifecycle.RuncLifecycle, containerPid int) (int, error) {
+	pids, err := runcLifecycle.ProcessPids(bpmCfg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the processes in the container: %s", err)
+	}
+
+	for _, hostPid := range pids {
+		if nsPid, err := namespacePid(hostPid); err == nil && nsPid == containerPid {
+			return hostPid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("pid %d is not a process in the container", containerPid)
+}
+
+// namespacePid returns the pid of a host process as it is seen inside the
+// innermost pid namespace the process belongs to.
+func namespacePid(hostPid int) (int, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", hostPid))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "NSpid:" {
+			return strconv.Atoi(fields[len(fields)-1])
+		}
+	}
+
+	return 0, fmt.Errorf("no NSpid in the status of pid %d", hostPid)
+}
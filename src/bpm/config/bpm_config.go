@@ -18,6 +18,7 @@ package config
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 
 	"bpm/bosh"
 	"bpm/jobid"
@@ -35,13 +36,21 @@ func RuncRoot(env *bosh.Env) string {
 	return env.Root().Join("sys", "run", "bpm-runc").External()
 }
 
+// SelftestRuncRoot is where runc keeps the state of the container started by
+// bpm selftest so that it is never listed alongside the containers of jobs.
+func SelftestRuncRoot(env *bosh.Env) string {
+	return env.Root().Join("sys", "run", "bpm-selftest-runc").External()
+}
+
 func LocksPath(env *bosh.Env) string {
 	return env.Root().Join("data", "bpm", "locks").External()
 }
 
 type BPMConfig struct {
-	jobName  string
-	procName string
+	jobName     string
+	procName    string
+	containerID string
+	restarts    int
 
 	boshEnv *bosh.Env
 }
@@ -82,6 +91,12 @@ func (c *BPMConfig) LogDir() bosh.Path {
 	return c.boshEnv.LogDir(c.JobName())
 }
 
+// CoreDir is where core dumps of processes of the job with core_dumps
+// enabled are written.
+func (c *BPMConfig) CoreDir() bosh.Path {
+	return c.LogDir().Join("cores")
+}
+
 func (c *BPMConfig) Stdout() bosh.Path {
 	return c.LogDir().Join(fmt.Sprintf("%s.stdout.log", c.procName))
 }
@@ -90,6 +105,50 @@ func (c *BPMConfig) Stderr() bosh.Path {
 	return c.LogDir().Join(fmt.Sprintf("%s.stderr.log", c.procName))
 }
 
+// StdoutLog is where the stdout of the process is written. It is Stdout unless
+// the logs of the process name another file.
+func (c *BPMConfig) StdoutLog(procCfg *ProcessConfig) bosh.Path {
+	if name := procCfg.StdoutLogFile(); name != "" {
+		return c.LogDir().Join(name)
+	}
+
+	return c.Stdout()
+}
+
+// StderrLog is where the stderr of the process is written. It is Stderr unless
+// the logs of the process name another file.
+func (c *BPMConfig) StderrLog(procCfg *ProcessConfig) bosh.Path {
+	if name := procCfg.StderrLogFile(); name != "" {
+		return c.LogDir().Join(name)
+	}
+
+	return c.Stderr()
+}
+
+// RuncLog is where runc writes its own diagnostics when running the container
+// of the process.
+func (c *BPMConfig) RuncLog() bosh.Path {
+	return c.LogDir().Join(fmt.Sprintf("%s.runc.log", c.procName))
+}
+
+// SpecRecord is where the runc spec of a process with record_spec enabled is
+// kept. Unlike the bundle it outlives the container.
+func (c *BPMConfig) SpecRecord() bosh.Path {
+	return c.LogDir().Join(fmt.Sprintf("%s.spec.json", c.procName))
+}
+
+// StdoutFifo is the named pipe the stdout of the process is written to when
+// it is configured to log to named pipes.
+func (c *BPMConfig) StdoutFifo() bosh.Path {
+	return c.SocketDir().Join(fmt.Sprintf("%s.stdout.fifo", c.procName))
+}
+
+// StderrFifo is the named pipe the stderr of the process is written to when
+// it is configured to log to named pipes.
+func (c *BPMConfig) StderrFifo() bosh.Path {
+	return c.SocketDir().Join(fmt.Sprintf("%s.stderr.fifo", c.procName))
+}
+
 func (c *BPMConfig) PidDir() bosh.Path {
 	return c.boshEnv.RunDir("bpm").Join(c.JobName())
 }
@@ -114,8 +173,12 @@ func (c *BPMConfig) JobDir() bosh.Path {
 	return c.boshEnv.JobDir(c.JobName())
 }
 
+func (c *BPMConfig) JobConfigDir() bosh.Path {
+	return c.JobDir().Join("config")
+}
+
 func (c *BPMConfig) JobConfig() string {
-	return c.JobDir().Join(filepath.Join("config", "bpm.yml")).External()
+	return c.JobConfigDir().Join("bpm.yml").External()
 }
 
 func (c *BPMConfig) TiniPath() bosh.Path {
@@ -148,11 +211,76 @@ func (c *BPMConfig) BundlePath() string {
 	return filepath.Join(BundlesRoot(c.boshEnv), c.jobName, c.procName)
 }
 
+// StartedConfigPath is where the configuration a process was started with is
+// kept for as long as its bundle exists so that bpm diff can compare it with
+// the current configuration.
+func (c *BPMConfig) StartedConfigPath() string {
+	return filepath.Join(c.BundlePath(), "bpm.yml")
+}
+
+// NsswitchConfPath is where the nsswitch.conf of a process which overrides it
+// is written before being mounted into the container.
+func (c *BPMConfig) NsswitchConfPath() string {
+	return filepath.Join(c.BundlePath(), "nsswitch.conf")
+}
+
 func (c *BPMConfig) RootFSPath() string {
 	return filepath.Join(c.BundlePath(), "rootfs")
 }
 
+// SetContainerID makes the container of the process be called id rather than
+// the name derived from the job and process.
+func (c *BPMConfig) SetContainerID(id string) {
+	c.containerID = id
+}
+
+// HasCustomContainerID reports whether the container of the process has been
+// given a name with SetContainerID.
+func (c *BPMConfig) HasCustomContainerID() bool {
+	return c.containerID != ""
+}
+
+// SetRestarts records how many times in a row the process has been started
+// again by its supervisor after failing its liveness check.
+func (c *BPMConfig) SetRestarts(n int) {
+	c.restarts = n
+}
+
+// Restarts is how many times in a row the process has been started again by
+// its supervisor. It is zero for a process started by an operator.
+func (c *BPMConfig) Restarts() int {
+	return c.restarts
+}
+
+// ForProcess returns the configuration of another process of the same job
+// which keeps the restart count given on the command line. A custom container
+// id is not kept as it only names the container of one process.
+func (c *BPMConfig) ForProcess(procName string) *BPMConfig {
+	return &BPMConfig{
+		jobName:  c.jobName,
+		procName: procName,
+		restarts: c.restarts,
+		boshEnv:  c.boshEnv,
+	}
+}
+
+var validContainerID = regexp.MustCompile(`^[\w+.-]+$`)
+
+// ValidateContainerID checks that id can be used as the name of a container
+// by runc.
+func ValidateContainerID(id string) error {
+	if !validContainerID.MatchString(id) || id == "." || id == ".." {
+		return fmt.Errorf("invalid container id %q: must only contain letters, digits, underscores, plus signs, dots and dashes", id)
+	}
+
+	return nil
+}
+
 func (c *BPMConfig) ContainerID() string {
+	if c.containerID != "" {
+		return c.containerID
+	}
+
 	var containerID string
 
 	if c.jobName == c.procName {
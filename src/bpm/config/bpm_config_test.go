@@ -56,6 +56,52 @@ var _ = Describe("Config", func() {
 					Expect(decoded).To(Equal("foo.bar"))
 				})
 			})
+
+			Context("when the container has been given an id", func() {
+				BeforeEach(func() {
+					env := bosh.NewEnv("")
+					bpmCfg = config.NewBPMConfig(env, "foo", "bar")
+					bpmCfg.SetContainerID("external-foo_1")
+				})
+
+				It("uses the id as it is", func() {
+					Expect(bpmCfg.HasCustomContainerID()).To(BeTrue())
+					Expect(bpmCfg.ContainerID()).To(Equal("external-foo_1"))
+				})
+			})
+
+			It("does not have a custom id by default", func() {
+				bpmCfg = config.NewBPMConfig(bosh.NewEnv(""), "foo", "bar")
+				Expect(bpmCfg.HasCustomContainerID()).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("ForProcess", func() {
+		It("keeps the job and restart count but not a custom container id", func() {
+			bpmCfg := config.NewBPMConfig(bosh.NewEnv(""), "foo", "bar")
+			bpmCfg.SetRestarts(2)
+			bpmCfg.SetContainerID("external-foo_1")
+
+			replicaCfg := bpmCfg.ForProcess("bar-0")
+			Expect(replicaCfg.JobName()).To(Equal("foo"))
+			Expect(replicaCfg.ProcName()).To(Equal("bar-0"))
+			Expect(replicaCfg.Restarts()).To(Equal(2))
+			Expect(replicaCfg.HasCustomContainerID()).To(BeFalse())
+
+			Expect(bpmCfg.ProcName()).To(Equal("bar"))
+		})
+	})
+
+	Describe("ValidateContainerID", func() {
+		It("accepts the ids runc accepts", func() {
+			Expect(config.ValidateContainerID("external.foo-bar_1+2")).To(Succeed())
+		})
+
+		It("rejects ids runc would reject", func() {
+			for _, id := range []string{"", ".", "..", "foo/bar", "foo bar", "../foo"} {
+				Expect(config.ValidateContainerID(id)).To(MatchError(ContainSubstring("invalid container id")), id)
+			}
 		})
 	})
 })
@@ -0,0 +1,92 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// EnvSource is a command which bpm runs when the process starts to fetch
+// environment variables for it, for example from a local agent. The command
+// writes one KEY=VALUE line to stdout for each variable.
+type EnvSource struct {
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args,omitempty"`
+}
+
+func (s EnvSource) Validate() error {
+	if !filepath.IsAbs(s.Command) {
+		return fmt.Errorf("invalid env_from: command must be an absolute path: %q", s.Command)
+	}
+
+	return nil
+}
+
+// AddEnvFromEnv runs each env_from command of the process in turn with fetch
+// and adds the variables it outputs to the environment of the process.
+// Variables set explicitly in env take precedence and later commands do not
+// override earlier ones. Empty lines and lines starting with # are ignored.
+func (c *ProcessConfig) AddEnvFromEnv(fetch func(EnvSource) ([]byte, error)) error {
+	for _, source := range c.EnvFrom {
+		out, err := fetch(source)
+		if err != nil {
+			return fmt.Errorf("failed to fetch environment from %s: %s", source.Command, err)
+		}
+
+		fetched, err := parseEnvLines(out)
+		if err != nil {
+			return fmt.Errorf("failed to parse environment from %s: %s", source.Command, err)
+		}
+
+		for _, kv := range fetched {
+			if _, ok := c.Env[kv[0]]; ok {
+				continue
+			}
+
+			if c.Env == nil {
+				c.Env = map[string]string{}
+			}
+			c.Env[kv[0]] = kv[1]
+		}
+	}
+
+	return nil
+}
+
+func parseEnvLines(out []byte) ([][2]string, error) {
+	var env [][2]string
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("line %d is not of the form KEY=VALUE", n)
+		}
+
+		env = append(env, [2]string{parts[0], parts[1]})
+	}
+
+	return env, scanner.Err()
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/bosh"
+	"bpm/config"
+)
+
+var _ = Describe("EnvFrom", func() {
+	var (
+		cfg     *config.ProcessConfig
+		outputs map[string]string
+	)
+
+	fetch := func(source config.EnvSource) ([]byte, error) {
+		out, ok := outputs[source.Command]
+		if !ok {
+			return nil, errors.New("exit status 1")
+		}
+		return []byte(out), nil
+	}
+
+	BeforeEach(func() {
+		outputs = map[string]string{}
+		cfg = &config.ProcessConfig{
+			Name:       "example",
+			Executable: "/bin/sleep",
+			EnvFrom:    []config.EnvSource{{Command: "/bin/fetch-env"}},
+		}
+	})
+
+	It("adds the variables the command outputs", func() {
+		outputs["/bin/fetch-env"] = "# from the agent\nDB_HOST=10.0.0.1\n\nDB_URL=postgres://u:p@h/db?a=b\n"
+
+		Expect(cfg.AddEnvFromEnv(fetch)).To(Succeed())
+		Expect(cfg.Env).To(Equal(map[string]string{
+			"DB_HOST": "10.0.0.1",
+			"DB_URL":  "postgres://u:p@h/db?a=b",
+		}))
+	})
+
+	It("does not override variables which are already set", func() {
+		cfg.Env = map[string]string{"DB_HOST": "explicit"}
+		cfg.EnvFrom = append(cfg.EnvFrom, config.EnvSource{Command: "/bin/fetch-more-env"})
+		outputs["/bin/fetch-env"] = "DB_HOST=10.0.0.1\nDB_PORT=5432\n"
+		outputs["/bin/fetch-more-env"] = "DB_PORT=6543\n"
+
+		Expect(cfg.AddEnvFromEnv(fetch)).To(Succeed())
+		Expect(cfg.Env).To(Equal(map[string]string{"DB_HOST": "explicit", "DB_PORT": "5432"}))
+	})
+
+	It("returns an error when the command fails", func() {
+		cfg.EnvFrom = []config.EnvSource{{Command: "/bin/false"}}
+		Expect(cfg.AddEnvFromEnv(fetch)).To(MatchError("failed to fetch environment from /bin/false: exit status 1"))
+	})
+
+	It("returns an error when a line is not a variable", func() {
+		outputs["/bin/fetch-env"] = "DB_HOST=10.0.0.1\nnonsense\n"
+		Expect(cfg.AddEnvFromEnv(fetch)).To(MatchError(ContainSubstring("line 2 is not of the form KEY=VALUE")))
+	})
+
+	It("requires the command to be an absolute path", func() {
+		cfg.EnvFrom = []config.EnvSource{{Command: "fetch-env"}}
+		Expect(cfg.Validate(bosh.NewEnv("/var/vcap"), nil)).To(MatchError(ContainSubstring("command must be an absolute path")))
+	})
+})
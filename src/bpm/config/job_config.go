@@ -19,9 +19,14 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"code.cloudfoundry.org/bytefmt"
 	yaml "gopkg.in/yaml.v2"
 
 	"bpm/bosh"
@@ -36,38 +41,767 @@ type ProcessConfig struct {
 	Executable        string            `yaml:"executable"`
 	Args              []string          `yaml:"args"`
 	Env               map[string]string `yaml:"env"`
+	PassEnv           []string          `yaml:"pass_env"`
+	EnvFrom           []EnvSource       `yaml:"env_from,omitempty"`
+	SensitiveEnv      []string          `yaml:"sensitive_env"`
+	Links             *Links            `yaml:"links"`
+	PropertiesFile    string            `yaml:"properties_file"`
 	AdditionalVolumes []Volume          `yaml:"additional_volumes"`
 	Capabilities      []string          `yaml:"capabilities"`
+	CoreDumps         bool              `yaml:"core_dumps"`
+	EnableFuse        bool              `yaml:"enable_fuse,omitempty"`
 	EphemeralDisk     bool              `yaml:"ephemeral_disk"`
+	DataMountOptions  []string          `yaml:"data_mount_options"`
+	FreezeOnStop      bool              `yaml:"freeze_on_stop,omitempty"`
 	Hooks             *Hooks            `yaml:"hooks,omitempty"`
+	Hostname          HostnameMode      `yaml:"hostname,omitempty"`
+	IONice            *IONice           `yaml:"ionice,omitempty"`
 	Limits            *Limits           `yaml:"limits"`
-	PersistentDisk    bool              `yaml:"persistent_disk"`
+	ListenSockets     []ListenSocket    `yaml:"listen_sockets,omitempty"`
+	Liveness          *Liveness         `yaml:"liveness"`
+	Logs              *Logs             `yaml:"logs"`
+	MinUptime         string            `yaml:"min_uptime,omitempty"`
+	NetworkMode       NetworkMode       `yaml:"network_mode,omitempty"`
+	Notify            *Notify           `yaml:"notify,omitempty"`
+	NsswitchConf      string            `yaml:"nsswitch_conf"`
+	Oneshot           bool              `yaml:"oneshot,omitempty"`
+	PersistentDisk    DiskMode          `yaml:"persistent_disk"`
+	RecordSpec        bool              `yaml:"record_spec,omitempty"`
+	Replicas          int               `yaml:"replicas"`
+	ReplicaStopDelay  string            `yaml:"replica_stop_delay,omitempty"`
+	RootFS            string            `yaml:"rootfs"`
+	SELinuxLabel      string            `yaml:"selinux_label"`
+	ShmSize           string            `yaml:"shm_size"`
+	StartupTimeout    string            `yaml:"startup_timeout"`
+	StopSignals       []StopSignal      `yaml:"stop_signals"`
+	UserNamespace     *UserNamespace    `yaml:"user_namespace,omitempty"`
+	WaitForMount      *WaitForMount     `yaml:"wait_for_mount"`
 	WorkDir           string            `yaml:"workdir"`
 	Unsafe            *Unsafe           `yaml:"unsafe"`
+
+	// ReplicaOf is the name of the process this process is a replica of. It
+	// is set when the replicas of a process are expanded.
+	ReplicaOf string `yaml:"-"`
+
+	// ReplicaIndex is the index of the replica among the replicas of the
+	// process it is a replica of.
+	ReplicaIndex int `yaml:"-"`
 }
 
+// RedactedValue replaces the values of the variables named in sensitive_env
+// wherever bpm shows or records the environment of a process.
+const RedactedValue = "<redacted>"
+
 type Limits struct {
-	Memory    *string `yaml:"memory"`
-	OpenFiles *uint64 `yaml:"open_files"`
-	Processes *int64  `yaml:"processes"`
+	Cpus              *float64 `yaml:"cpus"`
+	CPUCountEnv       string   `yaml:"cpu_count_env"`
+	Cpuset            *Cpuset  `yaml:"cpuset"`
+	KernelMemory      *string  `yaml:"kernel_memory"`
+	MaxFileSize       *string  `yaml:"max_file_size"`
+	Memory            *string  `yaml:"memory"`
+	MemorySwap        *string  `yaml:"memory_swap"`
+	MemoryWarnPercent *int     `yaml:"memory_warn_percent"`
+	NetBandwidth      *string  `yaml:"net_bandwidth"`
+	OpenFiles         *uint64  `yaml:"open_files"`
+	Processes         *int64   `yaml:"processes"`
+}
+
+// MemoryWarnPercent is the percentage of its memory limit past which the
+// supervisor of the process warns that it is running out of memory. It is
+// zero when no warning is wanted.
+func (c *ProcessConfig) MemoryWarnPercent() int {
+	if c.Limits == nil || c.Limits.MemoryWarnPercent == nil {
+		return 0
+	}
+
+	return *c.Limits.MemoryWarnPercent
+}
+
+// limitIsSet reports, for each limit by its name in the configuration,
+// whether it has been set.
+var limitIsSet = map[string]func(*Limits) bool{
+	"cpus":          func(l *Limits) bool { return l.Cpus != nil },
+	"cpuset":        func(l *Limits) bool { return l.Cpuset != nil },
+	"kernel_memory": func(l *Limits) bool { return l.KernelMemory != nil },
+	"max_file_size": func(l *Limits) bool { return l.MaxFileSize != nil },
+	"memory":        func(l *Limits) bool { return l.Memory != nil },
+	"memory_swap":   func(l *Limits) bool { return l.MemorySwap != nil },
+	"net_bandwidth": func(l *Limits) bool { return l.NetBandwidth != nil },
+	"open_files":    func(l *Limits) bool { return l.OpenFiles != nil },
+	"processes":     func(l *Limits) bool { return l.Processes != nil },
+}
+
+// UnsetLimits returns those of the named limits, such as memory or
+// processes, which the process does not set. It is an error to name a limit
+// which does not exist.
+func (c *ProcessConfig) UnsetLimits(names []string) ([]string, error) {
+	limits := c.Limits
+	if limits == nil {
+		limits = &Limits{}
+	}
+
+	var unset []string
+	for _, name := range names {
+		isSet, ok := limitIsSet[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown limit %q", name)
+		}
+
+		if !isSet(limits) {
+			unset = append(unset, name)
+		}
+	}
+
+	return unset, nil
+}
+
+// CPUCount is the number of CPUs the process can run on at once given its
+// cpus and cpuset limits, rounded up to a whole CPU. It is zero when neither
+// is set.
+func (l *Limits) CPUCount() int {
+	if l == nil {
+		return 0
+	}
+
+	var count int
+	if l.Cpus != nil {
+		count = int(math.Ceil(*l.Cpus))
+	}
+
+	if l.Cpuset != nil {
+		if n, err := cpuListSize(l.Cpuset.Cpus); err == nil && (count == 0 || n < count) {
+			count = n
+		}
+	}
+
+	return count
+}
+
+// Cpuset pins the process to a set of CPUs and, optionally, memory nodes.
+// Both are lists such as "0-3,6" in the format used by the cpuset cgroup.
+type Cpuset struct {
+	Cpus string `yaml:"cpus"`
+	Mems string `yaml:"mems"`
+}
+
+// ListenSocket is a socket which bpm binds and passes to the process in the
+// style of systemd socket activation.
+type ListenSocket struct {
+	Name    string `yaml:"name"`
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+}
+
+// ListenNetwork is the network the socket is bound on. It defaults to tcp.
+func (s ListenSocket) ListenNetwork() string {
+	if s.Network == "" {
+		return "tcp"
+	}
+
+	return s.Network
+}
+
+var validListenNetworks = []string{"tcp", "tcp4", "tcp6", "unix"}
+
+func (s ListenSocket) Validate() error {
+	if !contains(validListenNetworks, s.ListenNetwork()) {
+		return fmt.Errorf("invalid listen socket network: %q", s.Network)
+	}
+
+	if s.Address == "" {
+		return errors.New("invalid listen socket: address is required")
+	}
+
+	if s.ListenNetwork() == "unix" && !filepath.IsAbs(s.Address) {
+		return fmt.Errorf("invalid listen socket address: %q must be an absolute path", s.Address)
+	}
+
+	if strings.Contains(s.Name, ":") {
+		return fmt.Errorf("invalid listen socket name: %q must not contain a colon", s.Name)
+	}
+
+	return nil
 }
 
 type Hooks struct {
-	PreStart string `yaml:"pre_start"`
+	PreStart        string `yaml:"pre_start"`
+	PreStartUser    string `yaml:"pre_start_user"`
+	PreStartTimeout string `yaml:"pre_start_timeout"`
+
+	PostStart               string `yaml:"post_start"`
+	PostStartInContainer    bool   `yaml:"post_start_in_container"`
+	PostStartAbortOnFailure bool   `yaml:"post_start_abort_on_failure"`
+	PostStartTimeout        string `yaml:"post_start_timeout"`
+
+	Drain string `yaml:"drain"`
+}
+
+// PreStartDeadline is how long the pre-start hook may run before it is
+// killed. A hook without a timeout may run for as long as it likes and is
+// given a deadline of zero.
+func (h *Hooks) PreStartDeadline() time.Duration {
+	timeout, _ := time.ParseDuration(h.PreStartTimeout)
+	return timeout
+}
+
+// PostStartDeadline is how long the post-start hook may run before it is
+// killed, or zero if it may run for as long as it likes.
+func (h *Hooks) PostStartDeadline() time.Duration {
+	timeout, _ := time.ParseDuration(h.PostStartTimeout)
+	return timeout
+}
+
+// Liveness configures a heartbeat file which the process must keep touching
+// while it is healthy. Every interval in a row for which the heartbeat file
+// has not been modified is a failure and a process which reaches the failure
+// threshold is killed.
+type Liveness struct {
+	HeartbeatFile    string `yaml:"heartbeat_file"`
+	Interval         string `yaml:"interval"`
+	FailureThreshold int    `yaml:"failure_threshold,omitempty"`
+	Restart          bool   `yaml:"restart,omitempty"`
+	MaxRestarts      int    `yaml:"max_restarts,omitempty"`
+}
+
+// Failures is how many intervals in a row the heartbeat may be missed before
+// the process is killed. It defaults to one.
+func (l *Liveness) Failures() int {
+	if l.FailureThreshold > 0 {
+		return l.FailureThreshold
+	}
+
+	return 1
+}
+
+// HostHeartbeatFile is the path of the heartbeat file, which is given as seen
+// from inside the container, on the host.
+func (l *Liveness) HostHeartbeatFile(boshEnv *bosh.Env) string {
+	rel := strings.TrimPrefix(l.HeartbeatFile, boshEnv.Root().Internal())
+	return filepath.Join(boshEnv.Root().External(), rel)
+}
+
+// Supervised reports whether the process needs a supervisor watching over it
+// while it runs.
+func (c *ProcessConfig) Supervised() bool {
+	return c.Liveness != nil || c.MemoryWarnPercent() > 0
+}
+
+// Logs configures how the output of the process is written to its log
+// files.
+type Logs struct {
+	LineBuffered bool         `yaml:"line_buffered"`
+	Fifo         bool         `yaml:"fifo"`
+	Prefix       string       `yaml:"prefix"`
+	Rotate       *LogRotation `yaml:"rotate,omitempty"`
+	MergeStderr  bool         `yaml:"merge_stderr"`
+	Filter       string       `yaml:"filter"`
+	StdoutFile   string       `yaml:"stdout_file,omitempty"`
+	StderrFile   string       `yaml:"stderr_file,omitempty"`
+
+	// ChownExisting makes bpm give the existing log files of the process,
+	// including its rotated ones, to the user the process runs as.
+	ChownExisting bool `yaml:"chown_existing,omitempty"`
+}
+
+// DefaultLogRotationKeep is how many rotated log files are kept when no count
+// is configured.
+const DefaultLogRotationKeep = 5
+
+// LogRotation configures the rotation of the log files of the process once
+// they grow past a size.
+type LogRotation struct {
+	MaxSize string `yaml:"max_size"`
+	Keep    int    `yaml:"keep"`
+}
+
+// MaxBytes is the size past which a log file is rotated.
+func (r *LogRotation) MaxBytes() uint64 {
+	size, err := bytefmt.ToBytes(r.MaxSize)
+	if err != nil {
+		return 0
+	}
+
+	return size
 }
 
+// KeepCount is how many rotated log files are kept alongside the current one.
+func (r *LogRotation) KeepCount() int {
+	if r.Keep == 0 {
+		return DefaultLogRotationKeep
+	}
+
+	return r.Keep
+}
+
+func (r *LogRotation) Validate() error {
+	if r.MaxSize == "" {
+		return errors.New("invalid logs rotate: max_size is required")
+	}
+
+	if _, err := bytefmt.ToBytes(r.MaxSize); err != nil {
+		return fmt.Errorf("invalid logs rotate: max_size: %s", err)
+	}
+
+	if r.Keep < 0 {
+		return errors.New("invalid logs rotate: keep must not be negative")
+	}
+
+	return nil
+}
+
+// LineBufferedLogs reports whether only whole lines of output are written to the
+// log files of the process. By default output is written as it arrives.
+func (c *ProcessConfig) LineBufferedLogs() bool {
+	return c.Logs != nil && c.Logs.LineBuffered
+}
+
+// MergedLogs reports whether the stderr of the process is written to the same
+// log file as its stdout rather than to a log file of its own.
+func (c *ProcessConfig) MergedLogs() bool {
+	return c.Logs != nil && c.Logs.MergeStderr
+}
+
+// ChownExistingLogs reports whether the stdout and stderr log files of the
+// process which already exist, and the files rotated from them, are given to
+// the user the process runs as before it starts.
+func (c *ProcessConfig) ChownExistingLogs() bool {
+	return c.Logs != nil && c.Logs.ChownExisting
+}
+
+// FifoLogs reports whether the output of the process is written to named pipes
+// in its job run directory rather than to its log files.
+func (c *ProcessConfig) FifoLogs() bool {
+	return c.Logs != nil && c.Logs.Fifo
+}
+
+// LogPrefix is written at the start of every line of output in the log files
+// of the process. By default output is written unchanged.
+func (c *ProcessConfig) LogPrefix() string {
+	if c.Logs == nil {
+		return ""
+	}
+
+	return c.Logs.Prefix
+}
+
+// LogFilter is the path of a command which the output of the process is piped
+// through before it is written to its log files. By default output is written
+// unchanged.
+func (c *ProcessConfig) LogFilter() string {
+	if c.Logs == nil {
+		return ""
+	}
+
+	return c.Logs.Filter
+}
+
+// StdoutLogFile is the name of the file in the log directory of the job which
+// the stdout of the process is written to. It is empty when the default name
+// is used.
+func (c *ProcessConfig) StdoutLogFile() string {
+	if c.Logs == nil {
+		return ""
+	}
+
+	return c.Logs.StdoutFile
+}
+
+// StderrLogFile is the name of the file in the log directory of the job which
+// the stderr of the process is written to. It is empty when the default name
+// is used.
+func (c *ProcessConfig) StderrLogFile() string {
+	if c.Logs == nil {
+		return ""
+	}
+
+	return c.Logs.StderrFile
+}
+
+// LogRotation configures the rotation of the log files of the process. It is
+// nil when the log files are never rotated, which is the default.
+func (c *ProcessConfig) LogRotation() *LogRotation {
+	if c.Logs == nil {
+		return nil
+	}
+
+	return c.Logs.Rotate
+}
+
+// validateLogFile checks that a configured log file name stays directly inside
+// the log directory of the job and does not clash with the log of bpm itself.
+func validateLogFile(option, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("invalid config: logs %s: %q must be a file name within the log directory", option, name)
+	}
+
+	if name == "bpm.log" {
+		return fmt.Errorf("invalid config: logs %s: %q is the log file of bpm", option, name)
+	}
+
+	return nil
+}
+
+// validateLogFilter checks that a configured log filter is an executable
+// file given by its absolute path as it is run without a lookup in PATH.
+func validateLogFilter(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("invalid config: logs filter must be an absolute path: %q", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("invalid config: logs filter: %s", err)
+	}
+
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("invalid config: logs filter must be an executable file: %q", path)
+	}
+
+	return nil
+}
+
+// DefaultStartupTimeout is how long a process may take to start when no
+// startup_timeout is configured.
+const DefaultStartupTimeout = 5 * time.Minute
+
+// StartupDeadline is how long the container of the process may take to start
+// running before the start is aborted.
+func (c *ProcessConfig) StartupDeadline() time.Duration {
+	if c.StartupTimeout == "" {
+		return DefaultStartupTimeout
+	}
+
+	timeout, _ := time.ParseDuration(c.StartupTimeout)
+	return timeout
+}
+
+// MinUptimeDuration is how long the process must have been up for bpm start
+// to succeed. bpm start does not wait for it when it is zero.
+func (c *ProcessConfig) MinUptimeDuration() time.Duration {
+	if c.MinUptime == "" {
+		return 0
+	}
+
+	uptime, _ := time.ParseDuration(c.MinUptime)
+	return uptime
+}
+
+// WaitForMount makes bpm start wait until a mount point, such as that of the
+// persistent disk, has been mounted before it starts the process.
+type WaitForMount struct {
+	Path    string `yaml:"path"`
+	Timeout string `yaml:"timeout"`
+}
+
+// DefaultMountWaitTimeout is how long bpm start waits for a mount when no
+// timeout is configured.
+const DefaultMountWaitTimeout = time.Minute
+
+// WaitTimeout is how long bpm start waits for the mount to appear before
+// giving up.
+func (w *WaitForMount) WaitTimeout() time.Duration {
+	if w.Timeout == "" {
+		return DefaultMountWaitTimeout
+	}
+
+	timeout, _ := time.ParseDuration(w.Timeout)
+	return timeout
+}
+
+// Notify tells a supervisor outside of bpm that the process is up once bpm
+// start has seen its container running. The file is created and the socket
+// is sent READY=1 in the style of sd_notify.
+type Notify struct {
+	File   string `yaml:"file"`
+	Socket string `yaml:"socket"`
+}
+
+func (n *Notify) Validate() error {
+	if n.File == "" && n.Socket == "" {
+		return errors.New("invalid notify: one of file or socket is required")
+	}
+
+	if n.File != "" && !filepath.IsAbs(n.File) {
+		return fmt.Errorf("invalid notify file: %q must be an absolute path", n.File)
+	}
+
+	if n.Socket != "" && !filepath.IsAbs(n.Socket) {
+		return fmt.Errorf("invalid notify socket: %q must be an absolute path", n.Socket)
+	}
+
+	return nil
+}
+
+// IONice is the I/O scheduling class, and the level within it, which the
+// process is started with in the manner of ionice.
+type IONice struct {
+	Class string `yaml:"class"`
+	Level *int   `yaml:"level"`
+}
+
+const (
+	IONiceRealtime   = "realtime"
+	IONiceBestEffort = "best-effort"
+	IONiceIdle       = "idle"
+)
+
+// DefaultIONiceLevel is the level within the realtime and best-effort
+// classes when none is configured. It is the level a process gets from the
+// kernel by default.
+const DefaultIONiceLevel = 4
+
+// IOLevel is the level within the class of the process. Processes in the idle
+// class have no level.
+func (n *IONice) IOLevel() int {
+	if n.Class == IONiceIdle {
+		return 0
+	}
+
+	if n.Level == nil {
+		return DefaultIONiceLevel
+	}
+
+	return *n.Level
+}
+
+func (n *IONice) Validate() error {
+	switch n.Class {
+	case IONiceRealtime, IONiceBestEffort:
+	case IONiceIdle:
+		if n.Level != nil {
+			return errors.New("invalid config: ionice: level cannot be used with the idle class")
+		}
+	default:
+		return fmt.Errorf("invalid config: ionice: class %q must be realtime, best-effort or idle", n.Class)
+	}
+
+	if n.Level != nil && (*n.Level < 0 || *n.Level > 7) {
+		return fmt.Errorf("invalid config: ionice: level %d must be between 0 and 7", *n.Level)
+	}
+
+	return nil
+}
+
+// UserNamespace runs the process in a user namespace of its own. The ids
+// from zero upwards in the namespace are mapped to those from HostUID and
+// HostGID upwards on the host, so the process runs as root in the namespace
+// but as an unprivileged user on the host.
+type UserNamespace struct {
+	HostUID uint32 `yaml:"host_uid"`
+	HostGID uint32 `yaml:"host_gid"`
+	Size    uint32 `yaml:"size"`
+}
+
+// DefaultUserNamespaceSize is how many ids are mapped into a user namespace
+// when no size is configured.
+const DefaultUserNamespaceSize = 65536
+
+// MappingSize is how many uids and gids are mapped into the namespace.
+func (n *UserNamespace) MappingSize() uint32 {
+	if n.Size == 0 {
+		return DefaultUserNamespaceSize
+	}
+
+	return n.Size
+}
+
+func (n *UserNamespace) Validate() error {
+	if n.HostUID == 0 {
+		return errors.New("invalid config: user_namespace: host_uid must not be root")
+	}
+
+	if n.HostGID == 0 {
+		return errors.New("invalid config: user_namespace: host_gid must not be root")
+	}
+
+	if uint64(n.HostUID)+uint64(n.MappingSize()) > math.MaxUint32 {
+		return fmt.Errorf("invalid config: user_namespace: %d ids from host_uid %d exceed the largest uid", n.MappingSize(), n.HostUID)
+	}
+
+	if uint64(n.HostGID)+uint64(n.MappingSize()) > math.MaxUint32 {
+		return fmt.Errorf("invalid config: user_namespace: %d ids from host_gid %d exceed the largest gid", n.MappingSize(), n.HostGID)
+	}
+
+	return nil
+}
+
+type StopSignal struct {
+	Signal string `yaml:"signal"`
+	Wait   string `yaml:"wait"`
+}
+
+var validStopSignals = []string{"TERM", "INT", "QUIT", "HUP", "USR1", "USR2", "KILL"}
+
 type Volume struct {
 	Path            string `yaml:"path"`
 	Writable        bool   `yaml:"writable"`
 	AllowExecutions bool   `yaml:"allow_executions"`
 	MountOnly       bool   `yaml:"mount_only"`
 	Shared          bool   `yaml:"shared"`
+	Create          bool   `yaml:"create"`
+	CreateMode      string `yaml:"create_mode"`
+}
+
+// DefaultCreateMode is the mode of the directory made for a volume with
+// create set when no create_mode is given.
+const DefaultCreateMode os.FileMode = 0700
+
+// CreateFileMode is the mode the directory of the volume is made with.
+func (v Volume) CreateFileMode() os.FileMode {
+	if v.CreateMode == "" {
+		return DefaultCreateMode
+	}
+
+	mode, _ := strconv.ParseUint(v.CreateMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+func (v Volume) Validate() error {
+	if v.CreateMode != "" {
+		if !v.Create {
+			return fmt.Errorf("invalid volume %s: create_mode requires create", v.Path)
+		}
+
+		if mode, err := strconv.ParseUint(v.CreateMode, 8, 32); err != nil || mode > 0777 {
+			return fmt.Errorf("invalid volume %s: create_mode %q must be an octal permission such as 0750", v.Path, v.CreateMode)
+		}
+	}
+
+	if v.Create && strings.ContainsAny(v.Path, "*?[") {
+		return fmt.Errorf("invalid volume %s: create cannot be used with a glob", v.Path)
+	}
+
+	return nil
+}
+
+// DiskMode is how a disk is mounted into the container. It is empty when the
+// disk is not mounted at all.
+type DiskMode string
+
+const (
+	DiskModeReadWrite DiskMode = "rw"
+	DiskModeReadOnly  DiskMode = "ro"
+)
+
+// UnmarshalYAML accepts a boolean, where true is the same as "rw", as well
+// as the name of a mode. Configurations written before there was a choice of
+// mode keep working as they did.
+func (m *DiskMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		if enabled {
+			*m = DiskModeReadWrite
+		} else {
+			*m = ""
+		}
+		return nil
+	}
+
+	var mode string
+	if err := unmarshal(&mode); err != nil {
+		return err
+	}
+
+	*m = DiskMode(mode)
+	return nil
+}
+
+func (m DiskMode) Enabled() bool {
+	return m != ""
+}
+
+func (m DiskMode) ReadOnly() bool {
+	return m == DiskModeReadOnly
+}
+
+func (m DiskMode) Validate() error {
+	switch m {
+	case "", DiskModeReadWrite, DiskModeReadOnly:
+		return nil
+	default:
+		return fmt.Errorf("invalid disk mode: %q must be ro or rw", string(m))
+	}
+}
+
+// NetworkMode is the network namespace the process runs in. It is empty when
+// the process shares the network of the host, which is the same as host.
+type NetworkMode string
+
+const (
+	NetworkModeHost     NetworkMode = "host"
+	NetworkModeIsolated NetworkMode = "isolated"
+	NetworkModeNone     NetworkMode = "none"
+)
+
+// Isolated reports whether the process gets a network namespace of its own.
+// bpm does not set up any interfaces in it so the process can only reach
+// itself over the loopback interface, which makes isolated the same as none.
+func (m NetworkMode) Isolated() bool {
+	return m == NetworkModeIsolated || m == NetworkModeNone
+}
+
+func (m NetworkMode) Validate() error {
+	switch m {
+	case "", NetworkModeHost, NetworkModeIsolated, NetworkModeNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid network mode: %q must be host, isolated or none", string(m))
+	}
+}
+
+// HostnameMode is where the hostname of the container comes from. It is empty
+// when the process sees the hostname of the host, which is the same as host.
+type HostnameMode string
+
+const (
+	HostnameHost     HostnameMode = "host"
+	HostnameInstance HostnameMode = "instance"
+)
+
+func (m HostnameMode) Validate() error {
+	switch m {
+	case "", HostnameHost, HostnameInstance:
+		return nil
+	default:
+		return fmt.Errorf("invalid hostname: %q must be host or instance", string(m))
+	}
 }
 
+var validDataMountOptions = []string{"exec", "noexec", "nosuid", "nodev", "ro", "rw"}
+
 type Unsafe struct {
 	Privileged          bool     `yaml:"privileged"`
 	UnrestrictedVolumes []Volume `yaml:"unrestricted_volumes"`
 	HostPidNamespace    bool     `yaml:"host_pid_namespace"`
+
+	// KeepDefaultCapabilities grants the capabilities runc gives a
+	// container by default rather than none at all.
+	KeepDefaultCapabilities bool `yaml:"keep_default_capabilities"`
+}
+
+// ParseError is returned when a job configuration file is not valid YAML or
+// does not have the expected structure.
+type ParseError struct {
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	// The YAML errors carry the line numbers of the problems, if known.
+	msg := strings.TrimPrefix(e.Err.Error(), "yaml: ")
+	if terr, ok := e.Err.(*yaml.TypeError); ok {
+		msg = strings.Join(terr.Errors, "; ")
+	}
+
+	return fmt.Sprintf("%s is not valid: %s", e.Path, msg)
 }
 
 func ParseJobConfig(configPath string) (*JobConfig, error) {
@@ -80,9 +814,11 @@ func ParseJobConfig(configPath string) (*JobConfig, error) {
 
 	err = yaml.Unmarshal(data, &cfg)
 	if err != nil {
-		return nil, err
+		return nil, &ParseError{Path: configPath, Err: err}
 	}
 
+	cfg.expandReplicas()
+
 	return &cfg, nil
 }
 
@@ -93,6 +829,25 @@ func (c *JobConfig) Validate(boshEnv *bosh.Env, defaultVolumes []string) error {
 		}
 	}
 
+	return c.validateReplicaNames()
+}
+
+// validateReplicaNames checks that no replica has been given the name of a
+// process which is configured explicitly, as both would share a container.
+func (c *JobConfig) validateReplicaNames() error {
+	explicit := map[string]bool{}
+	for _, v := range c.Processes {
+		if v.ReplicaOf == "" {
+			explicit[v.Name] = true
+		}
+	}
+
+	for _, v := range c.Processes {
+		if v.ReplicaOf != "" && explicit[v.Name] {
+			return fmt.Errorf("invalid config: replica %s of process %s has the name of another process", v.Name, v.ReplicaOf)
+		}
+	}
+
 	return nil
 }
 
@@ -125,6 +880,456 @@ func (c *ProcessConfig) Validate(boshEnv *bosh.Env, defaultVolumes []string) err
 				boshEnv.Root().External(),
 			)
 		}
+
+		if err := vol.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Unsafe != nil {
+		for _, vol := range c.Unsafe.UnrestrictedVolumes {
+			if err := vol.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.PersistentDisk.Validate(); err != nil {
+		return fmt.Errorf("invalid config: persistent_disk: %s", err)
+	}
+
+	// A oneshot process has exited by the time bpm start returns so there is
+	// nothing left to supervise or announce.
+	if c.Oneshot {
+		if c.Supervised() {
+			return errors.New("invalid config: oneshot cannot be used with liveness or memory_warn_percent")
+		}
+
+		if c.Notify != nil {
+			return errors.New("invalid config: oneshot cannot be used with notify")
+		}
+
+		if c.MinUptime != "" {
+			return errors.New("invalid config: oneshot cannot be used with min_uptime")
+		}
+	}
+
+	if err := c.NetworkMode.Validate(); err != nil {
+		return fmt.Errorf("invalid config: network_mode: %s", err)
+	}
+
+	if err := c.Hostname.Validate(); err != nil {
+		return fmt.Errorf("invalid config: hostname: %s", err)
+	}
+
+	if len(c.DataMountOptions) > 0 && !c.EphemeralDisk {
+		return errors.New("invalid config: data_mount_options requires ephemeral_disk")
+	}
+
+	for _, option := range c.DataMountOptions {
+		if !contains(validDataMountOptions, option) {
+			return fmt.Errorf("invalid data mount option: %s", option)
+		}
+	}
+
+	if c.RootFS != "" {
+		rootfs := filepath.Clean(c.RootFS)
+		if rootfs != c.RootFS {
+			return fmt.Errorf("rootfs path must be canonical, expected %s but got %s", rootfs, c.RootFS)
+		}
+
+		if !pathIsIn(rootfs, boshEnv.Root().External()) {
+			return fmt.Errorf(
+				"invalid rootfs path: %s must be within %s",
+				c.RootFS,
+				boshEnv.Root().External(),
+			)
+		}
+	}
+
+	if c.ShmSize != "" {
+		if _, err := bytefmt.ToBytes(c.ShmSize); err != nil {
+			return fmt.Errorf("invalid config: shm_size: %s", err)
+		}
+	}
+
+	if c.MinUptime != "" {
+		uptime, err := time.ParseDuration(c.MinUptime)
+		if err != nil {
+			return fmt.Errorf("invalid config: min_uptime: %s", err)
+		}
+
+		if uptime <= 0 {
+			return fmt.Errorf("invalid config: min_uptime: %s must be positive", c.MinUptime)
+		}
+	}
+
+	if c.StartupTimeout != "" {
+		timeout, err := time.ParseDuration(c.StartupTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid config: startup_timeout: %s", err)
+		}
+
+		if timeout <= 0 {
+			return fmt.Errorf("invalid config: startup_timeout: %s must be positive", c.StartupTimeout)
+		}
+	}
+
+	for _, s := range c.StopSignals {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.IONice != nil {
+		if err := c.IONice.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.UserNamespace != nil {
+		if err := c.UserNamespace.Validate(); err != nil {
+			return err
+		}
+
+		// Privileged processes are root on the host and the proc filesystem
+		// of another pid namespace cannot be mounted in a user namespace.
+		if c.Unsafe != nil && c.Unsafe.Privileged {
+			return errors.New("invalid config: user_namespace cannot be used with unsafe privileged")
+		}
+
+		if c.Unsafe != nil && c.Unsafe.HostPidNamespace {
+			return errors.New("invalid config: user_namespace cannot be used with unsafe host_pid_namespace")
+		}
+	}
+
+	if c.Limits != nil {
+		if err := c.Limits.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if rotate := c.LogRotation(); rotate != nil {
+		if c.FifoLogs() {
+			return errors.New("invalid config: logs rotate cannot be used with fifo")
+		}
+
+		// Each stream is rotated on its own and the two rotations of a
+		// shared log file would trip over each other.
+		if c.MergedLogs() {
+			return errors.New("invalid config: logs rotate cannot be used with merge_stderr")
+		}
+
+		if err := rotate.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateLogFilter(c.LogFilter()); err != nil {
+		return err
+	}
+
+	if err := validateLogFile("stdout_file", c.StdoutLogFile()); err != nil {
+		return err
+	}
+
+	if err := validateLogFile("stderr_file", c.StderrLogFile()); err != nil {
+		return err
+	}
+
+	if name := c.StdoutLogFile(); name != "" && name == c.StderrLogFile() {
+		return errors.New("invalid config: logs stdout_file and stderr_file must differ, use merge_stderr to write both to one file")
+	}
+
+	if c.Links != nil {
+		if err := c.Links.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range c.EnvFrom {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range c.ListenSockets {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Hooks != nil {
+		if err := c.Hooks.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Liveness != nil {
+		if err := c.Liveness.Validate(boshEnv); err != nil {
+			return err
+		}
+	}
+
+	if c.Notify != nil {
+		if err := c.Notify.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.WaitForMount != nil {
+		if err := c.WaitForMount.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.PropertiesFile != "" {
+		if err := validatePropertiesFile(c.PropertiesFile); err != nil {
+			return err
+		}
+	}
+
+	if c.Replicas < 0 {
+		return errors.New("invalid config: replicas must not be negative")
+	}
+
+	if c.ReplicaStopDelay != "" {
+		if c.Replicas == 0 && c.ReplicaOf == "" {
+			return errors.New("invalid config: replica_stop_delay requires replicas")
+		}
+
+		delay, err := time.ParseDuration(c.ReplicaStopDelay)
+		if err != nil {
+			return fmt.Errorf("invalid config: replica_stop_delay: %s", err)
+		}
+
+		if delay <= 0 {
+			return fmt.Errorf("invalid config: replica_stop_delay: %s must be positive", c.ReplicaStopDelay)
+		}
+	}
+
+	return nil
+}
+
+func (s StopSignal) Validate() error {
+	if !contains(validStopSignals, strings.TrimPrefix(strings.ToUpper(s.Signal), "SIG")) {
+		return fmt.Errorf("invalid stop signal: %q", s.Signal)
+	}
+
+	wait, err := time.ParseDuration(s.Wait)
+	if err != nil {
+		return fmt.Errorf("invalid stop signal wait: %s", err)
+	}
+
+	if wait <= 0 {
+		return fmt.Errorf("invalid stop signal wait: %s must be positive", s.Wait)
+	}
+
+	return nil
+}
+
+func (h *Hooks) Validate() error {
+	if err := validateHookTimeout("pre_start_timeout", h.PreStartTimeout); err != nil {
+		return err
+	}
+
+	if err := validateHookTimeout("post_start_timeout", h.PostStartTimeout); err != nil {
+		return err
+	}
+
+	// A hook run with runc exec cannot be reliably killed along with
+	// everything it started inside the container.
+	if h.PostStartTimeout != "" && h.PostStartInContainer {
+		return errors.New("invalid hooks: post_start_timeout cannot be used with post_start_in_container")
+	}
+
+	return nil
+}
+
+func validateHookTimeout(name, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid hooks %s: %s", name, err)
+	}
+
+	if timeout <= 0 {
+		return fmt.Errorf("invalid hooks %s: %s must be positive", name, value)
+	}
+
+	return nil
+}
+
+func (l *Liveness) Validate(boshEnv *bosh.Env) error {
+	heartbeatFile := filepath.Clean(l.HeartbeatFile)
+	if heartbeatFile != l.HeartbeatFile {
+		return fmt.Errorf("liveness heartbeat_file must be canonical, expected %s but got %s", heartbeatFile, l.HeartbeatFile)
+	}
+
+	// The file is looked at from outside the container so it must be
+	// somewhere which is also reachable from the host.
+	if !pathIsIn(heartbeatFile, boshEnv.Root().Internal()) {
+		return fmt.Errorf(
+			"invalid liveness heartbeat_file: %s must be within %s",
+			l.HeartbeatFile,
+			boshEnv.Root().Internal(),
+		)
+	}
+
+	interval, err := time.ParseDuration(l.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid liveness interval: %s", err)
+	}
+
+	if interval <= 0 {
+		return fmt.Errorf("invalid liveness interval: %s must be positive", l.Interval)
+	}
+
+	if l.FailureThreshold < 0 {
+		return fmt.Errorf("invalid liveness failure_threshold: %d must not be negative", l.FailureThreshold)
+	}
+
+	if l.MaxRestarts < 0 {
+		return fmt.Errorf("invalid liveness max_restarts: %d must not be negative", l.MaxRestarts)
+	}
+
+	if l.MaxRestarts > 0 && !l.Restart {
+		return errors.New("invalid liveness max_restarts: restart must be enabled")
+	}
+
+	return nil
+}
+
+// cpuListSize checks that list is a comma separated list of numbers and
+// ranges of numbers, e.g. "0-3,6", as accepted by the cpuset cgroup, and
+// returns how many numbers it covers.
+func cpuListSize(list string) (int, error) {
+	if list == "" {
+		return 0, errors.New("must not be empty")
+	}
+
+	var size int
+	for _, item := range strings.Split(list, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+
+		first, err := strconv.ParseUint(bounds[0], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number or range", item)
+		}
+
+		if len(bounds) == 1 {
+			size++
+			continue
+		}
+
+		last, err := strconv.ParseUint(bounds[1], 10, 32)
+		if err != nil || last < first {
+			return 0, fmt.Errorf("%q is not a number or range", item)
+		}
+
+		size += int(last-first) + 1
+	}
+
+	return size, nil
+}
+
+func (w *WaitForMount) Validate() error {
+	if !filepath.IsAbs(w.Path) || filepath.Clean(w.Path) != w.Path {
+		return fmt.Errorf("invalid wait_for_mount path: %q must be an absolute canonical path", w.Path)
+	}
+
+	if w.Timeout == "" {
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(w.Timeout)
+	if err != nil {
+		return fmt.Errorf("invalid wait_for_mount timeout: %s", err)
+	}
+
+	if timeout <= 0 {
+		return fmt.Errorf("invalid wait_for_mount timeout: %s must be positive", w.Timeout)
+	}
+
+	return nil
+}
+
+func (l *Limits) Validate() error {
+	if l.NetBandwidth != nil {
+		if _, err := bytefmt.ToBytes(*l.NetBandwidth); err != nil {
+			return fmt.Errorf("invalid limits: net_bandwidth: %s", err)
+		}
+	}
+
+	if l.KernelMemory != nil {
+		if _, err := bytefmt.ToBytes(*l.KernelMemory); err != nil {
+			return fmt.Errorf("invalid limits: kernel_memory: %s", err)
+		}
+	}
+
+	if l.MaxFileSize != nil {
+		if _, err := bytefmt.ToBytes(*l.MaxFileSize); err != nil {
+			return fmt.Errorf("invalid limits: max_file_size: %s", err)
+		}
+	}
+
+	if l.Cpus != nil && *l.Cpus <= 0 {
+		return fmt.Errorf("invalid limits: cpus: %v must be positive", *l.Cpus)
+	}
+
+	if strings.Contains(l.CPUCountEnv, "=") {
+		return fmt.Errorf("invalid limits: cpu_count_env: %q must not contain =", l.CPUCountEnv)
+	}
+
+	if l.Cpuset != nil {
+		if _, err := cpuListSize(l.Cpuset.Cpus); err != nil {
+			return fmt.Errorf("invalid limits: cpuset cpus: %s", err)
+		}
+
+		if l.Cpuset.Mems != "" {
+			if _, err := cpuListSize(l.Cpuset.Mems); err != nil {
+				return fmt.Errorf("invalid limits: cpuset mems: %s", err)
+			}
+		}
+	}
+
+	if l.MemoryWarnPercent != nil {
+		if l.Memory == nil {
+			return errors.New("invalid limits: memory_warn_percent requires memory to be set")
+		}
+
+		if *l.MemoryWarnPercent < 1 || *l.MemoryWarnPercent > 100 {
+			return fmt.Errorf("invalid limits: memory_warn_percent: %d must be between 1 and 100", *l.MemoryWarnPercent)
+		}
+	}
+
+	if l.MemorySwap == nil {
+		return nil
+	}
+
+	if l.Memory == nil {
+		return errors.New("invalid limits: memory_swap requires memory to be set")
+	}
+
+	memory, err := bytefmt.ToBytes(*l.Memory)
+	if err != nil {
+		return fmt.Errorf("invalid limits: memory: %s", err)
+	}
+
+	memorySwap, err := bytefmt.ToBytes(*l.MemorySwap)
+	if err != nil {
+		return fmt.Errorf("invalid limits: memory_swap: %s", err)
+	}
+
+	if memorySwap < memory {
+		return fmt.Errorf(
+			"invalid limits: memory_swap (%s) must be greater than or equal to memory (%s)",
+			*l.MemorySwap,
+			*l.Memory,
+		)
 	}
 
 	return nil
@@ -158,6 +1363,8 @@ func (c *ProcessConfig) AddVolumes(
 					v.AllowExecutions = true
 				case "shared":
 					v.Shared = true
+				case "create":
+					v.Create = true
 				default:
 					return fmt.Errorf("invalid volume option: %s", option)
 				}
@@ -193,6 +1400,27 @@ func (c *ProcessConfig) AddEnvVars(
 	return c.Validate(boshEnv, defaultVolumes)
 }
 
+// AddPassEnv copies the environment variables named in pass_env from the
+// environment of bpm into the process configuration. Variables which are not
+// present are skipped and variables set explicitly in env take precedence.
+func (c *ProcessConfig) AddPassEnv(lookupEnv func(string) (string, bool)) {
+	for _, name := range c.PassEnv {
+		if _, ok := c.Env[name]; ok {
+			continue
+		}
+
+		value, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if c.Env == nil {
+			c.Env = map[string]string{}
+		}
+		c.Env[name] = value
+	}
+}
+
 func contains(elements []string, s string) bool {
 	for _, elem := range elements {
 		if s == elem {
@@ -16,6 +16,11 @@
 package config_test
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -62,7 +67,7 @@ var _ = Describe("Config", func() {
 			Expect(cfg.Processes[0].Hooks.PreStart).To(Equal("/var/vcap/jobs/program/bin/pre"))
 			Expect(cfg.Processes[0].Capabilities).To(ConsistOf("NET_BIND_SERVICE", "SYS_TIME"))
 			Expect(cfg.Processes[0].WorkDir).To(Equal("/I/AM/A/WORKDIR"))
-			Expect(cfg.Processes[0].PersistentDisk).To(BeTrue())
+			Expect(cfg.Processes[0].PersistentDisk).To(Equal(config.DiskModeReadWrite))
 			Expect(cfg.Processes[0].EphemeralDisk).To(BeTrue())
 			Expect(cfg.Processes[0].Unsafe.Privileged).To(BeTrue())
 			Expect(cfg.Processes[0].Unsafe.HostPidNamespace).To(BeTrue())
@@ -75,14 +80,59 @@ var _ = Describe("Config", func() {
 			Expect(cfg.Processes[1].Name).To(Equal("second-process"))
 			Expect(cfg.Processes[1].Executable).To(Equal("/I/AM/A/SECOND-EXECUTABLE"))
 			Expect(cfg.Processes[1].Hooks).To(BeNil())
+			Expect(cfg.Processes[1].PersistentDisk).To(Equal(config.DiskModeReadOnly))
 			Expect(cfg.Processes[1].Unsafe).To(BeNil())
 
 			Expect(cfg.Processes[2].Name).To(Equal("third-process"))
 			Expect(cfg.Processes[2].Executable).To(Equal("/I/AM/A/THIRD-EXECUTABLE"))
 			Expect(cfg.Processes[2].Hooks.PreStart).To(BeEmpty())
+			Expect(cfg.Processes[2].PersistentDisk.Enabled()).To(BeFalse())
 			Expect(cfg.Processes[2].Unsafe).To(BeNil())
 		})
 
+		Context("when a process has replicas", func() {
+			BeforeEach(func() {
+				configPath = "testdata/example-replicas.yml"
+			})
+
+			It("expands it into one process per replica", func() {
+				cfg, err := config.ParseJobConfig(configPath)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(cfg.Processes).To(HaveLen(4))
+				for i, proc := range cfg.Processes[:3] {
+					Expect(proc.Name).To(Equal(fmt.Sprintf("worker-%d", i)))
+					Expect(proc.ReplicaOf).To(Equal("worker"))
+					Expect(proc.ReplicaIndex).To(Equal(i))
+					Expect(proc.Executable).To(Equal("/var/vcap/packages/worker/bin/worker"))
+					Expect(proc.Env).To(Equal(map[string]string{
+						"FOO":               "BAR",
+						"BPM_REPLICA_INDEX": strconv.Itoa(i),
+					}))
+				}
+
+				Expect(cfg.Processes[3].Name).To(Equal("server"))
+				Expect(cfg.Processes[3].ReplicaOf).To(BeEmpty())
+
+				Expect(cfg.ReplicasOf("worker")).To(Equal(cfg.Processes[:3]))
+				Expect(cfg.ReplicasOf("server")).To(BeEmpty())
+			})
+
+			Context("when a replica has the name of another process", func() {
+				BeforeEach(func() {
+					configPath = "testdata/example-replica-collision.yml"
+				})
+
+				It("fails validation", func() {
+					cfg, err := config.ParseJobConfig(configPath)
+					Expect(err).NotTo(HaveOccurred())
+
+					err = cfg.Validate(boshEnv, []string{})
+					Expect(err).To(MatchError("invalid config: replica worker-1 of process worker has the name of another process"))
+				})
+			})
+		})
+
 		Context("when reading the file fails", func() {
 			BeforeEach(func() {
 				configPath = "does-not-exist"
@@ -99,9 +149,21 @@ var _ = Describe("Config", func() {
 				configPath = "testdata/example-invalid-yaml.yml"
 			})
 
-			It("returns an error", func() {
+			It("returns an error naming the file and the line", func() {
 				_, err := config.ParseJobConfig(configPath)
-				Expect(err).To(HaveOccurred())
+				Expect(err).To(BeAssignableToTypeOf(&config.ParseError{}))
+				Expect(err).To(MatchError(MatchRegexp(`^testdata/example-invalid-yaml\.yml is not valid: line \d+: `)))
+			})
+		})
+
+		Context("when the yaml does not have the expected structure", func() {
+			BeforeEach(func() {
+				configPath = "testdata/example-invalid-structure.yml"
+			})
+
+			It("returns an error naming the file and the line", func() {
+				_, err := config.ParseJobConfig(configPath)
+				Expect(err).To(MatchError(HavePrefix("testdata/example-invalid-structure.yml is not valid: line 5: cannot unmarshal")))
 			})
 		})
 	})
@@ -183,6 +245,672 @@ var _ = Describe("Config", func() {
 				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
 			})
 		})
+
+		Context("when the config has data_mount_options", func() {
+			BeforeEach(func() {
+				jobCfg.Processes[0].EphemeralDisk = true
+				jobCfg.Processes[0].DataMountOptions = []string{"noexec", "nosuid", "nodev"}
+			})
+
+			It("does not error on valid options", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error for an unknown option", func() {
+				jobCfg.Processes[0].DataMountOptions = []string{"suid"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
+			})
+
+			It("returns an error when the ephemeral disk is not requested", func() {
+				jobCfg.Processes[0].EphemeralDisk = false
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
+			})
+		})
+
+		Context("when the config has an unknown persistent_disk mode", func() {
+			It("returns an error", func() {
+				jobCfg.Processes[0].PersistentDisk = "rx"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid config: persistent_disk: invalid disk mode: "rx" must be ro or rw`))
+			})
+		})
+
+		Context("when the process is a oneshot", func() {
+			BeforeEach(func() {
+				jobCfg.Processes[0].Oneshot = true
+			})
+
+			It("does not error", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error when it has a liveness check", func() {
+				jobCfg.Processes[0].Liveness = &config.Liveness{HeartbeatFile: "/var/vcap/data/job/heartbeat", Interval: "10s"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: oneshot cannot be used with liveness or memory_warn_percent"))
+			})
+
+			It("returns an error when it notifies once started", func() {
+				jobCfg.Processes[0].Notify = &config.Notify{File: "/var/vcap/data/job/ready"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: oneshot cannot be used with notify"))
+			})
+		})
+
+		Context("when the config has an unknown network_mode", func() {
+			It("returns an error", func() {
+				jobCfg.Processes[0].NetworkMode = "bridge"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid config: network_mode: invalid network mode: "bridge" must be host, isolated or none`))
+			})
+		})
+
+		Context("when the config has an isolated network_mode", func() {
+			It("gives the process a network of its own", func() {
+				jobCfg.Processes[0].NetworkMode = config.NetworkModeIsolated
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].NetworkMode.Isolated()).To(BeTrue())
+			})
+		})
+
+		Context("when the config has an ionice", func() {
+			It("uses the default level of the class", func() {
+				jobCfg.Processes[0].IONice = &config.IONice{Class: "best-effort"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].IONice.IOLevel()).To(Equal(config.DefaultIONiceLevel))
+			})
+
+			It("returns an error for an unknown class", func() {
+				jobCfg.Processes[0].IONice = &config.IONice{Class: "background"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid config: ionice: class "background" must be realtime, best-effort or idle`))
+			})
+
+			It("returns an error for a level out of range", func() {
+				level := 8
+				jobCfg.Processes[0].IONice = &config.IONice{Class: "realtime", Level: &level}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: ionice: level 8 must be between 0 and 7"))
+			})
+
+			It("returns an error for a level in the idle class", func() {
+				level := 0
+				jobCfg.Processes[0].IONice = &config.IONice{Class: "idle", Level: &level}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: ionice: level cannot be used with the idle class"))
+			})
+		})
+
+		Context("when the config has a user_namespace", func() {
+			BeforeEach(func() {
+				jobCfg.Processes[0].UserNamespace = &config.UserNamespace{HostUID: 100000, HostGID: 100000}
+			})
+
+			It("maps the default number of ids", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].UserNamespace.MappingSize()).To(Equal(uint32(config.DefaultUserNamespaceSize)))
+			})
+
+			It("returns an error when root is mapped to the host root user", func() {
+				jobCfg.Processes[0].UserNamespace.HostUID = 0
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: user_namespace: host_uid must not be root"))
+			})
+
+			It("returns an error when the mapped ids run past the largest id", func() {
+				jobCfg.Processes[0].UserNamespace.HostGID = 4294967000
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: user_namespace: 65536 ids from host_gid 4294967000 exceed the largest gid"))
+			})
+
+			It("returns an error when the process is privileged", func() {
+				jobCfg.Processes[0].Unsafe = &config.Unsafe{Privileged: true}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: user_namespace cannot be used with unsafe privileged"))
+			})
+		})
+
+		Context("when the config has an unknown hostname", func() {
+			It("returns an error", func() {
+				jobCfg.Processes[0].Hostname = "container"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid config: hostname: invalid hostname: "container" must be host or instance`))
+			})
+		})
+
+		Context("when the config has an invalid shm_size", func() {
+			It("returns an error", func() {
+				jobCfg.Processes[0].ShmSize = "huge"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
+			})
+		})
+
+		Context("when the config has a startup_timeout", func() {
+			It("uses it as the startup deadline", func() {
+				jobCfg.Processes[0].StartupTimeout = "90s"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].StartupDeadline()).To(Equal(90 * time.Second))
+			})
+
+			It("returns an error when it is not a duration", func() {
+				jobCfg.Processes[0].StartupTimeout = "forever"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("startup_timeout")))
+			})
+
+			It("returns an error when it is not positive", func() {
+				jobCfg.Processes[0].StartupTimeout = "0s"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("startup_timeout")))
+			})
+		})
+
+		Context("when the config has no startup_timeout", func() {
+			It("uses the default startup deadline", func() {
+				Expect(jobCfg.Processes[0].StartupDeadline()).To(Equal(config.DefaultStartupTimeout))
+			})
+		})
+
+		Context("when the config has a min_uptime", func() {
+			It("uses it as the minimum uptime", func() {
+				jobCfg.Processes[0].MinUptime = "3s"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].MinUptimeDuration()).To(Equal(3 * time.Second))
+			})
+
+			It("returns an error when it is not a duration", func() {
+				jobCfg.Processes[0].MinUptime = "a while"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("min_uptime")))
+			})
+
+			It("returns an error when it is not positive", func() {
+				jobCfg.Processes[0].MinUptime = "-1s"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: min_uptime: -1s must be positive"))
+			})
+
+			It("returns an error when the process is a oneshot", func() {
+				jobCfg.Processes[0].MinUptime = "3s"
+				jobCfg.Processes[0].Oneshot = true
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: oneshot cannot be used with min_uptime"))
+			})
+		})
+
+		Context("when the config has no min_uptime", func() {
+			It("does not wait for any uptime", func() {
+				Expect(jobCfg.Processes[0].MinUptimeDuration()).To(BeZero())
+			})
+		})
+
+		Context("when the config has a notify", func() {
+			It("does not error on an absolute file or socket", func() {
+				jobCfg.Processes[0].Notify = &config.Notify{File: "/var/vcap/sys/run/ready", Socket: "/run/notify.sock"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error when neither is given", func() {
+				jobCfg.Processes[0].Notify = &config.Notify{}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid notify: one of file or socket is required"))
+			})
+
+			It("returns an error for a relative path", func() {
+				jobCfg.Processes[0].Notify = &config.Notify{Socket: "notify.sock"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid notify socket: "notify.sock" must be an absolute path`))
+			})
+		})
+
+		Context("when the config has a wait_for_mount", func() {
+			It("does not error on a valid mount", func() {
+				jobCfg.Processes[0].WaitForMount = &config.WaitForMount{Path: "/var/vcap/store", Timeout: "2m"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].WaitForMount.WaitTimeout()).To(Equal(2 * time.Minute))
+			})
+
+			It("uses the default timeout when none is given", func() {
+				jobCfg.Processes[0].WaitForMount = &config.WaitForMount{Path: "/var/vcap/store"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].WaitForMount.WaitTimeout()).To(Equal(config.DefaultMountWaitTimeout))
+			})
+
+			It("returns an error when the path is not absolute", func() {
+				jobCfg.Processes[0].WaitForMount = &config.WaitForMount{Path: "store"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("wait_for_mount path")))
+			})
+
+			It("returns an error when the timeout is invalid", func() {
+				jobCfg.Processes[0].WaitForMount = &config.WaitForMount{Path: "/var/vcap/store", Timeout: "soon"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("wait_for_mount timeout")))
+			})
+		})
+
+		Context("when the config has hook timeouts", func() {
+			It("does not error on valid timeouts", func() {
+				jobCfg.Processes[0].Hooks = &config.Hooks{PreStart: "/bin/true", PreStartTimeout: "30s", PostStart: "/bin/true", PostStartTimeout: "1m"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].Hooks.PreStartDeadline()).To(Equal(30 * time.Second))
+				Expect(jobCfg.Processes[0].Hooks.PostStartDeadline()).To(Equal(time.Minute))
+			})
+
+			It("has no deadline when no timeout is given", func() {
+				jobCfg.Processes[0].Hooks = &config.Hooks{PreStart: "/bin/true"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].Hooks.PreStartDeadline()).To(BeZero())
+			})
+
+			It("returns an error when a timeout is invalid", func() {
+				jobCfg.Processes[0].Hooks = &config.Hooks{PreStart: "/bin/true", PreStartTimeout: "soon"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("invalid hooks pre_start_timeout")))
+			})
+
+			It("returns an error when a timeout is not positive", func() {
+				jobCfg.Processes[0].Hooks = &config.Hooks{PostStart: "/bin/true", PostStartTimeout: "0s"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid hooks post_start_timeout: 0s must be positive"))
+			})
+
+			It("returns an error when the post-start hook runs inside the container", func() {
+				jobCfg.Processes[0].Hooks = &config.Hooks{PostStart: "/bin/true", PostStartInContainer: true, PostStartTimeout: "1m"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("cannot be used with post_start_in_container")))
+			})
+		})
+
+		Context("when the config has listen sockets", func() {
+			It("does not error on valid sockets", func() {
+				jobCfg.Processes[0].ListenSockets = []config.ListenSocket{
+					{Name: "http", Address: "0.0.0.0:8080"},
+					{Network: "unix", Address: "/var/vcap/sys/run/example/admin.sock"},
+				}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].ListenSockets[0].ListenNetwork()).To(Equal("tcp"))
+			})
+
+			It("returns an error when the network is not supported", func() {
+				jobCfg.Processes[0].ListenSockets = []config.ListenSocket{{Network: "udp", Address: ":53"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid listen socket network: "udp"`))
+			})
+
+			It("returns an error when the address is missing", func() {
+				jobCfg.Processes[0].ListenSockets = []config.ListenSocket{{Name: "http"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("address is required")))
+			})
+
+			It("returns an error when a unix socket path is relative", func() {
+				jobCfg.Processes[0].ListenSockets = []config.ListenSocket{{Network: "unix", Address: "admin.sock"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("must be an absolute path")))
+			})
+
+			It("returns an error when a name contains a colon", func() {
+				jobCfg.Processes[0].ListenSockets = []config.ListenSocket{{Name: "a:b", Address: ":8080"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("must not contain a colon")))
+			})
+		})
+
+		Context("when the config rotates its logs", func() {
+			It("does not error on a valid rotation", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{Rotate: &config.LogRotation{MaxSize: "10M", Keep: 3}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].LogRotation().MaxBytes()).To(Equal(uint64(10 * 1024 * 1024)))
+				Expect(jobCfg.Processes[0].LogRotation().KeepCount()).To(Equal(3))
+			})
+
+			It("keeps the default number of files when no count is given", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{Rotate: &config.LogRotation{MaxSize: "10M"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].LogRotation().KeepCount()).To(Equal(config.DefaultLogRotationKeep))
+			})
+
+			It("returns an error when the size is missing or invalid", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{Rotate: &config.LogRotation{}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("max_size is required")))
+
+				jobCfg.Processes[0].Logs.Rotate.MaxSize = "big"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("invalid logs rotate: max_size")))
+			})
+
+			It("returns an error when the logs are fifos", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{Fifo: true, Rotate: &config.LogRotation{MaxSize: "10M"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("cannot be used with fifo")))
+			})
+
+			It("returns an error when stderr is merged into stdout", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{MergeStderr: true, Rotate: &config.LogRotation{MaxSize: "10M"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: logs rotate cannot be used with merge_stderr"))
+			})
+		})
+
+		Context("when the config has a log filter", func() {
+			It("does not error on an executable file", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{Filter: "/bin/sh"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error when the path is relative", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{Filter: "tr"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid config: logs filter must be an absolute path: "tr"`))
+			})
+
+			It("returns an error when the file does not exist", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{Filter: "/does/not/exist"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("invalid config: logs filter: stat /does/not/exist")))
+			})
+
+			It("returns an error when the file is not executable", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{Filter: "/etc/passwd"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("must be an executable file")))
+			})
+		})
+
+		Context("when the config names its log files", func() {
+			It("does not error on plain file names", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{StdoutFile: "example.log", StderrFile: "example.err"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error when a name leaves the log directory", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{StdoutFile: "../example.log"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid config: logs stdout_file: "../example.log" must be a file name within the log directory`))
+
+				jobCfg.Processes[0].Logs = &config.Logs{StderrFile: ".."}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("logs stderr_file")))
+			})
+
+			It("returns an error when a name is the bpm log", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{StdoutFile: "bpm.log"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("is the log file of bpm")))
+			})
+
+			It("returns an error when both streams are given the same file", func() {
+				jobCfg.Processes[0].Logs = &config.Logs{StdoutFile: "example.log", StderrFile: "example.log"}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("use merge_stderr")))
+			})
+		})
+
+		Context("when the config has a cpuset", func() {
+			It("does not error on valid lists", func() {
+				jobCfg.Processes[0].Limits = &config.Limits{Cpuset: &config.Cpuset{Cpus: "0-3,6", Mems: "0"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error for a backwards range", func() {
+				jobCfg.Processes[0].Limits = &config.Limits{Cpuset: &config.Cpuset{Cpus: "3-1"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("cpuset cpus")))
+			})
+
+			It("returns an error when no cpus are given", func() {
+				jobCfg.Processes[0].Limits = &config.Limits{Cpuset: &config.Cpuset{Mems: "0"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("cpuset cpus")))
+			})
+
+			It("returns an error for invalid mems", func() {
+				jobCfg.Processes[0].Limits = &config.Limits{Cpuset: &config.Cpuset{Cpus: "0", Mems: "zero"}}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("cpuset mems")))
+			})
+		})
+
+		Context("when a volume is created", func() {
+			BeforeEach(func() {
+				jobCfg.Processes[0].AdditionalVolumes = []config.Volume{
+					{Path: "/var/vcap/data/program/created", Create: true, CreateMode: "0750"},
+				}
+			})
+
+			It("does not error on a valid mode", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].AdditionalVolumes[0].CreateFileMode()).To(Equal(os.FileMode(0750)))
+			})
+
+			It("returns an error for a mode which is not octal", func() {
+				jobCfg.Processes[0].AdditionalVolumes[0].CreateMode = "rwx"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring(`create_mode "rwx" must be an octal permission`)))
+			})
+
+			It("returns an error when a mode is given without create", func() {
+				jobCfg.Processes[0].AdditionalVolumes[0].Create = false
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("create_mode requires create")))
+			})
+
+			It("returns an error for an unrestricted glob", func() {
+				jobCfg.Processes[0].Unsafe = &config.Unsafe{
+					UnrestrictedVolumes: []config.Volume{{Path: "/srv/*", Create: true}},
+				}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("create cannot be used with a glob")))
+			})
+		})
+
+		Context("when the config has an invalid max_file_size", func() {
+			It("returns an error", func() {
+				size := "huge"
+				jobCfg.Processes[0].Limits = &config.Limits{MaxFileSize: &size}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("invalid limits: max_file_size")))
+			})
+		})
+
+		Context("when the config has an invalid kernel_memory", func() {
+			It("returns an error", func() {
+				size := "lots"
+				jobCfg.Processes[0].Limits = &config.Limits{KernelMemory: &size}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("invalid limits: kernel_memory")))
+			})
+		})
+
+		Context("when the config has a cpus limit", func() {
+			It("returns an error when it is not positive", func() {
+				cpus := 0.0
+				jobCfg.Processes[0].Limits = &config.Limits{Cpus: &cpus}
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid limits: cpus: 0 must be positive"))
+			})
+
+			It("counts fractions of a cpu as a whole cpu", func() {
+				cpus := 1.5
+				limits := &config.Limits{Cpus: &cpus}
+				Expect(limits.CPUCount()).To(Equal(2))
+			})
+
+			It("counts the cpus in the cpuset", func() {
+				limits := &config.Limits{Cpuset: &config.Cpuset{Cpus: "0-3,6"}}
+				Expect(limits.CPUCount()).To(Equal(5))
+			})
+		})
+
+		Context("when the config has stop_signals", func() {
+			BeforeEach(func() {
+				jobCfg.Processes[0].StopSignals = []config.StopSignal{
+					{Signal: "TERM", Wait: "5s"},
+					{Signal: "SIGINT", Wait: "10s"},
+				}
+			})
+
+			It("does not error on valid signals", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error for an unsupported signal", func() {
+				jobCfg.Processes[0].StopSignals[1].Signal = "WINCH"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(`invalid stop signal: "WINCH"`))
+			})
+
+			It("returns an error for an invalid wait", func() {
+				jobCfg.Processes[0].StopSignals[1].Wait = "forever"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
+
+				jobCfg.Processes[0].StopSignals[1].Wait = "0s"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
+			})
+		})
+
+		Context("when the config has a memory_swap limit", func() {
+			var memory, memorySwap string
+
+			BeforeEach(func() {
+				memory = "1G"
+				memorySwap = "2G"
+				jobCfg.Processes[0].Limits = &config.Limits{
+					Memory:     &memory,
+					MemorySwap: &memorySwap,
+				}
+			})
+
+			It("does not error when it is greater than the memory limit", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("does not error when it is equal to the memory limit", func() {
+				memorySwap = "1024M"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error when it is less than the memory limit", func() {
+				memorySwap = "512M"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("memory_swap")))
+			})
+
+			It("returns an error when it is not a valid size", func() {
+				memorySwap = "lots"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
+			})
+
+			It("returns an error when the memory limit is not set", func() {
+				jobCfg.Processes[0].Limits.Memory = nil
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
+			})
+		})
+
+		Context("when the config has a memory_warn_percent", func() {
+			var (
+				memory  string
+				percent int
+			)
+
+			BeforeEach(func() {
+				memory = "1G"
+				percent = 80
+				jobCfg.Processes[0].Limits = &config.Limits{
+					Memory:            &memory,
+					MemoryWarnPercent: &percent,
+				}
+			})
+
+			It("does not error and supervises the process", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].MemoryWarnPercent()).To(Equal(80))
+				Expect(jobCfg.Processes[0].Supervised()).To(BeTrue())
+			})
+
+			It("returns an error when it is out of range", func() {
+				percent = 101
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid limits: memory_warn_percent: 101 must be between 1 and 100"))
+			})
+
+			It("returns an error when the memory limit is not set", func() {
+				jobCfg.Processes[0].Limits.Memory = nil
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("requires memory")))
+			})
+		})
+
+		Context("when the config has a rootfs", func() {
+			It("does not error when it is within the BOSH root", func() {
+				jobCfg.Processes[0].RootFS = boshEnv.Root().External() + "/packages/rootfs"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error when it is outside the BOSH root", func() {
+				jobCfg.Processes[0].RootFS = "/srv/rootfs"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("invalid rootfs path")))
+			})
+
+			It("returns an error when it is not canonical", func() {
+				jobCfg.Processes[0].RootFS = boshEnv.Root().External() + "/packages/../rootfs"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(HaveOccurred())
+			})
+		})
+
+		Context("when the config has a net_bandwidth limit", func() {
+			var netBandwidth string
+
+			BeforeEach(func() {
+				netBandwidth = "10M"
+				jobCfg.Processes[0].Limits = &config.Limits{NetBandwidth: &netBandwidth}
+			})
+
+			It("does not error when it is a valid rate", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error when it is not a valid rate", func() {
+				netBandwidth = "fast"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("net_bandwidth")))
+			})
+		})
+
+		Context("when the config has a liveness check", func() {
+			BeforeEach(func() {
+				jobCfg.Processes[0].Liveness = &config.Liveness{
+					HeartbeatFile: "/var/vcap/data/example/heartbeat",
+					Interval:      "30s",
+				}
+			})
+
+			It("does not error when it is valid", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+			})
+
+			It("returns an error when the heartbeat file is outside the bosh root", func() {
+				jobCfg.Processes[0].Liveness.HeartbeatFile = "/tmp/heartbeat"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("heartbeat_file")))
+			})
+
+			It("returns an error when the interval is invalid", func() {
+				jobCfg.Processes[0].Liveness.Interval = "soon"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("liveness interval")))
+			})
+
+			It("returns an error when the interval is not positive", func() {
+				jobCfg.Processes[0].Liveness.Interval = "0s"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("must be positive")))
+			})
+
+			It("returns an error when the failure threshold is negative", func() {
+				jobCfg.Processes[0].Liveness.FailureThreshold = -1
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("failure_threshold")))
+			})
+
+			It("allows one failure by default", func() {
+				Expect(jobCfg.Processes[0].Liveness.Failures()).To(Equal(1))
+
+				jobCfg.Processes[0].Liveness.FailureThreshold = 3
+				Expect(jobCfg.Processes[0].Liveness.Failures()).To(Equal(3))
+			})
+
+			It("returns an error when the restart limit is negative", func() {
+				jobCfg.Processes[0].Liveness.Restart = true
+				jobCfg.Processes[0].Liveness.MaxRestarts = -1
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("max_restarts")))
+			})
+
+			It("returns an error when the restart limit is set without restart", func() {
+				jobCfg.Processes[0].Liveness.MaxRestarts = 2
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("restart must be enabled")))
+			})
+
+			It("finds the heartbeat file on the host", func() {
+				hostEnv := bosh.NewEnv("/some/root")
+				Expect(jobCfg.Processes[0].Liveness.HostHeartbeatFile(hostEnv)).To(Equal("/some/root/data/example/heartbeat"))
+			})
+		})
+
+		Context("when the config has a negative number of replicas", func() {
+			It("returns an error", func() {
+				jobCfg.Processes[0].Replicas = -1
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError(ContainSubstring("replicas")))
+			})
+		})
+
+		Context("when the config has a replica_stop_delay", func() {
+			BeforeEach(func() {
+				jobCfg.Processes[0].Replicas = 3
+				jobCfg.Processes[0].ReplicaStopDelay = "5s"
+			})
+
+			It("waits that long between stopping replicas", func() {
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(Succeed())
+				Expect(jobCfg.Processes[0].ReplicaStopWait()).To(Equal(5 * time.Second))
+			})
+
+			It("returns an error when the process has no replicas", func() {
+				jobCfg.Processes[0].Replicas = 0
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: replica_stop_delay requires replicas"))
+			})
+
+			It("returns an error when the delay is not positive", func() {
+				jobCfg.Processes[0].ReplicaStopDelay = "0s"
+				Expect(jobCfg.Validate(boshEnv, []string{})).To(MatchError("invalid config: replica_stop_delay: 0s must be positive"))
+			})
+		})
 	})
 
 	Describe("AddVolumes", func() {
@@ -334,4 +1062,82 @@ var _ = Describe("Config", func() {
 			})
 		})
 	})
+	Describe("AddPassEnv", func() {
+		var (
+			cfg       *config.ProcessConfig
+			lookupEnv func(string) (string, bool)
+		)
+
+		BeforeEach(func() {
+			cfg = &config.ProcessConfig{
+				Name:       "name",
+				Executable: "executable",
+				PassEnv:    []string{"HTTP_PROXY", "NO_PROXY", "MISSING"},
+			}
+
+			hostEnv := map[string]string{
+				"HTTP_PROXY": "http://proxy.example.com",
+				"NO_PROXY":   "localhost",
+				"UNLISTED":   "value",
+			}
+			lookupEnv = func(name string) (string, bool) {
+				value, ok := hostEnv[name]
+				return value, ok
+			}
+		})
+
+		It("adds the listed environment variables which are present", func() {
+			cfg.AddPassEnv(lookupEnv)
+
+			Expect(cfg.Env).To(Equal(map[string]string{
+				"HTTP_PROXY": "http://proxy.example.com",
+				"NO_PROXY":   "localhost",
+			}))
+		})
+
+		Context("when the variable is also set explicitly", func() {
+			BeforeEach(func() {
+				cfg.Env = map[string]string{"NO_PROXY": "explicit"}
+			})
+
+			It("keeps the explicit value", func() {
+				cfg.AddPassEnv(lookupEnv)
+
+				Expect(cfg.Env).To(HaveKeyWithValue("NO_PROXY", "explicit"))
+				Expect(cfg.Env).To(HaveKeyWithValue("HTTP_PROXY", "http://proxy.example.com"))
+			})
+		})
+	})
+
+	Describe("UnsetLimits", func() {
+		var cfg *config.ProcessConfig
+
+		BeforeEach(func() {
+			memory := "1G"
+			cfg = &config.ProcessConfig{
+				Name:       "name",
+				Executable: "executable",
+				Limits:     &config.Limits{Memory: &memory},
+			}
+		})
+
+		It("returns the named limits which are not set", func() {
+			unset, err := cfg.UnsetLimits([]string{"memory", "processes", "cpus"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unset).To(Equal([]string{"processes", "cpus"}))
+		})
+
+		It("returns every named limit when no limits are set", func() {
+			cfg.Limits = nil
+
+			unset, err := cfg.UnsetLimits([]string{"memory"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unset).To(Equal([]string{"memory"}))
+		})
+
+		It("returns an error for an unknown limit", func() {
+			_, err := cfg.UnsetLimits([]string{"disk"})
+			Expect(err).To(MatchError(`unknown limit "disk"`))
+		})
+	})
 })
@@ -0,0 +1,133 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Links describes environment variables which are read from a JSON file of
+// BOSH links data. Each entry in Fields maps the name of a variable (which is
+// prefixed with Prefix) to a dotted path to a field in the file, for example
+// `DB_HOST: database.address` or `FIRST_PEER: peers.0.host`.
+type Links struct {
+	Path   string            `yaml:"path"`
+	Prefix string            `yaml:"prefix"`
+	Fields map[string]string `yaml:"fields"`
+}
+
+func (l *Links) Validate() error {
+	if l.Path == "" {
+		return errors.New("invalid links: path must be set")
+	}
+
+	if !filepath.IsAbs(l.Path) {
+		return fmt.Errorf("invalid links: path must be absolute: %s", l.Path)
+	}
+
+	if len(l.Fields) == 0 {
+		return errors.New("invalid links: at least one field must be set")
+	}
+
+	return nil
+}
+
+// AddLinksEnv reads the fields listed in links from the links file into the
+// environment of the process. Variables set explicitly in env take
+// precedence. An error is returned if the file or any field is missing.
+func (c *ProcessConfig) AddLinksEnv() error {
+	if c.Links == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(c.Links.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read links file: %s", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var links interface{}
+	if err := decoder.Decode(&links); err != nil {
+		return fmt.Errorf("failed to parse links file %s: %s", c.Links.Path, err)
+	}
+
+	for name, path := range c.Links.Fields {
+		value, err := lookupLinkField(links, path)
+		if err != nil {
+			return fmt.Errorf("links field %q not found in %s: %s", path, c.Links.Path, err)
+		}
+
+		key := c.Links.Prefix + name
+		if _, ok := c.Env[key]; ok {
+			continue
+		}
+
+		if c.Env == nil {
+			c.Env = map[string]string{}
+		}
+		c.Env[key] = value
+	}
+
+	return nil
+}
+
+func lookupLinkField(links interface{}, path string) (string, error) {
+	current := links
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			next, ok := node[segment]
+			if !ok {
+				return "", fmt.Errorf("no key %q", segment)
+			}
+			current = next
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("no index %q", segment)
+			}
+			current = node[i]
+		default:
+			return "", fmt.Errorf("cannot look up %q in a scalar value", segment)
+		}
+	}
+
+	switch value := current.(type) {
+	case nil:
+		return "", errors.New("value is null")
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/config"
+)
+
+var _ = Describe("Links", func() {
+	var (
+		cfg      *config.ProcessConfig
+		tmpdir   string
+		linkPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "links-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		linkPath = filepath.Join(tmpdir, "links.json")
+		links := `{
+  "database": {"address": "db.example.com", "port": 5432, "tls": true},
+  "peers": [{"host": "peer-0"}, {"host": "peer-1"}]
+}`
+		Expect(ioutil.WriteFile(linkPath, []byte(links), 0644)).To(Succeed())
+
+		cfg = &config.ProcessConfig{
+			Name:       "name",
+			Executable: "executable",
+			Links: &config.Links{
+				Path:   linkPath,
+				Prefix: "LINK_",
+				Fields: map[string]string{
+					"DB_HOST":    "database.address",
+					"DB_PORT":    "database.port",
+					"DB_TLS":     "database.tls",
+					"FIRST_PEER": "peers.0.host",
+					"PEERS":      "peers",
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpdir)).To(Succeed())
+	})
+
+	Describe("AddLinksEnv", func() {
+		It("adds the fields to the environment under the prefix", func() {
+			Expect(cfg.AddLinksEnv()).To(Succeed())
+
+			Expect(cfg.Env).To(Equal(map[string]string{
+				"LINK_DB_HOST":    "db.example.com",
+				"LINK_DB_PORT":    "5432",
+				"LINK_DB_TLS":     "true",
+				"LINK_FIRST_PEER": "peer-0",
+				"LINK_PEERS":      `[{"host":"peer-0"},{"host":"peer-1"}]`,
+			}))
+		})
+
+		Context("when the variable is also set explicitly", func() {
+			BeforeEach(func() {
+				cfg.Env = map[string]string{"LINK_DB_HOST": "explicit"}
+			})
+
+			It("keeps the explicit value", func() {
+				Expect(cfg.AddLinksEnv()).To(Succeed())
+				Expect(cfg.Env).To(HaveKeyWithValue("LINK_DB_HOST", "explicit"))
+			})
+		})
+
+		Context("when no links are configured", func() {
+			It("does nothing", func() {
+				cfg.Links = nil
+				Expect(cfg.AddLinksEnv()).To(Succeed())
+				Expect(cfg.Env).To(BeEmpty())
+			})
+		})
+
+		Context("when the links file does not exist", func() {
+			It("returns an error", func() {
+				cfg.Links.Path = filepath.Join(tmpdir, "missing.json")
+				Expect(cfg.AddLinksEnv()).To(MatchError(ContainSubstring("failed to read links file")))
+			})
+		})
+
+		Context("when a field does not exist", func() {
+			It("returns an error", func() {
+				cfg.Links.Fields["MISSING"] = "database.password"
+				Expect(cfg.AddLinksEnv()).To(MatchError(ContainSubstring(`links field "database.password" not found`)))
+			})
+
+			It("returns an error for an index out of range", func() {
+				cfg.Links.Fields["MISSING"] = "peers.2.host"
+				Expect(cfg.AddLinksEnv()).To(MatchError(ContainSubstring(`links field "peers.2.host" not found`)))
+			})
+		})
+	})
+
+	Describe("Validate", func() {
+		It("requires an absolute path", func() {
+			cfg.Links.Path = "links.json"
+			Expect(cfg.Links.Validate()).To(HaveOccurred())
+		})
+
+		It("requires at least one field", func() {
+			cfg.Links.Fields = nil
+			Expect(cfg.Links.Validate()).To(HaveOccurred())
+		})
+	})
+})
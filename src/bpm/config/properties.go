@@ -0,0 +1,147 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PropertiesEnvPrefix is prepended to the name of every variable which is
+// read from a properties file.
+const PropertiesEnvPrefix = "BPM_PROP_"
+
+func validatePropertiesFile(path string) error {
+	if filepath.IsAbs(path) {
+		return fmt.Errorf("invalid properties_file: path must be relative to the job config directory: %s", path)
+	}
+
+	clean := filepath.Clean(path)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid properties_file: path must be inside the job config directory: %s", path)
+	}
+
+	return nil
+}
+
+// AddPropertiesEnv flattens the YAML or JSON properties file of the process,
+// which is resolved relative to configDir, into the environment of the
+// process. Nested keys are joined with dots and every variable is prefixed
+// with PropertiesEnvPrefix. Variables set explicitly in env take precedence.
+func (c *ProcessConfig) AddPropertiesEnv(configDir string) error {
+	if c.PropertiesFile == "" {
+		return nil
+	}
+
+	path := filepath.Join(configDir, c.PropertiesFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read properties file: %s", err)
+	}
+
+	var properties map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &properties); err != nil {
+		return fmt.Errorf("failed to parse properties file %s: %s", path, err)
+	}
+
+	flattened := map[string]string{}
+	if err := flattenProperties("", properties, flattened); err != nil {
+		return fmt.Errorf("failed to parse properties file %s: %s", path, err)
+	}
+
+	for name, value := range flattened {
+		key := PropertiesEnvPrefix + name
+		if _, ok := c.Env[key]; ok {
+			continue
+		}
+
+		if c.Env == nil {
+			c.Env = map[string]string{}
+		}
+		c.Env[key] = value
+	}
+
+	return nil
+}
+
+func flattenProperties(prefix string, properties map[interface{}]interface{}, out map[string]string) error {
+	keys := make([]string, 0, len(properties))
+	values := map[string]interface{}{}
+	for k, v := range properties {
+		key := fmt.Sprintf("%v", k)
+		keys = append(keys, key)
+		values[key] = v
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+
+		switch value := values[key].(type) {
+		case map[interface{}]interface{}:
+			if err := flattenProperties(name, value, out); err != nil {
+				return err
+			}
+		case nil:
+			out[name] = ""
+		case string:
+			out[name] = value
+		case bool:
+			out[name] = strconv.FormatBool(value)
+		case int, int64, uint64, float64:
+			out[name] = fmt.Sprintf("%v", value)
+		default:
+			encoded, err := json.Marshal(jsonCompatible(value))
+			if err != nil {
+				return fmt.Errorf("cannot encode property %q: %s", name, err)
+			}
+			out[name] = string(encoded)
+		}
+	}
+
+	return nil
+}
+
+// jsonCompatible converts the maps produced by the YAML decoder into maps
+// with string keys so that they can be JSON encoded.
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for k, e := range v {
+			converted[fmt.Sprintf("%v", k)] = jsonCompatible(e)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, e := range v {
+			converted[i] = jsonCompatible(e)
+		}
+		return converted
+	default:
+		return v
+	}
+}
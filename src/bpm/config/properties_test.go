@@ -0,0 +1,150 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/bosh"
+	"bpm/config"
+)
+
+var _ = Describe("Properties", func() {
+	var (
+		cfg    *config.ProcessConfig
+		tmpdir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "properties-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		properties := `---
+name: example
+port: 8080
+tls:
+  enabled: true
+  cert:
+    path: /var/vcap/jobs/example/config/cert.pem
+peers: [peer-0, peer-1]
+`
+		Expect(ioutil.WriteFile(filepath.Join(tmpdir, "properties.yml"), []byte(properties), 0644)).To(Succeed())
+
+		cfg = &config.ProcessConfig{
+			Name:           "name",
+			Executable:     "executable",
+			PropertiesFile: "properties.yml",
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpdir)).To(Succeed())
+	})
+
+	Describe("AddPropertiesEnv", func() {
+		It("flattens the properties into the environment", func() {
+			Expect(cfg.AddPropertiesEnv(tmpdir)).To(Succeed())
+
+			Expect(cfg.Env).To(Equal(map[string]string{
+				"BPM_PROP_name":          "example",
+				"BPM_PROP_port":          "8080",
+				"BPM_PROP_tls.enabled":   "true",
+				"BPM_PROP_tls.cert.path": "/var/vcap/jobs/example/config/cert.pem",
+				"BPM_PROP_peers":         `["peer-0","peer-1"]`,
+			}))
+		})
+
+		Context("when the properties file is JSON", func() {
+			BeforeEach(func() {
+				properties := `{"database": {"host": "db.example.com", "port": 5432}}`
+				Expect(ioutil.WriteFile(filepath.Join(tmpdir, "properties.json"), []byte(properties), 0644)).To(Succeed())
+				cfg.PropertiesFile = "properties.json"
+			})
+
+			It("flattens the properties into the environment", func() {
+				Expect(cfg.AddPropertiesEnv(tmpdir)).To(Succeed())
+
+				Expect(cfg.Env).To(Equal(map[string]string{
+					"BPM_PROP_database.host": "db.example.com",
+					"BPM_PROP_database.port": "5432",
+				}))
+			})
+		})
+
+		Context("when the variable is also set explicitly", func() {
+			BeforeEach(func() {
+				cfg.Env = map[string]string{"BPM_PROP_name": "explicit"}
+			})
+
+			It("keeps the explicit value", func() {
+				Expect(cfg.AddPropertiesEnv(tmpdir)).To(Succeed())
+				Expect(cfg.Env).To(HaveKeyWithValue("BPM_PROP_name", "explicit"))
+			})
+		})
+
+		Context("when no properties file is configured", func() {
+			It("does nothing", func() {
+				cfg.PropertiesFile = ""
+				Expect(cfg.AddPropertiesEnv(tmpdir)).To(Succeed())
+				Expect(cfg.Env).To(BeEmpty())
+			})
+		})
+
+		Context("when the properties file does not exist", func() {
+			It("returns an error", func() {
+				cfg.PropertiesFile = "missing.yml"
+				Expect(cfg.AddPropertiesEnv(tmpdir)).To(MatchError(ContainSubstring("failed to read properties file")))
+			})
+		})
+
+		Context("when the properties file is not a map", func() {
+			It("returns an error", func() {
+				Expect(ioutil.WriteFile(filepath.Join(tmpdir, "list.yml"), []byte("[a, b]"), 0644)).To(Succeed())
+				cfg.PropertiesFile = "list.yml"
+				Expect(cfg.AddPropertiesEnv(tmpdir)).To(MatchError(ContainSubstring("failed to parse properties file")))
+			})
+		})
+	})
+
+	Describe("Validate", func() {
+		var boshEnv *bosh.Env
+
+		BeforeEach(func() {
+			boshEnv = bosh.NewEnv("/var/vcap")
+		})
+
+		It("accepts a path inside the job config directory", func() {
+			cfg.PropertiesFile = "nested/properties.yml"
+			Expect(cfg.Validate(boshEnv, nil)).To(Succeed())
+		})
+
+		It("requires a relative path", func() {
+			cfg.PropertiesFile = "/var/vcap/jobs/example/config/properties.yml"
+			Expect(cfg.Validate(boshEnv, nil)).To(HaveOccurred())
+		})
+
+		It("does not allow the path to leave the job config directory", func() {
+			cfg.PropertiesFile = "../../other/config/properties.yml"
+			Expect(cfg.Validate(boshEnv, nil)).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,88 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ReplicaIndexEnv is the environment variable which holds the index of each
+// replica of a process.
+const ReplicaIndexEnv = "BPM_REPLICA_INDEX"
+
+// ReplicaName is the name of the process which runs the replica of proc with
+// the given index.
+func ReplicaName(proc string, index int) string {
+	return fmt.Sprintf("%s-%d", proc, index)
+}
+
+// ReplicaStopWait is how long bpm stop waits after stopping one replica of the
+// process before it stops the next. The replicas are stopped one straight
+// after another when it is zero.
+func (c *ProcessConfig) ReplicaStopWait() time.Duration {
+	if c.ReplicaStopDelay == "" {
+		return 0
+	}
+
+	delay, _ := time.ParseDuration(c.ReplicaStopDelay)
+	return delay
+}
+
+// expandReplicas replaces every process which has replicas with one process
+// per replica. Each replica is configured exactly like the original process
+// apart from its name and the index in its environment.
+func (c *JobConfig) expandReplicas() {
+	var processes []*ProcessConfig
+	for _, proc := range c.Processes {
+		if proc.Replicas <= 0 {
+			processes = append(processes, proc)
+			continue
+		}
+
+		for i := 0; i < proc.Replicas; i++ {
+			replica := *proc
+			replica.Name = ReplicaName(proc.Name, i)
+			replica.Replicas = 0
+			replica.ReplicaOf = proc.Name
+			replica.ReplicaIndex = i
+
+			replica.Env = map[string]string{}
+			for k, v := range proc.Env {
+				replica.Env[k] = v
+			}
+			replica.Env[ReplicaIndexEnv] = strconv.Itoa(i)
+
+			processes = append(processes, &replica)
+		}
+	}
+
+	c.Processes = processes
+}
+
+// ReplicasOf returns the replicas of the process with the given name. It
+// returns nothing if the process does not have replicas.
+func (c *JobConfig) ReplicasOf(name string) []*ProcessConfig {
+	var replicas []*ProcessConfig
+	for _, proc := range c.Processes {
+		if proc.ReplicaOf == name {
+			replicas = append(replicas, proc)
+		}
+	}
+
+	return replicas
+}
@@ -20,6 +20,10 @@ package exitstatus
 
 import "fmt"
 
+// ConfigError is the exit status used when bpm cannot make sense of a job
+// configuration file. It is EX_CONFIG from sysexits.h.
+const ConfigError = 78
+
 // Error represents an error and an associated exit status to propagate.
 type Error struct {
 	Status int
@@ -59,6 +59,24 @@ func (f *Flock) Lock() error {
 	return nil
 }
 
+// TryLock exclusively locks the file if it is not already locked by another
+// handle. It does not block and returns whether the lock was acquired.
+func (f *Flock) TryLock() (bool, error) {
+	f.lockedMu.Lock()
+	defer f.lockedMu.Unlock()
+
+	err := unix.Flock(int(f.f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	f.locked = true
+	return true, nil
+}
+
 // Unlock unlocks the file so that another waiting task can acquire the lock.
 // This function will panic unlock is called on a lock which is already
 // unlocked. It is not possible to unlock a lock from a different handle than
@@ -114,6 +114,25 @@ var _ = Describe("Flock", func() {
 
 				Eventually(c).Should(BeClosed())
 			})
+
+			It("does not block trying to lock while the lock is held", func() {
+				err := lock.Lock()
+				Expect(err).NotTo(HaveOccurred())
+
+				acquired, err := lock2.TryLock()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(acquired).To(BeFalse())
+
+				err = lock.Unlock()
+				Expect(err).NotTo(HaveOccurred())
+
+				acquired, err = lock2.TryLock()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(acquired).To(BeTrue())
+
+				err = lock2.Unlock()
+				Expect(err).NotTo(HaveOccurred())
+			})
 		})
 	})
 })
@@ -23,6 +23,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"bpm/flock"
 	"bpm/jobid"
@@ -87,3 +88,37 @@ func (h *Handle) LockVolume(path string) (LockedLock, error) {
 
 	return fl, nil
 }
+
+// SlotRetryInterval is how long LockSlot waits before checking again whether
+// any of the slots have been released.
+const SlotRetryInterval = 100 * time.Millisecond
+
+// LockSlot acquires one of limit locks with the given name. It blocks until a
+// slot is free which allows it to be used to limit how many consumers on the
+// host perform an operation at the same time.
+func (h *Handle) LockSlot(name string, limit int) (LockedLock, error) {
+	var slots []*flock.Flock
+	for i := 0; i < limit; i++ {
+		path := filepath.Join(h.path, fmt.Sprintf("slot-%s-%d.lock", name, i))
+		fl, err := flock.New(path)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, fl)
+	}
+
+	for {
+		for _, fl := range slots {
+			acquired, err := fl.TryLock()
+			if err != nil {
+				return nil, err
+			}
+
+			if acquired {
+				return fl, nil
+			}
+		}
+
+		time.Sleep(SlotRetryInterval)
+	}
+}
@@ -109,4 +109,43 @@ var _ = Describe("Hostlock", func() {
 			return locks.LockVolume("/var/vcap/data/volume2")
 		})
 	})
+
+	Describe("locking slots", func() {
+		ItLocksCorrectly(func(locks *hostlock.Handle) (hostlock.LockedLock, error) {
+			return locks.LockSlot("start", 1)
+		}, func(locks *hostlock.Handle) (hostlock.LockedLock, error) {
+			return locks.LockSlot("other", 1)
+		})
+
+		It("allows up to the limit to be held at once", func() {
+			locks := hostlock.NewHandle(tmpdir)
+
+			first, err := locks.LockSlot("start", 2)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := locks.LockSlot("start", 2)
+			Expect(err).NotTo(HaveOccurred())
+
+			c := make(chan struct{})
+
+			go func() {
+				defer GinkgoRecover()
+
+				third, err := locks.LockSlot("start", 2)
+				Expect(err).NotTo(HaveOccurred())
+
+				close(c)
+
+				err = third.Unlock()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+
+			Consistently(c).ShouldNot(BeClosed())
+
+			Expect(first.Unlock()).To(Succeed())
+
+			Eventually(c).Should(BeClosed())
+
+			Expect(second.Unlock()).To(Succeed())
+		})
+	})
 })
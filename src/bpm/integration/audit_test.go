@@ -0,0 +1,172 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/jobid"
+)
+
+var _ = Describe("audit log", func() {
+	type auditEntry struct {
+		Command    string   `json:"command"`
+		Args       []string `json:"args"`
+		Job        string   `json:"job"`
+		Process    string   `json:"process"`
+		User       string   `json:"user"`
+		Result     string   `json:"result"`
+		ExitStatus int      `json:"exit_status"`
+		Error      string   `json:"error"`
+	}
+
+	var (
+		boshRoot    string
+		containerID string
+		job         string
+		runcRoot    string
+		auditLog    string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "audit-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		auditLog = filepath.Join(boshRoot, "audit.log")
+		writeConfig(boshRoot, job, newJobConfig(job, alternativeBash))
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	bpm := func(args ...string) *gexec.Session {
+		command := exec.Command(bpmPath, args...)
+		command.Env = append(
+			command.Env,
+			fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot),
+			fmt.Sprintf("BPM_AUDIT_LOG=%s", auditLog),
+		)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		return session
+	}
+
+	readEntries := func() []auditEntry {
+		f, err := os.Open(auditLog)
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+
+		var entries []auditEntry
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry auditEntry
+			Expect(json.Unmarshal(scanner.Bytes(), &entry)).To(Succeed())
+			entries = append(entries, entry)
+		}
+		Expect(scanner.Err()).NotTo(HaveOccurred())
+
+		return entries
+	}
+
+	It("records every command which is run", func() {
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+		Expect(bpm("list")).To(gexec.Exit(0))
+		Expect(bpm("stop", job)).To(gexec.Exit(0))
+		Expect(bpm("start", job, "-p", "missing")).To(gexec.Exit(1))
+
+		entries := readEntries()
+		Expect(entries).To(HaveLen(4))
+
+		Expect(entries[0].Command).To(Equal("bpm start"))
+		Expect(entries[0].Args).To(Equal([]string{"start", job}))
+		Expect(entries[0].Job).To(Equal(job))
+		Expect(entries[0].Process).To(Equal(job))
+		Expect(entries[0].User).To(Equal("root"))
+		Expect(entries[0].Result).To(Equal("success"))
+
+		Expect(entries[1].Command).To(Equal("bpm list"))
+		Expect(entries[1].Result).To(Equal("success"))
+
+		Expect(entries[2].Command).To(Equal("bpm stop"))
+		Expect(entries[2].Job).To(Equal(job))
+		Expect(entries[2].Result).To(Equal("success"))
+
+		Expect(entries[3].Command).To(Equal("bpm start"))
+		Expect(entries[3].Process).To(Equal("missing"))
+		Expect(entries[3].Result).To(Equal("failure"))
+		Expect(entries[3].ExitStatus).To(Equal(1))
+		Expect(entries[3].Error).To(ContainSubstring(`process "missing" not present in job configuration`))
+	})
+
+	It("does not credit stopping every process to any one job", func() {
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+		Expect(bpm("stop", "--all")).To(gexec.Exit(0))
+
+		entries := readEntries()
+		Expect(entries).To(HaveLen(2))
+
+		Expect(entries[1].Command).To(Equal("bpm stop"))
+		Expect(entries[1].Args).To(Equal([]string{"stop", "--all"}))
+		Expect(entries[1].Job).To(BeEmpty())
+		Expect(entries[1].Process).To(BeEmpty())
+		Expect(entries[1].Result).To(Equal("success"))
+	})
+
+	It("redacts the values of environment variables given on the command line", func() {
+		writeConfig(boshRoot, job, newJobConfig(job, "true"))
+		Expect(bpm("run", job, "-e", "SECRET=hunter2", "--env=TOKEN=abc")).To(gexec.Exit(0))
+
+		entries := readEntries()
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Args).To(Equal([]string{"run", job, "-e", "SECRET=<redacted>", "--env=TOKEN=<redacted>"}))
+	})
+
+	It("does not write an audit log unless asked to", func() {
+		command := exec.Command(bpmPath, "list")
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+		Expect(session).To(gexec.Exit(0))
+
+		Expect(auditLog).NotTo(BeAnExistingFile())
+	})
+})
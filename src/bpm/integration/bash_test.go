@@ -99,6 +99,17 @@ sleep 100 &
 child=$!;
 wait $child`
 
+const ignoreSigTERMBash = `trap "" SIGTERM;
+sleep 100 &
+child=$!;
+wait $child`
+
+const sigIntOnlyBash = `trap "" SIGTERM;
+trap "echo 'Received SIGINT' && kill -9 $child && exit 0" SIGINT;
+sleep 100 &
+child=$!;
+wait $child`
+
 const privilegedBash = `trap "kill -9 $child" SIGTERM;
 echo "Running as $(whoami)"
 echo "Privileges: $(cat /proc/1/status | grep CapEff)"
@@ -109,6 +120,32 @@ sleep 100 &
 child=$!;
 wait $child`
 
+func execFromDirBash(dir string) string {
+	return fmt.Sprintf(`trap "kill -9 $child" SIGTERM;
+cp /bin/true %[1]s/dropped-binary;
+chmod +x %[1]s/dropped-binary;
+if %[1]s/dropped-binary; then
+  echo "Executed dropped binary"
+else
+  echo "Could not execute dropped binary"
+fi
+sleep 100 &
+child=$!;
+wait $child`, dir)
+}
+
+func shmWriteBash(megabytes int) string {
+	return fmt.Sprintf(`trap "kill -9 $child" SIGTERM;
+if dd if=/dev/zero of=/dev/shm/data bs=1M count=%d; then
+  echo "Wrote to /dev/shm"
+else
+  echo "Failed to write to /dev/shm"
+fi
+sleep 100 &
+child=$!;
+wait $child`, megabytes)
+}
+
 func catBash(path string) string {
 	return fmt.Sprintf(`trap "echo 'Received a Signal' && kill -9 $child" SIGTERM;
 cat %s;
@@ -84,6 +84,21 @@ var _ = Describe("capabilities", func() {
 		Eventually(fileContents(stdout)).Should(MatchRegexp(`CapEff:\s*0000000000000000`))
 	})
 
+	Context("when the default capabilities are kept", func() {
+		BeforeEach(func() {
+			cfg.Processes[0].Unsafe = &config.Unsafe{KeepDefaultCapabilities: true}
+		})
+
+		It("has the default runc capabilities of AUDIT_WRITE, KILL and NET_BIND_SERVICE", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(0))
+			Eventually(fileContents(stdout)).Should(MatchRegexp(`CapEff:\s*0000000020000420`))
+		})
+	})
+
 	Context("when the NET_BIND_SERVICE capability is provided", func() {
 		BeforeEach(func() {
 			cfg = newJobConfig(job, netBindServiceCapabilityBash)
@@ -0,0 +1,112 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/jobid"
+)
+
+var _ = Describe("checkpoint and restore", func() {
+	var (
+		boshRoot    string
+		containerID string
+		imagePath   string
+		job         string
+		runcRoot    string
+		stdout      string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "checkpoint-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		imagePath = filepath.Join(boshRoot, "checkpoint")
+		stdout = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", job))
+
+		cfg := newJobConfig(job, `i=0; while true; do echo "count=$i"; i=$((i+1)); sleep 0.1; done`)
+		writeConfig(boshRoot, job, cfg)
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	bpm := func(args ...string) *gexec.Session {
+		command := exec.Command(bpmPath, args...)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		return session
+	}
+
+	It("resumes the process where it left off", func() {
+		if _, err := exec.LookPath("criu"); err != nil {
+			Skip("criu is not installed")
+		}
+
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+		Eventually(fileContents(stdout)).Should(ContainSubstring("count=5\n"))
+
+		Expect(bpm("checkpoint", job, "--image-path", imagePath)).To(gexec.Exit(0))
+		Expect(runcState(runcRoot, containerID).Status).To(BeEmpty())
+
+		lines := fileLines(stdout)()
+		last := lines[len(lines)-1]
+		var count int
+		_, err := fmt.Sscanf(last, "count=%d", &count)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(bpm("restore", job, "--image-path", imagePath)).To(gexec.Exit(0))
+		Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+
+		Eventually(fileContents(stdout)).Should(ContainSubstring(fmt.Sprintf("count=%d\n", count+1)))
+		Expect(strings.Count(fileContents(stdout)(), "count=0\n")).To(Equal(1))
+	})
+
+	Context("when no image path is given", func() {
+		It("exits with a non-zero exit code and prints an error", func() {
+			session := bpm("checkpoint", job)
+			Expect(session).To(gexec.Exit(1))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("must specify an image path"))
+		})
+	})
+})
@@ -0,0 +1,138 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	uuid "github.com/satori/go.uuid"
+	yaml "gopkg.in/yaml.v2"
+
+	"bpm/config"
+)
+
+var _ = Describe("config", func() {
+	var (
+		command *exec.Cmd
+
+		cfg config.JobConfig
+
+		boshRoot string
+		job      string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "config-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		setupBoshDirectories(boshRoot, job)
+
+		propertiesPath := filepath.Join(boshRoot, "jobs", job, "config", "properties.yml")
+		Expect(os.MkdirAll(filepath.Dir(propertiesPath), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(propertiesPath, []byte("port: 8080\n"), 0644)).To(Succeed())
+
+		cfg = newJobConfig(job, `sleep 100`)
+		cfg.Processes[0].PropertiesFile = "properties.yml"
+		cfg.Processes[0].Env = map[string]string{"LEVEL": "debug", "SECRET": "hunter2"}
+		cfg.Processes[0].SensitiveEnv = []string{"SECRET"}
+	})
+
+	JustBeforeEach(func() {
+		writeConfig(boshRoot, job, cfg)
+		command = exec.Command(bpmPath, "config", job)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("prints the configuration with the merged environment", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+		Expect(session).To(gexec.Exit(0))
+
+		var printed config.ProcessConfig
+		Expect(yaml.Unmarshal(session.Out.Contents(), &printed)).To(Succeed())
+
+		Expect(printed.Name).To(Equal(job))
+		Expect(printed.Env).To(HaveKeyWithValue("LEVEL", "debug"))
+		Expect(printed.Env).To(HaveKeyWithValue("BPM_PROP_port", "8080"))
+	})
+
+	It("redacts sensitive environment variables", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+		Expect(session).To(gexec.Exit(0))
+
+		Expect(string(session.Out.Contents())).NotTo(ContainSubstring("hunter2"))
+		Expect(session.Out).To(gbytes.Say("SECRET: <redacted>"))
+	})
+
+	Context("when environment variables are fetched by a command", func() {
+		var fetcher, marker string
+
+		BeforeEach(func() {
+			fetcher = filepath.Join(boshRoot, "fetch-env")
+			marker = filepath.Join(boshRoot, "fetched")
+			Expect(ioutil.WriteFile(fetcher, []byte(fmt.Sprintf("#!/bin/bash\ntouch %s\necho FETCHED=secret\n", marker)), 0755)).To(Succeed())
+
+			cfg.Processes[0].EnvFrom = []config.EnvSource{{Command: fetcher}}
+		})
+
+		It("prints the command without running it", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			var printed config.ProcessConfig
+			Expect(yaml.Unmarshal(session.Out.Contents(), &printed)).To(Succeed())
+			Expect(printed.EnvFrom).To(Equal([]config.EnvSource{{Command: fetcher}}))
+			Expect(printed.Env).NotTo(HaveKey("FETCHED"))
+
+			Expect(marker).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when the process does not exist", func() {
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "-p", "missing")
+		})
+
+		It("exits with a non-zero exit code and prints an error", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(`process "missing" not present in job configuration`))
+		})
+	})
+})
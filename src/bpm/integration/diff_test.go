@@ -0,0 +1,122 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	uuid "github.com/satori/go.uuid"
+	yaml "gopkg.in/yaml.v2"
+
+	"bpm/config"
+	"bpm/jobid"
+)
+
+var _ = Describe("diff", func() {
+	var (
+		boshRoot    string
+		cfg         config.JobConfig
+		containerID string
+		job         string
+		runcRoot    string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "diff-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		cfg = newJobConfig(job, "sleep 100")
+		cfg.Processes[0].Env = map[string]string{"COLOR": "red"}
+		writeConfig(boshRoot, job, cfg)
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	bpm := func(args ...string) *gexec.Session {
+		command := exec.Command(bpmPath, args...)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		return session
+	}
+
+	It("succeeds when the configuration has not changed", func() {
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+
+		session := bpm("diff", job)
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out.Contents()).To(BeEmpty())
+	})
+
+	It("reports the changes made to the configuration since the process was started", func() {
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+
+		cfg.Processes[0].Env["COLOR"] = "blue"
+		writeConfig(boshRoot, job, cfg)
+
+		session := bpm("diff", job)
+		Expect(session).To(gexec.Exit(1))
+		Expect(session.Out).To(gbytes.Say(`-\s+COLOR: red`))
+		Expect(session.Out).To(gbytes.Say(`\+\s+COLOR: blue`))
+		Expect(session.Err).To(gbytes.Say("the configuration has changed since the process was started"))
+	})
+
+	It("compares against another configuration file", func() {
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+
+		other := filepath.Join(boshRoot, "other.yml")
+		cfg.Processes[0].Executable = "/bin/sh"
+		data, err := yaml.Marshal(&cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(other, data, 0644)).To(Succeed())
+
+		session := bpm("diff", job, "-c", other)
+		Expect(session).To(gexec.Exit(1))
+		Expect(session.Out).To(gbytes.Say(`-executable: /bin/bash`))
+		Expect(session.Out).To(gbytes.Say(`\+executable: /bin/sh`))
+	})
+
+	Context("when the process has not been started", func() {
+		It("exits with a non-zero exit code and prints an error", func() {
+			session := bpm("diff", job)
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("no configuration was recorded when the process was started"))
+		})
+	})
+})
@@ -0,0 +1,110 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	uuid "github.com/satori/go.uuid"
+)
+
+var _ = Describe("doctor", func() {
+	var (
+		command *exec.Cmd
+
+		boshRoot string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "doctor-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+
+		setupBoshDirectories(boshRoot, uuid.NewV4().String())
+		Expect(os.MkdirAll(filepath.Join(boshRoot, "sys", "log"), 0755)).To(Succeed())
+
+		command = exec.Command(bpmPath, "doctor")
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("passes on a machine which is set up correctly", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say("PASS runc is installed"))
+		Expect(session.Out).To(gbytes.Say("PASS data directory is writable"))
+		Expect(session.Out).NotTo(gbytes.Say("FAIL"))
+	})
+
+	It("does not start any containers or create any directories", func() {
+		Expect(os.RemoveAll(filepath.Join(boshRoot, "data", "bpm"))).To(Succeed())
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		Expect(filepath.Join(boshRoot, "data", "bpm")).NotTo(BeADirectory())
+	})
+
+	Context("when runc is missing", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(filepath.Join(boshRoot, "packages", "bpm", "bin", "runc"))).To(Succeed())
+		})
+
+		It("reports the failure and exits with a non-zero exit code", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Out).To(gbytes.Say("FAIL runc is installed"))
+			Expect(session.Out).To(gbytes.Say("ensure the bpm release is deployed"))
+			Expect(session.Err).To(gbytes.Say("1 critical check\\(s\\) failed"))
+		})
+	})
+
+	Context("when the data directory is missing", func() {
+		BeforeEach(func() {
+			Expect(os.RemoveAll(filepath.Join(boshRoot, "data"))).To(Succeed())
+		})
+
+		It("reports the failure and exits with a non-zero exit code", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Out).To(gbytes.Say("FAIL data directory is writable"))
+			Expect(session.Out).To(gbytes.Say("ensure the ephemeral disk is mounted"))
+		})
+	})
+})
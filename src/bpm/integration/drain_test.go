@@ -0,0 +1,141 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/bosh"
+	"bpm/config"
+	"bpm/jobid"
+)
+
+var _ = Describe("drain", func() {
+	var (
+		boshRoot    string
+		containerID string
+		job         string
+		runcRoot    string
+		drainScript string
+		marker      string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "drain-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		drainScript = filepath.Join(boshRoot, "jobs", job, "bin", "drain")
+		Expect(os.MkdirAll(filepath.Dir(drainScript), 0755)).To(Succeed())
+		marker = filepath.Join(boshRoot, "data", job, "drained")
+
+		cfg := newJobConfig(job, `sleep 100`)
+		cfg.Processes[0].Hooks = &config.Hooks{
+			Drain: bosh.NewEnv(boshRoot).JobDir(job).Join("bin", "drain").Internal(),
+		}
+		writeConfig(boshRoot, job, cfg)
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	bpm := func(args ...string) *gexec.Session {
+		command := exec.Command(bpmPath, args...)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		return session
+	}
+
+	It("runs the drain hook in the container and leaves the process running", func() {
+		Expect(ioutil.WriteFile(drainScript, []byte("#!/bin/bash\ntouch /var/vcap/data/"+job+"/drained\n"), 0777)).To(Succeed())
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+
+		session := bpm("drain", job)
+		Expect(session).To(gexec.Exit(0))
+		Expect(string(session.Out.Contents())).To(Equal("0\n"))
+
+		Expect(marker).To(BeAnExistingFile())
+		Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+	})
+
+	Context("when the drain hook asks to be checked on", func() {
+		It("runs it again until it reports that it has drained", func() {
+			script := `#!/bin/bash
+if [ "$1" != job_check_status ]; then
+  echo -1
+  exit
+fi
+
+if [ -f /var/vcap/data/%[1]s/checked ]; then
+  touch /var/vcap/data/%[1]s/drained
+  echo 0
+else
+  touch /var/vcap/data/%[1]s/checked
+  echo -1
+fi
+`
+			Expect(ioutil.WriteFile(drainScript, []byte(fmt.Sprintf(script, job)), 0777)).To(Succeed())
+			Expect(bpm("start", job)).To(gexec.Exit(0))
+
+			Expect(bpm("drain", job)).To(gexec.Exit(0))
+			Expect(marker).To(BeAnExistingFile())
+		})
+	})
+
+	Context("when the drain hook fails", func() {
+		It("exits with a non-zero exit code and prints an error", func() {
+			Expect(ioutil.WriteFile(drainScript, []byte("#!/bin/bash\nexit 2\n"), 0777)).To(Succeed())
+			Expect(bpm("start", job)).To(gexec.Exit(0))
+
+			session := bpm("drain", job)
+			Expect(session).To(gexec.Exit(1))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("drain hook failed"))
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+		})
+	})
+
+	Context("when the process is not running", func() {
+		It("exits with a non-zero exit code and prints an error", func() {
+			session := bpm("drain", job)
+			Expect(session).To(gexec.Exit(1))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("process is not running or could not be found"))
+		})
+	})
+})
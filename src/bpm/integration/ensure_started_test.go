@@ -0,0 +1,134 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/config"
+	"bpm/jobid"
+)
+
+var _ = Describe("ensure-started", func() {
+	var (
+		command *exec.Cmd
+
+		cfg config.JobConfig
+
+		boshRoot    string
+		bpmLog      string
+		containerID string
+		job         string
+		runcRoot    string
+		stdout      string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "ensure-started-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		stdout = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", job))
+		bpmLog = filepath.Join(boshRoot, "sys", "log", job, "bpm.log")
+		logFile := filepath.Join(boshRoot, "sys", "log", job, "foo.log")
+
+		cfg = newJobConfig(job, defaultBash(logFile))
+	})
+
+	JustBeforeEach(func() {
+		writeConfig(boshRoot, job, cfg)
+		command = exec.Command(bpmPath, "ensure-started", job)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	Context("when the process is not running", func() {
+		It("starts the process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+			Eventually(fileContents(stdout)).Should(ContainSubstring("Logging to STDOUT"))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("bpm.ensure-started.starting"))
+		})
+	})
+
+	Context("when the process is already running", func() {
+		var existingPid int
+
+		JustBeforeEach(func() {
+			startJob(boshRoot, bpmPath, job)
+
+			state := runcState(runcRoot, containerID)
+			Expect(state.Status).To(Equal(specs.StateRunning))
+			existingPid = state.Pid
+		})
+
+		It("leaves the running process alone and succeeds", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(state.Status).To(Equal(specs.StateRunning))
+			Expect(state.Pid).To(Equal(existingPid))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("process-already-running"))
+		})
+	})
+
+	Context("when the configuration is invalid", func() {
+		JustBeforeEach(func() {
+			writeInvalidConfig(boshRoot, job)
+		})
+
+		It("exits with a non-zero exit code and prints an error", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("failed to parse job configuration"))
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+		})
+	})
+})
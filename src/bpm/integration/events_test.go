@@ -0,0 +1,155 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/config"
+	"bpm/jobid"
+)
+
+var _ = Describe("events", func() {
+	var (
+		command *exec.Cmd
+
+		cfg config.JobConfig
+
+		boshRoot    string
+		containerID string
+		job         string
+		runcRoot    string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "events-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		logFile := filepath.Join(boshRoot, "sys", "log", job, "foo.log")
+		cfg = newJobConfig(job, defaultBash(logFile))
+		writeConfig(boshRoot, job, cfg)
+	})
+
+	JustBeforeEach(func() {
+		command = exec.Command(bpmPath, "events", job)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("streams the container events", func() {
+		startJob(boshRoot, bpmPath, job)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer session.Kill()
+
+		Eventually(session.Out, 10*time.Second).Should(gbytes.Say(`"type":"stats"`))
+	})
+
+	It("detaches cleanly when interrupted without affecting the container", func() {
+		startJob(boshRoot, bpmPath, job)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer session.Kill()
+
+		Eventually(session.Out, 10*time.Second).Should(gbytes.Say(`"type":"stats"`))
+
+		session.Interrupt()
+		Eventually(session, 5*time.Second).Should(gexec.Exit(0))
+
+		Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+	})
+
+	Context("when the container does not exist", func() {
+		It("returns an error", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).Should(gbytes.Say("process is not running or could not be found"))
+		})
+	})
+
+	Context("when following the events", func() {
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "--follow")
+		})
+
+		It("continues streaming across a restart and marks the restart", func() {
+			startJob(boshRoot, bpmPath, job)
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer session.Kill()
+
+			Eventually(session.Out, 10*time.Second).Should(gbytes.Say(`"type":"stats"`))
+
+			stop := exec.Command(bpmPath, "stop", job)
+			stop.Env = append(stop.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			stopSession, err := gexec.Start(stop, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(stopSession, 20*time.Second).Should(gexec.Exit(0))
+
+			startJob(boshRoot, bpmPath, job)
+
+			Eventually(session.Out, 10*time.Second).Should(gbytes.Say(fmt.Sprintf(`"type":"restart","id":"%s"`, containerID)))
+			Eventually(session.Out, 10*time.Second).Should(gbytes.Say(`"type":"stats"`))
+			Expect(session.ExitCode()).To(Equal(-1))
+		})
+
+		It("detaches cleanly when terminated", func() {
+			startJob(boshRoot, bpmPath, job)
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer session.Kill()
+
+			Eventually(session.Out, 10*time.Second).Should(gbytes.Say(`"type":"stats"`))
+
+			session.Terminate()
+			Eventually(session, 5*time.Second).Should(gexec.Exit(0))
+
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+		})
+	})
+})
@@ -118,6 +118,31 @@ func prepareRunc(packagePath string) {
 	Expect(err).NotTo(HaveOccurred())
 }
 
+// installFlakyRunc replaces the runc binary in the BOSH root with a wrapper
+// which fails the first time it is invoked with the given subcommand and
+// otherwise passes through to the real runc.
+func installFlakyRunc(root, subcommand string) {
+	runcPath, err := exec.LookPath("runc")
+	Expect(err).NotTo(HaveOccurred())
+
+	wrapperPath := filepath.Join(root, "packages", "bpm", "bin", "runc")
+	markerPath := filepath.Join(root, "runc-failed-once")
+
+	wrapper := fmt.Sprintf(`#!/bin/sh
+for arg in "$@"; do
+  if [ "$arg" = "%[3]s" ] && [ ! -e %[1]s ]; then
+    touch %[1]s
+    echo "transient runc failure" >&2
+    exit 1
+  fi
+done
+exec %[2]s "$@"
+`, markerPath, runcPath, subcommand)
+
+	Expect(os.Remove(wrapperPath)).To(Succeed())
+	Expect(ioutil.WriteFile(wrapperPath, []byte(wrapper), 0755)).To(Succeed())
+}
+
 func prepareTini(packagePath string) {
 	tiniPath, err := exec.LookPath("tini")
 	Expect(err).NotTo(HaveOccurred())
@@ -16,11 +16,14 @@
 package integration_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -129,4 +132,284 @@ var _ = Describe("list", func() {
 		Expect(session.Out).NotTo(gbytes.Say(unimplementedJob))
 		Expect(session.Err).NotTo(gbytes.Say(unimplementedJob))
 	})
+
+	Context("when a process is started with a custom container id", func() {
+		var customID string
+
+		BeforeEach(func() {
+			customID = fmt.Sprintf("external-%s", uuid.NewV4().String())
+		})
+
+		AfterEach(func() {
+			err := runcCommand(runcRoot, "delete", "--force", customID).Run()
+			if err != nil {
+				fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+			}
+		})
+
+		It("still lists the other jobs", func() {
+			startJob(boshRoot, bpmPath, job)
+
+			start := exec.Command(bpmPath, "start", job, "-p", stoppedProcess, "--container-id", customID)
+			start.Env = append(start.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			session, err := gexec.Start(start, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(0))
+
+			session, err = gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(fmt.Sprintf("%s\\s+\\d+\\s+%s", job, models.ProcessStateRunning)))
+			Expect(session.Err).To(gbytes.Say(fmt.Sprintf(`process \(%s\) not defined`, customID)))
+		})
+	})
+
+	It("shows how long the running jobs have been up", func() {
+		startJob(boshRoot, bpmPath, job)
+		Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).ShouldNot(HaveOccurred())
+		<-session.Exited
+
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say("Name\\s+Pid\\s+Status\\s+Uptime"))
+		Expect(session.Out).To(gbytes.Say(fmt.Sprintf("%s\\s+\\d+\\s+%s\\s+\\d+", job, models.ProcessStateRunning)))
+	})
+
+	Context("when JSON output is requested", func() {
+		BeforeEach(func() {
+			command.Args = append(command.Args, "--json")
+		})
+
+		It("includes the creation time and uptime of the running jobs", func() {
+			startJob(boshRoot, bpmPath, job)
+			Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			var processes []struct {
+				Name          string    `json:"name"`
+				Status        string    `json:"status"`
+				Created       time.Time `json:"created"`
+				UptimeSeconds *int64    `json:"uptime_seconds"`
+			}
+			Expect(json.Unmarshal(session.Out.Contents(), &processes)).To(Succeed())
+
+			var found bool
+			for _, p := range processes {
+				if p.Name != job {
+					continue
+				}
+
+				found = true
+				Expect(p.Status).To(Equal(models.ProcessStateRunning))
+				Expect(p.Created).To(BeTemporally("<=", time.Now()))
+				Expect(p.UptimeSeconds).NotTo(BeNil())
+				Expect(*p.UptimeSeconds).To(BeNumerically(">=", 0))
+			}
+			Expect(found).To(BeTrue())
+		})
+
+		It("includes the annotations of jobs started with a BOSH spec file", func() {
+			specPath := filepath.Join(boshRoot, "spec.json")
+			contents := `{"deployment": "cf", "name": "router", "az": "z1", "index": 2, "id": "abc"}`
+			Expect(ioutil.WriteFile(specPath, []byte(contents), 0644)).To(Succeed())
+
+			startCommand := exec.Command(bpmPath, "start", job)
+			startCommand.Env = append(
+				startCommand.Env,
+				fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot),
+				fmt.Sprintf("BPM_BOSH_SPEC_FILE=%s", specPath),
+			)
+			startSession, err := gexec.Start(startCommand, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-startSession.Exited
+			Expect(startSession).To(gexec.Exit(0))
+
+			annotations := map[string]string{
+				"org.cloudfoundry.bpm.job":             job,
+				"org.cloudfoundry.bpm.process":         job,
+				"org.cloudfoundry.bosh.deployment":     "cf",
+				"org.cloudfoundry.bosh.instance_group": "router",
+				"org.cloudfoundry.bosh.az":             "z1",
+				"org.cloudfoundry.bosh.index":          "2",
+			}
+			Expect(runcState(runcRoot, containerID).Annotations).To(Equal(annotations))
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			var processes []struct {
+				Name        string            `json:"name"`
+				Annotations map[string]string `json:"annotations"`
+			}
+			Expect(json.Unmarshal(session.Out.Contents(), &processes)).To(Succeed())
+
+			var found bool
+			for _, p := range processes {
+				if p.Name != job {
+					continue
+				}
+
+				found = true
+				Expect(p.Annotations).To(Equal(annotations))
+			}
+			Expect(found).To(BeTrue())
+		})
+	})
+
+	Context("when a template is given", func() {
+		BeforeEach(func() {
+			command.Args = append(command.Args, "--job", job, "--template", "{{.Name}}={{.Pid}}")
+		})
+
+		It("prints each process with the template", func() {
+			startJob(boshRoot, bpmPath, job)
+			Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(string(session.Out.Contents())).To(Equal(fmt.Sprintf("%s=%d\n%s=0\n", job, state.Pid, stoppedProcess)))
+		})
+
+		It("rejects an invalid template before printing anything", func() {
+			command.Args = append(command.Args, "--template", "{{.Name")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(1))
+			Expect(session.Out.Contents()).To(BeEmpty())
+			Expect(session.Err).To(gbytes.Say("invalid template"))
+		})
+	})
+
+	Context("when watching", func() {
+		BeforeEach(func() {
+			command.Args = append(command.Args, "--job", job, "--watch=100ms")
+		})
+
+		It("redraws the list until it is interrupted", func() {
+			startJob(boshRoot, bpmPath, job)
+			Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				Eventually(session.Out).Should(gbytes.Say("\033\\[H\033\\[2J"))
+				Eventually(session.Out).Should(gbytes.Say(fmt.Sprintf("%s\\s+\\d+\\s+%s", job, models.ProcessStateRunning)))
+			}
+
+			session.Interrupt()
+			Eventually(session).Should(gexec.Exit(0))
+		})
+
+		It("rejects an interval which is not positive", func() {
+			command.Args = append(command.Args, "--watch=0s")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("invalid watch interval: 0s must be positive"))
+		})
+	})
+
+	Context("when filtering by job", func() {
+		BeforeEach(func() {
+			command.Args = append(command.Args, "--job", job)
+		})
+
+		It("only lists the processes of that job", func() {
+			startJob(boshRoot, bpmPath, job)
+			startJob(boshRoot, bpmPath, failedJob)
+
+			Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+			Eventually(func() specs.ContainerState { return runcState(runcRoot, failedContainerID).Status }).Should(Equal(specs.StateStopped))
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(fmt.Sprintf("%s\\s+\\d+\\s+%s", job, models.ProcessStateRunning)))
+			Expect(session.Out.Contents()).To(ContainSubstring(stoppedProcess))
+			Expect(session.Out.Contents()).NotTo(ContainSubstring(failedJob))
+			Expect(session.Err.Contents()).NotTo(ContainSubstring(invalidJob))
+		})
+
+		Context("when a process name is also given", func() {
+			BeforeEach(func() {
+				command.Args = append(command.Args, "--process", stoppedProcess)
+			})
+
+			It("only lists that process", func() {
+				startJob(boshRoot, bpmPath, job)
+				Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).ShouldNot(HaveOccurred())
+				<-session.Exited
+
+				Expect(session).To(gexec.Exit(0))
+				Expect(session.Out).To(gbytes.Say(fmt.Sprintf("%s\\s+%s\\s+%s", stoppedProcess, "-", models.ProcessStateStopped)))
+				Expect(session.Out.Contents()).NotTo(ContainSubstring(job + " "))
+			})
+		})
+
+		Context("when no job matches", func() {
+			BeforeEach(func() {
+				command.Args = []string{bpmPath, "list", "--job", "does-not-exist"}
+			})
+
+			It("only prints the header", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).ShouldNot(HaveOccurred())
+				<-session.Exited
+
+				Expect(session).To(gexec.Exit(0))
+				Expect(strings.TrimSpace(string(session.Out.Contents()))).To(MatchRegexp(`^Name\s+Pid\s+Status\s+Uptime$`))
+			})
+
+			It("prints an empty list as JSON", func() {
+				command.Args = append(command.Args, "--json")
+
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).ShouldNot(HaveOccurred())
+				<-session.Exited
+
+				Expect(session).To(gexec.Exit(0))
+				Expect(session.Out.Contents()).To(MatchJSON("[]"))
+			})
+		})
+	})
+
+	Context("when runc fails transiently", func() {
+		It("retries and lists the running jobs", func() {
+			startJob(boshRoot, bpmPath, job)
+			Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+			installFlakyRunc(boshRoot, "list")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			state := runcState(runcRoot, containerID)
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say(fmt.Sprintf("%s\\s+%d\\s+%s", job, state.Pid, state.Status)))
+		})
+	})
 })
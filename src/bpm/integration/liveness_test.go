@@ -0,0 +1,229 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/bosh"
+	"bpm/config"
+	"bpm/jobid"
+)
+
+var _ = Describe("liveness", func() {
+	var (
+		command *exec.Cmd
+
+		cfg config.JobConfig
+
+		boshRoot    string
+		containerID string
+		job         string
+		runcRoot    string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "liveness-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		heartbeatFile := filepath.Join(bosh.NewEnv(boshRoot).DataDir(job).Internal(), "heartbeat")
+
+		// The process keeps its heartbeat up for a few seconds and then hangs.
+		cfg = newJobConfig(job, fmt.Sprintf(`for i in 1 2 3; do touch %s; sleep 1; done; sleep 100`, heartbeatFile))
+		cfg.Processes[0].EphemeralDisk = true
+		cfg.Processes[0].Liveness = &config.Liveness{
+			HeartbeatFile: heartbeatFile,
+			Interval:      "2s",
+		}
+	})
+
+	JustBeforeEach(func() {
+		writeConfig(boshRoot, job, cfg)
+		command = exec.Command(bpmPath, "start", job)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("kills the process once its heartbeat goes stale", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+		Expect(session).To(gexec.Exit(0))
+
+		Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+
+		// It stays alive while the heartbeat is kept up.
+		Consistently(func() specs.ContainerState {
+			return runcState(runcRoot, containerID).Status
+		}, 2*time.Second).Should(Equal(specs.StateRunning))
+
+		Eventually(func() specs.ContainerState {
+			return runcState(runcRoot, containerID).Status
+		}, 10*time.Second).Should(Equal(specs.StateStopped))
+
+		bpmLog := filepath.Join(boshRoot, "sys", "log", job, "bpm.log")
+		Expect(fileContents(bpmLog)()).To(ContainSubstring("heartbeat-stale"))
+	})
+
+	Context("when the interval is changed while the process runs", func() {
+		BeforeEach(func() {
+			cfg.Processes[0].Liveness.Interval = "1h"
+		})
+
+		It("applies the new interval once the supervisor is sent SIGHUP", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			// The heartbeat stops after a few seconds and an hour is far
+			// longer than the process is watched for.
+			Consistently(func() specs.ContainerState {
+				return runcState(runcRoot, containerID).Status
+			}, 6*time.Second).Should(Equal(specs.StateRunning))
+
+			cfg.Processes[0].Liveness.Interval = "2s"
+			writeConfig(boshRoot, job, cfg)
+
+			output, err := exec.Command("pkill", "-HUP", "-f", fmt.Sprintf("supervise %s", job)).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+
+			Eventually(func() specs.ContainerState {
+				return runcState(runcRoot, containerID).Status
+			}, 10*time.Second).Should(Equal(specs.StateStopped))
+
+			bpmLog := filepath.Join(boshRoot, "sys", "log", job, "bpm.log")
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("bpm.supervise.reload.changed"))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("heartbeat-stale"))
+		})
+	})
+
+	Context("when the process should be restarted", func() {
+		BeforeEach(func() {
+			cfg.Processes[0].Liveness.Restart = true
+		})
+
+		It("starts it again after killing it", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			firstPid := runcState(runcRoot, containerID).Pid
+			Expect(firstPid).NotTo(BeZero())
+
+			Eventually(func() int {
+				state := runcState(runcRoot, containerID)
+				if state.Status != specs.StateRunning {
+					return firstPid
+				}
+				return state.Pid
+			}, 15*time.Second).ShouldNot(Equal(firstPid))
+		})
+	})
+
+	Context("when the heartbeat may be missed more than once", func() {
+		BeforeEach(func() {
+			cfg.Processes[0].Liveness.FailureThreshold = 3
+		})
+
+		It("only kills the process once it has been missed that many times in a row", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			bpmLog := filepath.Join(boshRoot, "sys", "log", job, "bpm.log")
+			Eventually(fileContents(bpmLog), 10*time.Second).Should(ContainSubstring("heartbeat-missed"))
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+
+			Eventually(func() specs.ContainerState {
+				return runcState(runcRoot, containerID).Status
+			}, 15*time.Second).Should(Equal(specs.StateStopped))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("heartbeat-stale"))
+		})
+	})
+
+	Context("when the number of restarts is limited", func() {
+		BeforeEach(func() {
+			cfg.Processes[0].Liveness.Restart = true
+			cfg.Processes[0].Liveness.MaxRestarts = 1
+		})
+
+		It("keeps restarting a process which sends a heartbeat after each restart", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			bpmLog := filepath.Join(boshRoot, "sys", "log", job, "bpm.log")
+			Eventually(fileContents(bpmLog), 30*time.Second).Should(ContainSubstring("restart-count-reset"))
+			Expect(fileContents(bpmLog)()).NotTo(ContainSubstring("restart-limit-reached"))
+		})
+
+		Context("when the process never sends a heartbeat", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].Args = []string{"-c", "sleep 100"}
+			})
+
+			It("stops restarting it once the limit is reached", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				firstPid := runcState(runcRoot, containerID).Pid
+				Expect(firstPid).NotTo(BeZero())
+
+				Eventually(func() int {
+					state := runcState(runcRoot, containerID)
+					if state.Status != specs.StateRunning {
+						return firstPid
+					}
+					return state.Pid
+				}, 15*time.Second).ShouldNot(Equal(firstPid))
+
+				bpmLog := filepath.Join(boshRoot, "sys", "log", job, "bpm.log")
+				Eventually(fileContents(bpmLog), 15*time.Second).Should(ContainSubstring("restart-limit-reached"))
+				Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateStopped))
+			})
+		})
+	})
+})
@@ -0,0 +1,148 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/config"
+	"bpm/jobid"
+)
+
+var _ = Describe("metrics", func() {
+	var (
+		command *exec.Cmd
+
+		cfg config.JobConfig
+
+		boshRoot    string
+		containerID string
+		job         string
+		runcRoot    string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "metrics-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		memory := "64M"
+		cfg = newJobConfig(job, `sleep 100`)
+		cfg.Processes[0].Limits = &config.Limits{Memory: &memory}
+		writeConfig(boshRoot, job, cfg)
+
+		command = exec.Command(bpmPath, "metrics")
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("labels the memory and cpu metrics with the job and process", func() {
+		startJob(boshRoot, bpmPath, job)
+		Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session).Should(gexec.Exit(0))
+
+		labels := fmt.Sprintf(`\{job="%s",process="%s"\}`, job, job)
+		Expect(session.Out).To(gbytes.Say(`bpm_up` + labels + ` 1\n`))
+		Expect(session.Out).To(gbytes.Say(`bpm_memory_usage_bytes` + labels + ` \d+\n`))
+		Expect(session.Out).To(gbytes.Say(`bpm_memory_limit_bytes` + labels + ` 67108864\n`))
+		Expect(session.Out).To(gbytes.Say(`bpm_cpu_seconds_total` + labels + ` [\d.e-]+\n`))
+		Expect(session.Out).To(gbytes.Say(`# EOF\n`))
+	})
+
+	It("reports a process which is not running as down", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(session.Out).To(gbytes.Say(fmt.Sprintf(`bpm_up\{job="%s",process="%s"\} 0\n`, job, job)))
+		Expect(string(session.Out.Contents())).NotTo(ContainSubstring("bpm_memory_usage_bytes{"))
+	})
+
+	Context("when the process is started with a custom container id", func() {
+		BeforeEach(func() {
+			containerID = "external-" + uuid.NewV4().String()
+		})
+
+		It("matches the container to the process through its annotations", func() {
+			start := exec.Command(bpmPath, "start", job, "--container-id", containerID)
+			start.Env = append(start.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			startSession, err := gexec.Start(start, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(startSession).Should(gexec.Exit(0))
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say(fmt.Sprintf(`bpm_up\{job="%s",process="%s"\} 1\n`, job, job)))
+			Expect(string(session.Out.Contents())).To(ContainSubstring(fmt.Sprintf(`bpm_memory_usage_bytes{job="%s",process="%s"}`, job, job)))
+		})
+	})
+
+	Context("when the process has replicas", func() {
+		BeforeEach(func() {
+			cfg.Processes[0].Replicas = 2
+			writeConfig(boshRoot, job, cfg)
+		})
+
+		AfterEach(func() {
+			for i := 0; i < 2; i++ {
+				replicaID := jobid.Encode(fmt.Sprintf("%s.%s", job, config.ReplicaName(job, i)))
+				err := runcCommand(runcRoot, "delete", "--force", replicaID).Run()
+				if err != nil {
+					fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+				}
+			}
+		})
+
+		It("labels each replica with its index", func() {
+			startJob(boshRoot, bpmPath, job)
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say(fmt.Sprintf(`bpm_up\{job="%s",process="%s",replica="0"\} 1\n`, job, job)))
+			Expect(session.Out).To(gbytes.Say(fmt.Sprintf(`bpm_up\{job="%s",process="%s",replica="1"\} 1\n`, job, job)))
+		})
+	})
+})
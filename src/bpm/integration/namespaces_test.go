@@ -18,6 +18,7 @@ package integration_test
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -40,6 +41,7 @@ var _ = Describe("start", func() {
 		cfg config.JobConfig
 
 		boshRoot    string
+		bpmLog      string
 		containerID string
 		job         string
 		runcRoot    string
@@ -59,6 +61,7 @@ var _ = Describe("start", func() {
 
 		stderr = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stderr.log", job))
 		stdout = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", job))
+		bpmLog = filepath.Join(boshRoot, "sys", "log", job, "bpm.log")
 	})
 
 	JustBeforeEach(func() {
@@ -110,6 +113,149 @@ var _ = Describe("start", func() {
 		})
 	})
 
+	Context("network", func() {
+		var listener net.Listener
+
+		BeforeEach(func() {
+			var err error
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			port := listener.Addr().(*net.TCPAddr).Port
+			cfg = newJobConfig(job, fmt.Sprintf(
+				`ls /sys/class/net; if exec 3<>/dev/tcp/127.0.0.1/%d; then echo reached host; else echo did not reach host; fi`,
+				port,
+			))
+		})
+
+		AfterEach(func() {
+			Expect(listener.Close()).To(Succeed())
+		})
+
+		It("can reach services bound on the host by default", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("reached host"))
+			Expect(fileContents(stdout)()).NotTo(ContainSubstring("did not reach host"))
+		})
+
+		Context("when the network mode is host", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].NetworkMode = config.NetworkModeHost
+			})
+
+			It("can reach services bound on the host", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(stdout)).Should(ContainSubstring("reached host"))
+				Expect(fileContents(stdout)()).NotTo(ContainSubstring("did not reach host"))
+			})
+		})
+
+		Context("when the network mode is none", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].NetworkMode = config.NetworkModeNone
+			})
+
+			It("only has a loopback interface", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(stdout)).Should(Equal("lo\ndid not reach host\n"))
+			})
+		})
+
+		Context("when the network mode is isolated", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].NetworkMode = config.NetworkModeIsolated
+			})
+
+			It("only has a loopback interface like none", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(stdout)).Should(Equal("lo\ndid not reach host\n"))
+			})
+		})
+	})
+
+	Context("user", func() {
+		const hostUID = 100000
+
+		var ownedFile string
+
+		BeforeEach(func() {
+			ownedFile = filepath.Join(boshRoot, "sys", "log", job, "owned")
+
+			cfg = newJobConfig(job, fmt.Sprintf("id -u; touch /var/vcap/sys/log/%s/owned", job))
+			cfg.Processes[0].UserNamespace = &config.UserNamespace{
+				HostUID: hostUID,
+				HostGID: hostUID,
+			}
+		})
+
+		It("runs the process as root in its namespace but as the mapped user on the host", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(Equal("0\n"))
+			Eventually(ownedFile).Should(BeAnExistingFile())
+
+			output, err := exec.Command("ls", "-n", ownedFile).Output()
+			Expect(err).NotTo(HaveOccurred())
+
+			fields := strings.Fields(string(output))
+			Expect(fields[2]).To(Equal(strconv.Itoa(hostUID)))
+			Expect(fields[3]).To(Equal(strconv.Itoa(hostUID)))
+		})
+	})
+
+	Context("uts", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, "hostname")
+			cfg.Processes[0].Hostname = config.HostnameInstance
+		})
+
+		It("uses the BOSH instance name as the hostname", func() {
+			command.Env = append(command.Env, "BPM_INSTANCE_NAME=web-0")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(Equal("web-0\n"))
+		})
+
+		It("falls back to the container ID without an instance name", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(Equal(containerID + "\n"))
+		})
+	})
+
 	Context("pid", func() {
 		var hostPidNs string
 
@@ -132,6 +278,7 @@ var _ = Describe("start", func() {
 
 			Eventually(fileLines(stdout)).Should(HaveLen(1))
 			Expect(fileLines(stdout)()).ToNot(ContainElement(hostPidNs))
+			Expect(fileContents(bpmLog)()).NotTo(ContainSubstring("host-pid"))
 		})
 
 		Context("when HostPidNamespace has been enabled", func() {
@@ -152,6 +299,16 @@ var _ = Describe("start", func() {
 					ContainElement(hostPidNs),
 				)
 			})
+
+			It("records the use of the host pid namespace for auditing", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Expect(fileContents(bpmLog)()).To(ContainSubstring("bpm.start.host-pid"))
+			})
 		})
 	})
 })
@@ -0,0 +1,148 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	uuid "github.com/satori/go.uuid"
+	yaml "gopkg.in/yaml.v2"
+
+	"bpm/config"
+	"bpm/jobid"
+)
+
+var _ = Describe("paths", func() {
+	var (
+		command *exec.Cmd
+
+		cfg config.JobConfig
+
+		boshRoot    string
+		containerID string
+		job         string
+		runcRoot    string
+	)
+
+	type processPaths struct {
+		Bundle  string `json:"bundle"`
+		PidFile string `json:"pid_file"`
+		Stdout  string `json:"stdout"`
+		Stderr  string `json:"stderr"`
+		DataDir string `json:"data_dir"`
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "paths-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		logFile := filepath.Join(boshRoot, "sys", "log", job, "foo.log")
+		cfg = newJobConfig(job, defaultBash(logFile))
+	})
+
+	JustBeforeEach(func() {
+		writeConfig(boshRoot, job, cfg)
+		command = exec.Command(bpmPath, "paths", job)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("prints the paths which start uses", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+		Expect(session).To(gexec.Exit(0))
+
+		var paths processPaths
+		Expect(json.Unmarshal(session.Out.Contents(), &paths)).To(Succeed())
+		Expect(paths.PidFile).To(Equal(filepath.Join(boshRoot, "sys", "run", "bpm", job, fmt.Sprintf("%s.pid", job))))
+		Expect(paths.Stdout).To(Equal(filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", job))))
+		Expect(paths.Bundle).To(Equal(filepath.Join(boshRoot, "data", "bpm", "bundles", job, job)))
+
+		Expect(paths.PidFile).NotTo(BeAnExistingFile())
+		Expect(paths.Bundle).NotTo(BeADirectory())
+
+		startJob(boshRoot, bpmPath, job)
+
+		Expect(paths.PidFile).To(BeAnExistingFile())
+		Expect(paths.Stdout).To(BeAnExistingFile())
+		Expect(paths.Stderr).To(BeAnExistingFile())
+		Expect(paths.Bundle).To(BeADirectory())
+	})
+
+	Context("when a config file is provided", func() {
+		JustBeforeEach(func() {
+			cfg.Processes[0].EphemeralDisk = true
+
+			data, err := yaml.Marshal(&cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			configPath := filepath.Join(boshRoot, "other-bpm.yml")
+			Expect(ioutil.WriteFile(configPath, data, 0644)).To(Succeed())
+
+			command.Args = append(command.Args, "-c", configPath)
+		})
+
+		It("uses the provided config file", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			var paths processPaths
+			Expect(json.Unmarshal(session.Out.Contents(), &paths)).To(Succeed())
+			Expect(paths.DataDir).To(Equal(filepath.Join(boshRoot, "data", job)))
+		})
+	})
+
+	Context("when the process is not in the job configuration", func() {
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "-p", "not-a-process")
+		})
+
+		It("exits with a non-zero exit code", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(`process "not-a-process" not present in job configuration`))
+		})
+	})
+})
@@ -0,0 +1,145 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/jobid"
+	"bpm/models"
+)
+
+var _ = Describe("pause and resume", func() {
+	var (
+		boshRoot    string
+		containerID string
+		job         string
+		runcRoot    string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "pause-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		cfg := newJobConfig(job, `while true; do :; done`)
+		writeConfig(boshRoot, job, cfg)
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	bpm := func(args ...string) *gexec.Session {
+		command := exec.Command(bpmPath, args...)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		return session
+	}
+
+	// cpuTicks returns the user and system time the process has used so
+	// far, in clock ticks.
+	cpuTicks := func(pid int) func() int {
+		return func() int {
+			stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+			Expect(err).NotTo(HaveOccurred())
+
+			// The command name may contain spaces so skip past it first.
+			fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+			utime, err := strconv.Atoi(fields[11])
+			Expect(err).NotTo(HaveOccurred())
+			stime, err := strconv.Atoi(fields[12])
+			Expect(err).NotTo(HaveOccurred())
+
+			return utime + stime
+		}
+	}
+
+	It("stops the process using the CPU until it is resumed", func() {
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+		pid := runcState(runcRoot, containerID).Pid
+		Eventually(cpuTicks(pid)).Should(BeNumerically(">", 0))
+
+		Expect(bpm("pause", job)).To(gexec.Exit(0))
+		Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.ContainerState("paused")))
+
+		before := cpuTicks(pid)()
+		Consistently(cpuTicks(pid), 2*time.Second).Should(Equal(before))
+
+		session := bpm("list")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(fmt.Sprintf(`%s\s+%d\s+%s`, job, pid, models.ProcessStatePaused)))
+
+		Expect(bpm("resume", job)).To(gexec.Exit(0))
+		Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+		Expect(runcState(runcRoot, containerID).Pid).To(Equal(pid))
+		Eventually(cpuTicks(pid)).Should(BeNumerically(">", before))
+	})
+
+	Context("when the process is not running", func() {
+		It("exits with a non-zero exit code and prints an error", func() {
+			session := bpm("pause", job)
+			Expect(session).To(gexec.Exit(1))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("process is not running or could not be found"))
+		})
+	})
+
+	Context("when the process is not paused", func() {
+		It("refuses to resume it", func() {
+			Expect(bpm("start", job)).To(gexec.Exit(0))
+
+			session := bpm("resume", job)
+			Expect(session).To(gexec.Exit(1))
+			Expect(string(session.Err.Contents())).To(ContainSubstring("process is not paused or could not be found"))
+		})
+	})
+
+	Context("when a paused process is stopped", func() {
+		It("resumes it so that it can exit", func() {
+			Expect(bpm("start", job)).To(gexec.Exit(0))
+			Expect(bpm("pause", job)).To(gexec.Exit(0))
+
+			Expect(bpm("stop", job)).To(gexec.Exit(0))
+			Expect(runcState(runcRoot, containerID).Status).To(BeEmpty())
+		})
+	})
+})
@@ -85,6 +85,56 @@ var _ = Describe("pid", func() {
 		Expect(session.Out).Should(gbytes.Say(fmt.Sprintf("%d", state.Pid)))
 	})
 
+	Context("when the status is requested", func() {
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "--status")
+		})
+
+		It("prints the status alongside the pid", func() {
+			startJob(boshRoot, bpmPath, job)
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(session.Out).Should(gbytes.Say(fmt.Sprintf("^%d running\n$", state.Pid)))
+		})
+	})
+
+	Context("when runc fails transiently", func() {
+		BeforeEach(func() {
+			startJob(boshRoot, bpmPath, job)
+			installFlakyRunc(boshRoot, "state")
+		})
+
+		It("retries and returns the external pid", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(session.Out).Should(gbytes.Say(fmt.Sprintf("%d", state.Pid)))
+		})
+
+		Context("when retries are disabled", func() {
+			JustBeforeEach(func() {
+				command = exec.Command(bpmPath, "pid", job, "--retries", "0")
+				command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			})
+
+			It("returns an error", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).ShouldNot(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).Should(gbytes.Say("failed to get job"))
+			})
+		})
+	})
+
 	Context("when the container is failed", func() {
 		BeforeEach(func() {
 			startJob(boshRoot, bpmPath, job)
@@ -0,0 +1,142 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/config"
+	"bpm/jobid"
+	"bpm/models"
+)
+
+var _ = Describe("replicas", func() {
+	var (
+		boshRoot     string
+		containerIDs []string
+		job          string
+		runcRoot     string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "replicas-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		cfg := newJobConfig(job, `echo "replica=${BPM_REPLICA_INDEX}"; sleep 100`)
+		cfg.Processes[0].Replicas = 3
+		writeConfig(boshRoot, job, cfg)
+
+		containerIDs = nil
+		for i := 0; i < 3; i++ {
+			containerIDs = append(containerIDs, jobid.Encode(fmt.Sprintf("%s.%s", job, config.ReplicaName(job, i))))
+		}
+	})
+
+	AfterEach(func() {
+		for _, containerID := range containerIDs {
+			err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+			if err != nil {
+				fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+			}
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	bpm := func(args ...string) *gexec.Session {
+		command := exec.Command(bpmPath, args...)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		return session
+	}
+
+	It("starts, lists and stops one container per replica", func() {
+		Expect(bpm("start", job)).To(gexec.Exit(0))
+
+		for i, containerID := range containerIDs {
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+
+			stdout := filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", config.ReplicaName(job, i)))
+			Eventually(fileContents(stdout)).Should(Equal(fmt.Sprintf("replica=%d\n", i)))
+		}
+
+		list := bpm("list")
+		Expect(list).To(gexec.Exit(0))
+		for i := 0; i < 3; i++ {
+			Expect(list.Out).To(gbytes.Say(fmt.Sprintf("%s\\s+\\d+\\s+%s", config.ReplicaName(job, i), models.ProcessStateRunning)))
+		}
+
+		Expect(bpm("stop", job)).To(gexec.Exit(0))
+
+		for _, containerID := range containerIDs {
+			Expect(runcState(runcRoot, containerID).Status).To(BeEmpty())
+		}
+	})
+
+	Context("when the process has a replica_stop_delay", func() {
+		var stopped string
+
+		BeforeEach(func() {
+			stopped = filepath.Join(boshRoot, "sys", "log", job, "stopped")
+
+			cfg := newJobConfig(job, fmt.Sprintf(
+				`trap "date +%%s.%%N >> /var/vcap/sys/log/%s/stopped; exit" TERM; while true; do sleep 0.1; done`,
+				job,
+			))
+			cfg.Processes[0].Replicas = 3
+			cfg.Processes[0].ReplicaStopDelay = "2s"
+			writeConfig(boshRoot, job, cfg)
+		})
+
+		It("stops the replicas one at a time with the delay between them", func() {
+			Expect(bpm("start", job)).To(gexec.Exit(0))
+			Expect(bpm("stop", job)).To(gexec.Exit(0))
+
+			lines := fileLines(stopped)()
+			Expect(lines).To(HaveLen(3))
+
+			var times []float64
+			for _, line := range lines {
+				t, err := strconv.ParseFloat(line, 64)
+				Expect(err).NotTo(HaveOccurred())
+				times = append(times, t)
+			}
+
+			Expect(times[1] - times[0]).To(BeNumerically(">=", 2))
+			Expect(times[2] - times[1]).To(BeNumerically(">=", 2))
+		})
+	})
+})
@@ -20,20 +20,28 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	uuid "github.com/satori/go.uuid"
 
 	"bpm/bosh"
 	"bpm/config"
 	"bpm/jobid"
+	"bpm/sysfeat"
 )
 
 var _ = Describe("resource limits", func() {
@@ -132,6 +140,173 @@ var _ = Describe("resource limits", func() {
 		})
 	})
 
+	Context("when the process is killed for running out of memory", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `sleep 1; exec perl -e '$data = "a" x 100_000_000; sleep 100'`)
+			limit := "8M"
+			cfg.Processes[0].Limits = &config.Limits{Memory: &limit}
+		})
+
+		It("reports the process as oom-killed until it is stopped", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+			Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }, 10*time.Second).Should(Equal(specs.StateStopped))
+
+			list := exec.Command(bpmPath, "list", "--json")
+			list.Env = append(list.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			session, err = gexec.Start(list, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			var processes []map[string]interface{}
+			Expect(json.Unmarshal(session.Out.Contents(), &processes)).To(Succeed())
+			Expect(processes).To(ContainElement(And(
+				HaveKeyWithValue("name", job),
+				HaveKeyWithValue("status", "oom-killed"),
+			)))
+
+			stop := exec.Command(bpmPath, "stop", job, "--report")
+			stop.Env = append(stop.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			session, err = gexec.Start(stop, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("process had already been killed for running out of memory"))
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+		})
+	})
+
+	Context("memory warning threshold", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `sleep 2; data=$(head -c 25000000 /dev/zero | tr '\0' a); sleep 100`)
+			limit := "128M"
+			percent := 10
+			cfg.Processes[0].Limits = &config.Limits{Memory: &limit, MemoryWarnPercent: &percent}
+		})
+
+		It("logs a warning once the usage passes the threshold, before the process is OOMed", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			bpmLog := filepath.Join(boshRoot, "sys", "log", job, "bpm.log")
+			Eventually(fileContents(bpmLog), 10*time.Second).Should(ContainSubstring("bpm.supervise.warn.memory-high"))
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+		})
+	})
+
+	Context("memory and swap", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, memoryLeakBash)
+			memory := "8M"
+			memorySwap := "16M"
+			cfg.Processes[0].Limits = &config.Limits{Memory: &memory, MemorySwap: &memorySwap}
+		})
+
+		It("surfaces the combined limit in the container spec and enforces it", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+			Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+			specData, err := ioutil.ReadFile(filepath.Join(boshRoot, "data", "bpm", "bundles", job, job, "config.json"))
+			Expect(err).NotTo(HaveOccurred())
+
+			var spec specs.Spec
+			Expect(json.Unmarshal(specData, &spec)).To(Succeed())
+			Expect(*spec.Linux.Resources.Memory.Limit).To(Equal(int64(8 * 1024 * 1024)))
+			Expect(*spec.Linux.Resources.Memory.Swap).To(Equal(int64(16 * 1024 * 1024)))
+
+			eventsCmd := runcCommand(runcRoot, "events", containerID)
+			stdout, err := eventsCmd.StdoutPipe()
+			Expect(err).NotTo(HaveOccurred())
+
+			decoder := json.NewDecoder(stdout)
+			Expect(eventsCmd.Start()).To(Succeed())
+			defer eventsCmd.Process.Kill()
+
+			Eventually(func() string {
+				var actualEvent event
+				if err := decoder.Decode(&actualEvent); err != nil {
+					return err.Error()
+				}
+				return actualEvent.Type
+			}, 10*time.Second).Should(Equal("oom"))
+		})
+	})
+
+	Context("kernel memory", func() {
+		BeforeEach(func() {
+			features, err := sysfeat.Fetch()
+			Expect(err).NotTo(HaveOccurred())
+			if !features.KernelMemoryLimitSupported {
+				Skip("kernel memory limits are not supported")
+			}
+
+			cfg = newJobConfig(job, `sleep 100`)
+			limit := "64M"
+			cfg.Processes[0].Limits = &config.Limits{KernelMemory: &limit}
+		})
+
+		It("sets the kernel memory limit of the cgroup of the container", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			pid := runcState(runcRoot, containerID).Pid
+			mountpoint, err := cgroups.FindCgroupMountpoint("", "memory")
+			Expect(err).NotTo(HaveOccurred())
+			paths, err := cgroups.ParseCgroupFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+			Expect(err).NotTo(HaveOccurred())
+
+			limit, err := ioutil.ReadFile(filepath.Join(mountpoint, paths["memory"], "memory.kmem.limit_in_bytes"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.TrimSpace(string(limit))).To(Equal(strconv.Itoa(64 * 1024 * 1024)))
+		})
+	})
+
+	Context("cpuset", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `grep Cpus_allowed_list /proc/self/status; sleep 100`)
+			cfg.Processes[0].Limits = &config.Limits{Cpuset: &config.Cpuset{Cpus: "0"}}
+		})
+
+		It("pins the process to the requested CPUs", func() {
+			if runtime.NumCPU() < 2 {
+				Skip("pinning cannot be observed with a single CPU")
+			}
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			stdout := filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", job))
+			Eventually(fileContents(stdout)).Should(MatchRegexp(`Cpus_allowed_list:\s+0\n`))
+		})
+
+		Context("when the range is invalid", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].Limits.Cpuset.Cpus = "3-1"
+			})
+
+			It("fails to start", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).To(gbytes.Say(`invalid limits: cpuset cpus: "3-1" is not a number or range`))
+			})
+		})
+	})
+
 	Context("open files", func() {
 		BeforeEach(func() {
 			cfg = newJobConfig(job, fileLeakBash(boshEnv.DataDir(job).Internal()))
@@ -152,6 +327,38 @@ var _ = Describe("resource limits", func() {
 		})
 	})
 
+	Context("max file size", func() {
+		var bigFile string
+
+		BeforeEach(func() {
+			dataDir := boshEnv.DataDir(job)
+			bigFile = filepath.Join(dataDir.External(), "big")
+
+			cfg = newJobConfig(job, fmt.Sprintf(
+				`head -c 2097152 /dev/zero > %s; echo "write exited with $?"; sleep 10000`,
+				filepath.Join(dataDir.Internal(), "big"),
+			))
+			limit := "1M"
+			cfg.Processes[0].Limits = &config.Limits{MaxFileSize: &limit}
+			cfg.Processes[0].EphemeralDisk = true
+		})
+
+		It("cannot grow a file beyond the limit", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			stdout := filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", job))
+			// The writer is killed by SIGXFSZ (25).
+			Eventually(fileContents(stdout)).Should(ContainSubstring("write exited with 153"))
+
+			info, err := os.Stat(bigFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(Equal(int64(1024 * 1024)))
+		})
+	})
+
 	Context("processes", func() {
 		BeforeEach(func() {
 			cfg = newJobConfig(job, processLeakBash)
@@ -171,6 +378,131 @@ var _ = Describe("resource limits", func() {
 			Eventually(fileContents(stderr)).Should(ContainSubstring("fork: retry: Resource temporarily unavailable"))
 		})
 	})
+
+	Context("network bandwidth", func() {
+		var (
+			listener net.Listener
+			received int64
+		)
+
+		BeforeEach(func() {
+			if _, err := exec.LookPath("tc"); err != nil {
+				Skip("tc is not installed")
+			}
+
+			features, err := sysfeat.Fetch()
+			Expect(err).NotTo(HaveOccurred())
+			if !features.NetClsSupported {
+				Skip("the net_cls cgroup is not available")
+			}
+
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			atomic.StoreInt64(&received, 0)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				buf := make([]byte, 32*1024)
+				for {
+					n, err := conn.Read(buf)
+					atomic.AddInt64(&received, int64(n))
+					if err != nil {
+						return
+					}
+				}
+			}()
+
+			port := listener.Addr().(*net.TCPAddr).Port
+			cfg = newJobConfig(job, fmt.Sprintf(`head -c 100000000 /dev/zero > /dev/tcp/127.0.0.1/%d; sleep 100`, port))
+			limit := "256K"
+			cfg.Processes[0].Limits = &config.Limits{NetBandwidth: &limit}
+		})
+
+		JustBeforeEach(func() {
+			command.Env = append(command.Env, "BPM_NET_INTERFACE=lo")
+		})
+
+		AfterEach(func() {
+			if listener != nil {
+				listener.Close()
+			}
+			exec.Command("tc", "qdisc", "del", "dev", "lo", "root").Run()
+		})
+
+		It("limits the rate the process can send at", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Err).NotTo(gbytes.Say("warning"))
+
+			Eventually(func() int64 { return atomic.LoadInt64(&received) }).Should(BeNumerically(">", 0))
+			time.Sleep(3 * time.Second)
+
+			// 3 seconds at 256K per second with generous headroom for the
+			// burst allowed by the htb class.
+			Expect(atomic.LoadInt64(&received)).To(BeNumerically("<", 2*1024*1024))
+		})
+
+		Context("when the process fails to start", func() {
+			BeforeEach(func() {
+				preStart := filepath.Join(boshRoot, "pre-start")
+				Expect(ioutil.WriteFile(preStart, []byte("#!/bin/bash\nexit 1\n"), 0755)).To(Succeed())
+				cfg.Processes[0].Hooks = &config.Hooks{PreStart: preStart}
+			})
+
+			It("removes the limit", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+
+				qdiscs, err := exec.Command("tc", "qdisc", "show", "dev", "lo").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(qdiscs)).NotTo(ContainSubstring("htb"))
+			})
+		})
+
+		Context("when the process is a oneshot", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].Args = []string{"-c", "exit 0"}
+				cfg.Processes[0].Oneshot = true
+			})
+
+			It("removes the limit once the process has exited", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				qdiscs, err := exec.Command("tc", "qdisc", "show", "dev", "lo").CombinedOutput()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(qdiscs)).NotTo(ContainSubstring("htb"))
+			})
+
+			Context("when the process fails", func() {
+				BeforeEach(func() {
+					cfg.Processes[0].Args = []string{"-c", "exit 3"}
+				})
+
+				It("still removes the limit", func() {
+					session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+					Expect(err).NotTo(HaveOccurred())
+					<-session.Exited
+					Expect(session).To(gexec.Exit(3))
+
+					qdiscs, err := exec.Command("tc", "qdisc", "show", "dev", "lo").CombinedOutput()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(qdiscs)).NotTo(ContainSubstring("htb"))
+				})
+			})
+		})
+	})
 })
 
 type event struct {
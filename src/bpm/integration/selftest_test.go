@@ -0,0 +1,109 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("selftest", func() {
+	var (
+		command *exec.Cmd
+
+		boshRoot string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "selftest-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+
+		setupBoshDirectories(boshRoot, "bpm-selftest")
+
+		command = exec.Command(bpmPath, "selftest")
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		runcRoot := filepath.Join(boshRoot, "sys", "run", "bpm-selftest-runc")
+		err := runcCommand(runcRoot, "delete", "--force", "bpm-selftest").Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("starts and stops a container and cleans up after it", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say("PASS starting a container"))
+		Expect(session.Out).To(gbytes.Say("PASS container is running"))
+		Expect(session.Out).To(gbytes.Say("PASS output is written to the log file"))
+		Expect(session.Out).To(gbytes.Say("PASS container is cleaned up"))
+		Expect(session.Out).NotTo(gbytes.Say("FAIL"))
+
+		Expect(filepath.Join(boshRoot, "data", "bpm", "bundles", "bpm-selftest")).NotTo(BeADirectory())
+		Expect(filepath.Join(boshRoot, "jobs", "bpm-selftest")).NotTo(BeADirectory())
+		Expect(filepath.Join(boshRoot, "sys", "log", "bpm-selftest")).NotTo(BeADirectory())
+	})
+
+	It("does not start its container alongside those of jobs", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+		Expect(session).To(gexec.Exit(0))
+
+		entries, err := ioutil.ReadDir(filepath.Join(boshRoot, "sys", "run", "bpm-runc"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	Context("when runc is broken", func() {
+		BeforeEach(func() {
+			runcPath := filepath.Join(boshRoot, "packages", "bpm", "bin", "runc")
+			// The runc in the BOSH root is a hard link to the real one.
+			Expect(os.Remove(runcPath)).To(Succeed())
+			Expect(ioutil.WriteFile(runcPath, []byte("#!/bin/sh\nexit 1\n"), 0755)).To(Succeed())
+		})
+
+		It("reports the failure and exits with a non-zero exit code", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Out).To(gbytes.Say("FAIL starting a container"))
+			Expect(session.Err).To(gbytes.Say("self test failed"))
+
+			bpmLog := filepath.Join(boshRoot, "sys", "log", "bpm-selftest", "bpm.log")
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("bpm.selftest.step-failed"))
+		})
+	})
+})
@@ -103,6 +103,58 @@ var _ = Describe("start", func() {
 		Eventually(session.Out).Should(gbytes.Say("xterm-256color"))
 	})
 
+	Context("when a terminal type is provided", func() {
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "--term", "dumb")
+		})
+
+		It("sets TERM inside the shell to the provided value", func() {
+			startJob(boshRoot, bpmPath, job)
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ttyF.Close()).NotTo(HaveOccurred())
+
+			_, err = ptyF.Write([]byte("/bin/echo $TERM\nexit\n"))
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(session.Out).Should(gbytes.Say("dumb"))
+		})
+	})
+
+	Context("when the process has sensitive environment variables", func() {
+		var stdout string
+
+		BeforeEach(func() {
+			stdout = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", job))
+			logFile := filepath.Join(boshRoot, "sys", "log", job, "foo.log")
+
+			cfg = newJobConfig(job, `echo "main-secret=[$SECRET]";`+defaultBash(logFile))
+			cfg.Processes[0].Env = map[string]string{"SECRET": "hunter2"}
+			cfg.Processes[0].SensitiveEnv = []string{"SECRET"}
+			writeConfig(boshRoot, job, cfg)
+		})
+
+		It("omits them from the shell but not from the main process", func() {
+			startJob(boshRoot, bpmPath, job)
+			Eventually(fileContents(stdout)).Should(ContainSubstring("main-secret=[hunter2]"))
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ttyF.Close()).NotTo(HaveOccurred())
+
+			_, err = ptyF.Write([]byte("/bin/echo \"shell-secret=[$SECRET]\"\nexit\n"))
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(session.Out).Should(gbytes.Say(`shell-secret=\[\]`))
+			Expect(session.Out).NotTo(gbytes.Say("hunter2"))
+		})
+	})
+
 	It("does not print the usage on invalid commands", func() {
 		startJob(boshRoot, bpmPath, job)
 
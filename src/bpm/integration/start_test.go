@@ -16,12 +16,18 @@
 package integration_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -33,7 +39,9 @@ import (
 
 	"bpm/bosh"
 	"bpm/config"
+	"bpm/exitstatus"
 	"bpm/jobid"
+	"bpm/sysfeat"
 )
 
 var _ = Describe("start", func() {
@@ -156,6 +164,75 @@ var _ = Describe("start", func() {
 		Eventually(fileContents(bpmLog)).Should(ContainSubstring("bpm.start.complete"))
 	})
 
+	Context("when a session id is given", func() {
+		It("includes it in every log line of the run", func() {
+			command.Args = append(command.Args, "--session-id", "deploy-1234")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			stop := exec.Command(bpmPath, "stop", job)
+			stop.Env = append(command.Env, "BPM_SESSION_ID=deploy-5678")
+			session, err = gexec.Start(stop, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			lines := strings.Split(strings.TrimSpace(fileContents(bpmLog)()), "\n")
+			var starts, stops int
+			for _, line := range lines {
+				if strings.Contains(line, "bpm.start") {
+					Expect(line).To(ContainSubstring(`"session-id":"deploy-1234"`))
+					starts++
+				}
+				if strings.Contains(line, "bpm.stop") {
+					Expect(line).To(ContainSubstring(`"session-id":"deploy-5678"`))
+					stops++
+				}
+			}
+			Expect(starts).To(BeNumerically(">", 0))
+			Expect(stops).To(BeNumerically(">", 0))
+		})
+
+		It("generates one when none is given", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Expect(fileContents(bpmLog)()).To(MatchRegexp(`"session-id":"[0-9a-f]{8}-[0-9a-f]{4}-`))
+		})
+	})
+
+	Context("when the bpm log is sent to stdout", func() {
+		It("logs bpm internal logs to stdout instead of bpm.log", func() {
+			command.Args = append(command.Args, "--log-to-stdout")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("bpm.start.starting"))
+			Expect(session.Out).To(gbytes.Say("bpm.start.complete"))
+			Expect(bpmLog).NotTo(BeAnExistingFile())
+		})
+
+		It("can be enabled through the environment", func() {
+			command.Env = append(command.Env, "BPM_LOG_TO_STDOUT=true")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("bpm.start.starting"))
+			Expect(bpmLog).NotTo(BeAnExistingFile())
+		})
+	})
+
 	Context("when a process name is specified", func() {
 		var process string
 
@@ -163,88 +240,1544 @@ var _ = Describe("start", func() {
 			process = uuid.NewV4().String()
 			containerID = jobid.Encode(fmt.Sprintf("%s.%s", job, process))
 
-			cfg.Processes = append(cfg.Processes, &config.ProcessConfig{
-				Name:       process,
-				Executable: "/bin/bash",
-				Args: []string{
-					"-c",
-					alternativeBash,
-				},
+			cfg.Processes = append(cfg.Processes, &config.ProcessConfig{
+				Name:       process,
+				Executable: "/bin/bash",
+				Args: []string{
+					"-c",
+					alternativeBash,
+				},
+			})
+
+			stdout = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", process))
+			stderr = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stderr.log", process))
+			pidFile = filepath.Join(boshRoot, "sys", "run", "bpm", job, fmt.Sprintf("%s.pid", process))
+		})
+
+		JustBeforeEach(func() {
+			command = exec.Command(bpmPath, "start", job, "-p", process)
+			command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+		})
+
+		It("runs the process specified in the config", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(state.Status).To(Equal(specs.StateRunning))
+			pidText, err := ioutil.ReadFile(pidFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			pid, err := strconv.Atoi(string(pidText))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pid).To(Equal(state.Pid))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("Alternate Logging to STDOUT"))
+			Eventually(fileContents(stderr)).Should(ContainSubstring("Alternate Logging to STDERR"))
+		})
+	})
+
+	Context("when a pre_start hook is specified", func() {
+		BeforeEach(func() {
+			preStart := filepath.Join(boshRoot, "pre-start")
+			f, err := os.OpenFile(preStart, os.O_CREATE|os.O_RDWR, 0777)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = f.Write([]byte(preStartBash))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+
+			cfg.Processes[0].Hooks = &config.Hooks{
+				PreStart: preStart,
+			}
+		})
+
+		It("executs the pre-start prior to starting the process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+			Eventually(fileContents(stdout)).Should(ContainSubstring("Executing Pre Start\nen_US.UTF-8\n"))
+		})
+	})
+
+	Context("when FUSE is enabled", func() {
+		BeforeEach(func() {
+			if _, err := os.Stat("/dev/fuse"); err != nil {
+				Skip("the host does not support FUSE")
+			}
+
+			if _, err := exec.LookPath("bindfs"); err != nil {
+				Skip("bindfs is not installed")
+			}
+
+			dataDir := boshEnv.DataDir(job).Internal()
+			cfg = newJobConfig(job, fmt.Sprintf(
+				"mkdir -p %[1]s/src %[1]s/mnt; echo through-fuse > %[1]s/src/file; bindfs %[1]s/src %[1]s/mnt && cat %[1]s/mnt/file; sleep 100",
+				dataDir,
+			))
+			cfg.Processes[0].EphemeralDisk = true
+			cfg.Processes[0].EnableFuse = true
+		})
+
+		It("can mount a FUSE filesystem inside the container", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("through-fuse"))
+		})
+	})
+
+	Context("when a pre_start hook runs past its timeout", func() {
+		BeforeEach(func() {
+			preStart := filepath.Join(boshRoot, "pre-start")
+			Expect(ioutil.WriteFile(preStart, []byte("#!/bin/bash\nsleep 60\n"), 0777)).To(Succeed())
+
+			cfg.Processes[0].Hooks = &config.Hooks{
+				PreStart:        preStart,
+				PreStartTimeout: "1s",
+			}
+		})
+
+		It("kills the hook, fails to start, and removes the bundle", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 10*time.Second).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("hook pre_start timed out after 1s"))
+
+			Expect(filepath.Join(boshRoot, "data", "bpm", "bundles", job, job)).NotTo(BeADirectory())
+		})
+	})
+
+	Context("when a pre_start hook is run as a specific user", func() {
+		var (
+			ownedDir  string
+			ownedFile bosh.Path
+		)
+
+		BeforeEach(func() {
+			ownedDir = filepath.Join(boshEnv.DataDir(job).External(), "owned")
+			Expect(os.MkdirAll(ownedDir, 0755)).To(Succeed())
+			Expect(os.Chown(ownedDir, 0, 0)).To(Succeed())
+
+			preStart := filepath.Join(boshRoot, "pre-start")
+			hook := fmt.Sprintf("#!/bin/bash\nchown vcap:vcap %s\n", ownedDir)
+			Expect(ioutil.WriteFile(preStart, []byte(hook), 0777)).To(Succeed())
+
+			ownedFile = boshEnv.DataDir(job).Join("owned", "data.txt")
+			cfg = newJobConfig(job, defaultBash(ownedFile.Internal()))
+			cfg.Processes[0].EphemeralDisk = true
+			cfg.Processes[0].Hooks = &config.Hooks{
+				PreStart:     preStart,
+				PreStartUser: "root",
+			}
+		})
+
+		It("lets a root hook prepare a directory for the non-root process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(ownedFile.External()).Should(BeAnExistingFile())
+			Eventually(fileContents(ownedFile.External())).Should(ContainSubstring("Logging to FILE"))
+		})
+
+		Context("when the hook runs as the vcap user", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].Hooks.PreStartUser = "vcap"
+			})
+
+			It("is not allowed to chown the directory and fails to start", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).To(gbytes.Say("prestart hook failed"))
+			})
+		})
+	})
+
+	Context("when environment variables are passed through", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `echo "HTTP_PROXY is $HTTP_PROXY"; echo "MISSING is ${MISSING-unset}"; sleep 100`)
+			cfg.Processes[0].PassEnv = []string{"HTTP_PROXY", "MISSING"}
+		})
+
+		JustBeforeEach(func() {
+			command.Env = append(command.Env, "HTTP_PROXY=http://proxy.example.com")
+		})
+
+		It("makes the variables from bpm's environment visible to the process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("HTTP_PROXY is http://proxy.example.com"))
+			Eventually(fileContents(stdout)).Should(ContainSubstring("MISSING is unset"))
+		})
+	})
+
+	Context("when the environment is printed", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `sleep 100`)
+			cfg.Processes[0].Env = map[string]string{"COLOR": "from-env", "SECRET": "hunter2"}
+			cfg.Processes[0].SensitiveEnv = []string{"SECRET"}
+			cfg.Processes[0].PassEnv = []string{"COLOR", "SHAPE"}
+		})
+
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "--print-env")
+			command.Env = append(command.Env, "COLOR=from-pass-env", "SHAPE=from-pass-env")
+		})
+
+		It("prints the environment with the documented precedence instead of starting the process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say("COLOR=from-env\n"))
+			Expect(session.Out).To(gbytes.Say("SECRET=<redacted>\n"))
+			Expect(session.Out).To(gbytes.Say("SHAPE=from-pass-env\n"))
+			Expect(session.Out.Contents()).NotTo(ContainSubstring("hunter2"))
+
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).NotTo(Succeed())
+		})
+	})
+
+	Context("when environment variables are read from links", func() {
+		BeforeEach(func() {
+			linksPath := filepath.Join(boshRoot, "jobs", job, "config", "links.json")
+			Expect(os.MkdirAll(filepath.Dir(linksPath), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(linksPath, []byte(`{"db": {"address": "db.example.com", "port": 5432}}`), 0644)).To(Succeed())
+
+			cfg = newJobConfig(job, `echo "db is $LINK_DB_HOST:$LINK_DB_PORT"; sleep 100`)
+			cfg.Processes[0].Links = &config.Links{
+				Path:   linksPath,
+				Prefix: "LINK_",
+				Fields: map[string]string{
+					"DB_HOST": "db.address",
+					"DB_PORT": "db.port",
+				},
+			}
+		})
+
+		It("makes the link fields visible to the process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("db is db.example.com:5432"))
+		})
+
+		Context("when a field is missing from the links file", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].Links.Fields["DB_PASSWORD"] = "db.password"
+			})
+
+			It("fails to start", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+
+				Expect(session.Err).To(gbytes.Say(`links field "db.password" not found`))
+			})
+		})
+	})
+
+	Context("when environment variables are read from a properties file", func() {
+		BeforeEach(func() {
+			propertiesPath := filepath.Join(boshRoot, "jobs", job, "config", "properties.yml")
+			Expect(os.MkdirAll(filepath.Dir(propertiesPath), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(propertiesPath, []byte("port: 8080\ndb:\n  host: db.example.com\n"), 0644)).To(Succeed())
+
+			cfg = newJobConfig(job, `env | grep ^BPM_PROP_ | sort; sleep 100`)
+			cfg.Processes[0].PropertiesFile = "properties.yml"
+		})
+
+		It("makes the flattened properties visible to the process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("BPM_PROP_db.host=db.example.com\nBPM_PROP_port=8080"))
+		})
+	})
+
+	Context("when environment variables are fetched by a command", func() {
+		var fetcher string
+
+		BeforeEach(func() {
+			fetcher = filepath.Join(boshRoot, "fetch-env")
+			Expect(ioutil.WriteFile(fetcher, []byte("#!/bin/bash\necho FETCHED_HOST=agent.example.com\necho \"FETCHED_ARG=$1\"\n"), 0755)).To(Succeed())
+
+			cfg = newJobConfig(job, `env | grep ^FETCHED_ | sort; sleep 100`)
+			cfg.Processes[0].EnvFrom = []config.EnvSource{{Command: fetcher, Args: []string{"db"}}}
+		})
+
+		It("makes the fetched variables visible to the process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("FETCHED_ARG=db\nFETCHED_HOST=agent.example.com"))
+		})
+
+		Context("when the command fails", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(fetcher, []byte("#!/bin/bash\necho agent unavailable >&2\nexit 3\n"), 0755)).To(Succeed())
+			})
+
+			It("does not start the process", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).To(gbytes.Say("failed to fetch environment from .*: exit status 3: agent unavailable"))
+
+				Expect(runcCommand(runcRoot, "state", containerID).Run()).NotTo(Succeed())
+			})
+		})
+
+		Context("when the process is already running", func() {
+			It("does not run the command again", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Expect(ioutil.WriteFile(fetcher, []byte("#!/bin/bash\necho agent unavailable >&2\nexit 3\n"), 0755)).To(Succeed())
+
+				command = exec.Command(bpmPath, "start", job)
+				command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+
+				session, err = gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+				Expect(fileContents(bpmLog)()).To(ContainSubstring("process-already-running"))
+			})
+
+			It("does not run the command again for bpm run", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Expect(ioutil.WriteFile(fetcher, []byte("#!/bin/bash\necho agent unavailable >&2\nexit 3\n"), 0755)).To(Succeed())
+
+				command = exec.Command(bpmPath, "run", job)
+				command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+
+				session, err = gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+				Expect(fileContents(bpmLog)()).To(ContainSubstring("process-already-running"))
+			})
+		})
+	})
+
+	Context("when the logs are line buffered", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `printf "partial-"; echo ready >&2; sleep 2; echo line; sleep 100`)
+			cfg.Processes[0].Logs = &config.Logs{LineBuffered: true}
+		})
+
+		It("only writes whole lines to the log files", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stderr)).Should(Equal("ready\n"))
+			Expect(fileContents(stdout)()).NotTo(ContainSubstring("partial-"))
+
+			Eventually(fileContents(stdout), 5*time.Second).Should(Equal("partial-line\n"))
+		})
+	})
+
+	Context("when stderr is merged into stdout", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `echo out; sleep 1; echo err >&2; sleep 1; echo out-again; sleep 100`)
+			cfg.Processes[0].Logs = &config.Logs{MergeStderr: true}
+		})
+
+		It("writes both to the stdout log file in the order they were written", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout), 5*time.Second).Should(Equal("out\nerr\nout-again\n"))
+			Expect(stderr).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when the logs have a prefix", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `echo one; echo two; echo oops >&2; sleep 100`)
+			cfg.Processes[0].Logs = &config.Logs{Prefix: "[web] "}
+		})
+
+		It("writes the prefix at the start of every line of the log files", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(Equal("[web] one\n[web] two\n"))
+			Eventually(fileContents(stderr)).Should(Equal("[web] oops\n"))
+		})
+	})
+
+	Context("when the process is a oneshot", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `echo migrated`)
+			cfg.Processes[0].Oneshot = true
+		})
+
+		It("runs it to completion and leaves no container behind", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Expect(fileContents(stdout)()).To(Equal("migrated\n"))
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).NotTo(Succeed())
+			Expect(filepath.Join(boshRoot, "data", "bpm", "bundles", job, job)).NotTo(BeADirectory())
+		})
+
+		Context("when it fails", func() {
+			BeforeEach(func() {
+				cfg = newJobConfig(job, `echo migration failed >&2; exit 3`)
+				cfg.Processes[0].Oneshot = true
+			})
+
+			It("exits with the exit status of the process", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(3))
+
+				Expect(fileContents(stderr)()).To(Equal("migration failed\n"))
+				Expect(runcCommand(runcRoot, "state", containerID).Run()).NotTo(Succeed())
+			})
+		})
+	})
+
+	Context("when the log files are named", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `echo out; echo err >&2; sleep 100`)
+			cfg.Processes[0].Logs = &config.Logs{StdoutFile: fmt.Sprintf("%s.log", job), StderrFile: fmt.Sprintf("%s.err", job)}
+		})
+
+		It("writes the output of the process to the named files", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			logDir := filepath.Join(boshRoot, "sys", "log", job)
+			Eventually(fileContents(filepath.Join(logDir, fmt.Sprintf("%s.log", job)))).Should(Equal("out\n"))
+			Eventually(fileContents(filepath.Join(logDir, fmt.Sprintf("%s.err", job)))).Should(Equal("err\n"))
+			Expect(stdout).NotTo(BeAnExistingFile())
+			Expect(stderr).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when a rotated log file of the process is owned by root", func() {
+		var existingLog string
+
+		BeforeEach(func() {
+			logDir := filepath.Join(boshRoot, "sys", "log", job)
+			Expect(os.MkdirAll(logDir, 0755)).To(Succeed())
+
+			name := fmt.Sprintf("%s.stdout.log.1", job)
+			existingLog = filepath.Join(logDir, name)
+			Expect(ioutil.WriteFile(existingLog, []byte("old\n"), 0644)).To(Succeed())
+
+			cfg = newJobConfig(job, fmt.Sprintf(`echo new >> %s; sleep 100`, filepath.Join("/var/vcap/sys/log", job, name)))
+		})
+
+		It("does not let the process write to it", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stderr)).Should(ContainSubstring("Permission denied"))
+			Expect(fileContents(existingLog)()).To(Equal("old\n"))
+		})
+
+		Context("when chown_existing is set", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].Logs = &config.Logs{ChownExisting: true}
+			})
+
+			It("lets the process write to it", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(existingLog)).Should(Equal("old\nnew\n"))
+			})
+		})
+	})
+
+	Context("when the logs are filtered", func() {
+		var filter string
+
+		BeforeEach(func() {
+			filter = filepath.Join(boshRoot, "log-filter")
+			Expect(ioutil.WriteFile(filter, []byte("#!/bin/bash\ntr a-z A-Z\n"), 0755)).To(Succeed())
+
+			cfg = newJobConfig(job, `echo one; echo oops >&2; sleep 100`)
+			cfg.Processes[0].Logs = &config.Logs{Filter: filter}
+		})
+
+		It("writes the output of the filter to the log files", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(Equal("ONE\n"))
+			Eventually(fileContents(stderr)).Should(Equal("OOPS\n"))
+		})
+
+		Context("when the filter fails", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filter, []byte("#!/bin/bash\nexit 1\n"), 0755)).To(Succeed())
+				cfg = newJobConfig(job, `sleep 1; echo one; sleep 100`)
+				cfg.Processes[0].Logs = &config.Logs{Filter: filter}
+			})
+
+			It("keeps the process running and writes its output unchanged", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(stdout), 5*time.Second).Should(Equal("one\n"))
+				Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+			})
+		})
+	})
+
+	Context("when several processes of the job use the ephemeral disk", func() {
+		var sidecarCfg *config.BPMConfig
+
+		BeforeEach(func() {
+			shared := boshEnv.DataDir(job).Join("shared").Internal()
+
+			cfg = newJobConfig(job, fmt.Sprintf(`echo hello > %s; sleep 100`, shared))
+			cfg.Processes[0].EphemeralDisk = true
+			cfg.Processes = append(cfg.Processes, &config.ProcessConfig{
+				Name:          "sidecar",
+				Executable:    "/bin/bash",
+				Args:          []string{"-c", fmt.Sprintf(`while [ ! -f %[1]s ]; do sleep 0.1; done; cat %[1]s; sleep 100`, shared)},
+				EphemeralDisk: true,
+			})
+
+			sidecarCfg = config.NewBPMConfig(boshEnv, job, "sidecar")
+		})
+
+		AfterEach(func() {
+			err := runcCommand(runcRoot, "delete", "--force", sidecarCfg.ContainerID()).Run()
+			if err != nil {
+				fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+			}
+		})
+
+		It("shares the data directory of the job between them", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			sidecar := exec.Command(bpmPath, "start", job, "-p", "sidecar")
+			sidecar.Env = append(sidecar.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			session, err = gexec.Start(sidecar, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(sidecarCfg.Stdout().External()), 5*time.Second).Should(Equal("hello\n"))
+		})
+	})
+
+	Context("when the spec is recorded", func() {
+		BeforeEach(func() {
+			memory := "128M"
+			cfg.Processes[0].RecordSpec = true
+			cfg.Processes[0].Env = map[string]string{"PASSWORD": "hunter2"}
+			cfg.Processes[0].SensitiveEnv = []string{"PASSWORD"}
+			cfg.Processes[0].Limits = &config.Limits{Memory: &memory}
+		})
+
+		It("writes the spec the process was started with to the log directory", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			data, err := ioutil.ReadFile(filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.spec.json", job)))
+			Expect(err).NotTo(HaveOccurred())
+
+			var spec specs.Spec
+			Expect(json.Unmarshal(data, &spec)).To(Succeed())
+			Expect(spec.Process.Env).To(ContainElement("PASSWORD=<redacted>"))
+			Expect(string(data)).NotTo(ContainSubstring("hunter2"))
+			Expect(*spec.Linux.Resources.Memory.Limit).To(Equal(int64(128 * 1024 * 1024)))
+
+			var destinations []string
+			for _, m := range spec.Mounts {
+				destinations = append(destinations, m.Destination)
+			}
+			Expect(destinations).To(ContainElement(filepath.Join("/var/vcap/sys/log", job)))
+		})
+	})
+
+	Context("when the process has a listen socket", func() {
+		var address string
+
+		BeforeEach(func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			address = l.Addr().String()
+			Expect(l.Close()).To(Succeed())
+
+			cfg = newJobConfig(job, `echo "fds=$LISTEN_FDS"; exec perl -MIO::Socket::INET -e '
+				my $l = IO::Socket::INET->new;
+				$l->fdopen(3, "r") or die "fd 3: $!";
+				while (my $c = $l->accept) { print $c "hello from fd 3\n"; close $c; }
+			'`)
+			cfg.Processes[0].ListenSockets = []config.ListenSocket{{Address: address}}
+		})
+
+		It("binds the address and passes the socket to the process as fd 3", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("fds=1"))
+
+			conn, err := net.Dial("tcp", address)
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			Expect(conn.SetDeadline(time.Now().Add(10 * time.Second))).To(Succeed())
+			reply, err := ioutil.ReadAll(conn)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(reply)).To(Equal("hello from fd 3\n"))
+		})
+	})
+
+	Context("when the logs are rotated", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `for i in 1 2 3; do printf '%0600d\n' $i; sleep 0.2; done; sleep 100`)
+			cfg.Processes[0].Logs = &config.Logs{Rotate: &config.LogRotation{MaxSize: "1K", Keep: 2}}
+		})
+
+		It("moves older output into numbered log files", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			// The log file is briefly missing while it is being rotated.
+			Eventually(func() string {
+				contents, _ := ioutil.ReadFile(stdout)
+				return string(contents)
+			}).Should(HaveSuffix("3\n"))
+			Expect(fileContents(stdout + ".1")()).To(HaveSuffix("2\n"))
+			Expect(fileContents(stdout + ".2")()).To(HaveSuffix("1\n"))
+			Expect(stdout + ".3").NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when waiting for the process to keep running", func() {
+		It("succeeds once the process has run for the wait", func() {
+			command.Args = append(command.Args, "--wait", "1s", "--wait-interval", "100ms")
+
+			startedAt := time.Now()
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 10*time.Second).Should(gexec.Exit(0))
+
+			Expect(time.Since(startedAt)).To(BeNumerically(">=", time.Second))
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+		})
+
+		Context("when the process crashes soon after starting", func() {
+			BeforeEach(func() {
+				cfg = newJobConfig(job, `sleep 0.2; exit 1`)
+			})
+
+			It("detects the crash promptly with a short interval and exits non-zero", func() {
+				command.Args = append(command.Args, "--wait", "30s", "--wait-interval", "100ms")
+
+				startedAt := time.Now()
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session, 10*time.Second).Should(gexec.Exit(1))
+
+				Expect(time.Since(startedAt)).To(BeNumerically("<", 5*time.Second))
+				Expect(session.Err).To(gbytes.Say("process exited within 30s of starting"))
+			})
+		})
+
+		Context("when the interval is not positive", func() {
+			It("exits with a non-zero exit code and prints an error", func() {
+				command.Args = append(command.Args, "--wait", "1s", "--wait-interval", "0s")
+
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).To(gbytes.Say("invalid wait interval: 0s must be positive"))
+			})
+		})
+	})
+
+	Context("when the container is given an id", func() {
+		BeforeEach(func() {
+			containerID = fmt.Sprintf("external-%s", uuid.NewV4().String())
+		})
+
+		It("runs the container under that id for every command given it", func() {
+			command.Args = append(command.Args, "--container-id", containerID)
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(state.Status).To(Equal(specs.StateRunning))
+			Expect(runcState(runcRoot, jobid.Encode(job)).Status).To(BeEmpty())
+
+			pidCommand := exec.Command(bpmPath, "pid", job, "--container-id", containerID)
+			pidCommand.Env = append(pidCommand.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			pidSession, err := gexec.Start(pidCommand, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-pidSession.Exited
+			Expect(pidSession).To(gexec.Exit(0))
+			Expect(pidSession.Out).To(gbytes.Say(fmt.Sprintf("^%d\n", state.Pid)))
+
+			stopCommand := exec.Command(bpmPath, "stop", job, "--container-id", containerID)
+			stopCommand.Env = append(stopCommand.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			stopSession, err := gexec.Start(stopCommand, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-stopSession.Exited
+			Expect(stopSession).To(gexec.Exit(0))
+
+			Expect(runcState(runcRoot, containerID).Status).To(BeEmpty())
+		})
+
+		It("refuses an id which runc cannot use", func() {
+			command.Args = append(command.Args, "--container-id", "../escape")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(`invalid container id "../escape"`))
+		})
+	})
+
+	Context("when the process must reach a minimum uptime", func() {
+		Context("when the process exits before reaching it", func() {
+			BeforeEach(func() {
+				cfg = newJobConfig(job, `sleep 1; exit 1`)
+			})
+
+			It("exits with a non-zero exit code and prints an error", func() {
+				command.Args = append(command.Args, "--min-uptime", "3s", "--wait-interval", "100ms")
+
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session, 10*time.Second).Should(gexec.Exit(1))
+
+				Expect(session.Err).To(gbytes.Say("process exited before it had been up for 3s"))
+			})
+
+			It("uses the min_uptime of the process", func() {
+				cfg.Processes[0].MinUptime = "3s"
+				writeConfig(boshRoot, job, cfg)
+
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session, 10*time.Second).Should(gexec.Exit(1))
+
+				Expect(session.Err).To(gbytes.Say("process exited before it had been up for 3s"))
+			})
+		})
+
+		It("succeeds once the process has been up for the minimum uptime", func() {
+			command.Args = append(command.Args, "--min-uptime", "1s", "--wait-interval", "100ms")
+
+			startedAt := time.Now()
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 10*time.Second).Should(gexec.Exit(0))
+
+			Expect(time.Since(startedAt)).To(BeNumerically(">=", time.Second))
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+		})
+	})
+
+	Context("when the parent of the log directory is read-only", func() {
+		var logParent string
+
+		BeforeEach(func() {
+			logParent = filepath.Join(boshRoot, "sys", "log")
+			Expect(os.MkdirAll(logParent, 0755)).To(Succeed())
+			Expect(syscall.Mount("tmpfs", logParent, "tmpfs", syscall.MS_RDONLY, "")).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(syscall.Unmount(logParent, 0)).To(Succeed())
+		})
+
+		It("exits with an error naming the directory which can't be written to", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(fmt.Sprintf(
+				`failed to create log directory %s: %s can't be written to \(read-only file system\): it must be a writable directory owned by root`,
+				regexp.QuoteMeta(filepath.Join(logParent, job)),
+				regexp.QuoteMeta(logParent),
+			)))
+		})
+	})
+
+	Context("when the process waits for a mount", func() {
+		var mountPoint string
+
+		BeforeEach(func() {
+			mountPoint = filepath.Join(boshRoot, "disk")
+			Expect(os.Mkdir(mountPoint, 0755)).To(Succeed())
+
+			cfg = newJobConfig(job, `sleep 100`)
+			cfg.Processes[0].WaitForMount = &config.WaitForMount{Path: mountPoint, Timeout: "30s"}
+		})
+
+		AfterEach(func() {
+			_ = syscall.Unmount(mountPoint, 0)
+		})
+
+		It("waits for the mount before starting the process", func() {
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(2 * time.Second)
+				Expect(syscall.Mount("tmpfs", mountPoint, "tmpfs", 0, "")).To(Succeed())
+			}()
+
+			startedAt := time.Now()
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 10*time.Second).Should(gexec.Exit(0))
+
+			Expect(time.Since(startedAt)).To(BeNumerically(">=", 2*time.Second))
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+		})
+
+		Context("when the mount never appears", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].WaitForMount.Timeout = "1s"
+			})
+
+			It("exits with a non-zero exit code and prints an error", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).To(gbytes.Say("was not mounted within 1s"))
+				Expect(runcState(runcRoot, containerID).Status).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when the process notifies readiness", func() {
+		var (
+			notifyFile   string
+			notifySocket string
+			conn         net.PacketConn
+			received     chan string
+		)
+
+		BeforeEach(func() {
+			notifyFile = filepath.Join(boshRoot, "ready")
+			notifySocket = filepath.Join(boshRoot, "notify.sock")
+
+			var err error
+			conn, err = net.ListenPacket("unixgram", notifySocket)
+			Expect(err).NotTo(HaveOccurred())
+
+			received = make(chan string, 1)
+			go func() {
+				buf := make([]byte, 1024)
+				n, _, err := conn.ReadFrom(buf)
+				if err == nil {
+					received <- string(buf[:n])
+				}
+			}()
+
+			cfg = newJobConfig(job, `sleep 100`)
+			cfg.Processes[0].Notify = &config.Notify{File: notifyFile, Socket: notifySocket}
+		})
+
+		AfterEach(func() {
+			Expect(conn.Close()).To(Succeed())
+		})
+
+		It("notifies once the container is running", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(state.Status).To(Equal(specs.StateRunning))
+			Expect(notifyFile).To(BeAnExistingFile())
+			Eventually(received).Should(Receive(Equal(fmt.Sprintf("READY=1\nMAINPID=%d\n", state.Pid))))
+		})
+
+		Context("when the container fails to start", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(notifyFile, nil, 0644)).To(Succeed())
+
+				// A mount only volume is not created by bpm so mounting it fails.
+				cfg.Processes[0].AdditionalVolumes = []config.Volume{
+					{Path: filepath.Join(boshRoot, "data", "does-not-exist"), MountOnly: true},
+				}
+			})
+
+			It("does not notify and removes the file from an earlier start", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+
+				Expect(notifyFile).NotTo(BeAnExistingFile())
+				Consistently(received).ShouldNot(Receive())
+			})
+		})
+	})
+
+	Context("when the process runs a binary from a package", func() {
+		BeforeEach(func() {
+			binDir := filepath.Join(boshRoot, "packages", "example", "bin")
+			Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(binDir, "hello"), []byte("#!/bin/bash\necho hello-from-package\n"), 0755)).To(Succeed())
+
+			cfg = newJobConfig(job, `/var/vcap/packages/example/bin/hello; touch /var/vcap/packages/example/bin/new || echo packages-read-only; sleep 100`)
+		})
+
+		It("runs it from the read-only packages mount", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(Equal("hello-from-package\npackages-read-only\n"))
+		})
+	})
+
+	Context("when the process has its own rootfs", func() {
+		BeforeEach(func() {
+			rootfs := filepath.Join(boshRoot, "packages", "minimal-rootfs")
+			buildMinimalRootFS(rootfs, "/bin/bash", filepath.Join(boshRoot, "packages", "bpm", "bin", "tini"))
+			Expect(ioutil.WriteFile(filepath.Join(rootfs, "sentinel"), []byte("minimal"), 0644)).To(Succeed())
+
+			cfg = newJobConfig(job, `echo "sentinel=$(< /sentinel)"; [ -e /usr/bin/env ] || echo "no host usr"`)
+			cfg.Processes[0].RootFS = rootfs
+		})
+
+		It("runs the process in that rootfs", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("sentinel=minimal"))
+			Eventually(fileContents(stdout)).Should(ContainSubstring("no host usr"))
+		})
+	})
+
+	Context("when a post-start hook is configured", func() {
+		var (
+			hook   string
+			marker string
+		)
+
+		BeforeEach(func() {
+			dataDir := bosh.NewEnv(boshRoot).DataDir(job)
+			marker = filepath.Join(boshRoot, "registered")
+			hook = filepath.Join(boshRoot, "post-start")
+
+			script := fmt.Sprintf(`#!/bin/bash
+for i in $(seq 50); do
+  if [ -e %[1]s ]; then
+    echo "registered after $(cat %[1]s)" > %[2]s
+    exit 0
+  fi
+  sleep 0.1
+done
+exit 1
+`, dataDir.Join("listening").External(), marker)
+			Expect(ioutil.WriteFile(hook, []byte(script), 0777)).To(Succeed())
+
+			cfg = newJobConfig(job, fmt.Sprintf(`sleep 1; echo listening > %s; sleep 100`, dataDir.Join("listening").Internal()))
+			cfg.Processes[0].EphemeralDisk = true
+			cfg.Processes[0].Hooks = &config.Hooks{PostStart: hook}
+		})
+
+		It("runs the hook once the process is up", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Expect(fileContents(marker)()).To(Equal("registered after listening\n"))
+			Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+		})
+
+		Context("when the hook runs inside the container", func() {
+			BeforeEach(func() {
+				jobHook := filepath.Join(boshRoot, "jobs", job, "bin", "post-start")
+				Expect(os.MkdirAll(filepath.Dir(jobHook), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(jobHook, []byte("#!/bin/bash\necho \"post-start next to $(cat /proc/1/comm)\"\n"), 0777)).To(Succeed())
+
+				cfg = newJobConfig(job, `sleep 100`)
+				cfg.Processes[0].Hooks = &config.Hooks{
+					PostStart:            bosh.NewEnv(boshRoot).JobDir(job).Join("bin", "post-start").Internal(),
+					PostStartInContainer: true,
+				}
+			})
+
+			It("runs the hook in the container of the process", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(stdout)).Should(ContainSubstring("post-start next to tini"))
+			})
+		})
+
+		Context("when the hook fails and should abort the start", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(hook, []byte("#!/bin/bash\nexit 1\n"), 0777)).To(Succeed())
+				cfg.Processes[0].Hooks.PostStartAbortOnFailure = true
+			})
+
+			It("removes the process and fails to start", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+
+				Expect(session.Err).To(gbytes.Say("post-start hook failed"))
+				Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("when the number of concurrent starts is limited", func() {
+		var (
+			hookLog string
+			jobs    []string
+		)
+
+		BeforeEach(func() {
+			hookLog = filepath.Join(boshRoot, "hook.log")
+			preStart := filepath.Join(boshRoot, "pre-start")
+			script := fmt.Sprintf("#!/bin/bash\necho begin >> %[1]s\nsleep 1\necho end >> %[1]s\n", hookLog)
+			Expect(ioutil.WriteFile(preStart, []byte(script), 0777)).To(Succeed())
+
+			jobs = []string{job}
+			for i := 0; i < 2; i++ {
+				jobs = append(jobs, uuid.NewV4().String())
+			}
+
+			for _, j := range jobs[1:] {
+				setupBoshDirectories(boshRoot, j)
+				otherCfg := newJobConfig(j, alternativeBash)
+				otherCfg.Processes[0].Hooks = &config.Hooks{PreStart: preStart}
+				writeConfig(boshRoot, j, otherCfg)
+			}
+
+			cfg.Processes[0].Hooks = &config.Hooks{PreStart: preStart}
+		})
+
+		AfterEach(func() {
+			for _, j := range jobs[1:] {
+				err := runcCommand(runcRoot, "delete", "--force", jobid.Encode(j)).Run()
+				if err != nil {
+					fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+				}
+			}
+		})
+
+		It("only starts up to the limit at once", func() {
+			var sessions []*gexec.Session
+			for _, j := range jobs {
+				startCommand := exec.Command(bpmPath, "start", j)
+				startCommand.Env = append(startCommand.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot), "BPM_MAX_CONCURRENT_STARTS=1")
+
+				session, err := gexec.Start(startCommand, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				sessions = append(sessions, session)
+			}
+
+			for _, session := range sessions {
+				Eventually(session, 30*time.Second).Should(gexec.Exit(0))
+			}
+
+			Expect(fileContents(hookLog)()).To(Equal(strings.Repeat("begin\nend\n", len(jobs))))
+		})
+	})
+
+	Context("when the process logs in local time", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `echo "zone is $(date +%Z)"; sleep 100`)
+		})
+
+		It("uses the timezone of the host", func() {
+			hostZone, err := exec.Command("date", "+%Z").Output()
+			Expect(err).NotTo(HaveOccurred())
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring(fmt.Sprintf("zone is %s", strings.TrimSpace(string(hostZone)))))
+		})
+	})
+
+	Context("when the systemd cgroup manager is requested", func() {
+		BeforeEach(func() {
+			if info, err := os.Lstat("/run/systemd/system"); err != nil || !info.IsDir() {
+				Skip("the host is not running systemd")
+			}
+		})
+
+		JustBeforeEach(func() {
+			command.Env = append(command.Env, "BPM_CGROUP_MANAGER=systemd")
+		})
+
+		AfterEach(func() {
+			stopCommand := exec.Command(bpmPath, "stop", job)
+			stopCommand.Env = append(stopCommand.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot), "BPM_CGROUP_MANAGER=systemd")
+			Expect(stopCommand.Run()).To(Succeed())
+		})
+
+		It("starts the process under a systemd slice", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(state.Status).To(Equal(specs.StateRunning))
+
+			cgroups, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", state.Pid))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(cgroups)).To(ContainSubstring(".slice"))
+		})
+	})
+
+	Context("when the process has an ionice class", func() {
+		BeforeEach(func() {
+			level := 6
+			cfg.Processes[0].IONice = &config.IONice{Class: config.IONiceBestEffort, Level: &level}
+		})
+
+		It("starts the process with that io priority", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(state.Status).To(Equal(specs.StateRunning))
+
+			output, err := exec.Command("ionice", "-p", strconv.Itoa(state.Pid)).CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), string(output))
+			Expect(string(output)).To(Equal("best-effort: prio 6\n"))
+		})
+
+		Context("when the level is out of range", func() {
+			BeforeEach(func() {
+				level := 9
+				cfg.Processes[0].IONice.Level = &level
+			})
+
+			It("fails to start", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+
+				Expect(session.Err).To(gbytes.Say("invalid config: ionice: level 9 must be between 0 and 7"))
+			})
+		})
+	})
+
+	Context("when BPM_REQUIRE_LIMITS requires a memory limit", func() {
+		JustBeforeEach(func() {
+			command.Env = append(command.Env, "BPM_REQUIRE_LIMITS=memory")
+		})
+
+		It("fails to start a process without a memory limit", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(1))
+
+			Expect(session.Err).To(gbytes.Say(`process "%s" does not set the limits required by BPM_REQUIRE_LIMITS: memory`, job))
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).NotTo(Succeed())
+		})
+
+		Context("when the process has a memory limit", func() {
+			BeforeEach(func() {
+				memory := "100M"
+				cfg.Processes[0].Limits = &config.Limits{Memory: &memory}
+			})
+
+			It("starts the process", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				state := runcState(runcRoot, containerID)
+				Expect(state.Status).To(Equal(specs.StateRunning))
 			})
+		})
 
-			stdout = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", process))
-			stderr = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stderr.log", process))
-			pidFile = filepath.Join(boshRoot, "sys", "run", "bpm", job, fmt.Sprintf("%s.pid", process))
+		Context("when the process is already running", func() {
+			It("leaves it running without checking its limits", func() {
+				start := exec.Command(bpmPath, "start", job)
+				start.Env = append(start.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+				session, err := gexec.Start(start, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				session, err = gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Expect(runcState(runcRoot, containerID).Status).To(Equal(specs.StateRunning))
+			})
 		})
+	})
 
+	Context("when an invalid cgroup manager is requested", func() {
 		JustBeforeEach(func() {
-			command = exec.Command(bpmPath, "start", job, "-p", process)
-			command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			command.Env = append(command.Env, "BPM_CGROUP_MANAGER=cgroupv3")
 		})
 
-		It("runs the process specified in the config", func() {
+		It("fails to start", func() {
 			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 			Expect(err).NotTo(HaveOccurred())
 			<-session.Exited
+			Expect(session).To(gexec.Exit(1))
+
+			Expect(session.Err).To(gbytes.Say(`invalid BPM_CGROUP_MANAGER "cgroupv3"`))
+		})
+	})
+
+	Context("when persistent storage is request", func() {
+		var dataFile bosh.Path
+
+		BeforeEach(func() {
+			dataFile = boshEnv.StoreDir(job).Join("data.txt")
+
+			cfg = newJobConfig(job, defaultBash(dataFile.Internal()))
+			cfg.Processes[0].PersistentDisk = config.DiskModeReadWrite
+		})
 
+		It("exposes the storage directory as a writeable mount point", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
 			Expect(session).To(gexec.Exit(0))
 
-			state := runcState(runcRoot, containerID)
-			Expect(state.Status).To(Equal(specs.StateRunning))
-			pidText, err := ioutil.ReadFile(pidFile)
+			Eventually(dataFile.External()).Should(BeAnExistingFile())
+			Eventually(fileContents(dataFile.External())).Should(ContainSubstring("Logging to FILE"))
+		})
+
+		Context("when it is requested read-only", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(boshEnv.StoreDir(job).External(), 0700)).To(Succeed())
+				Expect(ioutil.WriteFile(dataFile.External(), []byte("replicated data\n"), 0644)).To(Succeed())
+
+				cfg = newJobConfig(job, fmt.Sprintf(
+					`cat %[1]s; if echo more >> %[1]s; then echo "write succeeded"; else echo "write failed"; fi; sleep 10000`,
+					dataFile.Internal(),
+				))
+				cfg.Processes[0].PersistentDisk = config.DiskModeReadOnly
+			})
+
+			It("allows the process to read the store but not to write to it", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(stdout)).Should(ContainSubstring("write failed"))
+				Expect(fileContents(stdout)()).To(ContainSubstring("replicated data"))
+				Expect(fileContents(dataFile.External())()).To(Equal("replicated data\n"))
+			})
+		})
+	})
+
+	Context("when the data directory is mounted with noexec", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, execFromDirBash(boshEnv.DataDir(job).Internal()))
+			cfg.Processes[0].EphemeralDisk = true
+			cfg.Processes[0].DataMountOptions = []string{"noexec", "nosuid"}
+		})
+
+		It("prevents binaries dropped into the data directory from being executed", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
 
-			pid, err := strconv.Atoi(string(pidText))
+			Eventually(fileContents(stdout)).Should(ContainSubstring("Could not execute dropped binary"))
+		})
+
+		Context("when exec is allowed", func() {
+			BeforeEach(func() {
+				cfg.Processes[0].DataMountOptions = []string{"exec"}
+			})
+
+			It("allows binaries in the data directory to be executed", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(stdout)).Should(ContainSubstring("Executed dropped binary"))
+			})
+		})
+	})
+
+	Context("when a shm size is configured", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, shmWriteBash(96))
+			cfg.Processes[0].ShmSize = "128M"
+		})
+
+		It("allows writing more than the default size to /dev/shm", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(pid).To(Equal(state.Pid))
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
 
-			Eventually(fileContents(stdout)).Should(ContainSubstring("Alternate Logging to STDOUT"))
-			Eventually(fileContents(stderr)).Should(ContainSubstring("Alternate Logging to STDERR"))
+			Eventually(fileContents(stdout)).Should(ContainSubstring("Wrote to /dev/shm"))
 		})
 	})
 
-	Context("when a pre_start hook is specified", func() {
+	Context("when runc fails to start the container", func() {
 		BeforeEach(func() {
-			preStart := filepath.Join(boshRoot, "pre-start")
-			f, err := os.OpenFile(preStart, os.O_CREATE|os.O_RDWR, 0777)
+			// A mount only volume is not created by bpm so mounting it fails.
+			cfg.Processes[0].AdditionalVolumes = []config.Volume{
+				{Path: filepath.Join(boshRoot, "data", "does-not-exist"), MountOnly: true},
+			}
+		})
+
+		It("includes the runc log in the error", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(1))
 
-			_, err = f.Write([]byte(preStartBash))
+			Expect(session.Err).To(gbytes.Say("runc log: .*level=error"))
+
+			runcLog := filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.runc.log", job))
+			Expect(fileContents(runcLog)()).To(ContainSubstring("does-not-exist"))
+		})
+	})
+
+	Context("when a volume which does not exist should be created", func() {
+		var volumePath string
+
+		BeforeEach(func() {
+			volumePath = filepath.Join(boshRoot, "data", "created", "volume")
+			cfg = newJobConfig(job, fmt.Sprintf(`echo hello > %s/file; sleep 10000`, volumePath))
+			cfg.Processes[0].AdditionalVolumes = []config.Volume{
+				{Path: volumePath, MountOnly: true, Writable: true, Create: true, CreateMode: "0750"},
+			}
+		})
+
+		It("creates the directory and mounts it", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(f.Close()).To(Succeed())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
 
-			cfg.Processes[0].Hooks = &config.Hooks{
-				PreStart: preStart,
+			Eventually(filepath.Join(volumePath, "file")).Should(BeAnExistingFile())
+			Expect(fileContents(filepath.Join(volumePath, "file"))()).To(Equal("hello\n"))
+
+			info, err := os.Stat(volumePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode() & os.ModePerm).To(Equal(os.FileMode(0750)))
+		})
+	})
+
+	Context("when nsswitch.conf is overridden", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `cat /etc/nsswitch.conf; sleep 100`)
+			cfg.Processes[0].NsswitchConf = "hosts: files dns\n"
+		})
+
+		It("uses the provided contents inside the container", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(Equal("hosts: files dns\n"))
+		})
+	})
+
+	Context("when the logs are written to named pipes", func() {
+		var stdoutFifo string
+
+		BeforeEach(func() {
+			stdoutFifo = filepath.Join(boshRoot, "sys", "run", job, fmt.Sprintf("%s.stdout.fifo", job))
+		})
+
+		Context("when a reader is draining the named pipe", func() {
+			BeforeEach(func() {
+				cfg = newJobConfig(job, `while true; do echo "hello fifo"; sleep 0.1; done`)
+				cfg.Processes[0].Logs = &config.Logs{Fifo: true}
+			})
+
+			It("writes the output of the process to it", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				fifo, err := os.Open(stdoutFifo)
+				Expect(err).NotTo(HaveOccurred())
+				defer fifo.Close()
+
+				out := gbytes.NewBuffer()
+				go io.Copy(out, fifo)
+
+				Eventually(out).Should(gbytes.Say("hello fifo"))
+				Expect(stdout).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when nothing reads from the named pipe", func() {
+			BeforeEach(func() {
+				cfg = newJobConfig(job, fmt.Sprintf(`head -c 10000000 /dev/zero; echo finished > %s; sleep 100`, logFile.Internal()))
+				cfg.Processes[0].Logs = &config.Logs{Fifo: true}
+			})
+
+			It("does not block the process", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				Eventually(fileContents(logFile.External())).Should(ContainSubstring("finished"))
+			})
+		})
+	})
+
+	Context("when core dumps are requested", func() {
+		BeforeEach(func() {
+			features, err := sysfeat.Fetch()
+			Expect(err).NotTo(HaveOccurred())
+			if !filepath.IsAbs(features.CorePattern) {
+				Skip("the kernel core_pattern is not an absolute path")
 			}
+
+			cfg = newJobConfig(job, `sleep 100 & kill -SEGV $!; wait; sleep 100`)
+			cfg.Processes[0].CoreDumps = true
 		})
 
-		It("executs the pre-start prior to starting the process", func() {
+		It("writes core files of crashed processes to the core directory", func() {
 			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 			Expect(err).NotTo(HaveOccurred())
 			<-session.Exited
 			Expect(session).To(gexec.Exit(0))
-			Eventually(fileContents(stdout)).Should(ContainSubstring("Executing Pre Start\nen_US.UTF-8\n"))
+
+			coreDir := filepath.Join(boshRoot, "sys", "log", job, "cores")
+			Eventually(func() ([]os.FileInfo, error) {
+				return ioutil.ReadDir(coreDir)
+			}).ShouldNot(BeEmpty())
 		})
 	})
 
-	Context("when persistent storage is request", func() {
-		var dataFile bosh.Path
+	Context("when an SELinux label is provided", func() {
+		const label = "system_u:system_r:container_t:s0"
 
 		BeforeEach(func() {
-			dataFile = boshEnv.StoreDir(job).Join("data.txt")
+			features, err := sysfeat.Fetch()
+			Expect(err).NotTo(HaveOccurred())
+			if !features.SELinuxEnabled {
+				Skip("SELinux is not enabled on this host")
+			}
 
-			cfg = newJobConfig(job, defaultBash(dataFile.Internal()))
-			cfg.Processes[0].PersistentDisk = true
+			cfg = newJobConfig(job, `cat /proc/1/attr/current; sleep 100`)
+			cfg.Processes[0].SELinuxLabel = label
 		})
 
-		It("exposes the storage directory as a writeable mount point", func() {
+		It("runs the process under the configured context", func() {
 			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
 			Expect(err).NotTo(HaveOccurred())
 			<-session.Exited
 			Expect(session).To(gexec.Exit(0))
 
-			Eventually(dataFile.External()).Should(BeAnExistingFile())
-			Eventually(fileContents(dataFile.External())).Should(ContainSubstring("Logging to FILE"))
+			Eventually(fileContents(stdout)).Should(ContainSubstring(label))
+		})
+	})
+
+	Context("when the bpm configuration file is not valid YAML", func() {
+		JustBeforeEach(func() {
+			cfgPath := filepath.Join(boshRoot, "jobs", job, "config", "bpm.yml")
+			Expect(ioutil.WriteFile(cfgPath, []byte("processes:\n- name: "+job+"\n  executable: [/bin/bash\n"), 0644)).To(Succeed())
+		})
+
+		It("exits with the config error status and names the file and line", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(exitstatus.ConfigError))
+			Expect(session.Err).Should(gbytes.Say(`bpm\.yml is not valid: line \d+: `))
 		})
 	})
 
@@ -273,6 +1806,101 @@ var _ = Describe("start", func() {
 			Expect(session).To(gexec.Exit(1))
 			Expect(session.Err).Should(gbytes.Say("must specify a job"))
 		})
+
+		It("does not treat an overriding command as the job name", func() {
+			session, err := gexec.Start(exec.Command(bpmPath, "start", "--", "/bin/true"), GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-session.Exited
+
+			Expect(session).To(gexec.Exit(1))
+			Expect(session.Err).Should(gbytes.Say("must specify a job"))
+		})
+	})
+
+	Context("when a pidfile is left behind", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Dir(pidFile), 0700)).To(Succeed())
+		})
+
+		It("removes the stale pidfile and starts the process", func() {
+			Expect(ioutil.WriteFile(pidFile, []byte("999999"), 0600)).To(Succeed())
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			state := runcState(runcRoot, containerID)
+			Expect(fileContents(pidFile)()).To(Equal(strconv.Itoa(state.Pid)))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("removing-stale-pidfile"))
+		})
+
+		Context("when it holds the pid of another running container", func() {
+			var otherContainerID string
+
+			BeforeEach(func() {
+				otherContainerID = jobid.Encode(fmt.Sprintf("%s.other", job))
+				cfg.Processes = append(cfg.Processes, &config.ProcessConfig{
+					Name:       "other",
+					Executable: "/bin/bash",
+					Args:       []string{"-c", alternativeBash},
+				})
+			})
+
+			JustBeforeEach(func() {
+				otherCommand := exec.Command(bpmPath, "start", job, "-p", "other")
+				otherCommand.Env = command.Env
+				session, err := gexec.Start(otherCommand, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				otherPid := runcState(runcRoot, otherContainerID).Pid
+				Expect(ioutil.WriteFile(pidFile, []byte(strconv.Itoa(otherPid)), 0600)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				err := runcCommand(runcRoot, "delete", "--force", otherContainerID).Run()
+				if err != nil {
+					fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+				}
+			})
+
+			It("refuses to overwrite it", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).To(gbytes.Say("use --force to overwrite it"))
+			})
+
+			It("overwrites it when forced", func() {
+				command.Args = append(command.Args, "--force")
+
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+
+				state := runcState(runcRoot, containerID)
+				Expect(fileContents(pidFile)()).To(Equal(strconv.Itoa(state.Pid)))
+			})
+		})
+	})
+
+	Context("when the command is overridden on the command line", func() {
+		It("runs the given command instead of the configured one", func() {
+			command.Args = append(command.Args, "--", "/bin/bash", "-c", "echo overridden; sleep 100")
+
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("overridden"))
+			Expect(fileContents(stdout)()).NotTo(ContainSubstring("Logging to STDOUT"))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("overriding-command"))
+		})
 	})
 
 	Context("when a running container exist with the same name", func() {
@@ -470,3 +2098,34 @@ var _ = Describe("start", func() {
 		})
 	})
 })
+
+// buildMinimalRootFS copies the given binaries and the shared libraries they
+// need into rootfs at the same paths as on the host.
+func buildMinimalRootFS(rootfs string, binaries ...string) {
+	files := map[string]bool{}
+	for _, binary := range binaries {
+		files[binary] = true
+
+		// ldd fails for static binaries which need nothing else.
+		output, _ := exec.Command("ldd", binary).Output()
+		for _, line := range strings.Split(string(output), "\n") {
+			for _, field := range strings.Fields(line) {
+				if filepath.IsAbs(field) {
+					files[field] = true
+				}
+			}
+		}
+	}
+
+	for file := range files {
+		dst := filepath.Join(rootfs, file)
+		if strings.HasPrefix(file, filepath.Dir(filepath.Dir(rootfs))) {
+			// Binaries from the BOSH root are visible through its mounts.
+			continue
+		}
+
+		Expect(os.MkdirAll(filepath.Dir(dst), 0755)).To(Succeed())
+		Expect(copyFile(dst, file)).To(Succeed())
+		Expect(os.Chmod(dst, 0755)).To(Succeed())
+	}
+}
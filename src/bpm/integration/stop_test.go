@@ -21,6 +21,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -137,6 +138,302 @@ var _ = Describe("stop", func() {
 		Eventually(fileContents(bpmLog)).Should(ContainSubstring("bpm.stop.complete"))
 	})
 
+	Context("when a report is requested", func() {
+		JustBeforeEach(func() {
+			command = exec.Command(bpmPath, "stop", job, "--report")
+			command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+		})
+
+		It("reports that the process exited within the grace period", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say("process exited within the 15s grace period after"))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("bpm.stop.report"))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring(`"outcome":"exited"`))
+		})
+
+		Context("when the process ignores SIGTERM", func() {
+			BeforeEach(func() {
+				cfg = newJobConfig(job, ignoreSigTERMBash)
+			})
+
+			It("reports that the process was forcefully killed", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session, 30*time.Second).Should(gexec.Exit(0))
+
+				Expect(session.Out).To(gbytes.Say("process did not exit within the 15s grace period and was forcefully killed after"))
+				Expect(fileContents(bpmLog)()).To(ContainSubstring(`"outcome":"killed"`))
+				Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("when the grace period is overridden", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, ignoreSigTERMBash)
+		})
+
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "--report")
+			command.Env = append(command.Env, "BPM_STOP_GRACE_PERIOD=100ms")
+		})
+
+		It("kills a process which ignores SIGTERM once the override has passed", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 5*time.Second).Should(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say("process did not exit within the 100ms grace period and was forcefully killed after"))
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+		})
+
+		Context("when the override is invalid", func() {
+			JustBeforeEach(func() {
+				command.Env = append(command.Env, "BPM_STOP_GRACE_PERIOD=soon")
+			})
+
+			It("exits with a non-zero exit code and leaves the process running", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(1))
+
+				Expect(session.Err).To(gbytes.Say(`invalid BPM_STOP_GRACE_PERIOD "soon"`))
+				Expect(runcCommand(runcRoot, "state", containerID).Run()).To(Succeed())
+			})
+		})
+	})
+
+	Context("when the process is killed immediately", func() {
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "--kill")
+		})
+
+		It("removes the container without running the SIGTERM trap", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 5*time.Second).Should(gexec.Exit(0))
+
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("bpm.stop.immediate"))
+			Consistently(fileContents(stdout)).ShouldNot(ContainSubstring("Received a Signal"))
+		})
+	})
+
+	Context("when stop signals are configured", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, sigIntOnlyBash)
+			cfg.Processes[0].StopSignals = []config.StopSignal{
+				{Signal: "TERM", Wait: "2s"},
+				{Signal: "INT", Wait: "10s"},
+			}
+		})
+
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "--report")
+		})
+
+		It("escalates through the signals until the process exits", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 15*time.Second).Should(gexec.Exit(0))
+
+			Expect(fileContents(stdout)()).To(ContainSubstring("Received SIGINT"))
+			Expect(session.Out).To(gbytes.Say("process exited within the 12s grace period after"))
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+		})
+	})
+
+	Context("when the container is frozen to stop it", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `
+				for i in 1 2 3; do
+					(trap "echo child $i handled TERM; exit 0" TERM; while true; do sleep 0.1; done) &
+				done
+				trap 'wait; echo parent handled TERM; exit 0' TERM
+				while true; do sleep 0.1; done
+			`)
+			cfg.Processes[0].FreezeOnStop = true
+		})
+
+		JustBeforeEach(func() {
+			command.Args = append(command.Args, "--report")
+		})
+
+		It("delivers the signal to every process in the container", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 10*time.Second).Should(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say("process exited within the 15s grace period after"))
+			output := fileContents(stdout)()
+			for i := 1; i <= 3; i++ {
+				Expect(output).To(ContainSubstring(fmt.Sprintf("child %d handled TERM", i)))
+			}
+			Expect(output).To(ContainSubstring("parent handled TERM"))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("signalling-frozen-processes"))
+		})
+	})
+
+	Context("when signalling the process fails transiently", func() {
+		JustBeforeEach(func() {
+			installFlakyRunc(boshRoot, "kill")
+		})
+
+		It("retries and stops the process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Eventually(fileContents(stdout)).Should(ContainSubstring("Received a Signal"))
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("failed-to-sigterm"))
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+		})
+	})
+
+	Context("when all processes are stopped", func() {
+		var (
+			otherContainerID string
+			otherJob         string
+		)
+
+		BeforeEach(func() {
+			otherJob = uuid.NewV4().String()
+			otherContainerID = jobid.Encode(otherJob)
+			setupBoshDirectories(boshRoot, otherJob)
+
+			otherLogFile := filepath.Join(boshRoot, "sys", "log", otherJob, "foo.log")
+			writeConfig(boshRoot, otherJob, newJobConfig(otherJob, defaultBash(otherLogFile)))
+		})
+
+		JustBeforeEach(func() {
+			startJob(boshRoot, bpmPath, otherJob)
+
+			command = exec.Command(bpmPath, "stop", "--all")
+			command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+		})
+
+		AfterEach(func() {
+			err := runcCommand(runcRoot, "delete", "--force", otherContainerID).Run()
+			if err != nil {
+				fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+			}
+		})
+
+		It("stops every running process", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session, 30*time.Second).Should(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say("2 stopped, 0 failed"))
+			Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+			Expect(runcCommand(runcRoot, "state", otherContainerID).Run()).To(HaveOccurred())
+
+			Expect(fileContents(bpmLog)()).To(ContainSubstring("bpm.stop.complete"))
+			otherBpmLog := filepath.Join(boshRoot, "sys", "log", otherJob, "bpm.log")
+			Expect(fileContents(otherBpmLog)()).To(ContainSubstring("bpm.stop.complete"))
+		})
+
+		Context("when the configuration of a running process is invalid", func() {
+			JustBeforeEach(func() {
+				writeInvalidConfig(boshRoot, otherJob)
+			})
+
+			It("still stops the process", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session, 30*time.Second).Should(gexec.Exit(0))
+
+				Expect(session.Out).To(gbytes.Say("2 stopped, 0 failed"))
+				Expect(runcCommand(runcRoot, "state", containerID).Run()).To(HaveOccurred())
+				Expect(runcCommand(runcRoot, "state", otherContainerID).Run()).To(HaveOccurred())
+
+				otherBpmLog := filepath.Join(boshRoot, "sys", "log", otherJob, "bpm.log")
+				Expect(fileContents(otherBpmLog)()).To(ContainSubstring("failed-to-parse-config"))
+				Expect(fileContents(otherBpmLog)()).To(ContainSubstring("bpm.stop.complete"))
+			})
+		})
+
+		Context("when the job of a running process has been removed", func() {
+			JustBeforeEach(func() {
+				Expect(os.RemoveAll(filepath.Join(boshRoot, "jobs", otherJob))).To(Succeed())
+			})
+
+			It("still stops the process", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session, 30*time.Second).Should(gexec.Exit(0))
+
+				Expect(session.Out).To(gbytes.Say("2 stopped, 0 failed"))
+				Expect(runcCommand(runcRoot, "state", otherContainerID).Run()).To(HaveOccurred())
+			})
+		})
+
+		Context("when a process was started with a custom container id", func() {
+			var (
+				customJob         string
+				customContainerID string
+			)
+
+			BeforeEach(func() {
+				customJob = uuid.NewV4().String()
+				customContainerID = fmt.Sprintf("custom-%s", customJob)
+				setupBoshDirectories(boshRoot, customJob)
+
+				customLogFile := filepath.Join(boshRoot, "sys", "log", customJob, "foo.log")
+				writeConfig(boshRoot, customJob, newJobConfig(customJob, defaultBash(customLogFile)))
+			})
+
+			JustBeforeEach(func() {
+				startCommand := exec.Command(bpmPath, "start", customJob, "--container-id", customContainerID)
+				startCommand.Env = append(startCommand.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+				session, err := gexec.Start(startCommand, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+				Expect(session).To(gexec.Exit(0))
+			})
+
+			AfterEach(func() {
+				err := runcCommand(runcRoot, "delete", "--force", customContainerID).Run()
+				if err != nil {
+					fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+				}
+			})
+
+			It("stops it as well", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session, 30*time.Second).Should(gexec.Exit(0))
+
+				Expect(session.Out).To(gbytes.Say(fmt.Sprintf("stopped %s/%s", customJob, customJob)))
+				Expect(session.Out).To(gbytes.Say("3 stopped, 0 failed"))
+				Expect(runcCommand(runcRoot, "state", customContainerID).Run()).To(HaveOccurred())
+				Expect(filepath.Join(boshRoot, "data", "bpm", "bundles", customJob, customJob)).NotTo(BeADirectory())
+			})
+		})
+
+		Context("when a job is also specified", func() {
+			JustBeforeEach(func() {
+				command = exec.Command(bpmPath, "stop", "--all", job)
+				command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+			})
+
+			It("exits with a non-zero exit code", func() {
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				<-session.Exited
+
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).To(gbytes.Say("cannot specify a job when stopping all processes"))
+			})
+		})
+	})
+
 	Context("when the job name is not specified", func() {
 		It("exits with a non-zero exit code and prints the usage", func() {
 			command = exec.Command(bpmPath, "stop")
@@ -0,0 +1,104 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	uuid "github.com/satori/go.uuid"
+	yaml "gopkg.in/yaml.v2"
+
+	"bpm/config"
+)
+
+var _ = Describe("summary", func() {
+	var (
+		command *exec.Cmd
+
+		cfg config.JobConfig
+
+		boshRoot   string
+		configPath string
+		job        string
+		volumePath string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "summary-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+
+		setupBoshDirectories(boshRoot, job)
+
+		memory := "256M"
+		volumePath = filepath.Join(boshRoot, "data", job, "cache")
+
+		cfg = newJobConfig(job, `sleep 100`)
+		cfg.Processes[0].Limits = &config.Limits{Memory: &memory}
+		cfg.Processes[0].AdditionalVolumes = []config.Volume{{Path: volumePath, Writable: true}}
+	})
+
+	JustBeforeEach(func() {
+		data, err := yaml.Marshal(&cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		configPath = filepath.Join(boshRoot, "other-bpm.yml")
+		Expect(ioutil.WriteFile(configPath, data, 0644)).To(Succeed())
+
+		command = exec.Command(bpmPath, "summary", job, "-c", configPath)
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("lists the features the configuration enables", func() {
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		<-session.Exited
+		Expect(session).To(gexec.Exit(0))
+
+		Expect(session.Out).To(gbytes.Say("limits.memory: 256M\n"))
+		Expect(session.Out).To(gbytes.Say(fmt.Sprintf("additional_volumes: %s \\(writable\\)\n", volumePath)))
+	})
+
+	Context("when the configuration only uses the defaults", func() {
+		BeforeEach(func() {
+			cfg = newJobConfig(job, `sleep 100`)
+		})
+
+		It("says so", func() {
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+
+			Expect(string(session.Out.Contents())).To(Equal("no features beyond the defaults\n"))
+		})
+	})
+})
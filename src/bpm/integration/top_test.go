@@ -0,0 +1,111 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	uuid "github.com/satori/go.uuid"
+
+	"bpm/config"
+	"bpm/jobid"
+)
+
+var _ = Describe("top", func() {
+	var (
+		command *exec.Cmd
+
+		cfg config.JobConfig
+
+		boshRoot    string
+		containerID string
+		job         string
+		runcRoot    string
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		job = uuid.NewV4().String()
+		containerID = jobid.Encode(job)
+		boshRoot, err = ioutil.TempDir(bpmTmpDir, "top-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(boshRoot, 0755)).To(Succeed())
+		runcRoot = setupBoshDirectories(boshRoot, job)
+
+		memory := "64M"
+		cfg = newJobConfig(job, `sleep 100`)
+		cfg.Processes[0].Limits = &config.Limits{Memory: &memory}
+		writeConfig(boshRoot, job, cfg)
+
+		command = exec.Command(bpmPath, "top", "--interval", "100ms")
+		command.Env = append(command.Env, fmt.Sprintf("BPM_BOSH_ROOT=%s", boshRoot))
+	})
+
+	AfterEach(func() {
+		err := runcCommand(runcRoot, "delete", "--force", containerID).Run()
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "WARNING: Failed to cleanup container: %s\n", err.Error())
+		}
+		Expect(os.RemoveAll(boshRoot)).To(Succeed())
+	})
+
+	It("shows the memory usage of the running processes", func() {
+		startJob(boshRoot, bpmPath, job)
+		Eventually(func() specs.ContainerState { return runcState(runcRoot, containerID).Status }).Should(Equal(specs.StateRunning))
+
+		command.Args = append(command.Args, "-n", "2")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session).Should(gexec.Exit(0))
+
+		Expect(session.Out).To(gbytes.Say(`Name\s+Pid\s+CPU%\s+Memory\s+Limit\s+Tasks`))
+		Expect(session.Out).To(gbytes.Say(fmt.Sprintf(`%s\s+\d+\s+-\s+[\d.]+[BKM]\s+64M\s+\d+`, job)))
+		Expect(session.Out).To(gbytes.Say(fmt.Sprintf(`%s\s+\d+\s+[\d.]+\s+[\d.]+[BKM]\s+64M\s+\d+`, job)))
+	})
+
+	It("redraws until it is interrupted", func() {
+		startJob(boshRoot, bpmPath, job)
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+
+		for i := 0; i < 2; i++ {
+			Eventually(session.Out).Should(gbytes.Say("\033\\[H\033\\[2J"))
+			Eventually(session.Out).Should(gbytes.Say(job))
+		}
+
+		session.Interrupt()
+		Eventually(session).Should(gexec.Exit(0))
+	})
+
+	It("rejects an interval which is not positive", func() {
+		command.Args = append(command.Args, "--interval", "0s")
+
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session).Should(gexec.Exit(1))
+		Expect(session.Err).To(gbytes.Say("invalid interval: 0s must be positive"))
+	})
+})
@@ -21,6 +21,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -87,6 +88,50 @@ var _ = Describe("trace", func() {
 		Expect(session).To(gexec.Exit(0))
 	})
 
+	Context("when a pid is given", func() {
+		var stdout string
+
+		BeforeEach(func() {
+			stdout = filepath.Join(boshRoot, "sys", "log", job, fmt.Sprintf("%s.stdout.log", job))
+			cfg = newJobConfig(job, `(while true; do sleep 0.1; done) & echo "child=$!"; wait`)
+			writeConfig(boshRoot, job, cfg)
+		})
+
+		It("traces that process in the container", func() {
+			startJob(boshRoot, bpmPath, job)
+			Eventually(fileContents(stdout)).Should(MatchRegexp(`child=\d+\n`))
+
+			var child int
+			_, err := fmt.Sscanf(fileContents(stdout)(), "child=%d", &child)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(child).NotTo(Equal(2))
+
+			command.Args = append(command.Args, "--pid", strconv.Itoa(child))
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(session.Err).Should(gbytes.Say(`attached`))
+			Eventually(session.Err).Should(gbytes.Say("wait4"))
+
+			session.Interrupt()
+			<-session.Exited
+			Expect(session).To(gexec.Exit(0))
+		})
+
+		Context("when the pid is not in the container", func() {
+			It("returns an error", func() {
+				startJob(boshRoot, bpmPath, job)
+
+				command.Args = append(command.Args, "--pid", "99999")
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).ShouldNot(HaveOccurred())
+				<-session.Exited
+
+				Expect(session).To(gexec.Exit(1))
+				Expect(session.Err).Should(gbytes.Say("Error: pid 99999 is not a process in the container"))
+			})
+		})
+	})
+
 	Context("when the container is failed", func() {
 		BeforeEach(func() {
 			startJob(boshRoot, bpmPath, job)
@@ -0,0 +1,69 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package ioprio
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Class is an I/O scheduling class as used by ionice.
+type Class int
+
+const (
+	ClassNone Class = iota
+	ClassRealtime
+	ClassBestEffort
+	ClassIdle
+)
+
+const (
+	classShift = 13
+	levelMask  = 1<<classShift - 1
+
+	// whoProcess makes ioprio_set and ioprio_get act on a single thread,
+	// the calling one when the id is zero.
+	whoProcess = 1
+)
+
+// The levels within the realtime and best-effort classes go from 0, the
+// highest priority, to 7.
+const (
+	HighestLevel = 0
+	LowestLevel  = 7
+)
+
+// SetCurrentThread sets the I/O priority of the calling thread, which the
+// processes it forks inherit. The caller should have locked its goroutine to
+// the thread.
+func SetCurrentThread(class Class, level int) error {
+	prio := uintptr(class)<<classShift | uintptr(level)
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, whoProcess, 0, prio); errno != 0 {
+		return fmt.Errorf("failed to set io priority: %s", errno)
+	}
+
+	return nil
+}
+
+// Get returns the I/O priority of the thread with the given id.
+func Get(tid int) (Class, int, error) {
+	prio, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, whoProcess, uintptr(tid), 0)
+	if errno != 0 {
+		return ClassNone, 0, fmt.Errorf("failed to get io priority: %s", errno)
+	}
+
+	return Class(prio >> classShift), int(prio & levelMask), nil
+}
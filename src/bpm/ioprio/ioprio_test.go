@@ -0,0 +1,65 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package ioprio_test
+
+import (
+	"runtime"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/ioprio"
+)
+
+var _ = Describe("Ioprio", func() {
+	It("sets the priority of the calling thread", func() {
+		done := make(chan struct{})
+
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+
+			// The thread is thrown away when the goroutine exits while
+			// still locked to it.
+			runtime.LockOSThread()
+
+			Expect(ioprio.SetCurrentThread(ioprio.ClassBestEffort, 6)).To(Succeed())
+
+			class, level, err := ioprio.Get(syscall.Gettid())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(class).To(Equal(ioprio.ClassBestEffort))
+			Expect(level).To(Equal(6))
+		}()
+
+		<-done
+	})
+
+	It("rejects an unknown class", func() {
+		done := make(chan struct{})
+
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+
+			runtime.LockOSThread()
+
+			Expect(ioprio.SetCurrentThread(ioprio.Class(5), 0)).NotTo(Succeed())
+		}()
+
+		<-done
+	})
+})
@@ -0,0 +1,77 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logpump
+
+import (
+	"os"
+	"syscall"
+)
+
+// FifoWriter writes to a named pipe without ever blocking. Output which does
+// not fit in the pipe because nothing is reading from it is dropped.
+type FifoWriter struct {
+	conn syscall.RawConn
+}
+
+// NewFifoWriter switches f to non-blocking mode and returns a FifoWriter for
+// it.
+func NewFifoWriter(f *os.File) (*FifoWriter, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var nonblockErr error
+	if err := conn.Control(func(fd uintptr) {
+		nonblockErr = syscall.SetNonblock(int(fd), true)
+	}); err != nil {
+		return nil, err
+	}
+	if nonblockErr != nil {
+		return nil, nonblockErr
+	}
+
+	return &FifoWriter{conn: conn}, nil
+}
+
+func (w *FifoWriter) Write(p []byte) (int, error) {
+	var writeErr error
+	err := w.conn.Write(func(fd uintptr) bool {
+		for rest := p; len(rest) > 0; {
+			n, err := syscall.Write(int(fd), rest)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				return true
+			}
+			if err != nil {
+				writeErr = err
+				return true
+			}
+			rest = rest[n:]
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if writeErr != nil {
+		return 0, writeErr
+	}
+
+	return len(p), nil
+}
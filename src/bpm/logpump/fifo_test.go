@@ -0,0 +1,86 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logpump_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/logpump"
+)
+
+var _ = Describe("FifoWriter", func() {
+	var (
+		tempDir string
+		fifo    *os.File
+		fw      *logpump.FifoWriter
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "fifo-writer")
+		Expect(err).NotTo(HaveOccurred())
+
+		path := filepath.Join(tempDir, "out.fifo")
+		Expect(syscall.Mkfifo(path, 0600)).To(Succeed())
+
+		// Opening the fifo for reading and writing holds a reader open so that
+		// writes never fail with EPIPE.
+		fifo, err = os.OpenFile(path, os.O_RDWR, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		fw, err = logpump.NewFifoWriter(fifo)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(fifo.Close()).To(Succeed())
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	It("writes to the fifo", func() {
+		_, err := fw.Write([]byte("hello\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		buf := make([]byte, 6)
+		_, err = fifo.Read(buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(buf)).To(Equal("hello\n"))
+	})
+
+	It("drops output instead of blocking when the fifo is full", func() {
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+
+			chunk := bytes.Repeat([]byte("x"), 64*1024)
+			for i := 0; i < 32; i++ {
+				n, err := fw.Write(chunk)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(n).To(Equal(len(chunk)))
+			}
+		}()
+
+		Eventually(done).Should(BeClosed())
+	})
+})
@@ -0,0 +1,82 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logpump
+
+import (
+	"io"
+	"os/exec"
+)
+
+// FilterWriter writes through a filter command which transforms the output
+// before it is written to w. Once the filter has exited the output is written
+// to w unchanged so that a broken filter never holds up the process.
+type FilterWriter struct {
+	w     io.Writer
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	done  chan error
+
+	failed bool
+}
+
+// NewFilterWriter starts the filter at path with its output going to w.
+func NewFilterWriter(w io.Writer, path string) (*FilterWriter, error) {
+	cmd := exec.Command(path)
+	cmd.Stdout = w
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	f := &FilterWriter{w: w, cmd: cmd, stdin: stdin, done: make(chan error, 1)}
+	go func() { f.done <- cmd.Wait() }()
+
+	return f, nil
+}
+
+func (f *FilterWriter) Write(p []byte) (int, error) {
+	if !f.failed {
+		if _, err := f.stdin.Write(p); err == nil {
+			return len(p), nil
+		}
+
+		// Whatever the filter had already written must reach w before
+		// anything else does.
+		f.failed = true
+		f.stdin.Close()
+		<-f.done
+	}
+
+	return f.w.Write(p)
+}
+
+// Close closes the input of the filter and waits for it to write out the
+// rest of its output.
+func (f *FilterWriter) Close() error {
+	if f.failed {
+		return nil
+	}
+
+	f.failed = true
+	f.stdin.Close()
+
+	return <-f.done
+}
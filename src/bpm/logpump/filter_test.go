@@ -0,0 +1,94 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logpump_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/logpump"
+)
+
+var _ = Describe("FilterWriter", func() {
+	var (
+		tempDir string
+		out     *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "filter-writer")
+		Expect(err).NotTo(HaveOccurred())
+
+		out = &bytes.Buffer{}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	writeFilter := func(script string) string {
+		path := filepath.Join(tempDir, "filter")
+		Expect(ioutil.WriteFile(path, []byte(script), 0700)).To(Succeed())
+		return path
+	}
+
+	It("writes the output of the filter", func() {
+		fw, err := logpump.NewFilterWriter(out, writeFilter("#!/bin/bash\ntr a-z A-Z\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = fw.Write([]byte("hello\nworld\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fw.Close()).To(Succeed())
+
+		Expect(out.String()).To(Equal("HELLO\nWORLD\n"))
+	})
+
+	Context("when the filter exits", func() {
+		It("writes the output unchanged", func() {
+			// The filter writes to the file while the test reads from it.
+			logFile, err := os.Create(filepath.Join(tempDir, "log"))
+			Expect(err).NotTo(HaveOccurred())
+			defer logFile.Close()
+
+			fw, err := logpump.NewFilterWriter(logFile, writeFilter("#!/bin/bash\necho filtered\nexit 1\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() string {
+				_, err := fw.Write([]byte("raw\n"))
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, err := ioutil.ReadFile(logFile.Name())
+				Expect(err).NotTo(HaveOccurred())
+				return string(contents)
+			}).Should(HavePrefix("filtered\nraw\n"))
+
+			Expect(fw.Close()).To(Succeed())
+		})
+	})
+
+	Context("when the filter cannot be started", func() {
+		It("returns an error", func() {
+			_, err := logpump.NewFilterWriter(out, filepath.Join(tempDir, "missing"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
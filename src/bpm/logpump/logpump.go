@@ -0,0 +1,124 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package logpump copies the output of a process into its log files.
+package logpump
+
+import (
+	"bytes"
+	"io"
+)
+
+// MaxLineLength is the longest partial line a LineWriter holds on to. Longer
+// lines are written out in pieces rather than buffered without bound.
+const MaxLineLength = 64 * 1024
+
+// LineWriter buffers writes to w so that only whole lines are written. Any
+// trailing partial line is written when the LineWriter is closed.
+type LineWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewLineWriter(w io.Writer) *LineWriter {
+	return &LineWriter{w: w}
+}
+
+func (l *LineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+
+	i := bytes.LastIndexByte(l.buf, '\n')
+	if i < 0 {
+		if len(l.buf) >= MaxLineLength {
+			return len(p), l.flush()
+		}
+		return len(p), nil
+	}
+
+	_, err := l.w.Write(l.buf[:i+1])
+	l.buf = append(l.buf[:0], l.buf[i+1:]...)
+
+	return len(p), err
+}
+
+func (l *LineWriter) Close() error {
+	return l.flush()
+}
+
+func (l *LineWriter) flush() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+
+	_, err := l.w.Write(l.buf)
+	l.buf = l.buf[:0]
+
+	return err
+}
+
+// PrefixWriter writes a prefix to w at the start of every line. Each Write
+// results in a single write to w.
+type PrefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+func NewPrefixWriter(w io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	var out []byte
+	for rest := b; len(rest) > 0; {
+		if !p.midLine {
+			out = append(out, p.prefix...)
+		}
+
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			out = append(out, rest...)
+			p.midLine = true
+			break
+		}
+
+		out = append(out, rest[:i+1]...)
+		rest = rest[i+1:]
+		p.midLine = false
+	}
+
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Pump copies src to dst until src is closed. If lineBuffered is set only
+// whole lines are written to dst.
+func Pump(dst io.Writer, src io.Reader, lineBuffered bool) error {
+	if !lineBuffered {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+
+	lw := NewLineWriter(dst)
+	if _, err := io.Copy(lw, src); err != nil {
+		lw.Close()
+		return err
+	}
+
+	return lw.Close()
+}
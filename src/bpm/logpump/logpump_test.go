@@ -0,0 +1,121 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logpump_test
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/logpump"
+)
+
+// recordingWriter keeps each call to Write separately so that tests can
+// assert on how output was split up.
+type recordingWriter struct {
+	writes []string
+}
+
+func (r *recordingWriter) Write(p []byte) (int, error) {
+	r.writes = append(r.writes, string(p))
+	return len(p), nil
+}
+
+var _ = Describe("LineWriter", func() {
+	var (
+		dst *recordingWriter
+		lw  *logpump.LineWriter
+	)
+
+	BeforeEach(func() {
+		dst = &recordingWriter{}
+		lw = logpump.NewLineWriter(dst)
+	})
+
+	It("holds partial lines until they are complete", func() {
+		_, err := lw.Write([]byte("hello "))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dst.writes).To(BeEmpty())
+
+		_, err = lw.Write([]byte("world\nnext"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dst.writes).To(Equal([]string{"hello world\n"}))
+	})
+
+	It("writes the trailing partial line when closed", func() {
+		_, err := lw.Write([]byte("one\ntwo"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lw.Close()).To(Succeed())
+
+		Expect(dst.writes).To(Equal([]string{"one\n", "two"}))
+	})
+
+	It("does not buffer overly long lines without bound", func() {
+		long := strings.Repeat("x", logpump.MaxLineLength)
+		_, err := lw.Write([]byte(long))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(dst.writes).To(Equal([]string{long}))
+	})
+})
+
+var _ = Describe("PrefixWriter", func() {
+	var (
+		dst *recordingWriter
+		pw  *logpump.PrefixWriter
+	)
+
+	BeforeEach(func() {
+		dst = &recordingWriter{}
+		pw = logpump.NewPrefixWriter(dst, "[web] ")
+	})
+
+	It("prefixes every line in a single write", func() {
+		n, err := pw.Write([]byte("one\ntwo\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(8))
+
+		Expect(dst.writes).To(Equal([]string{"[web] one\n[web] two\n"}))
+	})
+
+	It("does not prefix the continuation of a partial line", func() {
+		_, err := pw.Write([]byte("hello "))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = pw.Write([]byte("world\nnext"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(strings.Join(dst.writes, "")).To(Equal("[web] hello world\n[web] next"))
+	})
+})
+
+var _ = Describe("Pump", func() {
+	It("copies whole lines when line buffered", func() {
+		dst := &recordingWriter{}
+		src := strings.NewReader("one\ntwo\nthree")
+
+		Expect(logpump.Pump(dst, src, true)).To(Succeed())
+		Expect(strings.Join(dst.writes, "")).To(Equal("one\ntwo\nthree"))
+		Expect(dst.writes[len(dst.writes)-1]).To(Equal("three"))
+	})
+
+	It("copies the bytes unchanged when not line buffered", func() {
+		var dst bytes.Buffer
+		Expect(logpump.Pump(&dst, strings.NewReader("partial"), false)).To(Succeed())
+		Expect(dst.String()).To(Equal("partial"))
+	})
+})
@@ -0,0 +1,96 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logpump
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// RotatingWriter writes to a log file and rotates it once it would grow past
+// a size. The current file is renamed to path.1, path.1 to path.2 and so on,
+// keeping at most keep rotated files. A write is never split across files.
+type RotatingWriter struct {
+	f       *os.File
+	maxSize int64
+	keep    int
+	size    int64
+}
+
+// NewRotatingWriter returns a RotatingWriter which starts by appending to f.
+// The path of f is taken from its name so it must have been opened by path.
+func NewRotatingWriter(f *os.File, maxSize int64, keep int) (*RotatingWriter, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RotatingWriter{f: f, maxSize: maxSize, keep: keep, size: fi.Size()}, nil
+}
+
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+func (w *RotatingWriter) rotate() error {
+	path := w.f.Name()
+
+	fi, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	for i := w.keep; i > 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", path, i-1), fmt.Sprintf("%s.%d", path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	// The new file belongs to whoever owned the one it replaces rather than
+	// to the user bpm runs as.
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err := f.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	w.f.Close()
+	w.f = f
+	w.size = 0
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package logpump_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bpm/logpump"
+)
+
+var _ = Describe("RotatingWriter", func() {
+	var (
+		tempDir string
+		path    string
+		rw      *logpump.RotatingWriter
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "rotating-writer")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = filepath.Join(tempDir, "job.stdout.log")
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		Expect(err).NotTo(HaveOccurred())
+
+		rw, err = logpump.NewRotatingWriter(f, 10, 2)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	write := func(s string) {
+		_, err := rw.Write([]byte(s))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	contents := func(path string) string {
+		b, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return string(b)
+	}
+
+	It("writes to the file until it would grow past the maximum size", func() {
+		write("12345\n")
+		write("678\n")
+
+		Expect(contents(path)).To(Equal("12345\n678\n"))
+		Expect(path + ".1").NotTo(BeAnExistingFile())
+	})
+
+	It("moves older output into numbered files", func() {
+		write("first\n")
+		write("second\n")
+		write("third\n")
+
+		Expect(contents(path)).To(Equal("third\n"))
+		Expect(contents(path + ".1")).To(Equal("second\n"))
+		Expect(contents(path + ".2")).To(Equal("first\n"))
+	})
+
+	It("keeps only the configured number of rotated files", func() {
+		write("first\n")
+		write("second\n")
+		write("third\n")
+		write("fourth\n")
+
+		Expect(contents(path + ".1")).To(Equal("third\n"))
+		Expect(contents(path + ".2")).To(Equal("second\n"))
+		Expect(path + ".3").NotTo(BeAnExistingFile())
+	})
+
+	It("does not split a write which is larger than the maximum size", func() {
+		write("this is longer than ten bytes\n")
+
+		Expect(contents(path)).To(Equal("this is longer than ten bytes\n"))
+	})
+})
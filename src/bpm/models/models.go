@@ -15,16 +15,41 @@
 
 package models
 
+import "time"
+
 const (
 	ProcessStateFailed   = "failed"
 	ProcessStateRunning  = "running"
+	ProcessStatePaused   = "paused"
 	ProcessStateStopped  = "stopped"
 	ProcessStateCreating = "creating"
 	ProcessStateCreated  = "created"
+
+	// ProcessStateOOMKilled is shown in place of failed when the process
+	// was killed by the kernel for exceeding its memory limit.
+	ProcessStateOOMKilled = "oom-killed"
 )
 
 type Process struct {
-	Name   string
-	Pid    int
-	Status string
+	Name    string
+	Pid     int
+	Status  string
+	Created time.Time
+
+	// OOMKilled is whether a failed process was killed for running out of
+	// memory.
+	OOMKilled bool
+
+	// Annotations are the annotations of the container of the process.
+	Annotations map[string]string
+}
+
+// DisplayStatus is the status shown to users, which tells a process killed
+// for running out of memory apart from one which failed for another reason.
+func (p *Process) DisplayStatus() string {
+	if p.Status == ProcessStateFailed && p.OOMKilled {
+		return ProcessStateOOMKilled
+	}
+
+	return p.Status
 }
@@ -0,0 +1,221 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package netshape limits the egress bandwidth of processes. Each container
+// is placed in a net_cls class and traffic in that class is shaped by a
+// traffic control (tc) class on the host interface.
+package netshape
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The major number of the root htb qdisc which holds the class of every
+// limited container.
+const major = 0x10
+
+// ClassID returns the net_cls class ID of the container. It is derived from
+// the container ID so that the class is the same across restarts.
+func ClassID(containerID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(containerID))
+
+	// Minor numbers 0 and 0xffff are reserved by tc.
+	minor := h.Sum32()%0xfffe + 1
+
+	return major<<16 | minor
+}
+
+// DefaultInterface returns the name of the interface of the default route.
+func DefaultInterface() (string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return defaultRouteInterface(f)
+}
+
+func defaultRouteInterface(routes io.Reader) (string, error) {
+	scanner := bufio.NewScanner(routes)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] == "Iface" {
+			continue
+		}
+
+		if fields[1] == "00000000" {
+			return fields[0], nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errors.New("no default route found")
+}
+
+// runTC runs tc with args and returns its combined output. It is replaced
+// in tests.
+var runTC = func(args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("tc"); err != nil {
+		return nil, errTCNotInstalled
+	}
+
+	return exec.Command("tc", args...).CombinedOutput()
+}
+
+var errTCNotInstalled = errors.New("tc is not installed")
+
+// root is the handle of the htb qdisc bpm adds as the root of an interface.
+var root = fmt.Sprintf("%x:", major)
+
+// Limit shapes the traffic sent from the net_cls class classID through iface
+// to bytesPerSecond. If iface does not already have a bpm root qdisc then its
+// root qdisc is replaced with one, but only if the root is still the default
+// one the kernel gave the interface. An interface whose root qdisc has been
+// configured by someone else is left alone and an error is returned. Traffic
+// from other classes is not shaped.
+func Limit(iface string, classID uint32, bytesPerSecond uint64) error {
+	kind, handle, err := rootQdisc(iface)
+	if err != nil {
+		return err
+	}
+
+	if !isBPMRoot(kind, handle) {
+		if handle != defaultHandle {
+			return fmt.Errorf("%s already has a %s root qdisc with handle %s which bpm does not manage", iface, kind, handle)
+		}
+
+		if out, err := runTC("qdisc", "replace", "dev", iface, "root", "handle", root, "htb"); err != nil {
+			return tcError("qdisc replace", out, err)
+		}
+	}
+
+	rate := fmt.Sprintf("%dbps", bytesPerSecond)
+	if out, err := runTC("class", "replace", "dev", iface, "parent", root, "classid", classHandle(classID), "htb", "rate", rate); err != nil {
+		return tcError("class replace", out, err)
+	}
+
+	if out, err := runTC("filter", "replace", "dev", iface, "parent", root, "protocol", "all", "prio", "10", "handle", "1:", "cgroup"); err != nil {
+		return tcError("filter replace", out, err)
+	}
+
+	return nil
+}
+
+// Unlimit removes the class of classID from iface once the container is
+// gone. When it was the last bpm class the bpm root qdisc is deleted as well,
+// which gives the interface back its default root qdisc. Nothing is done if
+// tc is not installed or iface does not have a bpm root qdisc.
+func Unlimit(iface string, classID uint32) error {
+	kind, handle, err := rootQdisc(iface)
+	if err == errTCNotInstalled {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !isBPMRoot(kind, handle) {
+		return nil
+	}
+
+	class := classHandle(classID)
+	classes, err := runTC("class", "show", "dev", iface, "parent", root)
+	if err != nil {
+		return tcError("class show", classes, err)
+	}
+
+	if hasClass(string(classes), class) {
+		if out, err := runTC("class", "del", "dev", iface, "classid", class); err != nil {
+			return tcError("class del", out, err)
+		}
+
+		classes, err = runTC("class", "show", "dev", iface, "parent", root)
+		if err != nil {
+			return tcError("class show", classes, err)
+		}
+	}
+
+	if strings.TrimSpace(string(classes)) != "" {
+		return nil
+	}
+
+	if out, err := runTC("qdisc", "del", "dev", iface, "root"); err != nil {
+		return tcError("qdisc del", out, err)
+	}
+
+	return nil
+}
+
+// defaultHandle is the handle of the root qdisc the kernel gives every
+// interface until someone configures another one.
+const defaultHandle = "0:"
+
+// rootQdisc returns the kind and handle of the root qdisc of iface.
+func rootQdisc(iface string) (string, string, error) {
+	out, err := runTC("qdisc", "show", "dev", iface)
+	if err == errTCNotInstalled {
+		return "", "", err
+	}
+	if err != nil {
+		return "", "", tcError("qdisc show", out, err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		// e.g. "qdisc fq_codel 0: root refcnt 2 limit 10240p ..."
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && fields[0] == "qdisc" && fields[3] == "root" {
+			return fields[1], fields[2], nil
+		}
+	}
+
+	return "", "", fmt.Errorf("%s has no root qdisc", iface)
+}
+
+func isBPMRoot(kind, handle string) bool {
+	return kind == "htb" && handle == root
+}
+
+// hasClass reports whether the output of tc class show lists class.
+func hasClass(classes, class string) bool {
+	for _, line := range strings.Split(classes, "\n") {
+		// e.g. "class htb 10:2a root prio 0 rate 1Mbit ..."
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "class" && fields[2] == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+func classHandle(classID uint32) string {
+	return fmt.Sprintf("%x:%x", classID>>16, classID&0xffff)
+}
+
+func tcError(command string, output []byte, err error) error {
+	return fmt.Errorf("tc %s failed: %s: %s", command, err, strings.TrimSpace(string(output)))
+}
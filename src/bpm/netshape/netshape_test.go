@@ -0,0 +1,154 @@
+// Copyright (C) 2020-Present CloudFoundry.org Foundation, Inc. All rights reserved.
+//
+// This program and the accompanying materials are made available under
+// the terms of the under the Apache License, Version 2.0 (the "License”);
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package netshape
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Netshape", func() {
+	Describe("ClassID", func() {
+		It("is stable for a container", func() {
+			Expect(ClassID("example.server")).To(Equal(ClassID("example.server")))
+			Expect(ClassID("example.server")).NotTo(Equal(ClassID("example.worker")))
+		})
+
+		It("is in the bpm root class with a usable minor number", func() {
+			for _, id := range []string{"a", "b", "example.server", "example.worker"} {
+				classID := ClassID(id)
+				Expect(classID >> 16).To(BeEquivalentTo(major))
+				Expect(classID & 0xffff).To(BeNumerically(">", 0))
+				Expect(classID & 0xffff).To(BeNumerically("<", 0xffff))
+			}
+		})
+	})
+
+	Describe("finding the default route interface", func() {
+		It("returns the interface with the default destination", func() {
+			routes := strings.NewReader(`Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth1	0000FEA9	00000000	0001	0	0	0	0000FFFF	0	0	0
+eth0	00000000	0100000A	0003	0	0	0	00000000	0	0	0
+`)
+			iface, err := defaultRouteInterface(routes)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(iface).To(Equal("eth0"))
+		})
+
+		It("returns an error when there is no default route", func() {
+			routes := strings.NewReader(`Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth1	0000FEA9	00000000	0001	0	0	0	0000FFFF	0	0	0
+`)
+			_, err := defaultRouteInterface(routes)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("shaping with tc", func() {
+		var (
+			commands []string
+			outputs  map[string][]string
+			original func(...string) ([]byte, error)
+		)
+
+		classID := uint32(major<<16 | 0x2a)
+
+		BeforeEach(func() {
+			commands = nil
+			outputs = map[string][]string{}
+
+			original = runTC
+			runTC = func(args ...string) ([]byte, error) {
+				command := strings.Join(args, " ")
+				commands = append(commands, command)
+
+				// Each command returns its queued outputs in turn.
+				queued := outputs[command]
+				if len(queued) == 0 {
+					return nil, nil
+				}
+				outputs[command] = queued[1:]
+				return []byte(queued[0]), nil
+			}
+		})
+
+		AfterEach(func() {
+			runTC = original
+		})
+
+		Describe("Limit", func() {
+			It("replaces the default root qdisc of the interface", func() {
+				outputs["qdisc show dev eth0"] = []string{"qdisc fq_codel 0: root refcnt 2 limit 10240p\n"}
+
+				Expect(Limit("eth0", classID, 1000)).To(Succeed())
+				Expect(commands).To(Equal([]string{
+					"qdisc show dev eth0",
+					"qdisc replace dev eth0 root handle 10: htb",
+					"class replace dev eth0 parent 10: classid 10:2a htb rate 1000bps",
+					"filter replace dev eth0 parent 10: protocol all prio 10 handle 1: cgroup",
+				}))
+			})
+
+			It("reuses the bpm root qdisc", func() {
+				outputs["qdisc show dev eth0"] = []string{"qdisc htb 10: root refcnt 2 r2q 10 default 0\n"}
+
+				Expect(Limit("eth0", classID, 1000)).To(Succeed())
+				Expect(commands).NotTo(ContainElement(HavePrefix("qdisc replace")))
+			})
+
+			It("refuses to replace a root qdisc configured by someone else", func() {
+				outputs["qdisc show dev eth0"] = []string{"qdisc htb 1: root refcnt 2 r2q 10 default 0\n"}
+
+				Expect(Limit("eth0", classID, 1000)).To(MatchError(ContainSubstring("bpm does not manage")))
+				Expect(commands).To(Equal([]string{"qdisc show dev eth0"}))
+			})
+		})
+
+		Describe("Unlimit", func() {
+			BeforeEach(func() {
+				outputs["qdisc show dev eth0"] = []string{"qdisc htb 10: root refcnt 2 r2q 10 default 0\n"}
+			})
+
+			It("deletes the class and keeps the root while other classes remain", func() {
+				outputs["class show dev eth0 parent 10:"] = []string{
+					"class htb 10:2a root prio 0 rate 1Kbit\nclass htb 10:2b root prio 0 rate 1Kbit\n",
+					"class htb 10:2b root prio 0 rate 1Kbit\n",
+				}
+
+				Expect(Unlimit("eth0", classID)).To(Succeed())
+				Expect(commands).To(ContainElement("class del dev eth0 classid 10:2a"))
+				Expect(commands).NotTo(ContainElement("qdisc del dev eth0 root"))
+			})
+
+			It("deletes the root once the last class is gone", func() {
+				outputs["class show dev eth0 parent 10:"] = []string{"class htb 10:2a root prio 0 rate 1Kbit\n", ""}
+
+				Expect(Unlimit("eth0", classID)).To(Succeed())
+				Expect(commands).To(ContainElement("class del dev eth0 classid 10:2a"))
+				Expect(commands[len(commands)-1]).To(Equal("qdisc del dev eth0 root"))
+			})
+
+			It("leaves an interface without a bpm root qdisc alone", func() {
+				outputs["qdisc show dev eth0"] = []string{"qdisc mq 0: root\n"}
+
+				Expect(Unlimit("eth0", classID)).To(Succeed())
+				Expect(commands).To(Equal([]string{"qdisc show dev eth0"}))
+			})
+		})
+	})
+})
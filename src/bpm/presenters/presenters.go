@@ -16,11 +16,17 @@
 package presenters
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
 
 	"bpm/jobid"
 	"bpm/models"
@@ -29,24 +35,304 @@ import (
 func PrintJobs(processes []*models.Process, stdout io.Writer) error {
 	tw := tabwriter.NewWriter(stdout, 0, 0, 1, ' ', 0)
 
-	printRow(tw, "Name", "Pid", "Status")
+	printRow(tw, "Name", "Pid", "Status", "Uptime")
 	for _, process := range processes {
-		name, err := jobid.Decode(process.Name)
-		if err != nil {
-			return err
-		}
+		name := displayName(process.Name)
 
 		pid := "-"
 		if process.Pid > 0 {
 			pid = strconv.Itoa(process.Pid)
 		}
 
-		printRow(tw, name, pid, process.Status)
+		uptime := "-"
+		if isUp(process) {
+			uptime = time.Since(process.Created).Round(time.Second).String()
+		}
+
+		printRow(tw, name, pid, process.DisplayStatus(), uptime)
 	}
 
 	return tw.Flush()
 }
 
+type jsonProcess struct {
+	Name          string            `json:"name"`
+	Pid           int               `json:"pid"`
+	Status        string            `json:"status"`
+	Created       *time.Time        `json:"created,omitempty"`
+	UptimeSeconds *int64            `json:"uptime_seconds,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+func PrintJobsJSON(processes []*models.Process, stdout io.Writer) error {
+	jps := []jsonProcess{}
+	for _, process := range processes {
+		name := displayName(process.Name)
+
+		jp := jsonProcess{
+			Name:        name,
+			Pid:         process.Pid,
+			Status:      process.DisplayStatus(),
+			Annotations: process.Annotations,
+		}
+
+		if !process.Created.IsZero() {
+			created := process.Created
+			jp.Created = &created
+		}
+
+		if isUp(process) {
+			uptime := int64(time.Since(process.Created) / time.Second)
+			jp.UptimeSeconds = &uptime
+		}
+
+		jps = append(jps, jp)
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(jps)
+}
+
+// TemplateProcess is what a template given to PrintJobsTemplate is evaluated
+// against for each process. Uptime is zero unless the process is running.
+type TemplateProcess struct {
+	Name    string
+	Pid     int
+	Status  string
+	Created time.Time
+	Uptime  time.Duration
+}
+
+// PrintJobsTemplate evaluates tmpl once per process and writes each result on
+// a line of its own.
+func PrintJobsTemplate(processes []*models.Process, tmpl *template.Template, stdout io.Writer) error {
+	for _, process := range processes {
+		name := displayName(process.Name)
+
+		tp := TemplateProcess{
+			Name:    name,
+			Pid:     process.Pid,
+			Status:  process.DisplayStatus(),
+			Created: process.Created,
+		}
+
+		if isUp(process) {
+			tp.Uptime = time.Since(process.Created).Round(time.Second)
+		}
+
+		if err := tmpl.Execute(stdout, tp); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	return nil
+}
+
+// TopProcess is the resource usage of a running process shown by bpm top.
+type TopProcess struct {
+	Name        string
+	Pid         int
+	CPUPercent  *float64
+	Memory      uint64
+	MemoryLimit uint64
+	Pids        uint64
+}
+
+// PrintTop prints the usage of the processes in a table, the busiest first.
+// The CPU usage of a process which has only been sampled once is not known
+// yet and is shown as a dash.
+func PrintTop(processes []TopProcess, stdout io.Writer) error {
+	sorted := make([]TopProcess, len(processes))
+	copy(sorted, processes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := cpuPercent(sorted[i]), cpuPercent(sorted[j])
+		if a != b {
+			return a > b
+		}
+		return sorted[i].Memory > sorted[j].Memory
+	})
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 1, ' ', 0)
+
+	printRow(tw, "Name", "Pid", "CPU%", "Memory", "Limit", "Tasks")
+	for _, process := range sorted {
+		name := displayName(process.Name)
+
+		cpu := "-"
+		if process.CPUPercent != nil {
+			cpu = strconv.FormatFloat(*process.CPUPercent, 'f', 1, 64)
+		}
+
+		limit := "-"
+		if process.MemoryLimit > 0 && process.MemoryLimit < noMemoryLimit {
+			limit = bytefmt.ByteSize(process.MemoryLimit)
+		}
+
+		printRow(tw, name, strconv.Itoa(process.Pid), cpu, bytefmt.ByteSize(process.Memory), limit, strconv.FormatUint(process.Pids, 10))
+	}
+
+	return tw.Flush()
+}
+
+// MetricsProcess is a process of a job as exported by bpm metrics. The
+// resource usage is only known while it is running. Replica is the index of
+// the replica for a process which has replicas.
+type MetricsProcess struct {
+	Job     string
+	Process string
+	Replica *int
+	Running bool
+
+	Memory      uint64
+	MemoryLimit uint64
+	CPUTotal    uint64
+	Pids        uint64
+}
+
+// metricFamily is one metric of the OpenMetrics exposition and the value it
+// has for a process, if any. Only metrics which are always written have a
+// value for a process which is not running.
+type metricFamily struct {
+	name   string
+	kind   string
+	unit   string
+	help   string
+	always bool
+	value  func(MetricsProcess) (string, bool)
+}
+
+var metricFamilies = []metricFamily{
+	{
+		name:   "bpm_up",
+		kind:   "gauge",
+		help:   "Whether the process is running.",
+		always: true,
+		value: func(p MetricsProcess) (string, bool) {
+			if p.Running {
+				return "1", true
+			}
+			return "0", true
+		},
+	},
+	{
+		name: "bpm_memory_usage_bytes",
+		kind: "gauge",
+		unit: "bytes",
+		help: "Memory used by the container of the process.",
+		value: func(p MetricsProcess) (string, bool) {
+			return strconv.FormatUint(p.Memory, 10), true
+		},
+	},
+	{
+		name: "bpm_memory_limit_bytes",
+		kind: "gauge",
+		unit: "bytes",
+		help: "Memory limit of the container of the process.",
+		value: func(p MetricsProcess) (string, bool) {
+			return strconv.FormatUint(p.MemoryLimit, 10), p.MemoryLimit > 0 && p.MemoryLimit < noMemoryLimit
+		},
+	},
+	{
+		name: "bpm_cpu_seconds",
+		kind: "counter",
+		unit: "seconds",
+		help: "CPU time used by the container of the process since it started.",
+		value: func(p MetricsProcess) (string, bool) {
+			return strconv.FormatFloat(float64(p.CPUTotal)/float64(time.Second), 'f', -1, 64), true
+		},
+	},
+	{
+		name: "bpm_tasks",
+		kind: "gauge",
+		help: "Number of tasks in the container of the process.",
+		value: func(p MetricsProcess) (string, bool) {
+			return strconv.FormatUint(p.Pids, 10), true
+		},
+	},
+}
+
+// PrintMetrics writes the resource usage of the processes in the OpenMetrics
+// text format. Every sample is labelled with the job and process, and with
+// the replica index for the replicas of a process. bpm_up is written for
+// every process and the usage only for those which are running.
+func PrintMetrics(processes []MetricsProcess, stdout io.Writer) error {
+	var b strings.Builder
+	for _, family := range metricFamilies {
+		fmt.Fprintf(&b, "# TYPE %s %s\n", family.name, family.kind)
+		if family.unit != "" {
+			fmt.Fprintf(&b, "# UNIT %s %s\n", family.name, family.unit)
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", family.name, family.help)
+
+		sample := family.name
+		if family.kind == "counter" {
+			sample += "_total"
+		}
+
+		for _, process := range processes {
+			if !process.Running && !family.always {
+				continue
+			}
+
+			value, ok := family.value(process)
+			if !ok {
+				continue
+			}
+
+			fmt.Fprintf(&b, "%s{%s} %s\n", sample, metricLabels(process), value)
+		}
+	}
+	b.WriteString("# EOF\n")
+
+	_, err := io.WriteString(stdout, b.String())
+	return err
+}
+
+func metricLabels(process MetricsProcess) string {
+	labels := fmt.Sprintf(`job="%s",process="%s"`, escapeLabelValue(process.Job), escapeLabelValue(process.Process))
+	if process.Replica != nil {
+		labels += fmt.Sprintf(`,replica="%d"`, *process.Replica)
+	}
+
+	return labels
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// noMemoryLimit is about the limit the memory cgroup reports for a container
+// which has none, which is the largest page aligned int64.
+const noMemoryLimit = 1 << 62
+
+func cpuPercent(process TopProcess) float64 {
+	if process.CPUPercent == nil {
+		return -1
+	}
+
+	return *process.CPUPercent
+}
+
+// displayName is the job and process name encoded in the container ID. A
+// container given its own ID with --container-id does not encode one and is
+// shown by its ID instead.
+func displayName(containerID string) string {
+	name, err := jobid.Decode(containerID)
+	if err != nil {
+		return containerID
+	}
+
+	return name
+}
+
+func isUp(process *models.Process) bool {
+	return process.Status == models.ProcessStateRunning && !process.Created.IsZero()
+}
+
 func printRow(w io.Writer, args ...string) {
 	row := strings.Join(args, "\t")
 	fmt.Fprintf(w, "%s\n", row)
@@ -16,7 +16,10 @@
 package presenters_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"text/template"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -37,7 +40,7 @@ var _ = Describe("Presenters", func() {
 		BeforeEach(func() {
 			processes = []*models.Process{
 				{Name: jobid.Encode("job-process-2"), Pid: 23456, Status: "created"},
-				{Name: jobid.Encode("job-process-1"), Pid: 34567, Status: "running"},
+				{Name: jobid.Encode("job-process-1"), Pid: 34567, Status: "running", Created: time.Now().Add(-90 * time.Second)},
 				{Name: jobid.Encode("job-process-3"), Pid: 0, Status: "failed"},
 			}
 
@@ -46,10 +49,170 @@ var _ = Describe("Presenters", func() {
 
 		It("prints the jobs in a table", func() {
 			Expect(presenters.PrintJobs(processes, output)).To(Succeed())
-			Expect(output).Should(gbytes.Say("Name\\s+Pid\\s+Status"))
-			Expect(output).Should(gbytes.Say(fmt.Sprintf("%s\\s+%d\\s+%s", "job-process-2", 23456, "created")))
-			Expect(output).Should(gbytes.Say(fmt.Sprintf("%s\\s+%d\\s+%s", "job-process-1", 34567, "running")))
-			Expect(output).Should(gbytes.Say(fmt.Sprintf("%s\\s+%s\\s+%s", "job-process-3", "-", "failed")))
+			Expect(output).Should(gbytes.Say("Name\\s+Pid\\s+Status\\s+Uptime"))
+			Expect(output).Should(gbytes.Say(fmt.Sprintf("%s\\s+%d\\s+%s\\s+%s", "job-process-2", 23456, "created", "-")))
+			Expect(output).Should(gbytes.Say(fmt.Sprintf("%s\\s+%d\\s+%s\\s+%s", "job-process-1", 34567, "running", "1m30s")))
+			Expect(output).Should(gbytes.Say(fmt.Sprintf("%s\\s+%s\\s+%s\\s+%s", "job-process-3", "-", "failed", "-")))
+		})
+
+		It("shows a container with a custom id by its id", func() {
+			processes = append(processes, &models.Process{Name: "external-foo", Pid: 45678, Status: "running"})
+
+			Expect(presenters.PrintJobs(processes, output)).To(Succeed())
+			Expect(output).Should(gbytes.Say(fmt.Sprintf("%s\\s+%d\\s+%s", "external-foo", 45678, "running")))
+		})
+	})
+
+	Describe("PrintJobsJSON", func() {
+		It("prints the jobs as JSON", func() {
+			created := time.Now().Add(-90 * time.Second)
+			processes := []*models.Process{
+				{Name: jobid.Encode("job-process-1"), Pid: 34567, Status: "running", Created: created},
+				{Name: jobid.Encode("job-process-3"), Pid: 0, Status: "failed"},
+			}
+
+			output := gbytes.NewBuffer()
+			Expect(presenters.PrintJobsJSON(processes, output)).To(Succeed())
+
+			var jobs []map[string]interface{}
+			Expect(json.Unmarshal(output.Contents(), &jobs)).To(Succeed())
+			Expect(jobs).To(HaveLen(2))
+
+			Expect(jobs[0]).To(HaveKeyWithValue("name", "job-process-1"))
+			Expect(jobs[0]).To(HaveKeyWithValue("pid", BeNumerically("==", 34567)))
+			Expect(jobs[0]).To(HaveKeyWithValue("status", "running"))
+			Expect(jobs[0]).To(HaveKeyWithValue("created", created.Format(time.RFC3339Nano)))
+			Expect(jobs[0]).To(HaveKeyWithValue("uptime_seconds", BeNumerically("~", 90, 1)))
+
+			Expect(jobs[1]).To(HaveKeyWithValue("name", "job-process-3"))
+			Expect(jobs[1]).NotTo(HaveKey("created"))
+			Expect(jobs[1]).NotTo(HaveKey("uptime_seconds"))
+		})
+
+		It("includes the annotations of the containers", func() {
+			processes := []*models.Process{
+				{
+					Name:        jobid.Encode("job-process-1"),
+					Pid:         34567,
+					Status:      "running",
+					Annotations: map[string]string{"org.cloudfoundry.bosh.deployment": "cf"},
+				},
+				{Name: jobid.Encode("job-process-2"), Pid: 0, Status: "stopped"},
+			}
+
+			output := gbytes.NewBuffer()
+			Expect(presenters.PrintJobsJSON(processes, output)).To(Succeed())
+
+			var jobs []map[string]interface{}
+			Expect(json.Unmarshal(output.Contents(), &jobs)).To(Succeed())
+			Expect(jobs).To(HaveLen(2))
+			Expect(jobs[0]).To(HaveKeyWithValue("annotations", map[string]interface{}{
+				"org.cloudfoundry.bosh.deployment": "cf",
+			}))
+			Expect(jobs[1]).NotTo(HaveKey("annotations"))
+		})
+
+		It("shows a job killed for running out of memory as oom-killed", func() {
+			processes := []*models.Process{
+				{Name: jobid.Encode("job-process-1"), Pid: 0, Status: "failed", OOMKilled: true},
+			}
+
+			output := gbytes.NewBuffer()
+			Expect(presenters.PrintJobsJSON(processes, output)).To(Succeed())
+
+			var jobs []map[string]interface{}
+			Expect(json.Unmarshal(output.Contents(), &jobs)).To(Succeed())
+			Expect(jobs).To(HaveLen(1))
+			Expect(jobs[0]).To(HaveKeyWithValue("status", "oom-killed"))
+		})
+	})
+
+	Describe("PrintTop", func() {
+		It("prints the busiest processes first", func() {
+			idle, busy := 0.5, 42.3
+			processes := []presenters.TopProcess{
+				{Name: jobid.Encode("job-idle"), Pid: 23456, CPUPercent: &idle, Memory: 2 * 1024 * 1024, MemoryLimit: 1 << 63, Pids: 1},
+				{Name: jobid.Encode("job-busy"), Pid: 34567, CPUPercent: &busy, Memory: 512 * 1024 * 1024, MemoryLimit: 1024 * 1024 * 1024, Pids: 7},
+				{Name: jobid.Encode("job-new"), Pid: 45678, Memory: 1024, Pids: 1},
+			}
+
+			output := gbytes.NewBuffer()
+			Expect(presenters.PrintTop(processes, output)).To(Succeed())
+
+			Expect(output).To(gbytes.Say(`Name\s+Pid\s+CPU%\s+Memory\s+Limit\s+Tasks\n`))
+			Expect(output).To(gbytes.Say(`job-busy\s+34567\s+42\.3\s+512M\s+1G\s+7\n`))
+			Expect(output).To(gbytes.Say(`job-idle\s+23456\s+0\.5\s+2M\s+-\s+1\n`))
+			Expect(output).To(gbytes.Say(`job-new\s+45678\s+-\s+1K\s+-\s+1\n`))
+		})
+	})
+
+	Describe("PrintMetrics", func() {
+		It("labels the memory and cpu metrics with the job and process", func() {
+			replica := 1
+			processes := []presenters.MetricsProcess{
+				{Job: "job", Process: "web", Running: true, Memory: 2048, MemoryLimit: 1 << 63, CPUTotal: 1500000000, Pids: 3},
+				{Job: "job", Process: "worker", Replica: &replica, Running: true, Memory: 4096, MemoryLimit: 64 * 1024 * 1024, CPUTotal: 2000000000, Pids: 1},
+				{Job: "other", Process: "other"},
+			}
+
+			output := gbytes.NewBuffer()
+			Expect(presenters.PrintMetrics(processes, output)).To(Succeed())
+
+			Expect(string(output.Contents())).To(Equal(`# TYPE bpm_up gauge
+# HELP bpm_up Whether the process is running.
+bpm_up{job="job",process="web"} 1
+bpm_up{job="job",process="worker",replica="1"} 1
+bpm_up{job="other",process="other"} 0
+# TYPE bpm_memory_usage_bytes gauge
+# UNIT bpm_memory_usage_bytes bytes
+# HELP bpm_memory_usage_bytes Memory used by the container of the process.
+bpm_memory_usage_bytes{job="job",process="web"} 2048
+bpm_memory_usage_bytes{job="job",process="worker",replica="1"} 4096
+# TYPE bpm_memory_limit_bytes gauge
+# UNIT bpm_memory_limit_bytes bytes
+# HELP bpm_memory_limit_bytes Memory limit of the container of the process.
+bpm_memory_limit_bytes{job="job",process="worker",replica="1"} 67108864
+# TYPE bpm_cpu_seconds counter
+# UNIT bpm_cpu_seconds seconds
+# HELP bpm_cpu_seconds CPU time used by the container of the process since it started.
+bpm_cpu_seconds_total{job="job",process="web"} 1.5
+bpm_cpu_seconds_total{job="job",process="worker",replica="1"} 2
+# TYPE bpm_tasks gauge
+# HELP bpm_tasks Number of tasks in the container of the process.
+bpm_tasks{job="job",process="web"} 3
+bpm_tasks{job="job",process="worker",replica="1"} 1
+# EOF
+`))
+		})
+
+		It("escapes the label values", func() {
+			processes := []presenters.MetricsProcess{{Job: `a"b\c`, Process: "p"}}
+
+			output := gbytes.NewBuffer()
+			Expect(presenters.PrintMetrics(processes, output)).To(Succeed())
+			Expect(string(output.Contents())).To(ContainSubstring(`bpm_up{job="a\"b\\c",process="p"} 0`))
+		})
+	})
+
+	Describe("PrintJobsTemplate", func() {
+		It("prints a line per job using the template", func() {
+			processes := []*models.Process{
+				{Name: jobid.Encode("job-process-1"), Pid: 34567, Status: "running", Created: time.Now().Add(-90 * time.Second)},
+				{Name: jobid.Encode("job-process-3"), Pid: 0, Status: "failed"},
+			}
+
+			tmpl := template.Must(template.New("list").Parse("{{.Name}}={{.Pid}} {{.Status}} {{.Uptime}}"))
+
+			output := gbytes.NewBuffer()
+			Expect(presenters.PrintJobsTemplate(processes, tmpl, output)).To(Succeed())
+			Expect(string(output.Contents())).To(Equal("job-process-1=34567 running 1m30s\njob-process-3=0 failed 0s\n"))
+		})
+
+		It("returns an error when the template cannot be evaluated", func() {
+			processes := []*models.Process{{Name: jobid.Encode("job-process-1"), Status: "stopped"}}
+			tmpl := template.Must(template.New("list").Parse("{{.Missing}}"))
+
+			Expect(presenters.PrintJobsTemplate(processes, tmpl, gbytes.NewBuffer())).To(HaveOccurred())
 		})
 	})
 })
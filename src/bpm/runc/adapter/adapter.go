@@ -18,22 +18,30 @@ package adapter
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"code.cloudfoundry.org/bytefmt"
 	"code.cloudfoundry.org/lager"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 
+	"bpm/bosh"
 	"bpm/config"
 	"bpm/hostlock"
+	"bpm/netshape"
 	"bpm/runc/specbuilder"
 	"bpm/sysfeat"
 )
 
 const (
-	resolvConfDir = "/run/resolvconf"
-	defaultLang   = "en_US.UTF-8"
+	resolvConfDir    = "/run/resolvconf"
+	nsswitchConfPath = "/etc/nsswitch.conf"
+	defaultLang      = "en_US.UTF-8"
 )
 
 // GlobFunc is a function which when given a file path pattern returns a list
@@ -47,10 +55,12 @@ type VolumeLocker interface {
 }
 
 type RuncAdapter struct {
-	features   sysfeat.Features
-	glob       GlobFunc
-	shareMount MountShare
-	locker     VolumeLocker
+	features     sysfeat.Features
+	glob         GlobFunc
+	shareMount   MountShare
+	locker       VolumeLocker
+	instanceName string
+	boshSpecFile string
 }
 
 func NewRuncAdapter(features sysfeat.Features, glob GlobFunc, mountSharer MountShare, locker VolumeLocker) *RuncAdapter {
@@ -62,11 +72,26 @@ func NewRuncAdapter(features sysfeat.Features, glob GlobFunc, mountSharer MountS
 	}
 }
 
+// SetInstanceName sets the name of the BOSH instance, which is the hostname
+// of the containers of processes with hostname set to instance.
+func (a *RuncAdapter) SetInstanceName(name string) {
+	a.instanceName = name
+}
+
+// SetBoshSpecFile sets the path of the BOSH spec file which the deployment,
+// instance group, AZ and index of the instance are read from to annotate
+// containers with. Containers are not annotated if it is not set.
+func (a *RuncAdapter) SetBoshSpecFile(path string) {
+	a.boshSpecFile = path
+}
+
 func (a *RuncAdapter) CreateJobPrerequisites(
 	bpmCfg *config.BPMConfig,
 	procCfg *config.ProcessConfig,
 	user specs.User,
 ) (*os.File, *os.File, error) {
+	user = processOwner(procCfg, user)
+
 	err := os.MkdirAll(bpmCfg.PidDir().External(), 0700)
 	if err != nil {
 		return nil, nil, err
@@ -74,13 +99,19 @@ func (a *RuncAdapter) CreateJobPrerequisites(
 
 	var dirsToCreate, pathsToChown []string
 	for _, vol := range procCfg.AdditionalVolumes {
+		if vol.Create {
+			if err := createVolume(vol, user); err != nil {
+				return nil, nil, err
+			}
+		}
+
 		if vol.Shared {
 			if err := a.makeShared(vol); err != nil {
 				return nil, nil, err
 			}
 		}
 
-		if vol.MountOnly {
+		if vol.MountOnly || vol.Create {
 			continue
 		}
 
@@ -98,6 +129,16 @@ func (a *RuncAdapter) CreateJobPrerequisites(
 		pathsToChown = append(pathsToChown, vol.Path)
 	}
 
+	if procCfg.Unsafe != nil {
+		for _, vol := range procCfg.Unsafe.UnrestrictedVolumes {
+			if vol.Create {
+				if err := createVolume(vol, user); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
 	dirsToCreate = append(
 		dirsToCreate,
 		bpmCfg.LogDir().External(),
@@ -109,7 +150,11 @@ func (a *RuncAdapter) CreateJobPrerequisites(
 		dirsToCreate = append(dirsToCreate, bpmCfg.DataDir().External())
 	}
 
-	if procCfg.PersistentDisk {
+	if procCfg.CoreDumps {
+		dirsToCreate = append(dirsToCreate, bpmCfg.CoreDir().External())
+	}
+
+	if procCfg.PersistentDisk.Enabled() {
 		storeDir := bpmCfg.StoreDir().External()
 		storeExists, err := checkDirExists(filepath.Dir(storeDir))
 		if err != nil {
@@ -133,7 +178,45 @@ func (a *RuncAdapter) CreateJobPrerequisites(
 		return nil, nil, err
 	}
 
-	return createLogFiles(bpmCfg, user)
+	if procCfg.ChownExistingLogs() {
+		if err := chownExistingLogs(bpmCfg, procCfg, user); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if procCfg.NsswitchConf != "" {
+		if err := writeNsswitchConf(bpmCfg, procCfg.NsswitchConf); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if procCfg.FifoLogs() {
+		return createLogFifos(bpmCfg, user, procCfg.MergedLogs())
+	}
+
+	return createLogFiles(bpmCfg, procCfg, user)
+}
+
+// processOwner is the host user which owns the files and directories made
+// for the process. A process in a user namespace runs as the root user of the
+// namespace and so as the host user that root is mapped to.
+func processOwner(procCfg *config.ProcessConfig, user specs.User) specs.User {
+	if procCfg.UserNamespace == nil {
+		return user
+	}
+
+	return specs.User{
+		UID: procCfg.UserNamespace.HostUID,
+		GID: procCfg.UserNamespace.HostGID,
+	}
+}
+
+func writeNsswitchConf(bpmCfg *config.BPMConfig, contents string) error {
+	if err := os.MkdirAll(bpmCfg.BundlePath(), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(bpmCfg.NsswitchConfPath(), []byte(contents), 0644)
 }
 
 func (a *RuncAdapter) makeShared(volume config.Volume) error {
@@ -150,6 +233,26 @@ func (a *RuncAdapter) makeShared(volume config.Volume) error {
 	return nil
 }
 
+// createVolume makes the directory of a volume with create set, owned by the
+// user, if it does not exist yet. A directory which already exists is left as
+// it is.
+func createVolume(vol config.Volume, user specs.User) error {
+	if _, err := os.Stat(vol.Path); !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(vol.Path, 0755); err != nil {
+		return err
+	}
+
+	// The mode given to MkdirAll is subject to the umask.
+	if err := os.Chmod(vol.Path, vol.CreateFileMode()); err != nil {
+		return err
+	}
+
+	return os.Chown(vol.Path, int(user.UID), int(user.GID))
+}
+
 func createDirs(dirs []string, user specs.User) error {
 	for _, dir := range dirs {
 		err := createDirFor(dir, int(user.UID), int(user.GID))
@@ -181,9 +284,76 @@ func chownPaths(paths []string, user specs.User) error {
 	return nil
 }
 
-func createLogFiles(bpmCfg *config.BPMConfig, user specs.User) (*os.File, *os.File, error) {
+// chownExistingLogs gives the stdout and stderr log files of the process,
+// and those rotated from them, to the user. Nothing else in the log
+// directory is touched as it belongs to bpm or to the other processes of the
+// job. Only regular files with a single hard link are changed so that a
+// process cannot use a link to be given a file from elsewhere.
+func chownExistingLogs(bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig, user specs.User) error {
+	logs := []string{bpmCfg.StdoutLog(procCfg).External()}
+	if !procCfg.MergedLogs() {
+		logs = append(logs, bpmCfg.StderrLog(procCfg).External())
+	}
+
+	entries, err := ioutil.ReadDir(bpmCfg.LogDir().External())
+	if err != nil {
+		return err
+	}
+
+	for _, log := range logs {
+		paths := []string{log}
+		for _, entry := range entries {
+			if isRotatedLog(filepath.Base(log), entry.Name()) {
+				paths = append(paths, filepath.Join(filepath.Dir(log), entry.Name()))
+			}
+		}
+
+		for _, path := range paths {
+			info, err := os.Lstat(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			stat, ok := info.Sys().(*syscall.Stat_t)
+			if !info.Mode().IsRegular() || !ok || stat.Nlink > 1 {
+				continue
+			}
+
+			if err := os.Lchown(path, int(user.UID), int(user.GID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isRotatedLog reports whether name is the name of log followed by a dot
+// and a number, which is what a log file is rotated to.
+func isRotatedLog(log, name string) bool {
+	suffix := strings.TrimPrefix(name, log+".")
+	if suffix == name || suffix == "" {
+		return false
+	}
+
+	for _, r := range suffix {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// createLogFiles opens the log files the output of the process is written
+// to. When the logs are merged stderr is written to the stdout log file too.
+// Both are opened for appending so that neither overwrites the other.
+func createLogFiles(bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig, user specs.User) (*os.File, *os.File, error) {
 	files := make([]*os.File, 2)
-	paths := []string{bpmCfg.Stdout().External(), bpmCfg.Stderr().External()}
+	paths := logPaths(bpmCfg.StdoutLog(procCfg).External(), bpmCfg.StderrLog(procCfg).External(), procCfg.MergedLogs())
 	for i, path := range paths {
 		f, err := createFileFor(path, int(user.UID), int(user.GID))
 		if err != nil {
@@ -195,6 +365,56 @@ func createLogFiles(bpmCfg *config.BPMConfig, user specs.User) (*os.File, *os.Fi
 	return files[0], files[1], nil
 }
 
+// createLogFifos creates the named pipes the output of the process is written
+// to. They are opened for both reading and writing so that opening them does
+// not wait for a reader and writes do not fail while no reader is attached.
+func createLogFifos(bpmCfg *config.BPMConfig, user specs.User, merged bool) (*os.File, *os.File, error) {
+	files := make([]*os.File, 2)
+	paths := logPaths(bpmCfg.StdoutFifo().External(), bpmCfg.StderrFifo().External(), merged)
+	for i, path := range paths {
+		f, err := createFifoFor(path, int(user.UID), int(user.GID))
+		if err != nil {
+			return nil, nil, err
+		}
+		files[i] = f
+	}
+
+	return files[0], files[1], nil
+}
+
+func logPaths(stdout, stderr string, merged bool) []string {
+	if merged {
+		return []string{stdout, stdout}
+	}
+
+	return []string{stdout, stderr}
+}
+
+func createFifoFor(path string, uid, gid int) (*os.File, error) {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		if err := syscall.Mkfifo(path, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if fi.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s exists and is not a named pipe", path)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chown(path, uid, gid)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
 func createFileFor(path string, uid, gid int) (*os.File, error) {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
@@ -225,9 +445,16 @@ func (a *RuncAdapter) BuildSpec(
 		return specs.Spec{}, err
 	}
 
+	// A process with its own rootfs must not see the host system directories
+	// which are otherwise shared into every container.
+	rootfs := bpmCfg.RootFSPath()
 	ms := newMountDedup(logger)
-	ms.addMounts(systemIdentityMounts(mountResolvConf))
-	ms.addMounts(boshMounts(bpmCfg, procCfg.EphemeralDisk, procCfg.PersistentDisk))
+	if procCfg.RootFS != "" {
+		rootfs = procCfg.RootFS
+	} else {
+		ms.addMounts(systemIdentityMounts(mountResolvConf))
+	}
+	ms.addMounts(boshMounts(bpmCfg, procCfg))
 	ms.addMounts(userProvidedIdentityMounts(bpmCfg, procCfg.AdditionalVolumes))
 	if procCfg.Unsafe != nil && len(procCfg.Unsafe.UnrestrictedVolumes) > 0 {
 		expanded, err := a.globExpandVolumes(procCfg.Unsafe.UnrestrictedVolumes)
@@ -237,24 +464,49 @@ func (a *RuncAdapter) BuildSpec(
 		ms.addMounts(userProvidedIdentityMounts(bpmCfg, expanded))
 	}
 
+	if procCfg.NsswitchConf != "" {
+		ms.addMounts([]specs.Mount{
+			Mount(bpmCfg.NsswitchConfPath(), nsswitchConfPath),
+		})
+	}
+
+	if procCfg.CoreDumps {
+		if dir, ok := coreDumpDir(a.features.CorePattern); ok {
+			ms.addMounts([]specs.Mount{
+				Mount(bpmCfg.CoreDir().External(), dir, AllowWrites()),
+			})
+		} else {
+			logger.Info("core-dumps-not-redirected", lager.Data{"core-pattern": a.features.CorePattern})
+		}
+	}
+
 	wrappedExe, wrappedArgs := wrapWithInit(bpmCfg, procCfg)
 
 	spec := specbuilder.Build(
-		specbuilder.WithRootFilesystem(bpmCfg.RootFSPath()),
+		specbuilder.WithRootFilesystem(rootfs),
 		specbuilder.WithUser(user),
 		specbuilder.WithProcess(
 			wrappedExe,
 			wrappedArgs,
-			processEnvironment(procCfg.Env, bpmCfg),
+			append(append(processEnvironment(procCfg.Env, bpmCfg), listenEnvironment(procCfg)...), cpuEnvironment(procCfg)...),
 			cwd,
 		),
-		specbuilder.WithCapabilities(processCapabilities(procCfg.Capabilities)),
+		specbuilder.WithCapabilities(processCapabilities(procCfg)),
 		specbuilder.WithMounts(ms.mounts()),
 		specbuilder.WithNamespace("ipc"),
 		specbuilder.WithNamespace("mount"),
 		specbuilder.WithNamespace("uts"),
 	)
 
+	if procCfg.ShmSize != "" {
+		shmSize, err := bytefmt.ToBytes(procCfg.ShmSize)
+		if err != nil {
+			return specs.Spec{}, err
+		}
+
+		specbuilder.Apply(spec, specbuilder.WithShmSize(shmSize))
+	}
+
 	if procCfg.Limits != nil {
 		if procCfg.Limits.Memory != nil {
 			memLimit, err := bytefmt.ToBytes(*procCfg.Limits.Memory)
@@ -265,6 +517,40 @@ func (a *RuncAdapter) BuildSpec(
 			specbuilder.Apply(spec, specbuilder.WithMemoryLimit(int64(memLimit), a.features))
 		}
 
+		if procCfg.Limits.MemorySwap != nil {
+			if !a.features.SwapLimitSupported {
+				return specs.Spec{}, errors.New("a memory_swap limit was requested but swap accounting is not supported on this system")
+			}
+
+			swapLimit, err := bytefmt.ToBytes(*procCfg.Limits.MemorySwap)
+			if err != nil {
+				return specs.Spec{}, err
+			}
+
+			specbuilder.Apply(spec, specbuilder.WithMemorySwapLimit(int64(swapLimit)))
+		}
+
+		if procCfg.Limits.KernelMemory != nil {
+			if a.features.KernelMemoryLimitSupported {
+				kernelLimit, err := bytefmt.ToBytes(*procCfg.Limits.KernelMemory)
+				if err != nil {
+					return specs.Spec{}, err
+				}
+
+				specbuilder.Apply(spec, specbuilder.WithKernelMemoryLimit(int64(kernelLimit)))
+			} else {
+				logger.Info("kernel-memory-limit-not-supported-ignoring", lager.Data{"limit": *procCfg.Limits.KernelMemory})
+			}
+		}
+
+		if procCfg.Limits.Cpus != nil {
+			specbuilder.Apply(spec, specbuilder.WithCPUQuota(*procCfg.Limits.Cpus))
+		}
+
+		if procCfg.Limits.Cpuset != nil {
+			specbuilder.Apply(spec, specbuilder.WithCpuset(procCfg.Limits.Cpuset.Cpus, procCfg.Limits.Cpuset.Mems))
+		}
+
 		if procCfg.Limits.Processes != nil {
 			specbuilder.Apply(spec, specbuilder.WithPidLimit(*procCfg.Limits.Processes))
 		}
@@ -272,19 +558,162 @@ func (a *RuncAdapter) BuildSpec(
 		if procCfg.Limits.OpenFiles != nil {
 			specbuilder.Apply(spec, specbuilder.WithOpenFileLimit(*procCfg.Limits.OpenFiles))
 		}
+
+		if procCfg.Limits.MaxFileSize != nil {
+			maxFileSize, err := bytefmt.ToBytes(*procCfg.Limits.MaxFileSize)
+			if err != nil {
+				return specs.Spec{}, err
+			}
+
+			specbuilder.Apply(spec, specbuilder.WithFileSizeLimit(maxFileSize))
+		}
+
+		if procCfg.Limits.NetBandwidth != nil && a.features.NetClsSupported {
+			specbuilder.Apply(spec, specbuilder.WithNetClassID(netshape.ClassID(bpmCfg.ContainerID())))
+		}
+	}
+
+	if procCfg.CoreDumps {
+		specbuilder.Apply(spec, specbuilder.WithCoreDumpLimit(unlimitedCoreDumpSize))
+	}
+
+	if procCfg.SELinuxLabel != "" {
+		if a.features.SELinuxEnabled {
+			specbuilder.Apply(spec, specbuilder.WithSELinuxLabel(procCfg.SELinuxLabel))
+		} else {
+			logger.Info("selinux-not-enabled-ignoring-label", lager.Data{"label": procCfg.SELinuxLabel})
+		}
+	}
+
+	if procCfg.EnableFuse {
+		specbuilder.Apply(spec, specbuilder.WithFuse())
 	}
 
 	if procCfg.Unsafe == nil || !procCfg.Unsafe.HostPidNamespace {
 		specbuilder.Apply(spec, specbuilder.WithNamespace("pid"))
 	}
 
+	if procCfg.NetworkMode.Isolated() {
+		specbuilder.Apply(spec, specbuilder.WithNamespace("network"))
+	}
+
 	if procCfg.Unsafe != nil && procCfg.Unsafe.Privileged {
 		specbuilder.Apply(spec, specbuilder.WithPrivileged())
 	}
 
+	if procCfg.Hostname == config.HostnameInstance {
+		specbuilder.Apply(spec, specbuilder.WithHostname(a.instanceHostname(bpmCfg)))
+	}
+
+	if ns := procCfg.UserNamespace; ns != nil {
+		specbuilder.Apply(spec, specbuilder.WithUserNamespace(ns.HostUID, ns.HostGID, ns.MappingSize()))
+	}
+
+	annotations, err := a.boshAnnotations(logger)
+	if err != nil {
+		return specs.Spec{}, err
+	}
+
+	specbuilder.Apply(spec, specbuilder.WithAnnotations(map[string]string{
+		AnnotationJob:     bpmCfg.JobName(),
+		AnnotationProcess: bpmCfg.ProcName(),
+	}))
+
+	if len(annotations) > 0 {
+		specbuilder.Apply(spec, specbuilder.WithAnnotations(annotations))
+	}
+
 	return *spec, nil
 }
 
+// The annotations which name the job and process a container belongs to.
+// They are set even when the container is given a custom id.
+const (
+	AnnotationJob     = "org.cloudfoundry.bpm.job"
+	AnnotationProcess = "org.cloudfoundry.bpm.process"
+)
+
+// The annotations which containers are given from the BOSH spec.
+const (
+	AnnotationDeployment    = "org.cloudfoundry.bosh.deployment"
+	AnnotationInstanceGroup = "org.cloudfoundry.bosh.instance_group"
+	AnnotationAZ            = "org.cloudfoundry.bosh.az"
+	AnnotationIndex         = "org.cloudfoundry.bosh.index"
+)
+
+// boshAnnotations returns the annotations describing the BOSH instance from
+// the BOSH spec file. Fields which are missing from the spec are left out. A
+// spec file which does not exist, e.g. because the agent has yet to write
+// it, gives no annotations rather than stopping the process from starting.
+func (a *RuncAdapter) boshAnnotations(logger lager.Logger) (map[string]string, error) {
+	if a.boshSpecFile == "" {
+		return nil, nil
+	}
+
+	instance, err := bosh.ReadInstanceSpec(a.boshSpecFile)
+	if os.IsNotExist(err) {
+		logger.Info("bosh-spec-not-found", lager.Data{"path": a.boshSpecFile})
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := map[string]string{}
+	if instance.Deployment != "" {
+		annotations[AnnotationDeployment] = instance.Deployment
+	}
+	if instance.InstanceGroup != "" {
+		annotations[AnnotationInstanceGroup] = instance.InstanceGroup
+	}
+	if instance.AZ != "" {
+		annotations[AnnotationAZ] = instance.AZ
+	}
+	if instance.Index != nil {
+		annotations[AnnotationIndex] = strconv.Itoa(*instance.Index)
+	}
+
+	return annotations, nil
+}
+
+const unlimitedCoreDumpSize = ^uint64(0)
+
+// maxHostnameLength is the longest hostname the kernel accepts.
+const maxHostnameLength = 64
+
+// instanceHostname is the name of the BOSH instance or, if bpm has not been
+// told it, the container ID.
+func (a *RuncAdapter) instanceHostname(bpmCfg *config.BPMConfig) string {
+	name := a.instanceName
+	if name == "" {
+		name = bpmCfg.ContainerID()
+	}
+
+	if len(name) > maxHostnameLength {
+		name = name[:maxHostnameLength]
+	}
+
+	return name
+}
+
+// coreDumpDir returns the directory which the kernel writes core dumps to
+// according to pattern. Core dumps can only be redirected into the core
+// directory of the job when the pattern is an absolute path: core dumps piped
+// to a program are handled on the host and relative patterns are resolved
+// against the working directory of the crashing process.
+func coreDumpDir(pattern string) (string, bool) {
+	if !filepath.IsAbs(pattern) {
+		return "", false
+	}
+
+	dir := filepath.Dir(pattern)
+	if dir == "/" || strings.Contains(dir, "%") {
+		return "", false
+	}
+
+	return dir, true
+}
+
 func wrapWithInit(bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) (string, []string) {
 	exe := bpmCfg.TiniPath().Internal()
 	args := append([]string{"-w", "-s", "--", procCfg.Executable}, procCfg.Args...)
@@ -308,7 +737,7 @@ func systemIdentityMounts(mountResolvConf bool) []specs.Mount {
 	return mounts
 }
 
-func boshMounts(bpmCfg *config.BPMConfig, mountData, mountStore bool) []specs.Mount {
+func boshMounts(bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) []specs.Mount {
 	jobDir := bpmCfg.JobDir()
 	logDir := bpmCfg.LogDir()
 	tmpDir := bpmCfg.TempDir()
@@ -325,14 +754,24 @@ func boshMounts(bpmCfg *config.BPMConfig, mountData, mountStore bool) []specs.Mo
 		Mount(logDir.External(), logDir.Internal(), WithRecursiveBind(), AllowWrites()),
 	}
 
-	if mountData {
+	if procCfg.EphemeralDisk {
 		dataDir := bpmCfg.DataDir()
-		mounts = append(mounts, Mount(dataDir.External(), dataDir.Internal(), WithRecursiveBind(), AllowWrites()))
+		mounts = append(mounts, Mount(
+			dataDir.External(),
+			dataDir.Internal(),
+			WithRecursiveBind(),
+			AllowWrites(),
+			WithOptions(procCfg.DataMountOptions),
+		))
 	}
 
-	if mountStore {
+	if procCfg.PersistentDisk.Enabled() {
 		storeDir := bpmCfg.StoreDir()
-		mounts = append(mounts, Mount(storeDir.External(), storeDir.Internal(), WithRecursiveBind(), AllowWrites()))
+		opts := []MountOption{WithRecursiveBind()}
+		if !procCfg.PersistentDisk.ReadOnly() {
+			opts = append(opts, AllowWrites())
+		}
+		mounts = append(mounts, Mount(storeDir.External(), storeDir.Internal(), opts...))
 	}
 
 	return mounts
@@ -380,8 +819,16 @@ func userProvidedIdentityMounts(bpmCfg *config.BPMConfig, volumes []config.Volum
 func processEnvironment(env map[string]string, cfg *config.BPMConfig) []string {
 	var environ []string
 
-	for k, v := range env {
-		environ = append(environ, fmt.Sprintf("%s=%s", k, v))
+	// The variables are sorted so that the same configuration always gives
+	// the same spec.
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		environ = append(environ, fmt.Sprintf("%s=%s", k, env[k]))
 	}
 
 	if _, ok := env["TMPDIR"]; !ok {
@@ -403,11 +850,83 @@ func processEnvironment(env map[string]string, cfg *config.BPMConfig) []string {
 	return environ
 }
 
-func processCapabilities(caps []string) []string {
+// listenEnvironment describes the listen sockets passed to the process in the
+// variables used by systemd socket activation.
+func listenEnvironment(procCfg *config.ProcessConfig) []string {
+	if len(procCfg.ListenSockets) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(procCfg.ListenSockets))
+	for i, s := range procCfg.ListenSockets {
+		names[i] = s.Name
+		if names[i] == "" {
+			names[i] = "unknown"
+		}
+	}
+
+	environ := []string{
+		fmt.Sprintf("LISTEN_FDS=%d", len(procCfg.ListenSockets)),
+		fmt.Sprintf("LISTEN_FDNAMES=%s", strings.Join(names, ":")),
+	}
+
+	// The process is the first child of tini, which is the first process in
+	// its own pid namespace. Its pid is not known up front when it shares the
+	// pid namespace of the host.
+	if procCfg.Unsafe == nil || !procCfg.Unsafe.HostPidNamespace {
+		environ = append(environ, "LISTEN_PID=2")
+	}
+
+	return environ
+}
+
+// cpuEnvironment tells runtimes which size their thread pools by the number of
+// CPUs on the machine how many of them the process can really use.
+// Variables already set in the environment of the process are left alone.
+func cpuEnvironment(procCfg *config.ProcessConfig) []string {
+	count := procCfg.Limits.CPUCount()
+	if count == 0 {
+		return nil
+	}
+
+	names := []string{"GOMAXPROCS"}
+	if procCfg.Limits.CPUCountEnv != "" && procCfg.Limits.CPUCountEnv != "GOMAXPROCS" {
+		names = append(names, procCfg.Limits.CPUCountEnv)
+	}
+
+	var environ []string
+	for _, name := range names {
+		if _, ok := procCfg.Env[name]; !ok {
+			environ = append(environ, fmt.Sprintf("%s=%d", name, count))
+		}
+	}
+
+	return environ
+}
+
+// defaultRuncCapabilities are the capabilities in the spec runc generates.
+var defaultRuncCapabilities = []string{"CAP_AUDIT_WRITE", "CAP_KILL", "CAP_NET_BIND_SERVICE"}
+
+func processCapabilities(procCfg *config.ProcessConfig) []string {
 	var capsWithPrefix []string
 
+	if procCfg.Unsafe != nil && procCfg.Unsafe.KeepDefaultCapabilities {
+		capsWithPrefix = append(capsWithPrefix, defaultRuncCapabilities...)
+	}
+
+	caps := procCfg.Capabilities
+	if procCfg.EnableFuse {
+		// Mounting a FUSE filesystem without the setuid fusermount helper,
+		// which cannot gain privileges inside the container, needs
+		// SYS_ADMIN.
+		caps = append(caps[:len(caps):len(caps)], "SYS_ADMIN")
+	}
+
 	for _, cap := range caps {
-		capsWithPrefix = append(capsWithPrefix, fmt.Sprintf("CAP_%s", cap))
+		prefixed := fmt.Sprintf("CAP_%s", cap)
+		if !containsString(capsWithPrefix, prefixed) {
+			capsWithPrefix = append(capsWithPrefix, prefixed)
+		}
 	}
 
 	return capsWithPrefix
@@ -428,3 +947,13 @@ func defaultPath(cfg *config.BPMConfig) string {
 	defaultPathTmpl := "%s:/usr/local/bin:/usr/local/sbin:/usr/bin:/usr/sbin:/bin:/sbin:."
 	return fmt.Sprintf(defaultPathTmpl, cfg.JobDir().Join("bin").Internal())
 }
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -30,12 +30,14 @@ import (
 
 	"code.cloudfoundry.org/bytefmt"
 	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/types"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 
 	"bpm/bosh"
 	"bpm/config"
 	"bpm/hostlock"
+	"bpm/netshape"
 	"bpm/runc/specbuilder"
 	"bpm/sysfeat"
 )
@@ -223,6 +225,66 @@ var _ = Describe("RuncAdapter", func() {
 			})
 		})
 
+		Context("when a volume should be created", func() {
+			var createPath string
+
+			BeforeEach(func() {
+				createPath = filepath.Join(systemRoot, "create", "me")
+				procCfg.AdditionalVolumes = append(procCfg.AdditionalVolumes, config.Volume{
+					Path:       createPath,
+					MountOnly:  true,
+					Create:     true,
+					CreateMode: "0750",
+				})
+			})
+
+			It("creates the directory with the mode and owned by the process user", func() {
+				_, _, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				info, err := os.Stat(createPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.IsDir()).To(BeTrue())
+				Expect(info.Mode() & os.ModePerm).To(Equal(os.FileMode(0750)))
+				Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(200)))
+				Expect(info.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(300)))
+			})
+
+			Context("when the directory already exists", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(createPath, 0755)).To(Succeed())
+				})
+
+				It("leaves it as it is", func() {
+					_, _, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					info, err := os.Stat(createPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode() & os.ModePerm).To(Equal(os.FileMode(0755)))
+					Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(0)))
+				})
+			})
+
+			Context("when it is an unrestricted volume", func() {
+				BeforeEach(func() {
+					procCfg.AdditionalVolumes = procCfg.AdditionalVolumes[:len(procCfg.AdditionalVolumes)-1]
+					procCfg.Unsafe = &config.Unsafe{
+						UnrestrictedVolumes: []config.Volume{{Path: createPath, Create: true}},
+					}
+				})
+
+				It("creates the directory with the default mode", func() {
+					_, _, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					info, err := os.Stat(createPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode() & os.ModePerm).To(Equal(os.FileMode(0700)))
+				})
+			})
+		})
+
 		Context("when a volume should be shared", func() {
 			var sharedPath string
 
@@ -284,9 +346,32 @@ var _ = Describe("RuncAdapter", func() {
 			})
 		})
 
+		Context("when a rootfs is provided", func() {
+			BeforeEach(func() {
+				procCfg.RootFS = "/var/vcap/packages/minimal-rootfs"
+			})
+
+			It("uses it as the root of the container instead of the host system directories", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(spec.Root).To(Equal(&specs.Root{
+					Path: "/var/vcap/packages/minimal-rootfs",
+				}))
+
+				var destinations []string
+				for _, m := range spec.Mounts {
+					destinations = append(destinations, m.Destination)
+				}
+				Expect(destinations).NotTo(ContainElement("/usr"))
+				Expect(destinations).NotTo(ContainElement("/etc"))
+				Expect(destinations).To(ContainElement(filepath.Join("/var/vcap/jobs", jobName)))
+			})
+		})
+
 		Context("when the user requests a persistent disk", func() {
 			BeforeEach(func() {
-				procCfg.PersistentDisk = true
+				procCfg.PersistentDisk = config.DiskModeReadWrite
 			})
 
 			It("creates the job prerequisites", func() {
@@ -330,6 +415,230 @@ var _ = Describe("RuncAdapter", func() {
 				Expect(dataDirInfo.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(300)))
 			})
 		})
+
+		Context("when the process runs in a user namespace", func() {
+			BeforeEach(func() {
+				procCfg.UserNamespace = &config.UserNamespace{HostUID: 100000, HostGID: 110000}
+			})
+
+			It("gives the directories and log files to the host user root is mapped to", func() {
+				_, _, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, path := range []string{
+					bpmCfg.LogDir().External(),
+					bpmCfg.TempDir().External(),
+					bpmCfg.Stdout().External(),
+					bpmCfg.Stderr().External(),
+				} {
+					info, err := os.Stat(path)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(100000)))
+					Expect(info.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(110000)))
+				}
+			})
+		})
+
+		Context("when the user requests core dumps", func() {
+			BeforeEach(func() {
+				procCfg.CoreDumps = true
+			})
+
+			It("creates the core directory with the correct permissions", func() {
+				_, _, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				coreDirInfo, err := os.Stat(bpmCfg.CoreDir().External())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(coreDirInfo.Mode() & os.ModePerm).To(Equal(os.FileMode(0700)))
+				Expect(coreDirInfo.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(200)))
+				Expect(coreDirInfo.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(300)))
+			})
+		})
+
+		Context("when the user overrides nsswitch.conf", func() {
+			BeforeEach(func() {
+				procCfg.NsswitchConf = "hosts: files dns\n"
+			})
+
+			It("writes the contents to the bundle of the process", func() {
+				_, _, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, err := ioutil.ReadFile(bpmCfg.NsswitchConfPath())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal("hosts: files dns\n"))
+			})
+		})
+
+		Context("when stderr is merged into stdout", func() {
+			BeforeEach(func() {
+				procCfg.Logs = &config.Logs{MergeStderr: true}
+			})
+
+			It("writes both to the stdout log file", func() {
+				stdout, stderr, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				defer stdout.Close()
+				defer stderr.Close()
+
+				Expect(stdout.Name()).To(Equal(bpmCfg.Stdout().External()))
+				Expect(stderr.Name()).To(Equal(bpmCfg.Stdout().External()))
+
+				_, err = os.Stat(bpmCfg.Stderr().External())
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+
+		Context("when the log files are named", func() {
+			BeforeEach(func() {
+				procCfg.Logs = &config.Logs{StdoutFile: "example.log", StderrFile: "example.err.log"}
+			})
+
+			It("opens the named files in the log directory of the job", func() {
+				stdout, stderr, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				defer stdout.Close()
+				defer stderr.Close()
+
+				Expect(stdout.Name()).To(Equal(bpmCfg.LogDir().Join("example.log").External()))
+				Expect(stderr.Name()).To(Equal(bpmCfg.LogDir().Join("example.err.log").External()))
+
+				_, err = os.Stat(bpmCfg.Stdout().External())
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+
+		Context("when the log directory already has content", func() {
+			var (
+				ownLogs     []string
+				otherFiles  []string
+				outsideFile string
+			)
+
+			BeforeEach(func() {
+				logDir := bpmCfg.LogDir().External()
+				Expect(os.MkdirAll(logDir, 0755)).To(Succeed())
+
+				stdoutLog := bpmCfg.Stdout().External()
+				ownLogs = []string{stdoutLog, stdoutLog + ".1", bpmCfg.Stderr().External()}
+				otherFiles = []string{
+					filepath.Join(logDir, "bpm.log"),
+					filepath.Join(logDir, "other.stdout.log"),
+					bpmCfg.RuncLog().External(),
+					stdoutLog + ".old",
+				}
+				for _, path := range append(ownLogs, otherFiles...) {
+					Expect(ioutil.WriteFile(path, []byte("old"), 0644)).To(Succeed())
+				}
+
+				outsideFile = filepath.Join(systemRoot, "outside")
+				Expect(ioutil.WriteFile(outsideFile, []byte("secret"), 0600)).To(Succeed())
+				Expect(os.Symlink(outsideFile, stdoutLog+".2")).To(Succeed())
+				Expect(os.Link(outsideFile, stdoutLog+".3")).To(Succeed())
+			})
+
+			Context("when chown_existing is set", func() {
+				BeforeEach(func() {
+					procCfg.Logs = &config.Logs{ChownExisting: true}
+				})
+
+				It("gives the log files of the process and their rotated files to the process user", func() {
+					stdout, stderr, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+					defer stdout.Close()
+					defer stderr.Close()
+
+					for _, path := range ownLogs {
+						info, err := os.Stat(path)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(200)), path)
+						Expect(info.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(300)), path)
+					}
+				})
+
+				It("leaves the other files in the log directory alone", func() {
+					stdout, stderr, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+					defer stdout.Close()
+					defer stderr.Close()
+
+					for _, path := range otherFiles {
+						info, err := os.Stat(path)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(0)), path)
+						Expect(info.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(0)), path)
+					}
+				})
+
+				It("does not follow symlinks or change hard-linked files", func() {
+					stdout, stderr, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+					defer stdout.Close()
+					defer stderr.Close()
+
+					info, err := os.Stat(outsideFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(0)))
+					Expect(info.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(0)))
+
+					link, err := os.Lstat(bpmCfg.Stdout().External() + ".2")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(link.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(0)))
+				})
+			})
+
+			It("leaves the existing rotated files alone by default", func() {
+				stdout, stderr, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				defer stdout.Close()
+				defer stderr.Close()
+
+				info, err := os.Stat(bpmCfg.Stdout().External() + ".1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(0)))
+				Expect(info.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(0)))
+			})
+		})
+
+		Context("when the logs are written to named pipes", func() {
+			BeforeEach(func() {
+				procCfg.Logs = &config.Logs{Fifo: true}
+			})
+
+			It("creates named pipes in the job run directory instead of log files", func() {
+				stdout, stderr, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				defer stdout.Close()
+				defer stderr.Close()
+
+				Expect(stdout.Name()).To(Equal(bpmCfg.StdoutFifo().External()))
+				Expect(stderr.Name()).To(Equal(bpmCfg.StderrFifo().External()))
+
+				for _, path := range []string{stdout.Name(), stderr.Name()} {
+					fifoInfo, err := os.Stat(path)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fifoInfo.Mode() & os.ModeNamedPipe).NotTo(BeZero())
+					Expect(fifoInfo.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(200)))
+					Expect(fifoInfo.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(300)))
+				}
+
+				_, err = os.Stat(bpmCfg.Stdout().External())
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+
+			Context("when a regular file is in the way of a named pipe", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(bpmCfg.SocketDir().External(), 0700)).To(Succeed())
+					Expect(ioutil.WriteFile(bpmCfg.StdoutFifo().External(), nil, 0600)).To(Succeed())
+				})
+
+				It("returns an error", func() {
+					_, _, err := runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
+					Expect(err).To(MatchError(ContainSubstring("is not a named pipe")))
+				})
+			})
+		})
 	})
 
 	Describe("BuildSpec", func() {
@@ -345,7 +654,7 @@ var _ = Describe("RuncAdapter", func() {
 					"ONE":  "two",
 				},
 				EphemeralDisk:  true,
-				PersistentDisk: false,
+				PersistentDisk: "",
 				AdditionalVolumes: []config.Volume{
 					{Path: "/path/to/volume/1", Writable: true},
 					{Path: "/path/to/volume/jna-tmp", Writable: true, AllowExecutions: true},
@@ -633,6 +942,83 @@ var _ = Describe("RuncAdapter", func() {
 				Expect(spec.Process.Env).To(ContainElement("PATH=some-path"))
 				Expect(spec.Process.Env).To(ContainElement("HOME=some-home"))
 			})
+
+			It("sorts the variables of the configuration by name", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				var names []string
+				for _, kv := range spec.Process.Env[:len(procCfg.Env)] {
+					names = append(names, strings.SplitN(kv, "=", 2)[0])
+				}
+				Expect(sort.StringsAreSorted(names)).To(BeTrue())
+			})
+		})
+
+		Context("when the process has listen sockets", func() {
+			BeforeEach(func() {
+				procCfg.ListenSockets = []config.ListenSocket{
+					{Name: "http", Address: "127.0.0.1:8080"},
+					{Network: "unix", Address: "/var/vcap/sys/run/example/admin.sock"},
+				}
+			})
+
+			It("describes them in the socket activation environment variables", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Process.Env).To(ContainElement("LISTEN_FDS=2"))
+				Expect(spec.Process.Env).To(ContainElement("LISTEN_FDNAMES=http:unknown"))
+				Expect(spec.Process.Env).To(ContainElement("LISTEN_PID=2"))
+			})
+
+			Context("when the process shares the host pid namespace", func() {
+				BeforeEach(func() {
+					procCfg.Unsafe = &config.Unsafe{HostPidNamespace: true}
+				})
+
+				It("does not set LISTEN_PID", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(spec.Process.Env).To(ContainElement("LISTEN_FDS=2"))
+					Expect(spec.Process.Env).NotTo(ContainElement(HavePrefix("LISTEN_PID=")))
+				})
+			})
+		})
+
+		Context("when the process has no network", func() {
+			BeforeEach(func() {
+				procCfg.NetworkMode = config.NetworkModeNone
+			})
+
+			It("gives it a network namespace of its own", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Linux.Namespaces).To(ContainElement(specs.LinuxNamespace{Type: "network"}))
+			})
+		})
+
+		Context("when the process has an isolated network", func() {
+			BeforeEach(func() {
+				procCfg.NetworkMode = config.NetworkModeIsolated
+			})
+
+			It("gives it a network namespace of its own", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Linux.Namespaces).To(ContainElement(specs.LinuxNamespace{Type: "network"}))
+			})
+		})
+
+		Context("when the process uses the host network", func() {
+			BeforeEach(func() {
+				procCfg.NetworkMode = config.NetworkModeHost
+			})
+
+			It("shares the network namespace of the host", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Linux.Namespaces).NotTo(ContainElement(specs.LinuxNamespace{Type: "network"}))
+			})
 		})
 
 		Context("when a workdir is provided", func() {
@@ -649,7 +1035,7 @@ var _ = Describe("RuncAdapter", func() {
 
 		Context("when the user requests a persistent disk", func() {
 			BeforeEach(func() {
-				procCfg.PersistentDisk = true
+				procCfg.PersistentDisk = config.DiskModeReadWrite
 			})
 
 			It("bind mounts the store directory into the container", func() {
@@ -663,6 +1049,24 @@ var _ = Describe("RuncAdapter", func() {
 					Options:     []string{"nodev", "nosuid", "noexec", "rbind", "rw"},
 				}))
 			})
+
+			Context("and wants it read-only", func() {
+				BeforeEach(func() {
+					procCfg.PersistentDisk = config.DiskModeReadOnly
+				})
+
+				It("bind mounts the store directory without allowing writes", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(spec.Mounts).To(HaveMount(specs.Mount{
+						Destination: filepath.Join("/var/vcap/store", jobName),
+						Type:        "bind",
+						Source:      filepath.Join(systemRoot, "store", "example"),
+						Options:     []string{"nodev", "nosuid", "noexec", "rbind", "ro"},
+					}))
+				})
+			})
 		})
 
 		Context("when the user requests an ephemeral disk", func() {
@@ -681,39 +1085,160 @@ var _ = Describe("RuncAdapter", func() {
 					Options:     []string{"nodev", "nosuid", "noexec", "rbind", "rw"},
 				}))
 			})
+
+			Context("when data mount options are provided", func() {
+				BeforeEach(func() {
+					procCfg.DataMountOptions = []string{"exec", "ro"}
+				})
+
+				It("applies them to the data directory mount", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(spec.Mounts).To(HaveMount(specs.Mount{
+						Destination: filepath.Join("/var/vcap/data", jobName),
+						Type:        "bind",
+						Source:      filepath.Join(systemRoot, "data", jobName),
+						Options:     []string{"nodev", "nosuid", "exec", "rbind", "ro"},
+					}))
+				})
+			})
 		})
 
-		Context("when limits are provided", func() {
+		Context("when the user overrides nsswitch.conf", func() {
 			BeforeEach(func() {
-				procCfg.Limits = &config.Limits{}
+				procCfg.NsswitchConf = "hosts: files dns\n"
 			})
 
-			It("sets no limits by default", func() {
-				_, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+			It("mounts it read-only over the nsswitch.conf of the container", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
 				Expect(err).NotTo(HaveOccurred())
+
+				Expect(spec.Mounts).To(HaveMount(specs.Mount{
+					Destination: "/etc/nsswitch.conf",
+					Type:        "bind",
+					Source:      bpmCfg.NsswitchConfPath(),
+					Options:     []string{"nodev", "nosuid", "noexec", "bind", "ro"},
+				}))
 			})
+		})
 
-			Context("Memory", func() {
-				var expectedMemoryLimit string
+		Context("when an SELinux label is provided", func() {
+			BeforeEach(func() {
+				procCfg.SELinuxLabel = "system_u:system_r:container_t:s0"
+			})
 
+			Context("when SELinux is enabled", func() {
 				BeforeEach(func() {
-					expectedMemoryLimit = "100G"
-					procCfg.Limits.Memory = &expectedMemoryLimit
+					features.SELinuxEnabled = true
 				})
 
-				Context("when the system supports swap", func() {
-					BeforeEach(func() {
-						features.SwapLimitSupported = true
-					})
+				It("labels the process and its mounts", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
 
-					It("sets the memory limit on the container", func() {
-						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
-						Expect(err).NotTo(HaveOccurred())
+					Expect(spec.Process.SelinuxLabel).To(Equal("system_u:system_r:container_t:s0"))
+					Expect(spec.Linux.MountLabel).To(Equal("system_u:system_r:container_t:s0"))
+				})
+			})
 
-						expectedMemoryLimitInBytes, err := bytefmt.ToBytes(expectedMemoryLimit)
-						Expect(err).NotTo(HaveOccurred())
-						signedExpectedMemoryLimitInBytes := int64(expectedMemoryLimitInBytes)
-						Expect(spec.Linux.Resources.Memory).To(Equal(&specs.LinuxMemory{
+			Context("when SELinux is not enabled", func() {
+				BeforeEach(func() {
+					features.SELinuxEnabled = false
+				})
+
+				It("ignores the label and logs a warning", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(spec.Process.SelinuxLabel).To(BeEmpty())
+					Expect(spec.Linux.MountLabel).To(BeEmpty())
+					Expect(logger).To(gbytes.Say("selinux-not-enabled-ignoring-label"))
+				})
+			})
+		})
+
+		Context("when the user requests core dumps", func() {
+			BeforeEach(func() {
+				procCfg.CoreDumps = true
+			})
+
+			It("does not limit the size of core dumps", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(spec.Process.Rlimits).To(ContainElement(specs.POSIXRlimit{
+					Type: "RLIMIT_CORE",
+					Hard: ^uint64(0),
+					Soft: ^uint64(0),
+				}))
+			})
+
+			Context("when the core pattern is an absolute path", func() {
+				BeforeEach(func() {
+					features.CorePattern = "/var/cores/core.%e.%p"
+				})
+
+				It("mounts the core directory where the kernel writes core dumps", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(spec.Mounts).To(HaveMount(specs.Mount{
+						Destination: "/var/cores",
+						Type:        "bind",
+						Source:      filepath.Join(systemRoot, "sys", "log", jobName, "cores"),
+						Options:     []string{"nodev", "nosuid", "noexec", "bind", "rw"},
+					}))
+				})
+			})
+
+			Context("when core dumps are piped to a program", func() {
+				BeforeEach(func() {
+					features.CorePattern = "|/usr/share/apport/apport %p %s %c"
+				})
+
+				It("does not mount the core directory", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					for _, m := range spec.Mounts {
+						Expect(m.Source).NotTo(Equal(bpmCfg.CoreDir().External()))
+					}
+				})
+			})
+		})
+
+		Context("when limits are provided", func() {
+			BeforeEach(func() {
+				procCfg.Limits = &config.Limits{}
+			})
+
+			It("sets no limits by default", func() {
+				_, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			Context("Memory", func() {
+				var expectedMemoryLimit string
+
+				BeforeEach(func() {
+					expectedMemoryLimit = "100G"
+					procCfg.Limits.Memory = &expectedMemoryLimit
+				})
+
+				Context("when the system supports swap", func() {
+					BeforeEach(func() {
+						features.SwapLimitSupported = true
+					})
+
+					It("sets the memory limit on the container", func() {
+						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+						Expect(err).NotTo(HaveOccurred())
+
+						expectedMemoryLimitInBytes, err := bytefmt.ToBytes(expectedMemoryLimit)
+						Expect(err).NotTo(HaveOccurred())
+						signedExpectedMemoryLimitInBytes := int64(expectedMemoryLimitInBytes)
+						Expect(spec.Linux.Resources.Memory).To(Equal(&specs.LinuxMemory{
 							Limit: &signedExpectedMemoryLimitInBytes,
 							Swap:  &signedExpectedMemoryLimitInBytes,
 						}))
@@ -749,6 +1274,183 @@ var _ = Describe("RuncAdapter", func() {
 						Expect(err).To(HaveOccurred())
 					})
 				})
+
+				Context("when a combined memory and swap limit is provided", func() {
+					var expectedMemorySwapLimit string
+
+					BeforeEach(func() {
+						expectedMemorySwapLimit = "150G"
+						procCfg.Limits.MemorySwap = &expectedMemorySwapLimit
+					})
+
+					Context("when the system supports swap", func() {
+						BeforeEach(func() {
+							features.SwapLimitSupported = true
+						})
+
+						It("sets the swap limit to the combined limit", func() {
+							spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+							Expect(err).NotTo(HaveOccurred())
+
+							expectedMemoryLimitInBytes, err := bytefmt.ToBytes(expectedMemoryLimit)
+							Expect(err).NotTo(HaveOccurred())
+							expectedMemorySwapLimitInBytes, err := bytefmt.ToBytes(expectedMemorySwapLimit)
+							Expect(err).NotTo(HaveOccurred())
+							signedExpectedMemoryLimitInBytes := int64(expectedMemoryLimitInBytes)
+							signedExpectedMemorySwapLimitInBytes := int64(expectedMemorySwapLimitInBytes)
+							Expect(spec.Linux.Resources.Memory).To(Equal(&specs.LinuxMemory{
+								Limit: &signedExpectedMemoryLimitInBytes,
+								Swap:  &signedExpectedMemorySwapLimitInBytes,
+							}))
+						})
+					})
+
+					Context("when the system does not support swap", func() {
+						BeforeEach(func() {
+							features.SwapLimitSupported = false
+						})
+
+						It("returns an error", func() {
+							_, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+							Expect(err).To(HaveOccurred())
+						})
+					})
+
+					Context("when the combined limit is invalid", func() {
+						BeforeEach(func() {
+							features.SwapLimitSupported = true
+							memorySwapLimit := "invalid byte value"
+							procCfg.Limits.MemorySwap = &memorySwapLimit
+						})
+
+						It("returns an error", func() {
+							_, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+							Expect(err).To(HaveOccurred())
+						})
+					})
+				})
+			})
+
+			Context("KernelMemory", func() {
+				BeforeEach(func() {
+					kernelMemoryLimit := "64M"
+					procCfg.Limits.KernelMemory = &kernelMemoryLimit
+				})
+
+				Context("when the system supports kernel memory limits", func() {
+					BeforeEach(func() {
+						features.KernelMemoryLimitSupported = true
+					})
+
+					It("sets the kernel memory limit on the container", func() {
+						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+						Expect(err).NotTo(HaveOccurred())
+
+						expectedKernelLimit := int64(64 * 1024 * 1024)
+						Expect(spec.Linux.Resources.Memory).To(Equal(&specs.LinuxMemory{
+							Kernel: &expectedKernelLimit,
+						}))
+					})
+				})
+
+				Context("when the system does not support kernel memory limits", func() {
+					BeforeEach(func() {
+						features.KernelMemoryLimitSupported = false
+					})
+
+					It("warns and leaves the kernel memory unlimited", func() {
+						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(spec.Linux.Resources.Memory).To(BeNil())
+						Expect(logger.LogMessages()).To(ContainElement(HaveSuffix("kernel-memory-limit-not-supported-ignoring")))
+					})
+				})
+			})
+
+			Context("Cpuset", func() {
+				BeforeEach(func() {
+					procCfg.Limits.Cpuset = &config.Cpuset{Cpus: "0-1", Mems: "0"}
+				})
+
+				It("pins the container to the cpus and mems", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(spec.Linux.Resources.CPU).To(Equal(&specs.LinuxCPU{Cpus: "0-1", Mems: "0"}))
+				})
+
+				It("sets GOMAXPROCS to the number of cpus", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(spec.Process.Env).To(ContainElement("GOMAXPROCS=2"))
+				})
+			})
+
+			Context("Cpus", func() {
+				BeforeEach(func() {
+					cpus := 2.0
+					procCfg.Limits.Cpus = &cpus
+				})
+
+				It("sets a CFS quota of that many cpus", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(*spec.Linux.Resources.CPU.Quota).To(Equal(int64(200000)))
+					Expect(*spec.Linux.Resources.CPU.Period).To(Equal(uint64(100000)))
+				})
+
+				It("sets GOMAXPROCS to the number of cpus", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(spec.Process.Env).To(ContainElement("GOMAXPROCS=2"))
+				})
+
+				Context("and a cpu_count_env is given", func() {
+					BeforeEach(func() {
+						procCfg.Limits.CPUCountEnv = "JVM_ACTIVE_PROCESSORS"
+					})
+
+					It("sets it as well", func() {
+						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(spec.Process.Env).To(ContainElement("GOMAXPROCS=2"))
+						Expect(spec.Process.Env).To(ContainElement("JVM_ACTIVE_PROCESSORS=2"))
+					})
+				})
+
+				Context("and the process sets GOMAXPROCS itself", func() {
+					BeforeEach(func() {
+						procCfg.Env = map[string]string{"GOMAXPROCS": "8"}
+					})
+
+					It("keeps its value", func() {
+						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(spec.Process.Env).To(ContainElement("GOMAXPROCS=8"))
+						Expect(spec.Process.Env).NotTo(ContainElement("GOMAXPROCS=2"))
+					})
+				})
+
+				Context("and a smaller cpuset", func() {
+					BeforeEach(func() {
+						procCfg.Limits.Cpuset = &config.Cpuset{Cpus: "3"}
+					})
+
+					It("keeps both limits and uses the smaller count", func() {
+						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(spec.Linux.Resources.CPU.Cpus).To(Equal("3"))
+						Expect(*spec.Linux.Resources.CPU.Quota).To(Equal(int64(200000)))
+						Expect(spec.Process.Env).To(ContainElement("GOMAXPROCS=1"))
+					})
+				})
 			})
 
 			Context("OpenFiles", func() {
@@ -773,6 +1475,26 @@ var _ = Describe("RuncAdapter", func() {
 				})
 			})
 
+			Context("MaxFileSize", func() {
+				BeforeEach(func() {
+					maxFileSize := "1M"
+					procCfg.Limits.MaxFileSize = &maxFileSize
+				})
+
+				It("sets the rlimit on the process", func() {
+					spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(spec.Process.Rlimits).To(ConsistOf([]specs.POSIXRlimit{
+						{
+							Type: "RLIMIT_FSIZE",
+							Hard: 1024 * 1024,
+							Soft: 1024 * 1024,
+						},
+					}))
+				})
+			})
+
 			Context("Pids", func() {
 				var pidLimit int64
 
@@ -793,6 +1515,41 @@ var _ = Describe("RuncAdapter", func() {
 					}))
 				})
 			})
+
+			Context("NetBandwidth", func() {
+				BeforeEach(func() {
+					netBandwidth := "1M"
+					procCfg.Limits.NetBandwidth = &netBandwidth
+				})
+
+				Context("when the system supports net_cls", func() {
+					BeforeEach(func() {
+						features.NetClsSupported = true
+					})
+
+					It("places the container in its net_cls class", func() {
+						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+						Expect(err).NotTo(HaveOccurred())
+
+						classID := netshape.ClassID(bpmCfg.ContainerID())
+						Expect(spec.Linux.Resources.Network).To(Equal(&specs.LinuxNetwork{
+							ClassID: &classID,
+						}))
+					})
+				})
+
+				Context("when the system does not support net_cls", func() {
+					BeforeEach(func() {
+						features.NetClsSupported = false
+					})
+
+					It("does not set a net_cls class", func() {
+						spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(spec.Linux.Resources.Network).To(BeNil())
+					})
+				})
+			})
 		})
 
 		Context("when the limits configuration is not provided", func() {
@@ -807,6 +1564,222 @@ var _ = Describe("RuncAdapter", func() {
 			})
 		})
 
+		Context("when a shm size is provided", func() {
+			BeforeEach(func() {
+				procCfg.ShmSize = "1G"
+			})
+
+			It("sets the size of the /dev/shm mount", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(spec.Mounts).To(HaveMount(specs.Mount{
+					Destination: "/dev/shm",
+					Type:        "tmpfs",
+					Source:      "shm",
+					Options:     []string{"nosuid", "noexec", "nodev", "mode=1777", "size=1073741824"},
+				}))
+			})
+
+			Context("when the shm size is invalid", func() {
+				BeforeEach(func() {
+					procCfg.ShmSize = "huge"
+				})
+
+				It("returns an error", func() {
+					_, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+					Expect(err).To(HaveOccurred())
+				})
+			})
+		})
+
+		Context("when the user enables FUSE", func() {
+			BeforeEach(func() {
+				procCfg.Capabilities = []string{"TAIN"}
+				procCfg.EnableFuse = true
+			})
+
+			It("gives the process the FUSE device and lets it mount filesystems", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(spec.Process.Capabilities.Ambient).To(Equal([]string{"CAP_TAIN", "CAP_SYS_ADMIN"}))
+				Expect(spec.Process.Capabilities.Bounding).To(Equal([]string{"CAP_TAIN", "CAP_SYS_ADMIN"}))
+				Expect(procCfg.Capabilities).To(Equal([]string{"TAIN"}))
+
+				mode := os.FileMode(0666)
+				root := uint32(0)
+				major, minor := int64(10), int64(229)
+				Expect(spec.Linux.Devices).To(ConsistOf(specs.LinuxDevice{
+					Path:     "/dev/fuse",
+					Type:     "c",
+					Major:    major,
+					Minor:    minor,
+					FileMode: &mode,
+					UID:      &root,
+					GID:      &root,
+				}))
+				Expect(spec.Linux.Resources.Devices).To(ConsistOf(specs.LinuxDeviceCgroup{
+					Allow:  true,
+					Type:   "c",
+					Major:  &major,
+					Minor:  &minor,
+					Access: "rwm",
+				}))
+
+				Expect(spec.Linux.Seccomp.Syscalls).To(ContainElement(specbuilder.AllowSyscall("mount")))
+				Expect(spec.Linux.Seccomp.Syscalls).To(ContainElement(specbuilder.AllowSyscall("umount2")))
+			})
+		})
+
+		Context("when the user keeps the default capabilities", func() {
+			BeforeEach(func() {
+				procCfg.Capabilities = []string{"TAIN", "KILL"}
+				procCfg.Unsafe = &config.Unsafe{KeepDefaultCapabilities: true}
+			})
+
+			It("grants the capabilities runc grants by default as well as the requested ones", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectedCapabilities := []string{"CAP_AUDIT_WRITE", "CAP_KILL", "CAP_NET_BIND_SERVICE", "CAP_TAIN"}
+				Expect(spec.Process.Capabilities).To(Equal(&specs.LinuxCapabilities{
+					Ambient:     expectedCapabilities,
+					Bounding:    expectedCapabilities,
+					Effective:   nil,
+					Inheritable: expectedCapabilities,
+					Permitted:   expectedCapabilities,
+				}))
+			})
+		})
+
+		Context("when the hostname is that of the instance", func() {
+			BeforeEach(func() {
+				procCfg.Hostname = config.HostnameInstance
+			})
+
+			It("sets the hostname to the instance name", func() {
+				runcAdapter.SetInstanceName("web-0")
+
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Hostname).To(Equal("web-0"))
+			})
+
+			It("falls back to the container ID without an instance name", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Hostname).To(Equal(bpmCfg.ContainerID()))
+			})
+		})
+
+		It("keeps the hostname of the host by default", func() {
+			runcAdapter.SetInstanceName("web-0")
+
+			spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.Hostname).To(BeEmpty())
+		})
+
+		Context("when the process runs in a user namespace", func() {
+			BeforeEach(func() {
+				procCfg.UserNamespace = &config.UserNamespace{HostUID: 100000, HostGID: 110000, Size: 1000}
+			})
+
+			It("adds a user namespace mapping root to the host ids", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(spec.Linux.Namespaces).To(ContainElement(specs.LinuxNamespace{Type: "user"}))
+				Expect(spec.Linux.UIDMappings).To(Equal([]specs.LinuxIDMapping{
+					{ContainerID: 0, HostID: 100000, Size: 1000},
+				}))
+				Expect(spec.Linux.GIDMappings).To(Equal([]specs.LinuxIDMapping{
+					{ContainerID: 0, HostID: 110000, Size: 1000},
+				}))
+			})
+
+			It("uses the root user of the namespace", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Process.User).To(Equal(specs.User{UID: 0, GID: 0}))
+			})
+		})
+
+		Context("when a BOSH spec file is set", func() {
+			var specPath string
+
+			BeforeEach(func() {
+				specPath = filepath.Join(systemRoot, "spec.json")
+			})
+
+			JustBeforeEach(func() {
+				runcAdapter.SetBoshSpecFile(specPath)
+			})
+
+			It("annotates the container with the instance", func() {
+				contents := `{"deployment": "cf", "name": "router", "az": "z1", "index": 0, "id": "abc"}`
+				Expect(ioutil.WriteFile(specPath, []byte(contents), 0644)).To(Succeed())
+
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Annotations).To(Equal(map[string]string{
+					AnnotationJob:           "example",
+					AnnotationProcess:       "server",
+					AnnotationDeployment:    "cf",
+					AnnotationInstanceGroup: "router",
+					AnnotationAZ:            "z1",
+					AnnotationIndex:         "0",
+				}))
+			})
+
+			It("leaves out the fields which the spec does not have", func() {
+				Expect(ioutil.WriteFile(specPath, []byte(`{"deployment": "cf"}`), 0644)).To(Succeed())
+
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Annotations).To(Equal(map[string]string{
+					AnnotationJob:        "example",
+					AnnotationProcess:    "server",
+					AnnotationDeployment: "cf",
+				}))
+			})
+
+			It("does not annotate the container with the instance when the spec file does not exist", func() {
+				spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.Annotations).To(Equal(map[string]string{
+					AnnotationJob:     "example",
+					AnnotationProcess: "server",
+				}))
+			})
+
+			It("returns an error when the spec file is invalid", func() {
+				Expect(ioutil.WriteFile(specPath, []byte("not json"), 0644)).To(Succeed())
+
+				_, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+				Expect(err).To(MatchError(ContainSubstring("invalid BOSH spec")))
+			})
+		})
+
+		It("only annotates the container with its job and process without a BOSH spec file", func() {
+			spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.Annotations).To(Equal(map[string]string{
+				AnnotationJob:     "example",
+				AnnotationProcess: "server",
+			}))
+		})
+
+		It("annotates a container with a custom id with its job and process", func() {
+			bpmCfg.SetContainerID("custom")
+
+			spec, err := runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.Annotations).To(HaveKeyWithValue(AnnotationJob, "example"))
+			Expect(spec.Annotations).To(HaveKeyWithValue(AnnotationProcess, "server"))
+		})
+
 		Context("when the user requests a privileged container", func() {
 			BeforeEach(func() {
 				procCfg.Unsafe = &config.Unsafe{Privileged: true}
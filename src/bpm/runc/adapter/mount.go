@@ -74,6 +74,29 @@ func WithRecursiveBind() MountOption {
 	}
 }
 
+// WithOptions applies a list of named mount options (e.g. "noexec", "ro") on
+// top of the other options. Unknown names are ignored.
+func WithOptions(names []string) MountOption {
+	return func(options *mountOptions) {
+		for _, name := range names {
+			switch name {
+			case "exec":
+				options.exec = true
+			case "noexec":
+				options.exec = false
+			case "nosuid":
+				options.suid = false
+			case "nodev":
+				options.dev = false
+			case "rw":
+				options.writable = true
+			case "ro":
+				options.writable = false
+			}
+		}
+	}
+}
+
 type mountOptions struct {
 	rbind    bool
 	exec     bool
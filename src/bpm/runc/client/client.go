@@ -16,14 +16,20 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
@@ -33,6 +39,11 @@ type Signal int
 const (
 	Term Signal = iota
 	Quit
+	Int
+	Hup
+	Usr1
+	Usr2
+	Kill
 )
 
 func (s Signal) String() string {
@@ -41,11 +52,53 @@ func (s Signal) String() string {
 		return "TERM"
 	case Quit:
 		return "QUIT"
+	case Int:
+		return "INT"
+	case Hup:
+		return "HUP"
+	case Usr1:
+		return "USR1"
+	case Usr2:
+		return "USR2"
+	case Kill:
+		return "KILL"
 	default:
 		return "unknown"
 	}
 }
 
+func (s Signal) syscall() syscall.Signal {
+	switch s {
+	case Term:
+		return syscall.SIGTERM
+	case Quit:
+		return syscall.SIGQUIT
+	case Int:
+		return syscall.SIGINT
+	case Hup:
+		return syscall.SIGHUP
+	case Usr1:
+		return syscall.SIGUSR1
+	case Usr2:
+		return syscall.SIGUSR2
+	default:
+		return syscall.SIGKILL
+	}
+}
+
+// ParseSignal converts a signal name such as TERM or SIGTERM into a Signal.
+func ParseSignal(name string) (Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+
+	for _, s := range []Signal{Term, Quit, Int, Hup, Usr1, Usr2, Kill} {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported signal: %s", name)
+}
+
 // https://github.com/opencontainers/runc/blob/master/list.go#L24-L45
 type ContainerState struct {
 	// ID is the container ID
@@ -54,23 +107,46 @@ type ContainerState struct {
 	InitProcessPid int `json:"pid"`
 	// Status is the current status of the container, running, paused, ...
 	Status string `json:"status"`
+	// Created is the unix timestamp for the creation time of the container in UTC
+	Created time.Time `json:"created"`
+	// Annotations are the annotations in the spec of the container
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
+// DefaultBusyTimeout is how long an operation on a container which another
+// runc command holds the lock of is retried for before it fails.
+const DefaultBusyTimeout = 10 * time.Second
+
+const maxBusyBackoff = time.Second
+
+// busyPattern matches the error runc gives when another runc command holds
+// the lock of the same container. Other errors, even ones caused by a busy
+// resource, are not retried.
+var busyPattern = regexp.MustCompile(`container is busy`)
+
 type RuncClient struct {
 	runcPath string
 	runcRoot string
 
-	inSystemd bool
+	inSystemd   bool
+	busyTimeout time.Duration
 }
 
 func NewRuncClient(runcPath, runcRoot string, inSystemd bool) *RuncClient {
 	return &RuncClient{
-		runcPath:  runcPath,
-		runcRoot:  runcRoot,
-		inSystemd: inSystemd,
+		runcPath:    runcPath,
+		runcRoot:    runcRoot,
+		inSystemd:   inSystemd,
+		busyTimeout: DefaultBusyTimeout,
 	}
 }
 
+// SetBusyTimeout changes how long operations on a busy container are retried
+// for. A timeout of zero disables the retries.
+func (c *RuncClient) SetBusyTimeout(timeout time.Duration) {
+	c.busyTimeout = timeout
+}
+
 func (*RuncClient) CreateBundle(
 	bundlePath string,
 	jobSpec specs.Spec,
@@ -87,6 +163,12 @@ func (*RuncClient) CreateBundle(
 		return err
 	}
 
+	if jobSpec.Linux != nil && len(jobSpec.Linux.UIDMappings) > 0 {
+		if err := allowNamespacedRoot(bundlePath, rootfsPath, jobSpec.Linux); err != nil {
+			return err
+		}
+	}
+
 	f, err := os.OpenFile(filepath.Join(bundlePath, "config.json"), os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		// This is super hard to test as we are root.
@@ -99,21 +181,84 @@ func (*RuncClient) CreateBundle(
 	return enc.Encode(&jobSpec)
 }
 
-func (c *RuncClient) RunContainer(pidFilePath, bundlePath, containerID string, detach bool, stdout, stderr io.Writer) (int, error) {
+// BundleSpec reads the spec which CreateBundle wrote to the bundle.
+func (c *RuncClient) BundleSpec(bundlePath string) (specs.Spec, error) {
+	var spec specs.Spec
+
+	data, err := ioutil.ReadFile(filepath.Join(bundlePath, "config.json"))
+	if err != nil {
+		return spec, err
+	}
+
+	err = json.Unmarshal(data, &spec)
+	return spec, err
+}
+
+// allowNamespacedRoot lets the root user of a user namespace, which is an
+// unprivileged user on the host, reach and own the rootfs of the bundle. The
+// bundle directory and the job and bundles directories above it become
+// searchable, but not listable, by other users and the config.json in the
+// bundle stays readable by the host root user only.
+func allowNamespacedRoot(bundlePath, rootfsPath string, linux *specs.Linux) error {
+	dir := bundlePath
+	for i := 0; i < 3; i++ {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+
+		if err := os.Chmod(dir, fi.Mode()|0011); err != nil {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	return os.Chown(rootfsPath, int(hostID(linux.UIDMappings)), int(hostID(linux.GIDMappings)))
+}
+
+// hostID is the host id which the root id of a user namespace is mapped to.
+func hostID(mappings []specs.LinuxIDMapping) uint32 {
+	for _, m := range mappings {
+		if m.ContainerID == 0 {
+			return m.HostID
+		}
+	}
+
+	return 0
+}
+
+// RunContainer runs the container in the bundle. If logPath is set runc writes
+// its own diagnostics there, replacing those of any previous run, and they are
+// included in the error if runc fails. The extraFiles are passed to the
+// process as file descriptors 3 onwards. runc is killed if ctx is done before
+// it has exited.
+func (c *RuncClient) RunContainer(ctx context.Context, pidFilePath, bundlePath, containerID, logPath string, detach bool, stdout, stderr io.Writer, extraFiles []*os.File) (int, error) {
 	args := []string{
 		"--bundle", bundlePath,
 	}
+	if len(extraFiles) > 0 {
+		args = append(args, "--preserve-fds", strconv.Itoa(len(extraFiles)))
+	}
 	if detach {
 		args = append(args, "--pid-file", pidFilePath)
 		args = append(args, "--detach")
 	}
 	args = append(args, containerID)
 
-	runcCmd := c.buildCmd("run", args...)
+	if logPath != "" {
+		if err := os.Truncate(logPath, 0); err != nil && !os.IsNotExist(err) {
+			return 1, err
+		}
+	}
+
+	runcCmd := c.buildCmdContext(ctx, logPath, "run", args...)
 	runcCmd.Stdout = stdout
 	runcCmd.Stderr = stderr
+	runcCmd.ExtraFiles = extraFiles
 
 	if err := runcCmd.Run(); err != nil {
+		err = withRuncLog(err, logPath)
+
 		if status, ok := runcCmd.ProcessState.Sys().(syscall.WaitStatus); ok {
 			return status.ExitStatus(), err
 		}
@@ -126,39 +271,173 @@ func (c *RuncClient) RunContainer(pidFilePath, bundlePath, containerID string, d
 	return 0, nil
 }
 
-// Exec assumes you are launching an interactive shell.
+// withRuncLog adds the contents of the runc log at logPath, if there are any,
+// to err.
+func withRuncLog(err error, logPath string) error {
+	if logPath == "" {
+		return err
+	}
+
+	data, rerr := ioutil.ReadFile(logPath)
+	if rerr != nil || len(bytes.TrimSpace(data)) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%s: runc log: %s", err, bytes.TrimSpace(data))
+}
+
+// Exec assumes you are launching an interactive shell. The first entry in
+// command is the executable and the rest are its arguments. Each entry in env
+// is a KEY=VALUE pair which is set in the environment of the exec session.
 // We should improve the interface to mirror `runc exec` more generally.
-func (c *RuncClient) Exec(containerID, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+func (c *RuncClient) Exec(containerID string, command, env []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	args := []string{"--tty"}
+	for _, e := range env {
+		args = append(args, "--env", e)
+	}
+	args = append(args, containerID)
+	args = append(args, command...)
+
+	runcCmd := c.buildCmd("exec", args...)
+
+	runcCmd.Stdin = stdin
+	runcCmd.Stdout = stdout
+	runcCmd.Stderr = stderr
+
+	return runcCmd.Run()
+}
+
+// ExecProcess runs process inside the container with its terminal attached
+// to stdin, stdout and stderr. Unlike Exec the process is given to runc
+// whole, so its environment replaces that of the container rather than
+// adding to it.
+func (c *RuncClient) ExecProcess(containerID string, process specs.Process, stdin io.Reader, stdout, stderr io.Writer) error {
+	f, err := ioutil.TempFile("", "bpm-exec-process")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	process.Terminal = true
+	if err := json.NewEncoder(f).Encode(&process); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	runcCmd := c.buildCmd("exec", "--tty", "--process", f.Name(), containerID)
+
+	runcCmd.Stdin = stdin
+	runcCmd.Stdout = stdout
+	runcCmd.Stderr = stderr
+
+	return runcCmd.Run()
+}
+
+// ExecCommand runs a non-interactive command inside the container and waits
+// for it to exit. The command runs as the user and with the environment of
+// the main process of the container.
+func (c *RuncClient) ExecCommand(containerID string, command []string, stdout, stderr io.Writer) error {
+	args := append([]string{containerID}, command...)
+
+	runcCmd := c.buildCmd("exec", args...)
+	runcCmd.Stdout = stdout
+	runcCmd.Stderr = stderr
+
+	return runcCmd.Run()
+}
+
+// Events streams the JSON events emitted by `runc events` for the container
+// to stdout. It returns once the container goes away or, without an error,
+// once done is closed.
+func (c *RuncClient) Events(containerID string, stdout io.Writer, done <-chan struct{}) error {
+	runcCmd := c.buildCmd("events", containerID)
+	runcCmd.Stdout = stdout
+	// Signals from the terminal are left for bpm to handle so that it can
+	// stop streaming on its own terms.
+	runcCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := runcCmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- runcCmd.Wait() }()
+
+	select {
+	case err := <-exited:
+		return err
+	case <-done:
+		_ = runcCmd.Process.Kill()
+		<-exited
+		return nil
+	}
+}
+
+// CheckpointContainer uses CRIU to save the state of the container to
+// imagePath. The container is stopped once it has been checkpointed.
+func (c *RuncClient) CheckpointContainer(containerID, imagePath string) error {
+	return withOutput(c.combinedOutput(
+		"checkpoint",
+		"--image-path", imagePath,
+		containerID,
+	))
+}
+
+func (c *RuncClient) PauseContainer(containerID string) error {
+	return withOutput(c.combinedOutput("pause", containerID))
+}
+
+func (c *RuncClient) ResumeContainer(containerID string) error {
+	return withOutput(c.combinedOutput("resume", containerID))
+}
+
+// RestoreContainer creates a detached container from the bundle and resumes
+// the checkpoint in imagePath inside it.
+func (c *RuncClient) RestoreContainer(pidFilePath, bundlePath, containerID, imagePath string, stdout, stderr io.Writer) error {
 	runcCmd := c.buildCmd(
-		"exec",
-		"--tty",
-		"--env", fmt.Sprintf("TERM=%s", os.Getenv("TERM")),
+		"restore",
+		"--detach",
+		"--bundle", bundlePath,
+		"--pid-file", pidFilePath,
+		"--image-path", imagePath,
 		containerID,
-		command,
 	)
-
-	runcCmd.Stdin = stdin
 	runcCmd.Stdout = stdout
 	runcCmd.Stderr = stderr
 
 	return runcCmd.Run()
 }
 
+// withOutput adds the output of a failed runc command, which is where runc
+// explains what went wrong, to its error.
+func withOutput(output []byte, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if msg := bytes.TrimSpace(output); len(msg) > 0 {
+		return fmt.Errorf("%s: %s", err, msg)
+	}
+
+	return err
+}
+
 // ContainerState returns the following:
 // - state, nil if the job is running,and no errors were encountered.
 // - nil,nil if the container state is not running and no other errors were encountered
 // - nil,error if there is any other error getting the container state
 //   (e.g. the container is running but in an unreachable state)
 func (c *RuncClient) ContainerState(containerID string) (*specs.State, error) {
-	runcCmd := c.buildCmd(
+	var state specs.State
+	data, err := c.combinedOutput(
 		"--log-format",
 		"json",
 		"state",
 		containerID,
 	)
-
-	var state specs.State
-	data, err := runcCmd.CombinedOutput()
 	if err != nil {
 		return nil, decodeContainerStateErr(data, err)
 	}
@@ -206,36 +485,215 @@ func (c *RuncClient) ListContainers() ([]ContainerState, error) {
 	return containerStates, nil
 }
 
-func (c *RuncClient) SignalContainer(containerID string, signal Signal) error {
+// ContainerPids returns the pids, in the pid namespace of the host, of every
+// process in the container.
+func (c *RuncClient) ContainerPids(containerID string) ([]int, error) {
+	runcCmd := c.buildCmd(
+		"ps",
+		"--format", "json",
+		containerID,
+	)
+
+	data, err := runcCmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	if err := json.Unmarshal(data, &pids); err != nil {
+		return nil, err
+	}
+
+	return pids, nil
+}
+
+// ContainerOOMKilled reports whether the kernel has killed a process in the
+// container for exceeding its memory limit. runc keeps the cgroup paths of a
+// container in its state directory until the container is deleted so this
+// works after the container has stopped. Both the oom_control file of the v1
+// memory cgroup and the memory.events file of a v2 cgroup are understood.
+func (c *RuncClient) ContainerOOMKilled(containerID string) (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.runcRoot, containerID, "state.json"))
+	if err != nil {
+		return false, err
+	}
+
+	var state struct {
+		CgroupPaths map[string]string `json:"cgroup_paths"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, err
+	}
+
+	var events string
+	if dir, ok := state.CgroupPaths["memory"]; ok {
+		events = filepath.Join(dir, "memory.oom_control")
+	} else if dir, ok := state.CgroupPaths[""]; ok {
+		events = filepath.Join(dir, "memory.events")
+	} else {
+		return false, fmt.Errorf("container %s has no memory cgroup", containerID)
+	}
+
+	data, err = ioutil.ReadFile(events)
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return fields[1] != "0", nil
+		}
+	}
+
+	return false, nil
+}
+
+// MemoryUsage is how much memory a container is using and how much it may
+// use, in bytes.
+type MemoryUsage struct {
+	Usage uint64 `json:"usage"`
+	Limit uint64 `json:"limit"`
+}
+
+// ContainerMemory returns the memory usage of the container from the stats
+// reported by `runc events --stats`.
+func (c *RuncClient) ContainerMemory(containerID string) (*MemoryUsage, error) {
+	stats, err := c.ContainerStats(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats.Memory, nil
+}
+
+// ContainerStats is a snapshot of the resources a container is using.
+type ContainerStats struct {
+	Memory MemoryUsage
+
+	// CPUTotal is the CPU time used by the container since it started, in
+	// nanoseconds.
+	CPUTotal uint64
+
+	// Pids is the number of processes in the container.
+	Pids uint64
+}
+
+// ContainerStats returns the resource usage of the container reported by
+// `runc events --stats`.
+func (c *RuncClient) ContainerStats(containerID string) (*ContainerStats, error) {
 	runcCmd := c.buildCmd(
+		"events",
+		"--stats",
+		containerID,
+	)
+
+	data, err := runcCmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		Data struct {
+			CPU struct {
+				Usage struct {
+					Total uint64 `json:"total"`
+				} `json:"usage"`
+			} `json:"cpu"`
+			Memory struct {
+				Usage MemoryUsage `json:"usage"`
+			} `json:"memory"`
+			Pids struct {
+				Current uint64 `json:"current"`
+			} `json:"pids"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+
+	return &ContainerStats{
+		Memory:   event.Data.Memory.Usage,
+		CPUTotal: event.Data.CPU.Usage.Total,
+		Pids:     event.Data.Pids.Current,
+	}, nil
+}
+
+func (c *RuncClient) SignalContainer(containerID string, signal Signal) error {
+	_, err := c.combinedOutput(
 		"kill",
 		containerID,
 		signal.String(),
 	)
 
-	return runcCmd.Run()
+	return err
+}
+
+// SignalProcesses sends the signal to each of the pids directly rather than
+// through runc, which only signals the init process of a container. Processes
+// which have already exited are skipped.
+func (*RuncClient) SignalProcesses(pids []int, signal Signal) error {
+	for _, pid := range pids {
+		err := syscall.Kill(pid, signal.syscall())
+		if err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to signal pid %d: %s", pid, err)
+		}
+	}
+
+	return nil
 }
 
 func (c *RuncClient) DeleteContainer(containerID string) error {
-	runcCmd := c.buildCmd(
+	_, err := c.combinedOutput(
 		"delete",
 		"--force",
 		containerID,
 	)
 
-	return runcCmd.Run()
+	return err
 }
 
 func (*RuncClient) DestroyBundle(bundlePath string) error {
 	return os.RemoveAll(bundlePath)
 }
 
+// combinedOutput runs a runc command and returns its combined output. The
+// command is retried with a growing backoff for as long as it fails because
+// the container is busy, up to the busy timeout.
+func (c *RuncClient) combinedOutput(command string, extra ...string) ([]byte, error) {
+	deadline := time.Now().Add(c.busyTimeout)
+	backoff := 50 * time.Millisecond
+
+	for {
+		output, err := c.buildCmd(command, extra...).CombinedOutput()
+		if err == nil || !busyPattern.Match(output) || time.Now().Add(backoff).After(deadline) {
+			return output, err
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBusyBackoff {
+			backoff = maxBusyBackoff
+		}
+	}
+}
+
 func (c *RuncClient) buildCmd(command string, extra ...string) *exec.Cmd {
+	return c.buildCmdWithLog("", command, extra...)
+}
+
+func (c *RuncClient) buildCmdWithLog(logPath, command string, extra ...string) *exec.Cmd {
+	return c.buildCmdContext(context.Background(), logPath, command, extra...)
+}
+
+func (c *RuncClient) buildCmdContext(ctx context.Context, logPath, command string, extra ...string) *exec.Cmd {
 	args := []string{"--root", c.runcRoot}
+	if logPath != "" {
+		args = append(args, "--log", logPath)
+	}
 	if c.inSystemd {
 		args = append(args, "--systemd-cgroup")
 	}
 	args = append(args, command)
 	args = append(args, extra...)
-	return exec.Command(c.runcPath, args...)
+	return exec.CommandContext(ctx, c.runcPath, args...)
 }
@@ -16,14 +16,21 @@
 package client_test
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 
 	"bpm/runc/client"
@@ -109,6 +116,46 @@ var _ = Describe("RuncClient", func() {
 			Expect(configData).To(MatchJSON(expectedConfigData))
 		})
 
+		Context("when the spec has a user namespace", func() {
+			BeforeEach(func() {
+				bundlePath = filepath.Join(bundlesRoot, "bundles", "job", "process")
+
+				jobSpec.Linux = &specs.Linux{
+					UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+					GIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 200000, Size: 65536}},
+				}
+			})
+
+			It("gives the rootfs directory to the host user root is mapped to", func() {
+				err := runcClient.CreateBundle(bundlePath, jobSpec, user)
+				Expect(err).ToNot(HaveOccurred())
+
+				f, err := os.Stat(filepath.Join(bundlePath, "rootfs"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(100000)))
+				Expect(f.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(200000)))
+			})
+
+			It("lets other users search but not list the directories above the rootfs", func() {
+				err := runcClient.CreateBundle(bundlePath, jobSpec, user)
+				Expect(err).ToNot(HaveOccurred())
+
+				for _, dir := range []string{
+					bundlePath,
+					filepath.Dir(bundlePath),
+					filepath.Join(bundlesRoot, "bundles"),
+				} {
+					f, err := os.Stat(dir)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(f.Mode() & os.ModePerm).To(Equal(os.FileMode(0711)))
+				}
+
+				f, err := os.Stat(filepath.Join(bundlePath, "config.json"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Mode() & os.ModePerm).To(Equal(os.FileMode(0600)))
+			})
+		})
+
 		Context("when creating the bundle directory fails", func() {
 			BeforeEach(func() {
 				_, err := os.Create(bundlePath)
@@ -137,6 +184,37 @@ var _ = Describe("RuncClient", func() {
 		})
 	})
 
+	Describe("BundleSpec", func() {
+		var bundlesRoot string
+
+		BeforeEach(func() {
+			var err error
+			bundlesRoot, err = ioutil.TempDir("", "bundle-builder")
+			Expect(err).ToNot(HaveOccurred())
+
+			bundlePath = filepath.Join(bundlesRoot, "bundle")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(bundlesRoot)).To(Succeed())
+		})
+
+		It("reads the spec written by CreateBundle", func() {
+			jobSpec = specs.Spec{
+				Version: "example-version",
+				Process: &specs.Process{Args: []string{"/bin/server"}, Env: []string{"PORT=8080"}},
+			}
+			Expect(runcClient.CreateBundle(bundlePath, jobSpec, user)).To(Succeed())
+
+			Expect(runcClient.BundleSpec(bundlePath)).To(Equal(jobSpec))
+		})
+
+		It("returns an error when there is no bundle", func() {
+			_, err := runcClient.BundleSpec(bundlePath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	Describe("DestroyBundle", func() {
 		var bundlePath string
 
@@ -212,6 +290,145 @@ exit 0
 		})
 	})
 
+	Describe("ContainerPids", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeRuncPath := filepath.Join(tempDir, "fakeRunc")
+			contents := []byte(`#!/bin/sh
+echo "$@" | grep -q -- "ps --format json foo" || exit 1
+echo -n '[1234,1240]'
+`)
+			Expect(ioutil.WriteFile(fakeRuncPath, contents, 0700)).To(Succeed())
+
+			runcClient = client.NewRuncClient(fakeRuncPath, "/path/to/things", false)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("returns the pids runc lists", func() {
+			Expect(runcClient.ContainerPids("foo")).To(Equal([]int{1234, 1240}))
+		})
+	})
+
+	Describe("ContainerOOMKilled", func() {
+		var runcRoot, cgroupDir string
+
+		writeState := func(cgroupPaths map[string]string) {
+			Expect(os.MkdirAll(filepath.Join(runcRoot, "foo"), 0700)).To(Succeed())
+			data, err := json.Marshal(map[string]interface{}{"cgroup_paths": cgroupPaths})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(runcRoot, "foo", "state.json"), data, 0600)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			runcRoot, err = ioutil.TempDir("", "runc-root")
+			Expect(err).NotTo(HaveOccurred())
+			cgroupDir, err = ioutil.TempDir("", "cgroup")
+			Expect(err).NotTo(HaveOccurred())
+
+			runcClient = client.NewRuncClient("/path/to/runc", runcRoot, false)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(runcRoot)).To(Succeed())
+			Expect(os.RemoveAll(cgroupDir)).To(Succeed())
+		})
+
+		It("reads the oom kill count of the v1 memory cgroup", func() {
+			writeState(map[string]string{"memory": cgroupDir, "cpu": "/elsewhere"})
+			oomControl := "oom_kill_disable 0\nunder_oom 0\noom_kill 1\n"
+			Expect(ioutil.WriteFile(filepath.Join(cgroupDir, "memory.oom_control"), []byte(oomControl), 0644)).To(Succeed())
+
+			Expect(runcClient.ContainerOOMKilled("foo")).To(BeTrue())
+		})
+
+		It("reads the oom kill count of a v2 cgroup", func() {
+			writeState(map[string]string{"": cgroupDir})
+			events := "low 0\nhigh 0\nmax 3\noom 1\noom_kill 0\n"
+			Expect(ioutil.WriteFile(filepath.Join(cgroupDir, "memory.events"), []byte(events), 0644)).To(Succeed())
+
+			Expect(runcClient.ContainerOOMKilled("foo")).To(BeFalse())
+		})
+
+		Context("when the container has no state", func() {
+			It("returns an error", func() {
+				_, err := runcClient.ContainerOOMKilled("foo")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("SignalProcesses", func() {
+		It("sends the signal to every pid", func() {
+			var cmds []*exec.Cmd
+			var pids []int
+			for i := 0; i < 2; i++ {
+				cmd := exec.Command("sleep", "100")
+				Expect(cmd.Start()).To(Succeed())
+				cmds = append(cmds, cmd)
+				pids = append(pids, cmd.Process.Pid)
+			}
+
+			Expect(runcClient.SignalProcesses(pids, client.Term)).To(Succeed())
+
+			for _, cmd := range cmds {
+				Expect(cmd.Wait()).To(HaveOccurred())
+				status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+				Expect(status.Signal()).To(Equal(syscall.SIGTERM))
+			}
+		})
+
+		It("skips pids which have already exited", func() {
+			cmd := exec.Command("true")
+			Expect(cmd.Run()).To(Succeed())
+
+			Expect(runcClient.SignalProcesses([]int{cmd.Process.Pid}, client.Term)).To(Succeed())
+		})
+	})
+
+	Describe("ContainerMemory", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeRuncPath := filepath.Join(tempDir, "fakeRunc")
+			contents := []byte(`#!/bin/sh
+echo "$@" | grep -q -- "events --stats foo" || exit 1
+echo '{"type":"stats","id":"foo","data":{"cpu":{"usage":{"total":123456789}},"memory":{"usage":{"limit":1048576,"usage":524288,"max":600000}},"pids":{"current":3}}}'
+`)
+			Expect(ioutil.WriteFile(fakeRuncPath, contents, 0700)).To(Succeed())
+
+			runcClient = client.NewRuncClient(fakeRuncPath, "/path/to/things", false)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("returns the memory usage and limit runc reports", func() {
+			Expect(runcClient.ContainerMemory("foo")).To(Equal(&client.MemoryUsage{Usage: 524288, Limit: 1048576}))
+		})
+
+		It("returns the cpu time and number of processes runc reports", func() {
+			Expect(runcClient.ContainerStats("foo")).To(Equal(&client.ContainerStats{
+				Memory:   client.MemoryUsage{Usage: 524288, Limit: 1048576},
+				CPUTotal: 123456789,
+				Pids:     3,
+			}))
+		})
+	})
+
 	Context("when running in systemd", func() {
 		var (
 			tempDir      string
@@ -331,4 +548,249 @@ exit 1
 			})
 		})
 	})
+
+	Context("when the container is busy", func() {
+		var (
+			tempDir      string
+			fakeRuncPath string
+			attemptsPath string
+		)
+
+		// writeFakeRunc writes a runc which fails as though another runc
+		// command held the container lock for its first busyAttempts runs.
+		writeFakeRunc := func(busyAttempts int) {
+			contents := fmt.Sprintf(`#!/bin/sh
+echo x >> %s
+if [ "$(wc -l < %s)" -le %d ]; then
+  echo 'level=error msg="container is busy"'
+  exit 1
+fi
+exit 0
+`, attemptsPath, attemptsPath, busyAttempts)
+
+			Expect(ioutil.WriteFile(fakeRuncPath, []byte(contents), 0700)).To(Succeed())
+		}
+
+		attempts := func() int {
+			data, err := ioutil.ReadFile(attemptsPath)
+			Expect(err).NotTo(HaveOccurred())
+			return strings.Count(string(data), "\n")
+		}
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeRuncPath = filepath.Join(tempDir, "fakeRunc")
+			attemptsPath = filepath.Join(tempDir, "attempts")
+
+			runcClient = client.NewRuncClient(fakeRuncPath, "/path/to/things", false)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("retries the operation until it succeeds", func() {
+			writeFakeRunc(3)
+
+			Expect(runcClient.SignalContainer("foo", client.Term)).To(Succeed())
+			Expect(attempts()).To(Equal(4))
+		})
+
+		Context("when the container stays busy", func() {
+			It("gives up once the timeout has passed", func() {
+				writeFakeRunc(1000)
+				runcClient.SetBusyTimeout(300 * time.Millisecond)
+
+				err := runcClient.PauseContainer("foo")
+				Expect(err).To(MatchError(ContainSubstring("container is busy")))
+				Expect(attempts()).To(BeNumerically(">", 1))
+			})
+		})
+
+		Context("when the timeout is zero", func() {
+			It("does not retry", func() {
+				writeFakeRunc(1)
+				runcClient.SetBusyTimeout(0)
+
+				Expect(runcClient.DeleteContainer("foo")).To(HaveOccurred())
+				Expect(attempts()).To(Equal(1))
+			})
+		})
+
+		Context("when the operation fails for another reason", func() {
+			It("does not retry", func() {
+				Expect(ioutil.WriteFile(fakeRuncPath, []byte("#!/bin/sh\necho x >> "+attemptsPath+"\necho 'no such container'\nexit 1\n"), 0700)).To(Succeed())
+
+				Expect(runcClient.ResumeContainer("foo")).To(MatchError(ContainSubstring("no such container")))
+				Expect(attempts()).To(Equal(1))
+			})
+
+			It("does not retry other busy errors", func() {
+				Expect(ioutil.WriteFile(fakeRuncPath, []byte("#!/bin/sh\necho x >> "+attemptsPath+"\necho 'unable to freeze: device or resource busy'\nexit 1\n"), 0700)).To(Succeed())
+
+				Expect(runcClient.PauseContainer("foo")).To(MatchError(ContainSubstring("device or resource busy")))
+				Expect(attempts()).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("RunContainer", func() {
+		var (
+			tempDir      string
+			fakeRuncPath string
+			logPath      string
+		)
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeRuncPath = filepath.Join(tempDir, "fakeRunc")
+			logPath = filepath.Join(tempDir, "runc.log")
+
+			// The fake runc writes to the file after --log like the real one.
+			contents := []byte(`#!/bin/sh
+while [ "$1" != "--log" ]; do shift; done
+echo 'level=error msg="container_linux.go:349: starting container process caused: bad things"' >> "$2"
+exit 1
+`)
+
+			err = ioutil.WriteFile(fakeRuncPath, contents, 0700)
+			Expect(err).NotTo(HaveOccurred())
+
+			runcClient = client.NewRuncClient(fakeRuncPath, "/path/to/things", false)
+		})
+
+		AfterEach(func() {
+			err := os.RemoveAll(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("includes the runc log in the error when runc fails", func() {
+			status, err := runcClient.RunContainer(context.Background(), "pid", "bundle", "foo", logPath, true, ioutil.Discard, ioutil.Discard, nil)
+			Expect(status).To(Equal(1))
+			Expect(err).To(MatchError(ContainSubstring(`runc log: level=error msg="container_linux.go:349: starting container process caused: bad things"`)))
+		})
+
+		It("only includes the log of the latest run", func() {
+			Expect(ioutil.WriteFile(logPath, []byte("level=error msg=\"old failure\"\n"), 0600)).To(Succeed())
+
+			_, err := runcClient.RunContainer(context.Background(), "pid", "bundle", "foo", logPath, true, ioutil.Discard, ioutil.Discard, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("old failure"))
+		})
+
+		Context("when the context is done before runc exits", func() {
+			var pidPath string
+
+			BeforeEach(func() {
+				pidPath = filepath.Join(tempDir, "runc.pid")
+
+				// This fake runc blocks like one waiting on a container which
+				// never starts.
+				contents := []byte(fmt.Sprintf("#!/bin/sh\necho $$ > %s\nexec sleep 100\n", pidPath))
+				Expect(ioutil.WriteFile(fakeRuncPath, contents, 0700)).To(Succeed())
+			})
+
+			It("kills runc and waits for it to exit", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				go func() {
+					defer GinkgoRecover()
+					Eventually(pidPath).Should(BeAnExistingFile())
+					cancel()
+				}()
+
+				_, err := runcClient.RunContainer(ctx, "pid", "bundle", "foo", "", true, ioutil.Discard, ioutil.Discard, nil)
+				Expect(err).To(HaveOccurred())
+
+				data, err := ioutil.ReadFile(pidPath)
+				Expect(err).NotTo(HaveOccurred())
+				pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(syscall.Kill(pid, 0)).To(MatchError(syscall.ESRCH))
+			})
+		})
+	})
+
+	Describe("ExecProcess", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			// The fake runc prints its arguments and the process it is given.
+			fakeRuncPath := filepath.Join(tempDir, "fakeRunc")
+			contents := []byte("#!/bin/sh\necho \"$@\"\nwhile [ \"$1\" != \"--process\" ]; do shift; done\ncat \"$2\"\n")
+			Expect(ioutil.WriteFile(fakeRuncPath, contents, 0700)).To(Succeed())
+
+			runcClient = client.NewRuncClient(fakeRuncPath, "/path/to/things", false)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("gives runc the whole process with a terminal", func() {
+			stdout := gbytes.NewBuffer()
+			process := specs.Process{Args: []string{"/bin/bash"}, Env: []string{"TERM=xterm"}}
+
+			Expect(runcClient.ExecProcess("foo", process, nil, stdout, ioutil.Discard)).To(Succeed())
+
+			Expect(stdout).To(gbytes.Say(`exec --tty --process \S+ foo\n`))
+			Expect(stdout).To(gbytes.Say(`"terminal":true`))
+			Expect(stdout).To(gbytes.Say(`"args":\["/bin/bash"\],"env":\["TERM=xterm"\]`))
+		})
+	})
+
+	Describe("Events", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeRuncPath := filepath.Join(tempDir, "fakeRunc")
+			contents := []byte("#!/bin/sh\necho '{\"type\":\"stats\"}'\nexec sleep 100\n")
+			Expect(ioutil.WriteFile(fakeRuncPath, contents, 0700)).To(Succeed())
+
+			runcClient = client.NewRuncClient(fakeRuncPath, "/path/to/things", false)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("stops streaming without an error once done is closed", func() {
+			stdout := gbytes.NewBuffer()
+			done := make(chan struct{})
+
+			errCh := make(chan error, 1)
+			go func() { errCh <- runcClient.Events("foo", stdout, done) }()
+
+			Eventually(stdout).Should(gbytes.Say(`"type":"stats"`))
+			close(done)
+
+			Eventually(errCh).Should(Receive(BeNil()))
+		})
+	})
+
+	Describe("ParseSignal", func() {
+		It("parses signal names with or without the SIG prefix", func() {
+			Expect(client.ParseSignal("TERM")).To(Equal(client.Term))
+			Expect(client.ParseSignal("SIGINT")).To(Equal(client.Int))
+			Expect(client.ParseSignal("kill")).To(Equal(client.Kill))
+		})
+
+		It("returns an error for unsupported signals", func() {
+			_, err := client.ParseSignal("SIGWINCH")
+			Expect(err).To(MatchError("unsupported signal: WINCH"))
+		})
+	})
 })
@@ -16,11 +16,21 @@
 package lifecycle
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
@@ -29,6 +39,8 @@ import (
 	"code.cloudfoundry.org/lager"
 
 	"bpm/config"
+	"bpm/ioprio"
+	"bpm/logpump"
 	"bpm/models"
 	"bpm/runc/client"
 	"bpm/usertools"
@@ -37,6 +49,9 @@ import (
 const (
 	ContainerSigQuitGracePeriod = 2 * time.Second
 	ContainerStatePollInterval  = 1 * time.Second
+	ContainerStateRetryInterval = 100 * time.Millisecond
+	HeartbeatPollInterval       = 1 * time.Second
+	MemoryPollInterval          = 1 * time.Second
 
 	ContainerStateRunning = "running"
 	ContainerStatePaused  = "paused"
@@ -52,6 +67,10 @@ func IsNotExist(err error) bool {
 	return err == isNotExistError
 }
 
+func IsTimeout(err error) bool {
+	return err == timeoutError
+}
+
 //go:generate go run -mod=vendor github.com/golang/mock/mockgen -copyright_file ./mock_lifecycle/header.txt -destination ./mock_lifecycle/mocks.go bpm/runc/lifecycle UserFinder,CommandRunner,RuncAdapter,RuncClient
 
 type UserFinder interface {
@@ -60,6 +79,7 @@ type UserFinder interface {
 
 type CommandRunner interface {
 	Run(*exec.Cmd) error
+	Start(*exec.Cmd) error
 }
 
 type RuncAdapter interface {
@@ -69,11 +89,24 @@ type RuncAdapter interface {
 
 type RuncClient interface {
 	CreateBundle(bundlePath string, jobSpec specs.Spec, user specs.User) error
-	RunContainer(pidFilePath, bundlePath, containerID string, detach bool, stdout, stderr io.Writer) (int, error)
-	Exec(containerID, command string, stdin io.Reader, stdout, stderr io.Writer) error
+	BundleSpec(bundlePath string) (specs.Spec, error)
+	RunContainer(ctx context.Context, pidFilePath, bundlePath, containerID, logPath string, detach bool, stdout, stderr io.Writer, extraFiles []*os.File) (int, error)
+	Exec(containerID string, command, env []string, stdin io.Reader, stdout, stderr io.Writer) error
+	ExecProcess(containerID string, process specs.Process, stdin io.Reader, stdout, stderr io.Writer) error
+	ExecCommand(containerID string, command []string, stdout, stderr io.Writer) error
+	Events(containerID string, stdout io.Writer, done <-chan struct{}) error
+	CheckpointContainer(containerID, imagePath string) error
+	PauseContainer(containerID string) error
+	ResumeContainer(containerID string) error
+	RestoreContainer(pidFilePath, bundlePath, containerID, imagePath string, stdout, stderr io.Writer) error
 	ContainerState(containerID string) (*specs.State, error)
 	ListContainers() ([]client.ContainerState, error)
+	ContainerPids(containerID string) ([]int, error)
+	ContainerMemory(containerID string) (*client.MemoryUsage, error)
+	ContainerStats(containerID string) (*client.ContainerStats, error)
+	ContainerOOMKilled(containerID string) (bool, error)
 	SignalContainer(containerID string, signal client.Signal) error
+	SignalProcesses(pids []int, signal client.Signal) error
 	DeleteContainer(containerID string) error
 	DestroyBundle(bundlePath string) error
 }
@@ -110,24 +143,470 @@ func (j *RuncLifecycle) StartProcess(logger lager.Logger, bpmCfg *config.BPMConf
 	logger.Info("starting")
 	defer logger.Info("complete")
 
-	stdout, stderr, err := j.setupProcess(logger, bpmCfg, procCfg)
+	stdout, stderr, env, err := j.setupProcess(logger, bpmCfg, procCfg)
 	if err != nil {
 		return err
 	}
 	defer stdout.Close()
 	defer stderr.Close()
 
+	if usesLogPump(procCfg) {
+		logger.Info("starting-log-pump")
+		stdout, stderr, err = j.startLogPump(stdout, stderr, procCfg)
+		if err != nil {
+			return fmt.Errorf("failed to start log pump: %s", err)
+		}
+		defer stdout.Close()
+		defer stderr.Close()
+	}
+
+	sockets, err := listenSockets(logger, procCfg.ListenSockets)
+	if err != nil {
+		return fmt.Errorf("failed to bind listen sockets: %s", err)
+	}
+	defer closeFiles(sockets)
+
 	logger.Info("running-container")
-	_, err = j.runcClient.RunContainer(
+	if err := j.runContainer(logger, bpmCfg, procCfg, stdout, stderr, sockets); err != nil {
+		return err
+	}
+
+	if procCfg.Hooks != nil && procCfg.Hooks.PostStart != "" {
+		logger.Info("running-post-start-hook")
+		err := j.runPostStartHook(bpmCfg, procCfg.Hooks, env, stdout, stderr)
+		if err != nil && procCfg.Hooks.PostStartAbortOnFailure {
+			logger.Error("post-start-hook-failed-removing-process", err)
+			if rerr := j.RemoveProcess(logger, bpmCfg); rerr != nil {
+				logger.Error("failed-to-cleanup", rerr)
+			}
+
+			return fmt.Errorf("post-start hook failed: %s", err.Error())
+		}
+
+		if err != nil {
+			logger.Error("post-start-hook-failed", err)
+		}
+	}
+
+	if procCfg.Supervised() {
+		logger.Info("starting-supervisor")
+		if err := j.startSupervisor(bpmCfg); err != nil {
+			// The process is already running and failing the start now would
+			// only leave it running without a supervisor anyway.
+			logger.Error("failed-to-start-supervisor", err)
+		}
+	}
+
+	return nil
+}
+
+// usesLogPump reports whether the output of the process is written to its log
+// files by a log pump rather than by the process itself. Output written
+// straight to a named pipe would block the process while nothing reads from
+// it so it always goes through the log pump.
+func usesLogPump(procCfg *config.ProcessConfig) bool {
+	return procCfg.LineBufferedLogs() || procCfg.FifoLogs() || procCfg.LogPrefix() != "" || procCfg.LogRotation() != nil || procCfg.LogFilter() != ""
+}
+
+var logPumpCheckpointError = errors.New("processes whose logs go through a log pump cannot be checkpointed")
+
+// CheckpointProcess saves the state of the running process to imagePath and
+// then removes it in the same way as a stopped process. It can be resumed
+// later, possibly on another machine, with RestoreProcess.
+func (j *RuncLifecycle) CheckpointProcess(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig, imagePath string) error {
+	logger = logger.Session("checkpoint-process", lager.Data{"image-path": imagePath})
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	// The pipes to a log pump cannot be reconnected to a new log pump when
+	// the process is restored.
+	if usesLogPump(procCfg) {
+		return logPumpCheckpointError
+	}
+
+	logger.Info("checkpointing-container")
+	if err := j.runcClient.CheckpointContainer(bpmCfg.ContainerID(), imagePath); err != nil {
+		return fmt.Errorf("failed to checkpoint process: %s", err)
+	}
+
+	return j.RemoveProcess(logger, bpmCfg)
+}
+
+// RestoreProcess sets up the process as StartProcess does but rather than
+// running it anew it resumes the checkpoint in imagePath.
+func (j *RuncLifecycle) RestoreProcess(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig, imagePath string) error {
+	logger = logger.Session("restore-process", lager.Data{"image-path": imagePath})
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	if usesLogPump(procCfg) {
+		return logPumpCheckpointError
+	}
+
+	stdout, stderr, _, err := j.setupProcess(logger, bpmCfg, procCfg)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	defer stderr.Close()
+
+	logger.Info("restoring-container")
+	err = j.runcClient.RestoreContainer(
 		bpmCfg.PidFile().External(),
 		bpmCfg.BundlePath(),
 		bpmCfg.ContainerID(),
-		true,
+		imagePath,
 		stdout,
 		stderr,
 	)
+	if err != nil {
+		return fmt.Errorf("failed to restore process: %s", err)
+	}
 
-	return err
+	if procCfg.Supervised() {
+		logger.Info("starting-supervisor")
+		if err := j.startSupervisor(bpmCfg); err != nil {
+			logger.Error("failed-to-start-supervisor", err)
+		}
+	}
+
+	return nil
+}
+
+// runContainer runs the detached container of the process and waits for it to
+// start. If it has not started within the timeout the container and its
+// bundle are removed so that a later start begins from scratch.
+func (j *RuncLifecycle) runContainer(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig, stdout, stderr io.Writer, sockets []*os.File) error {
+	timeout := procCfg.StartupDeadline()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := withIOPriority(procCfg.IONice, func() (int, error) {
+			return j.runcClient.RunContainer(
+				ctx,
+				bpmCfg.PidFile().External(),
+				bpmCfg.BundlePath(),
+				bpmCfg.ContainerID(),
+				bpmCfg.RuncLog().External(),
+				true,
+				stdout,
+				stderr,
+				sockets,
+			)
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-j.clock.After(timeout):
+	}
+
+	logger.Info("startup-timed-out", lager.Data{"timeout": timeout.String()})
+
+	// Nothing may be left holding on to the bundle when it is removed, so
+	// the blocked runc and the init of the container, if it has one yet, are
+	// both killed and runc is waited for first.
+	cancel()
+	if err := j.runcClient.SignalContainer(bpmCfg.ContainerID(), client.Kill); err != nil {
+		logger.Info("failed-to-kill-container", lager.Data{"error": err.Error()})
+	}
+	<-done
+
+	// The container may not have been created yet so the bundle is removed
+	// even if there was nothing to delete.
+	if err := j.runcClient.DeleteContainer(bpmCfg.ContainerID()); err != nil {
+		logger.Error("failed-to-delete-container", err)
+	}
+
+	if err := j.runcClient.DestroyBundle(bpmCfg.BundlePath()); err != nil {
+		logger.Error("failed-to-destroy-bundle", err)
+	}
+
+	if err := j.deleteFile(bpmCfg.PidFile().External()); err != nil {
+		logger.Error("failed-to-delete-pidfile", err)
+	}
+
+	return fmt.Errorf("process did not start within %s", timeout)
+}
+
+// SuperviseCommand is the hidden bpm subcommand which watches over a detached
+// process for as long as it runs, e.g. to enforce its liveness check.
+const SuperviseCommand = "supervise"
+
+func (j *RuncLifecycle) startSupervisor(bpmCfg *config.BPMConfig) error {
+	args := []string{SuperviseCommand, bpmCfg.JobName(), "-p", bpmCfg.ProcName()}
+	if bpmCfg.HasCustomContainerID() {
+		args = append(args, "--container-id", bpmCfg.ContainerID())
+	}
+	if bpmCfg.Restarts() > 0 {
+		args = append(args, "--restarts", strconv.Itoa(bpmCfg.Restarts()))
+	}
+
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	return j.commandRunner.Start(cmd)
+}
+
+// Thresholds are the limits a supervisor watches a process against. They can
+// be changed while the process is being watched, e.g. when the supervisor
+// reloads the configuration of the process, and take effect at the next poll.
+type Thresholds struct {
+	mu                sync.Mutex
+	memoryWarnPercent int
+	heartbeatInterval time.Duration
+	heartbeatFailures int
+}
+
+func NewThresholds(memoryWarnPercent int, heartbeatInterval time.Duration) *Thresholds {
+	return &Thresholds{
+		memoryWarnPercent: memoryWarnPercent,
+		heartbeatInterval: heartbeatInterval,
+	}
+}
+
+func (t *Thresholds) Set(memoryWarnPercent int, heartbeatInterval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.memoryWarnPercent = memoryWarnPercent
+	t.heartbeatInterval = heartbeatInterval
+}
+
+// MemoryWarnPercent is the percentage of its memory limit past which the
+// process is warned about. It is zero when no warning is wanted.
+func (t *Thresholds) MemoryWarnPercent() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.memoryWarnPercent
+}
+
+// HeartbeatInterval is how long the heartbeat of the process may go without
+// being modified.
+func (t *Thresholds) HeartbeatInterval() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.heartbeatInterval
+}
+
+// SetHeartbeatFailures changes how many heartbeat intervals in a row may be
+// missed before the process is killed.
+func (t *Thresholds) SetHeartbeatFailures(failures int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.heartbeatFailures = failures
+}
+
+// HeartbeatFailures is how many heartbeat intervals in a row may be missed
+// before the process is killed. It is at least one.
+func (t *Thresholds) HeartbeatFailures() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.heartbeatFailures < 1 {
+		return 1
+	}
+
+	return t.heartbeatFailures
+}
+
+// WatchHeartbeat blocks for as long as the process is running and its
+// heartbeat file is modified at least once every heartbeat interval of the
+// thresholds. A process is given a full interval to write its first
+// heartbeat. Each interval which passes without a heartbeat is logged as a
+// failure and once the heartbeat failures of the thresholds have been
+// reached in a row the process is killed and true is returned once it has
+// stopped.
+//
+// The watch also ends if the process is restarted by someone else as the new
+// process has a supervisor of its own.
+func (j *RuncLifecycle) WatchHeartbeat(logger lager.Logger, cfg *config.BPMConfig, heartbeatFile string, thresholds *Thresholds, killRetries int) (bool, error) {
+	ticker := j.clock.NewTicker(HeartbeatPollInterval)
+	defer ticker.Stop()
+
+	var (
+		pid      int
+		failures int
+	)
+	lastBeat := j.clock.Now()
+	for range ticker.C() {
+		interval := thresholds.HeartbeatInterval()
+
+		process, err := j.StatProcess(cfg)
+		if err == nil && process.Status == models.ProcessStatePaused {
+			// A paused process cannot touch its heartbeat file. It is given
+			// a full interval once it is resumed.
+			lastBeat = j.clock.Now()
+			failures = 0
+			continue
+		}
+		if IsNotExist(err) || (err == nil && process.Status != models.ProcessStateRunning) {
+			return false, nil
+		}
+		if err != nil {
+			logger.Error("failed-to-fetch-state", err)
+			continue
+		}
+
+		if pid == 0 {
+			pid = process.Pid
+		} else if process.Pid != pid {
+			return false, nil
+		}
+
+		if fi, err := os.Stat(heartbeatFile); err == nil && fi.ModTime().After(lastBeat) {
+			lastBeat = fi.ModTime()
+			failures = 0
+		}
+
+		if j.clock.Since(lastBeat) <= interval*time.Duration(failures+1) {
+			continue
+		}
+
+		failures++
+		if failures < thresholds.HeartbeatFailures() {
+			logger.Info("heartbeat-missed", lager.Data{"last-heartbeat": lastBeat, "failures": failures})
+			continue
+		}
+
+		logger.Info("heartbeat-stale", lager.Data{"last-heartbeat": lastBeat, "failures": failures})
+		if err := j.KillProcess(logger, cfg, killRetries); err != nil {
+			return false, err
+		}
+
+		j.waitForStop(logger, cfg, interval)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// WatchMemory compares the memory usage of the process with its memory limit
+// until the process exits or done is closed. It logs memory-high when the
+// usage reaches the memory warn percent of the thresholds and memory-normal
+// once it has dropped back below. Like WatchHeartbeat it stops watching if
+// the process is restarted by someone else.
+func (j *RuncLifecycle) WatchMemory(logger lager.Logger, cfg *config.BPMConfig, thresholds *Thresholds, done <-chan struct{}) {
+	ticker := j.clock.NewTicker(MemoryPollInterval)
+	defer ticker.Stop()
+
+	var (
+		pid  int
+		high bool
+	)
+	for {
+		select {
+		case <-ticker.C():
+		case <-done:
+			return
+		}
+
+		process, err := j.StatProcess(cfg)
+		if err == nil && process.Status == models.ProcessStatePaused {
+			continue
+		}
+		if IsNotExist(err) || (err == nil && process.Status != models.ProcessStateRunning) {
+			return
+		}
+		if err != nil {
+			logger.Error("failed-to-fetch-state", err)
+			continue
+		}
+
+		if pid == 0 {
+			pid = process.Pid
+		} else if process.Pid != pid {
+			return
+		}
+
+		memory, err := j.runcClient.ContainerMemory(cfg.ContainerID())
+		if err != nil {
+			logger.Error("failed-to-fetch-memory", err)
+			continue
+		}
+
+		percent := thresholds.MemoryWarnPercent()
+		if memory.Limit == 0 || percent == 0 {
+			continue
+		}
+
+		data := lager.Data{"usage": memory.Usage, "limit": memory.Limit, "percent": percent}
+		if !high && memory.Usage*100 >= memory.Limit*uint64(percent) {
+			high = true
+			logger.Info("memory-high", data)
+		} else if high && memory.Usage*100 < memory.Limit*uint64(percent) {
+			high = false
+			logger.Info("memory-normal", data)
+		}
+	}
+}
+
+// runPostStartHook runs the post-start hook either on the host, with the same
+// environment as the pre-start hook, or inside the container of the process.
+func (j *RuncLifecycle) runPostStartHook(bpmCfg *config.BPMConfig, hooks *config.Hooks, env []string, stdout, stderr io.Writer) error {
+	if hooks.PostStartInContainer {
+		return j.runcClient.ExecCommand(bpmCfg.ContainerID(), []string{hooks.PostStart}, stdout, stderr)
+	}
+
+	postStartCmd := exec.Command(hooks.PostStart)
+	postStartCmd.Env = env
+	postStartCmd.Stdout = stdout
+	postStartCmd.Stderr = stderr
+
+	return j.runHook("post_start", postStartCmd, hooks.PostStartDeadline())
+}
+
+// hookTimeoutError is returned by runHook when a hook is killed for running
+// past its timeout.
+type hookTimeoutError struct {
+	name    string
+	timeout time.Duration
+}
+
+func (e *hookTimeoutError) Error() string {
+	return fmt.Sprintf("hook %s timed out after %s", e.name, e.timeout)
+}
+
+// runHook runs a hook on the host. A hook with a timeout is started in its
+// own process group so that it can be killed along with anything it has
+// started once the timeout has passed.
+func (j *RuncLifecycle) runHook(name string, cmd *exec.Cmd, timeout time.Duration) error {
+	if timeout == 0 {
+		return j.commandRunner.Run(cmd)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	if err := j.commandRunner.Start(cmd); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-j.clock.After(timeout):
+	}
+
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	<-done
+
+	return &hookTimeoutError{name: name, timeout: timeout}
 }
 
 func (j *RuncLifecycle) RunProcess(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) (int, error) {
@@ -135,61 +614,376 @@ func (j *RuncLifecycle) RunProcess(logger lager.Logger, bpmCfg *config.BPMConfig
 	logger.Info("starting")
 	defer logger.Info("complete")
 
-	stdout, stderr, err := j.setupProcess(logger, bpmCfg, procCfg)
+	stdout, stderr, _, err := j.setupProcess(logger, bpmCfg, procCfg)
 	if err != nil {
 		return 0, err
 	}
 	defer stdout.Close()
 	defer stderr.Close()
 
+	var stdoutLog, stderrLog io.Writer = stdout, stderr
+	if procCfg.FifoLogs() {
+		stdoutFifo, err := logpump.NewFifoWriter(stdout)
+		if err != nil {
+			return 0, err
+		}
+		stderrFifo, err := logpump.NewFifoWriter(stderr)
+		if err != nil {
+			return 0, err
+		}
+		stdoutLog, stderrLog = stdoutFifo, stderrFifo
+	}
+
+	if rotate := procCfg.LogRotation(); rotate != nil {
+		stdoutRotating, err := logpump.NewRotatingWriter(stdout, int64(rotate.MaxBytes()), rotate.KeepCount())
+		if err != nil {
+			return 0, err
+		}
+		stderrRotating, err := logpump.NewRotatingWriter(stderr, int64(rotate.MaxBytes()), rotate.KeepCount())
+		if err != nil {
+			return 0, err
+		}
+		stdoutLog, stderrLog = stdoutRotating, stderrRotating
+	}
+
+	if prefix := procCfg.LogPrefix(); prefix != "" {
+		stdoutLog, stderrLog = logpump.NewPrefixWriter(stdoutLog, prefix), logpump.NewPrefixWriter(stderrLog, prefix)
+	}
+
+	// As with the log pump the output is filtered before the prefix is added.
+	if filter := procCfg.LogFilter(); filter != "" {
+		stdoutFilter, err := logpump.NewFilterWriter(stdoutLog, filter)
+		if err != nil {
+			return 0, err
+		}
+		defer stdoutFilter.Close()
+
+		stderrFilter, err := logpump.NewFilterWriter(stderrLog, filter)
+		if err != nil {
+			return 0, err
+		}
+		defer stderrFilter.Close()
+
+		stdoutLog, stderrLog = stdoutFilter, stderrFilter
+	}
+
+	var stdoutW, stderrW io.Writer = io.MultiWriter(stdoutLog, os.Stdout), io.MultiWriter(stderrLog, os.Stderr)
+	if procCfg.LineBufferedLogs() {
+		stdoutLines, stderrLines := logpump.NewLineWriter(stdoutW), logpump.NewLineWriter(stderrW)
+		defer stdoutLines.Close()
+		defer stderrLines.Close()
+		stdoutW, stderrW = stdoutLines, stderrLines
+	}
+
+	sockets, err := listenSockets(logger, procCfg.ListenSockets)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bind listen sockets: %s", err)
+	}
+	defer closeFiles(sockets)
+
 	logger.Info("running-container")
-	return j.runcClient.RunContainer(
-		bpmCfg.PidFile().External(),
-		bpmCfg.BundlePath(),
-		bpmCfg.ContainerID(),
-		false,
-		io.MultiWriter(stdout, os.Stdout),
-		io.MultiWriter(stderr, os.Stderr),
-	)
+	return withIOPriority(procCfg.IONice, func() (int, error) {
+		return j.runcClient.RunContainer(
+			context.Background(),
+			bpmCfg.PidFile().External(),
+			bpmCfg.BundlePath(),
+			bpmCfg.ContainerID(),
+			bpmCfg.RuncLog().External(),
+			false,
+			stdoutW,
+			stderrW,
+			sockets,
+		)
+	})
 }
 
-func (j *RuncLifecycle) setupProcess(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) (io.WriteCloser, io.WriteCloser, error) {
-	user, err := j.userFinder.Lookup(usertools.VcapUser)
+var ioClasses = map[string]ioprio.Class{
+	config.IONiceRealtime:   ioprio.ClassRealtime,
+	config.IONiceBestEffort: ioprio.ClassBestEffort,
+	config.IONiceIdle:       ioprio.ClassIdle,
+}
+
+// withIOPriority calls run on a thread of its own with the I/O priority of
+// the process so that runc, and through it the process, inherit the priority
+// when they are forked. The thread is thrown away once run returns rather
+// than being reused for other goroutines.
+func withIOPriority(ionice *config.IONice, run func() (int, error)) (int, error) {
+	if ionice == nil {
+		return run()
+	}
+
+	type result struct {
+		status int
+		err    error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		if err := ioprio.SetCurrentThread(ioClasses[ionice.Class], ionice.IOLevel()); err != nil {
+			done <- result{status: 1, err: err}
+			return
+		}
+
+		status, err := run()
+		done <- result{status: status, err: err}
+	}()
+
+	r := <-done
+	return r.status, r.err
+}
+
+// RunOneshotProcess runs a process which is expected to exit by itself to
+// completion, like RunProcess, and then removes its bundle so that nothing of
+// it is left behind. runc removes the container itself once it has exited.
+func (j *RuncLifecycle) RunOneshotProcess(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) (int, error) {
+	status, err := j.RunProcess(logger, bpmCfg, procCfg)
+
+	logger.Info("destroying-bundle")
+	if derr := j.runcClient.DestroyBundle(bpmCfg.BundlePath()); derr != nil {
+		logger.Error("failed-to-destroy-bundle", derr)
+	}
+
+	return status, err
+}
+
+// listenSockets binds the listen sockets of the process and returns their
+// file descriptors in order, ready to be passed to the container. The
+// listeners themselves are closed as the container keeps its own copies.
+func listenSockets(logger lager.Logger, cfgs []config.ListenSocket) ([]*os.File, error) {
+	var files []*os.File
+	for _, cfg := range cfgs {
+		f, err := listenSocket(cfg)
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+
+		logger.Info("bound-listen-socket", lager.Data{"network": cfg.ListenNetwork(), "address": cfg.Address})
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+func listenSocket(cfg config.ListenSocket) (*os.File, error) {
+	if cfg.ListenNetwork() == "unix" {
+		// A socket left behind by a previous run would stop the address being
+		// bound again.
+		if fi, err := os.Lstat(cfg.Address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(cfg.Address); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	l, err := net.Listen(cfg.ListenNetwork(), cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	switch l := l.(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		l.SetUnlinkOnClose(false)
+		return l.File()
+	default:
+		return nil, fmt.Errorf("cannot pass a %s listener to the process", cfg.ListenNetwork())
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// LogPumpCommand is the hidden bpm subcommand which copies the output of a
+// detached process into its log files. It reads the output from file
+// descriptors 3 and 4 and writes it to the log files on 5 and 6.
+const LogPumpCommand = "log-pump"
+
+// startLogPump starts a log pump which outlives bpm and copies from a pair of
+// pipes into the stdout and stderr log files. It returns the write ends of the
+// pipes which should be given to the container.
+func (j *RuncLifecycle) startLogPump(stdout, stderr *os.File, procCfg *config.ProcessConfig) (*os.File, *os.File, error) {
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stdoutR.Close()
+
+	stderrR, stderrW, err := os.Pipe()
 	if err != nil {
+		stdoutW.Close()
 		return nil, nil, err
 	}
+	defer stderrR.Close()
+
+	args := []string{LogPumpCommand}
+	if procCfg.LineBufferedLogs() {
+		args = append(args, "--line-buffered")
+	}
+	if procCfg.FifoLogs() {
+		args = append(args, "--fifo")
+	}
+	if prefix := procCfg.LogPrefix(); prefix != "" {
+		args = append(args, "--prefix", prefix)
+	}
+	if filter := procCfg.LogFilter(); filter != "" {
+		args = append(args, "--filter", filter)
+	}
+	if rotate := procCfg.LogRotation(); rotate != nil {
+		args = append(args,
+			"--rotate-size", strconv.FormatUint(rotate.MaxBytes(), 10),
+			"--rotate-keep", strconv.Itoa(rotate.KeepCount()),
+			"--stdout-log", stdout.Name(),
+			"--stderr-log", stderr.Name(),
+		)
+	}
+
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.ExtraFiles = []*os.File{stdoutR, stderrR, stdout, stderr}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := j.commandRunner.Start(cmd); err != nil {
+		stdoutW.Close()
+		stderrW.Close()
+		return nil, nil, err
+	}
+
+	return stdoutW, stderrW, nil
+}
+
+func (j *RuncLifecycle) setupProcess(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) (*os.File, *os.File, []string, error) {
+	user, err := j.userFinder.Lookup(usertools.VcapUser)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	logger.Info("creating-job-prerequisites")
 	stdout, stderr, err := j.runcAdapter.CreateJobPrerequisites(bpmCfg, procCfg, user)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create system files: %s", err.Error())
+		return nil, nil, nil, fmt.Errorf("failed to create system files: %s", err.Error())
+	}
+
+	// The log files are only handed back to be passed to runc so nothing
+	// else would close them if the process cannot be run after all.
+	env, err := j.prepareBundle(logger, bpmCfg, procCfg, user, stdout, stderr)
+	if err != nil {
+		closeFiles([]*os.File{stdout, stderr})
+		return nil, nil, nil, err
 	}
 
+	return stdout, stderr, env, nil
+}
+
+// prepareBundle builds the spec and bundle of the process and runs its
+// pre-start hook, writing the output of the hook to the log files. It returns
+// the environment of the process.
+func (j *RuncLifecycle) prepareBundle(
+	logger lager.Logger,
+	bpmCfg *config.BPMConfig,
+	procCfg *config.ProcessConfig,
+	user specs.User,
+	stdout, stderr *os.File,
+) ([]string, error) {
 	logger.Info("building-spec")
 	spec, err := j.runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	logger.Info("creating-bundle")
 	err = j.runcClient.CreateBundle(bpmCfg.BundlePath(), spec, user)
 	if err != nil {
-		return nil, nil, fmt.Errorf("bundle build failure: %s", err.Error())
+		return nil, fmt.Errorf("bundle build failure: %s", err.Error())
+	}
+
+	if procCfg.RecordSpec {
+		logger.Info("recording-spec")
+		if err := recordSpec(bpmCfg.SpecRecord().External(), spec, procCfg.SensitiveEnv); err != nil {
+			return nil, fmt.Errorf("failed to record spec: %s", err.Error())
+		}
 	}
 
-	if procCfg.Hooks != nil {
+	if procCfg.Hooks != nil && procCfg.Hooks.PreStart != "" {
 		preStartCmd := exec.Command(procCfg.Hooks.PreStart)
 		preStartCmd.Env = spec.Process.Env
 		preStartCmd.Stdout = stdout
 		preStartCmd.Stderr = stderr
 
-		err := j.commandRunner.Run(preStartCmd)
+		if procCfg.Hooks.PreStartUser != "" {
+			hookUser, err := j.userFinder.Lookup(procCfg.Hooks.PreStartUser)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find prestart hook user: %s", err.Error())
+			}
+
+			preStartCmd.SysProcAttr = &syscall.SysProcAttr{
+				Credential: &syscall.Credential{Uid: hookUser.UID, Gid: hookUser.GID},
+			}
+		}
+
+		err := j.runHook("pre_start", preStartCmd, procCfg.Hooks.PreStartDeadline())
 		if err != nil {
-			return nil, nil, fmt.Errorf("prestart hook failed: %s", err.Error())
+			// A hook which hung has held up the start for long enough that
+			// the bundle is removed rather than left behind for the next one.
+			if _, ok := err.(*hookTimeoutError); ok {
+				logger.Error("pre-start-hook-timed-out", err)
+				if derr := j.runcClient.DestroyBundle(bpmCfg.BundlePath()); derr != nil {
+					logger.Error("failed-to-destroy-bundle", derr)
+				}
+			}
+
+			return nil, fmt.Errorf("prestart hook failed: %s", err.Error())
 		}
 	}
 
-	return stdout, stderr, nil
+	return spec.Process.Env, nil
+}
+
+// recordSpec writes the spec a process is run with to path for auditing. The
+// values of sensitive environment variables are left out.
+func recordSpec(path string, spec specs.Spec, sensitiveEnv []string) error {
+	process := *spec.Process
+	process.Env = make([]string, len(spec.Process.Env))
+	for i, kv := range spec.Process.Env {
+		name := strings.SplitN(kv, "=", 2)[0]
+		for _, sensitive := range sensitiveEnv {
+			if name == sensitive {
+				kv = name + "=" + config.RedactedValue
+				break
+			}
+		}
+		process.Env[i] = kv
+	}
+	spec.Process = &process
+
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// ProcessEnvironment returns the environment the process is started with
+// once bpm has added its own variables to those of the configuration.
+func (j *RuncLifecycle) ProcessEnvironment(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) ([]string, error) {
+	user, err := j.userFinder.Lookup(usertools.VcapUser)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := j.runcAdapter.BuildSpec(logger, bpmCfg, procCfg, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return spec.Process.Env, nil
 }
 
 func (j *RuncLifecycle) StatProcess(cfg *config.BPMConfig) (*models.Process, error) {
@@ -202,15 +996,153 @@ func (j *RuncLifecycle) StatProcess(cfg *config.BPMConfig) (*models.Process, err
 		return nil, isNotExistError
 	}
 
-	return newProcessFromContainerState(
+	process := newProcessFromContainerState(
 		container.ID,
 		container.Status,
 		container.Pid,
-	), nil
+	)
+	j.checkOOMKilled(process)
+
+	return process, nil
+}
+
+// checkOOMKilled marks a failed process which was killed for running out of
+// memory. Not being able to tell is not worth failing over so the process is
+// then left as failed.
+func (j *RuncLifecycle) checkOOMKilled(process *models.Process) {
+	if process.Status != models.ProcessStateFailed {
+		return
+	}
+
+	process.OOMKilled, _ = j.runcClient.ContainerOOMKilled(process.Name)
+}
+
+// StatProcessWithRetries behaves like StatProcess but retries failed state
+// queries up to retries times, doubling the wait between each attempt. A
+// process which does not exist is not treated as a failure.
+func (j *RuncLifecycle) StatProcessWithRetries(cfg *config.BPMConfig, retries int) (*models.Process, error) {
+	var process *models.Process
+
+	err := j.withRetries(retries, func() error {
+		var err error
+		process, err = j.StatProcess(cfg)
+		return err
+	})
+
+	return process, err
+}
+
+// OpenShell runs an interactive shell inside the process container. Any
+// variables in the sensitive_env of the process are left out of the
+// environment of the shell.
+func (j *RuncLifecycle) OpenShell(cfg *config.BPMConfig, procCfg *config.ProcessConfig, term string, stdin io.Reader, stdout, stderr io.Writer) error {
+	command := []string{"/bin/bash"}
+	env := []string{fmt.Sprintf("TERM=%s", term)}
+
+	if len(procCfg.SensitiveEnv) == 0 {
+		return j.runcClient.Exec(cfg.ContainerID(), command, env, stdin, stdout, stderr)
+	}
+
+	// runc exec can only add to the environment of the container so the
+	// shell is run as a copy of the process of the container without them.
+	spec, err := j.runcClient.BundleSpec(cfg.BundlePath())
+	if err != nil {
+		return err
+	}
+	if spec.Process == nil {
+		return errors.New("the bundle of the process has no process")
+	}
+
+	process := *spec.Process
+	process.Args = command
+	process.Env = append(withoutEnv(process.Env, procCfg.SensitiveEnv), env...)
+
+	return j.runcClient.ExecProcess(cfg.ContainerID(), process, stdin, stdout, stderr)
+}
+
+// withoutEnv returns the environment without the variables with the given
+// names.
+func withoutEnv(env, names []string) []string {
+	left := make(map[string]bool, len(names))
+	for _, name := range names {
+		left[name] = true
+	}
+
+	var kept []string
+	for _, kv := range env {
+		if !left[strings.SplitN(kv, "=", 2)[0]] {
+			kept = append(kept, kv)
+		}
+	}
+
+	return kept
+}
+
+// StreamEvents writes the runc events for the process container to stdout
+// until the container goes away or done is closed.
+func (j *RuncLifecycle) StreamEvents(cfg *config.BPMConfig, stdout io.Writer, done <-chan struct{}) error {
+	return j.runcClient.Events(cfg.ContainerID(), stdout, done)
+}
+
+// FollowEvents behaves like StreamEvents but keeps streaming across container
+// restarts. When the event stream ends it waits for a new instance of the
+// container to be running, writes a synthetic restart event and reattaches.
+// It returns when done is closed.
+func (j *RuncLifecycle) FollowEvents(logger lager.Logger, cfg *config.BPMConfig, stdout io.Writer, done <-chan struct{}) error {
+	var pid int
+	attached := false
+
+	for {
+		process, ok := j.waitForRestart(cfg, pid, done)
+		if !ok {
+			return nil
+		}
+
+		if attached {
+			event := restartEvent{Type: "restart", ID: cfg.ContainerID()}
+			if err := json.NewEncoder(stdout).Encode(event); err != nil {
+				return err
+			}
+		}
+
+		attached = true
+		pid = process.Pid
+
+		if err := j.runcClient.Events(cfg.ContainerID(), stdout, done); err != nil {
+			logger.Info("event-stream-ended", lager.Data{"error": err.Error()})
+		}
+
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+	}
 }
 
-func (j *RuncLifecycle) OpenShell(cfg *config.BPMConfig, stdin io.Reader, stdout, stderr io.Writer) error {
-	return j.runcClient.Exec(cfg.ContainerID(), "/bin/bash", stdin, stdout, stderr)
+type restartEvent struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// waitForRestart polls until the container is running with a pid other than
+// previousPid. It returns false if done is closed first.
+func (j *RuncLifecycle) waitForRestart(cfg *config.BPMConfig, previousPid int, done <-chan struct{}) (*models.Process, bool) {
+	ticker := j.clock.NewTicker(ContainerStatePollInterval)
+	defer ticker.Stop()
+
+	for {
+		process, err := j.StatProcess(cfg)
+		if err == nil && process.Status == models.ProcessStateRunning && process.Pid != previousPid {
+			return process, true
+		}
+
+		select {
+		case <-done:
+			return nil, false
+		case <-ticker.C():
+		}
+	}
 }
 
 func (j *RuncLifecycle) ListProcesses() ([]*models.Process, error) {
@@ -221,32 +1153,258 @@ func (j *RuncLifecycle) ListProcesses() ([]*models.Process, error) {
 
 	var processes []*models.Process
 	for _, c := range containers {
-		processes = append(processes, newProcessFromContainerState(
+		process := newProcessFromContainerState(
 			c.ID,
 			containerStateFromString(c.Status),
 			c.InitProcessPid,
-		))
+		)
+		process.Created = c.Created
+		process.Annotations = c.Annotations
+		j.checkOOMKilled(process)
+		processes = append(processes, process)
 	}
 
 	return processes, nil
 }
 
-func (j *RuncLifecycle) StopProcess(logger lager.Logger, cfg *config.BPMConfig, exitTimeout time.Duration) error {
-	err := j.runcClient.SignalContainer(cfg.ContainerID(), client.Term)
+// ProcessStats returns the resources the container of a process, named as by
+// ListProcesses, is using.
+func (j *RuncLifecycle) ProcessStats(name string) (*client.ContainerStats, error) {
+	return j.runcClient.ContainerStats(name)
+}
+
+// ProcessPids returns the host pids of every process in the container of the
+// process, including its init process.
+func (j *RuncLifecycle) ProcessPids(cfg *config.BPMConfig) ([]int, error) {
+	return j.runcClient.ContainerPids(cfg.ContainerID())
+}
+
+// ListProcessesWithRetries behaves like ListProcesses but retries failed
+// queries up to retries times, doubling the wait between each attempt.
+func (j *RuncLifecycle) ListProcessesWithRetries(retries int) ([]*models.Process, error) {
+	var processes []*models.Process
+
+	err := j.withRetries(retries, func() error {
+		var err error
+		processes, err = j.ListProcesses()
+		return err
+	})
+
+	return processes, err
+}
+
+func (j *RuncLifecycle) withRetries(retries int, f func() error) error {
+	interval := ContainerStateRetryInterval
+
+	err := f()
+	for attempt := 0; attempt < retries && err != nil && !IsNotExist(err); attempt++ {
+		j.clock.Sleep(interval)
+		interval *= 2
+
+		err = f()
+	}
+
+	return err
+}
+
+// StopProcess sends a SIGTERM to the process and waits up to exitTimeout for
+// it to exit. Sending the signal is retried up to killRetries times as runc
+// can fail to signal a container which is transitioning between states.
+func (j *RuncLifecycle) StopProcess(logger lager.Logger, cfg *config.BPMConfig, exitTimeout time.Duration, killRetries int) error {
+	if err := j.signalWithRetries(logger, cfg, client.Term, killRetries); err != nil {
+		return err
+	}
+
+	if j.waitForStop(logger, cfg, exitTimeout) {
+		return nil
+	}
+
+	err := j.runcClient.SignalContainer(cfg.ContainerID(), client.Quit)
 	if err != nil {
+		logger.Error("failed-to-sigquit", err)
+	}
+
+	j.clock.Sleep(ContainerSigQuitGracePeriod)
+	return timeoutError
+}
+
+// PauseProcess freezes every process in the container without stopping
+// them. They carry on from where they were when ResumeProcess is called.
+func (j *RuncLifecycle) PauseProcess(logger lager.Logger, cfg *config.BPMConfig) error {
+	logger = logger.Session("pause-process")
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	return j.runcClient.PauseContainer(cfg.ContainerID())
+}
+
+// DrainCheckStatusArg is passed to the drain hook when it is run again to
+// check on a drain it asked to be given more time for, as BOSH does with drain
+// scripts.
+const DrainCheckStatusArg = "job_check_status"
+
+// DrainProcess runs the drain hook inside the container of the process and
+// waits for the drain to complete, following the output contract of BOSH drain
+// scripts. A hook which prints nothing, or zero, has drained the process. A
+// positive number is how many seconds to wait before the drain is complete. A
+// negative number asks for the hook to be run again after that many seconds
+// to check whether it has finished. The container is left running.
+func (j *RuncLifecycle) DrainProcess(logger lager.Logger, cfg *config.BPMConfig, hooks *config.Hooks, stderr io.Writer) error {
+	logger = logger.Session("drain-process")
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	command := []string{hooks.Drain}
+	for {
+		var stdout bytes.Buffer
+		if err := j.runcClient.ExecCommand(cfg.ContainerID(), command, &stdout, stderr); err != nil {
+			return fmt.Errorf("drain hook failed: %s", err)
+		}
+
+		output := strings.TrimSpace(stdout.String())
+		if output == "" {
+			return nil
+		}
+
+		seconds, err := strconv.Atoi(output)
+		if err != nil {
+			return fmt.Errorf("drain hook printed %q, expected a number of seconds", output)
+		}
+
+		wait := time.Duration(seconds) * time.Second
+		if seconds == 0 {
+			return nil
+		} else if seconds > 0 {
+			logger.Info("waiting-for-drain", lager.Data{"wait": wait.String()})
+			<-j.clock.After(wait)
+			return nil
+		}
+
+		logger.Info("checking-drain-status", lager.Data{"wait": (-wait).String()})
+		<-j.clock.After(-wait)
+		command = []string{hooks.Drain, DrainCheckStatusArg}
+	}
+}
+
+func (j *RuncLifecycle) ResumeProcess(logger lager.Logger, cfg *config.BPMConfig) error {
+	logger = logger.Session("resume-process")
+	logger.Info("starting")
+	defer logger.Info("complete")
+
+	return j.runcClient.ResumeContainer(cfg.ContainerID())
+}
+
+// KillProcess sends SIGKILL to the process without giving it a chance to
+// exit by itself.
+func (j *RuncLifecycle) KillProcess(logger lager.Logger, cfg *config.BPMConfig, killRetries int) error {
+	return j.signalWithRetries(logger, cfg, client.Kill, killRetries)
+}
+
+// StopSignal is a single step of a custom stop sequence: the signal is sent
+// to the process which is then given Wait to exit before the next step.
+type StopSignal struct {
+	Signal client.Signal
+	Wait   time.Duration
+}
+
+// StopProcessWithSignals walks through signals in order until the process
+// exits. A timeout error is returned if it is still running after the last
+// step.
+func (j *RuncLifecycle) StopProcessWithSignals(logger lager.Logger, cfg *config.BPMConfig, signals []StopSignal, killRetries int) error {
+	return j.stopWithSignals(logger, cfg, signals, killRetries, j.signalWithRetries)
+}
+
+// StopFrozenProcess is StopProcessWithSignals for processes with
+// freeze_on_stop. Each signal is sent to every process in the container, not
+// just its init process, while the container is frozen so that none of them
+// can exit, fork or change how they handle the signal before all of them have
+// been sent it.
+func (j *RuncLifecycle) StopFrozenProcess(logger lager.Logger, cfg *config.BPMConfig, signals []StopSignal, killRetries int) error {
+	return j.stopWithSignals(logger, cfg, signals, killRetries, j.signalFrozenWithRetries)
+}
+
+type signalFunc func(logger lager.Logger, cfg *config.BPMConfig, signal client.Signal, retries int) error
+
+func (j *RuncLifecycle) stopWithSignals(logger lager.Logger, cfg *config.BPMConfig, signals []StopSignal, killRetries int, signal signalFunc) error {
+	for _, s := range signals {
+		if err := signal(logger, cfg, s.Signal, killRetries); err != nil {
+			return err
+		}
+
+		if j.waitForStop(logger, cfg, s.Wait) {
+			return nil
+		}
+	}
+
+	return timeoutError
+}
+
+func (j *RuncLifecycle) signalWithRetries(logger lager.Logger, cfg *config.BPMConfig, signal client.Signal, retries int) error {
+	err := j.withRetries(retries, func() error {
+		err := j.runcClient.SignalContainer(cfg.ContainerID(), signal)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed-to-sig%s", strings.ToLower(signal.String())), err)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send SIG%s after %d attempt(s): %s", signal, retries+1, err)
+	}
+
+	return nil
+}
+
+func (j *RuncLifecycle) signalFrozenWithRetries(logger lager.Logger, cfg *config.BPMConfig, signal client.Signal, retries int) error {
+	err := j.withRetries(retries, func() error {
+		err := j.signalFrozen(logger, cfg, signal)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed-to-sig%s-frozen", strings.ToLower(signal.String())), err)
+		}
 		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send SIG%s after %d attempt(s): %s", signal, retries+1, err)
+	}
+
+	return nil
+}
+
+// signalFrozen freezes the container, signals every process in it and then
+// thaws it again. The container is thawed even if signalling fails so that a
+// later step of the stop can still reach the processes.
+func (j *RuncLifecycle) signalFrozen(logger lager.Logger, cfg *config.BPMConfig, signal client.Signal) error {
+	if err := j.runcClient.PauseContainer(cfg.ContainerID()); err != nil {
+		return fmt.Errorf("failed to freeze container: %s", err)
 	}
+	defer func() {
+		if err := j.runcClient.ResumeContainer(cfg.ContainerID()); err != nil {
+			logger.Error("failed-to-thaw", err)
+		}
+	}()
+
+	pids, err := j.runcClient.ContainerPids(cfg.ContainerID())
+	if err != nil {
+		return fmt.Errorf("failed to list container processes: %s", err)
+	}
+
+	logger.Info("signalling-frozen-processes", lager.Data{"signal": signal.String(), "pids": pids})
+	return j.runcClient.SignalProcesses(pids, signal)
+}
 
+// waitForStop polls the state of the container until it has stopped or the
+// timeout has passed. It returns whether the container stopped.
+func (j *RuncLifecycle) waitForStop(logger lager.Logger, cfg *config.BPMConfig, timeout time.Duration) bool {
 	state, err := j.runcClient.ContainerState(cfg.ContainerID())
 	if err != nil {
 		logger.Error("failed-to-fetch-state", err)
 	} else {
 		if state.Status == ContainerStateStopped {
-			return nil
+			return true
 		}
 	}
 
-	timeout := j.clock.NewTimer(exitTimeout)
+	timer := j.clock.NewTimer(timeout)
+	defer timer.Stop()
 	stateTicker := j.clock.NewTicker(ContainerStatePollInterval)
 	defer stateTicker.Stop()
 
@@ -258,17 +1416,11 @@ func (j *RuncLifecycle) StopProcess(logger lager.Logger, cfg *config.BPMConfig,
 				logger.Error("failed-to-fetch-state", err)
 			} else {
 				if state.Status == ContainerStateStopped {
-					return nil
+					return true
 				}
 			}
-		case <-timeout.C():
-			err := j.runcClient.SignalContainer(cfg.ContainerID(), client.Quit)
-			if err != nil {
-				logger.Error("failed-to-sigquit", err)
-			}
-
-			j.clock.Sleep(ContainerSigQuitGracePeriod)
-			return timeoutError
+		case <-timer.C():
+			return false
 		}
 	}
 }
@@ -288,6 +1440,14 @@ func (j *RuncLifecycle) RemoveProcess(logger lager.Logger, cfg *config.BPMConfig
 	return j.deleteFile(cfg.PidFile().External())
 }
 
+// RemoveContainer deletes the container of a process whose job and process
+// are not known. Its bundle and pid file are left behind as there is no
+// telling where they are.
+func (j *RuncLifecycle) RemoveContainer(logger lager.Logger, cfg *config.BPMConfig) error {
+	logger.Info("forcefully-deleting-container")
+	return j.runcClient.DeleteContainer(cfg.ContainerID())
+}
+
 func newProcessFromContainerState(id string, status specs.ContainerState, pid int) *models.Process {
 	return &models.Process{
 		Name:   id,
@@ -298,8 +1458,9 @@ func newProcessFromContainerState(id string, status specs.ContainerState, pid in
 
 type commandRunner struct{}
 
-func NewCommandRunner() CommandRunner          { return &commandRunner{} }
-func (*commandRunner) Run(cmd *exec.Cmd) error { return cmd.Run() }
+func NewCommandRunner() CommandRunner            { return &commandRunner{} }
+func (*commandRunner) Run(cmd *exec.Cmd) error   { return cmd.Run() }
+func (*commandRunner) Start(cmd *exec.Cmd) error { return cmd.Start() }
 
 func containerStateToString(cs specs.ContainerState) string {
 	switch cs {
@@ -309,6 +1470,8 @@ func containerStateToString(cs specs.ContainerState) string {
 		return models.ProcessStateCreated
 	case specs.StateRunning:
 		return models.ProcessStateRunning
+	case ContainerStatePaused:
+		return models.ProcessStatePaused
 	case specs.StateStopped:
 		return models.ProcessStateFailed
 	default:
@@ -324,6 +1487,8 @@ func containerStateFromString(status string) specs.ContainerState {
 		return specs.StateCreated
 	case models.ProcessStateRunning:
 		return specs.StateRunning
+	case models.ProcessStatePaused:
+		return ContainerStatePaused
 	case models.ProcessStateFailed:
 		return specs.StateStopped
 	default:
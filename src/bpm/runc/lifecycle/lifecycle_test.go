@@ -16,12 +16,17 @@
 package lifecycle_test
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
@@ -37,6 +42,7 @@ import (
 
 	"bpm/bosh"
 	"bpm/config"
+	"bpm/ioprio"
 	"bpm/jobid"
 	"bpm/models"
 	"bpm/runc/client"
@@ -162,7 +168,7 @@ var _ = Describe("RuncJobLifecycle", func() {
 
 		fakeRuncClient.
 			EXPECT().
-			RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 			AnyTimes()
 
 		fakeRuncClient.
@@ -175,6 +181,12 @@ var _ = Describe("RuncJobLifecycle", func() {
 			DestroyBundle(gomock.Any()).
 			AnyTimes()
 
+		fakeRuncClient.
+			EXPECT().
+			ContainerOOMKilled(gomock.Any()).
+			Return(false, nil).
+			AnyTimes()
+
 		fakeCommandRunner.
 			EXPECT().
 			Run(gomock.Any()).
@@ -204,6 +216,54 @@ var _ = Describe("RuncJobLifecycle", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
 
+			Context("when the PreStart Hook has a user", func() {
+				BeforeEach(func() {
+					procCfg.Hooks.PreStartUser = "hook-user"
+				})
+
+				It("executes the pre start hook as that user", func() {
+					fakeUserFinder.
+						EXPECT().
+						Lookup("hook-user").
+						Return(specs.User{Username: "hook-user", UID: 500, GID: 600}, nil)
+
+					expectedCommand := exec.Command(procCfg.Hooks.PreStart)
+					expectedCommand.Stdout = expectedStdout
+					expectedCommand.Stderr = expectedStderr
+					expectedCommand.Env = []string{"foo=bar"}
+					expectedCommand.SysProcAttr = &syscall.SysProcAttr{
+						Credential: &syscall.Credential{Uid: 500, Gid: 600},
+					}
+
+					fakeCommandRunner.
+						EXPECT().
+						Run(expectedCommand).
+						Times(1)
+
+					err := run(logger, bpmCfg, procCfg)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				Context("when the user cannot be found", func() {
+					BeforeEach(func() {
+						fakeUserFinder.
+							EXPECT().
+							Lookup("hook-user").
+							Return(specs.User{}, errors.New("fake test error"))
+
+						fakeCommandRunner.
+							EXPECT().
+							Run(gomock.Any()).
+							Times(0)
+					})
+
+					It("returns an error without running the hook", func() {
+						err := run(logger, bpmCfg, procCfg)
+						Expect(err).To(HaveOccurred())
+					})
+				})
+			})
+
 			Context("when the PreStart Hook fails", func() {
 				BeforeEach(func() {
 					fakeCommandRunner.
@@ -218,6 +278,49 @@ var _ = Describe("RuncJobLifecycle", func() {
 					Expect(err).To(HaveOccurred())
 				})
 			})
+
+			Context("when the PreStart Hook runs past its timeout", func() {
+				var hookDir string
+
+				BeforeEach(func() {
+					var err error
+					hookDir, err = ioutil.TempDir("", "runc-lifecycle-hook")
+					Expect(err).NotTo(HaveOccurred())
+
+					hook := filepath.Join(hookDir, "pre-start")
+					Expect(ioutil.WriteFile(hook, []byte("#!/bin/sh\nsleep 60\n"), 0700)).To(Succeed())
+
+					procCfg.Hooks.PreStart = hook
+					procCfg.Hooks.PreStartTimeout = "10s"
+
+					fakeCommandRunner.
+						EXPECT().
+						Start(gomock.Any()).
+						DoAndReturn(func(cmd *exec.Cmd) error {
+							if err := cmd.Start(); err != nil {
+								return err
+							}
+
+							go fakeClock.WaitForWatcherAndIncrement(10 * time.Second)
+							return nil
+						}).
+						Times(1)
+
+					fakeRuncClient.
+						EXPECT().
+						DestroyBundle(bpmCfg.BundlePath()).
+						Times(1)
+				})
+
+				AfterEach(func() {
+					Expect(os.RemoveAll(hookDir)).To(Succeed())
+				})
+
+				It("kills the hook, removes the bundle, and returns an error", func() {
+					err := run(logger, bpmCfg, procCfg)
+					Expect(err).To(MatchError("prestart hook failed: hook pre_start timed out after 10s"))
+				})
+			})
 		})
 
 		Context("when PreStart Hook is empty", func() {
@@ -239,7 +342,7 @@ var _ = Describe("RuncJobLifecycle", func() {
 
 				fakeRuncClient.
 					EXPECT().
-					RunContainer(gomock.Any(), gomock.Any(), jobid.Encode(expectedJobName), gomock.Any(), gomock.Any(), gomock.Any()).
+					RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), jobid.Encode(expectedJobName), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 					Times(1)
 			})
 
@@ -290,6 +393,16 @@ var _ = Describe("RuncJobLifecycle", func() {
 				err := run(logger, bpmCfg, procCfg)
 				Expect(err).To(HaveOccurred())
 			})
+
+			It("closes the log files", func() {
+				err := run(logger, bpmCfg, procCfg)
+				Expect(err).To(HaveOccurred())
+
+				_, err = expectedStdout.Stat()
+				Expect(err).To(MatchError(ContainSubstring("file already closed")))
+				_, err = expectedStderr.Stat()
+				Expect(err).To(MatchError(ContainSubstring("file already closed")))
+			})
 		})
 
 		Context("when building the bundle fails", func() {
@@ -331,12 +444,15 @@ var _ = Describe("RuncJobLifecycle", func() {
 			fakeRuncClient.
 				EXPECT().
 				RunContainer(
+					gomock.Any(),
 					bpmCfg.PidFile().External(),
 					rootPath,
 					expectedContainerID,
+					bpmCfg.RuncLog().External(),
 					true,
 					expectedStdout,
 					expectedStderr,
+					nil,
 				).
 				Times(1)
 
@@ -346,259 +462,1582 @@ var _ = Describe("RuncJobLifecycle", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
-		Context("when running the container fails", func() {
+		Context("when the process has an ionice class", func() {
 			BeforeEach(func() {
+				level := 6
+				procCfg.IONice = &config.IONice{Class: config.IONiceBestEffort, Level: &level}
+			})
+
+			It("runs the container from a thread with that io priority", func() {
+				var (
+					class ioprio.Class
+					level int
+				)
 				fakeRuncClient.
 					EXPECT().
-					RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
-					Return(1, errors.New("fake test error"))
-			})
+					RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(context.Context, string, string, string, string, bool, io.Writer, io.Writer, []*os.File) (int, error) {
+						var err error
+						class, level, err = ioprio.Get(syscall.Gettid())
+						return 0, err
+					})
 
-			It("returns an error", func() {
 				setupMockDefaults()
 
 				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
-				Expect(err).To(HaveOccurred())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(class).To(Equal(ioprio.ClassBestEffort))
+				Expect(level).To(Equal(6))
 			})
 		})
 
-		ItSetsUpAndRunsAProcess(func(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) error {
-			setupMockDefaults()
-
-			return runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
-		})
-	})
-
-	Describe("RunProcess", func() {
-		It("builds the runc spec, bundle, and runs the container", func() {
-			fakeRuncAdapter.
-				EXPECT().
-				CreateJobPrerequisites(bpmCfg, procCfg, expectedUser).
-				Return(expectedStdout, expectedStderr, nil).
-				Times(1)
-
+		It("does not leak file descriptors when it is started repeatedly", func() {
 			fakeRuncAdapter.
 				EXPECT().
-				BuildSpec(gomock.Any(), bpmCfg, procCfg, expectedUser).
-				Return(jobSpec, nil).
-				Times(1)
-
-			rootPath := filepath.Join(expectedSystemRoot, "data", "bpm", "bundles", expectedJobName, expectedProcName)
-			fakeRuncClient.
-				EXPECT().
-				CreateBundle(rootPath, jobSpec, expectedUser).
-				Times(1)
-
+				CreateJobPrerequisites(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(*config.BPMConfig, *config.ProcessConfig, specs.User) (*os.File, *os.File, error) {
+					stdout, err := os.Open(expectedStdout.Name())
+					Expect(err).NotTo(HaveOccurred())
+					stderr, err := os.Open(expectedStderr.Name())
+					Expect(err).NotTo(HaveOccurred())
+					return stdout, stderr, nil
+				}).
+				AnyTimes()
+
+			// Every other start fails once the log files have been opened.
+			var starts int
 			fakeRuncClient.
 				EXPECT().
-				RunContainer(
-					bpmCfg.PidFile().External(),
-					rootPath,
-					expectedContainerID,
-					false,
-					gomock.Any(), // We can't assert on these because the function wraps them in io.MultiWriters.
-					gomock.Any(),
-				).
-				Return(0, nil).
-				Times(1)
+				CreateBundle(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(string, specs.Spec, specs.User) error {
+					starts++
+					if starts%2 == 0 {
+						return errors.New("fake test error")
+					}
+					return nil
+				}).
+				AnyTimes()
 
 			setupMockDefaults()
 
-			status, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(status).To(Equal(0))
+			openFDs := func() int {
+				fds, err := ioutil.ReadDir("/proc/self/fd")
+				Expect(err).NotTo(HaveOccurred())
+				return len(fds)
+			}
+
+			runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+			before := openFDs()
+
+			for i := 0; i < 20; i++ {
+				runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+			}
+
+			Expect(openFDs()).To(BeNumerically("<=", before))
 		})
 
-		Context("when running the container fails", func() {
+		Context("when the spec is recorded", func() {
+			var root, logDir string
+
 			BeforeEach(func() {
-				fakeRuncClient.
-					EXPECT().
-					RunContainer(
-						gomock.Any(),
-						gomock.Any(),
-						gomock.Any(),
-						gomock.Any(),
-						gomock.Any(),
-						gomock.Any(),
-					).
-					Return(1, errors.New("fake test error"))
+				var err error
+				root, err = ioutil.TempDir("", "runc-lifecycle-root")
+				Expect(err).NotTo(HaveOccurred())
+				bpmCfg = config.NewBPMConfig(bosh.NewEnv(root), expectedJobName, expectedProcName)
+				logDir = bpmCfg.LogDir().External()
+				Expect(os.MkdirAll(logDir, 0700)).To(Succeed())
+
+				jobSpec.Process.Env = []string{"foo=bar", "PASSWORD=hunter2"}
+				procCfg.RecordSpec = true
+				procCfg.SensitiveEnv = []string{"PASSWORD"}
 			})
 
-			It("returns an error", func() {
+			AfterEach(func() {
+				Expect(os.RemoveAll(root)).To(Succeed())
+			})
+
+			It("writes the spec to the log directory with sensitive values redacted", func() {
 				setupMockDefaults()
 
-				status, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg)
-				Expect(err).To(HaveOccurred())
-				Expect(status).To(Equal(1))
-			})
-		})
+				Expect(runcLifecycle.StartProcess(logger, bpmCfg, procCfg)).To(Succeed())
 
-		ItSetsUpAndRunsAProcess(func(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) error {
-			setupMockDefaults()
-			// status is tested separately
-			_, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg)
-			return err
+				data, err := ioutil.ReadFile(filepath.Join(logDir, expectedProcName+".spec.json"))
+				Expect(err).NotTo(HaveOccurred())
+
+				var recorded specs.Spec
+				Expect(json.Unmarshal(data, &recorded)).To(Succeed())
+				Expect(recorded.Version).To(Equal("example-version"))
+				Expect(recorded.Process.Env).To(Equal([]string{"foo=bar", "PASSWORD=<redacted>"}))
+				Expect(jobSpec.Process.Env).To(ContainElement("PASSWORD=hunter2"))
+			})
 		})
-	})
 
-	Describe("StopProcess", func() {
-		var exitTimeout time.Duration
+		Context("when a PostStart Hook is provided", func() {
+			BeforeEach(func() {
+				procCfg.Hooks = &config.Hooks{PostStart: "/var/vcap/jobs/example/bin/register"}
+			})
 
-		BeforeEach(func() {
-			exitTimeout = 5 * time.Second
-		})
+			It("runs the hook on the host after the container is running", func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+						Return(0, nil),
+					fakeCommandRunner.
+						EXPECT().
+						Run(gomock.Any()).
+						DoAndReturn(func(cmd *exec.Cmd) error {
+							Expect(cmd.Path).To(Equal("/var/vcap/jobs/example/bin/register"))
+							Expect(cmd.Env).To(Equal(jobSpec.Process.Env))
+							return nil
+						}),
+				)
 
-		It("stops the container", func() {
-			fakeRuncClient.
-				EXPECT().
-				ContainerState(expectedContainerID).
-				Return(&specs.State{
-					Status: "stopped",
-				}, nil)
+				setupMockDefaults()
 
-			fakeRuncClient.
-				EXPECT().
-				SignalContainer(expectedContainerID, client.Term).
-				Times(1)
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).NotTo(HaveOccurred())
+			})
 
-			setupMockDefaults()
-			err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout)
-			Expect(err).ToNot(HaveOccurred())
-		})
+			Context("when the hook runs inside the container", func() {
+				BeforeEach(func() {
+					procCfg.Hooks.PostStartInContainer = true
+				})
 
-		Context("when the container does not stop immediately", func() {
-			It("polls the container state every second until it stops", func() {
-				gomock.InOrder(
+				It("execs the hook in the container", func() {
 					fakeRuncClient.
 						EXPECT().
-						SignalContainer(expectedContainerID, client.Term).
-						Times(1),
-					fakeRuncClient.
+						ExecCommand(expectedContainerID, []string{"/var/vcap/jobs/example/bin/register"}, expectedStdout, expectedStderr).
+						Times(1)
+
+					fakeCommandRunner.
 						EXPECT().
-						ContainerState(expectedContainerID).
-						DoAndReturn(func(id string) (*specs.State, error) {
-							go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerStatePollInterval, 2)
-							return &specs.State{Status: "running"}, nil
-						}).
-						Times(1),
-					fakeRuncClient.
+						Run(gomock.Any()).
+						Times(0)
+
+					setupMockDefaults()
+
+					err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("when the hook fails", func() {
+				BeforeEach(func() {
+					fakeCommandRunner.
 						EXPECT().
-						ContainerState(expectedContainerID).
-						DoAndReturn(func(id string) (*specs.State, error) {
-							go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerStatePollInterval, 2)
-							return &specs.State{Status: "running"}, nil
-						}).
-						Times(1),
+						Run(gomock.Any()).
+						Return(errors.New("fake test error"))
+				})
+
+				It("leaves the process running", func() {
 					fakeRuncClient.
 						EXPECT().
-						ContainerState(expectedContainerID).
-						DoAndReturn(func(id string) (*specs.State, error) {
-							return &specs.State{Status: "stopped"}, nil
-						}).
-						Times(1),
-				)
+						DeleteContainer(gomock.Any()).
+						Times(0)
+
+					setupMockDefaults()
+
+					err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				Context("when the failure should abort the start", func() {
+					BeforeEach(func() {
+						procCfg.Hooks.PostStartAbortOnFailure = true
+					})
+
+					It("removes the process and returns an error", func() {
+						fakeRuncClient.
+							EXPECT().
+							DeleteContainer(expectedContainerID).
+							Times(1)
+
+						setupMockDefaults()
+
+						err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+						Expect(err).To(MatchError(ContainSubstring("post-start hook failed")))
+					})
+				})
+			})
+		})
+
+		Context("when the logs are line buffered", func() {
+			BeforeEach(func() {
+				procCfg.Logs = &config.Logs{LineBuffered: true}
+			})
+
+			It("runs the container with its output going through a log pump", func() {
+				fakeCommandRunner.
+					EXPECT().
+					Start(gomock.Any()).
+					DoAndReturn(func(cmd *exec.Cmd) error {
+						Expect(cmd.Args).To(Equal([]string{"/proc/self/exe", lifecycle.LogPumpCommand, "--line-buffered"}))
+						Expect(cmd.ExtraFiles).To(HaveLen(4))
+						Expect(cmd.ExtraFiles[2]).To(Equal(expectedStdout))
+						Expect(cmd.ExtraFiles[3]).To(Equal(expectedStderr))
+						return nil
+					})
+
+				fakeRuncClient.
+					EXPECT().
+					RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), true, gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _, _, _, _ string, _ bool, stdout, stderr io.Writer, _ []*os.File) (int, error) {
+						Expect(stdout).NotTo(Equal(expectedStdout))
+						Expect(stderr).NotTo(Equal(expectedStderr))
+						return 0, nil
+					})
+
+				setupMockDefaults()
+
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			Context("when the logs also have a prefix", func() {
+				BeforeEach(func() {
+					procCfg.Logs.Prefix = "[server] "
+				})
+
+				It("passes the prefix to the log pump", func() {
+					fakeCommandRunner.
+						EXPECT().
+						Start(gomock.Any()).
+						DoAndReturn(func(cmd *exec.Cmd) error {
+							Expect(cmd.Args).To(Equal([]string{"/proc/self/exe", lifecycle.LogPumpCommand, "--line-buffered", "--prefix", "[server] "}))
+							return nil
+						})
+
+					setupMockDefaults()
+
+					err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("when the logs are also filtered", func() {
+				BeforeEach(func() {
+					procCfg.Logs.Filter = "/var/vcap/packages/example/bin/log-filter"
+				})
+
+				It("passes the filter to the log pump", func() {
+					fakeCommandRunner.
+						EXPECT().
+						Start(gomock.Any()).
+						DoAndReturn(func(cmd *exec.Cmd) error {
+							Expect(cmd.Args).To(Equal([]string{"/proc/self/exe", lifecycle.LogPumpCommand, "--line-buffered", "--filter", "/var/vcap/packages/example/bin/log-filter"}))
+							return nil
+						})
+
+					setupMockDefaults()
+
+					err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("when the logs are also rotated", func() {
+				BeforeEach(func() {
+					procCfg.Logs.Rotate = &config.LogRotation{MaxSize: "1K", Keep: 3}
+				})
+
+				It("passes the rotation and the log file paths to the log pump", func() {
+					fakeCommandRunner.
+						EXPECT().
+						Start(gomock.Any()).
+						DoAndReturn(func(cmd *exec.Cmd) error {
+							Expect(cmd.Args).To(Equal([]string{
+								"/proc/self/exe", lifecycle.LogPumpCommand, "--line-buffered",
+								"--rotate-size", "1024",
+								"--rotate-keep", "3",
+								"--stdout-log", expectedStdout.Name(),
+								"--stderr-log", expectedStderr.Name(),
+							}))
+							return nil
+						})
+
+					setupMockDefaults()
+
+					err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("when the log pump cannot be started", func() {
+				It("returns an error", func() {
+					fakeCommandRunner.
+						EXPECT().
+						Start(gomock.Any()).
+						Return(errors.New("fake test error"))
+
+					setupMockDefaults()
+
+					err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+					Expect(err).To(MatchError(ContainSubstring("failed to start log pump")))
+				})
+			})
+		})
+
+		Context("when the process has a liveness check", func() {
+			BeforeEach(func() {
+				procCfg.Liveness = &config.Liveness{HeartbeatFile: "/var/vcap/data/example/heartbeat", Interval: "10s"}
+			})
+
+			It("starts a supervisor for the process once it is running", func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), true, gomock.Any(), gomock.Any(), gomock.Any()),
+					fakeCommandRunner.
+						EXPECT().
+						Start(gomock.Any()).
+						DoAndReturn(func(cmd *exec.Cmd) error {
+							Expect(cmd.Args).To(Equal([]string{"/proc/self/exe", lifecycle.SuperviseCommand, expectedJobName, "-p", expectedProcName}))
+							return nil
+						}),
+				)
+
+				setupMockDefaults()
+
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("passes a custom container id on to the supervisor", func() {
+				bpmCfg.SetContainerID("external-id")
+
+				fakeCommandRunner.
+					EXPECT().
+					Start(gomock.Any()).
+					DoAndReturn(func(cmd *exec.Cmd) error {
+						Expect(cmd.Args).To(Equal([]string{
+							"/proc/self/exe", lifecycle.SuperviseCommand, expectedJobName, "-p", expectedProcName,
+							"--container-id", "external-id",
+						}))
+						return nil
+					})
+
+				setupMockDefaults()
+
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("passes the number of restarts on to the supervisor", func() {
+				bpmCfg.SetRestarts(2)
+
+				fakeCommandRunner.
+					EXPECT().
+					Start(gomock.Any()).
+					DoAndReturn(func(cmd *exec.Cmd) error {
+						Expect(cmd.Args).To(Equal([]string{
+							"/proc/self/exe", lifecycle.SuperviseCommand, expectedJobName, "-p", expectedProcName,
+							"--restarts", "2",
+						}))
+						return nil
+					})
+
+				setupMockDefaults()
+
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			Context("when the supervisor cannot be started", func() {
+				It("leaves the process running", func() {
+					fakeCommandRunner.
+						EXPECT().
+						Start(gomock.Any()).
+						Return(errors.New("fake test error"))
+
+					setupMockDefaults()
+
+					err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(logger).To(gbytes.Say("failed-to-start-supervisor"))
+				})
+			})
+		})
+
+		Context("when the process has listen sockets", func() {
+			var socketPath string
+
+			BeforeEach(func() {
+				socketPath = filepath.Join(filepath.Dir(expectedStdout.Name()), fmt.Sprintf("lifecycle-%d.sock", GinkgoParallelNode()))
+				procCfg.ListenSockets = []config.ListenSocket{
+					{Network: "tcp", Address: "127.0.0.1:0"},
+					{Network: "unix", Address: socketPath},
+				}
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(socketPath)).To(Succeed())
+			})
+
+			It("binds them and passes them to the container in order", func() {
+				fakeRuncClient.
+					EXPECT().
+					RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), true, gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _, _, _, _ string, _ bool, _, _ io.Writer, extraFiles []*os.File) (int, error) {
+						Expect(extraFiles).To(HaveLen(2))
+
+						l, err := net.FileListener(extraFiles[0])
+						Expect(err).NotTo(HaveOccurred())
+						Expect(l.Addr().Network()).To(Equal("tcp"))
+						Expect(l.Close()).To(Succeed())
+
+						l, err = net.FileListener(extraFiles[1])
+						Expect(err).NotTo(HaveOccurred())
+						Expect(l.Addr().String()).To(Equal(socketPath))
+						Expect(l.Close()).To(Succeed())
+
+						return 0, nil
+					})
+
+				setupMockDefaults()
+
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(socketPath).To(BeAnExistingFile())
+			})
+
+			Context("when a socket cannot be bound", func() {
+				BeforeEach(func() {
+					procCfg.ListenSockets = []config.ListenSocket{{Network: "tcp", Address: "256.0.0.1:80"}}
+				})
+
+				It("returns an error without running the container", func() {
+					fakeRuncClient.
+						EXPECT().
+						RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+						Times(0)
+
+					setupMockDefaults()
+
+					err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+					Expect(err).To(MatchError(ContainSubstring("failed to bind listen sockets")))
+				})
+			})
+		})
+
+		Context("when the logs are written to named pipes", func() {
+			BeforeEach(func() {
+				procCfg.Logs = &config.Logs{Fifo: true}
+			})
+
+			It("runs the container with its output going through a log pump", func() {
+				fakeCommandRunner.
+					EXPECT().
+					Start(gomock.Any()).
+					DoAndReturn(func(cmd *exec.Cmd) error {
+						Expect(cmd.Args).To(Equal([]string{"/proc/self/exe", lifecycle.LogPumpCommand, "--fifo"}))
+						Expect(cmd.ExtraFiles[2]).To(Equal(expectedStdout))
+						Expect(cmd.ExtraFiles[3]).To(Equal(expectedStderr))
+						return nil
+					})
+
+				setupMockDefaults()
+
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when running the container fails", func() {
+			BeforeEach(func() {
+				fakeRuncClient.
+					EXPECT().
+					RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(1, errors.New("fake test error"))
+			})
+
+			It("returns an error", func() {
+				setupMockDefaults()
+
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when the container does not start within the startup timeout", func() {
+			var runcExited bool
+
+			BeforeEach(func() {
+				procCfg.StartupTimeout = "30s"
+				runcExited = false
+
+				fakeRuncClient.
+					EXPECT().
+					RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, _, _, _, _ string, _ bool, _, _ io.Writer, _ []*os.File) (int, error) {
+						go fakeClock.WaitForWatcherAndIncrement(30 * time.Second)
+						<-ctx.Done()
+						runcExited = true
+						return 1, ctx.Err()
+					})
+			})
+
+			It("kills runc and the container before removing the container and its bundle", func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						SignalContainer(expectedContainerID, client.Kill).
+						Return(nil),
+					fakeRuncClient.
+						EXPECT().
+						DeleteContainer(expectedContainerID).
+						Do(func(string) {
+							Expect(runcExited).To(BeTrue())
+						}),
+					fakeRuncClient.
+						EXPECT().
+						DestroyBundle(bpmCfg.BundlePath()),
+				)
+
+				setupMockDefaults()
+
+				err := runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+				Expect(err).To(MatchError("process did not start within 30s"))
+				Expect(runcExited).To(BeTrue())
+				Expect(fakeFileRemover.deletedFiles).To(ConsistOf(bpmCfg.PidFile().External()))
+			})
+		})
+
+		ItSetsUpAndRunsAProcess(func(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) error {
+			setupMockDefaults()
+
+			return runcLifecycle.StartProcess(logger, bpmCfg, procCfg)
+		})
+	})
+
+	Describe("RunProcess", func() {
+		It("builds the runc spec, bundle, and runs the container", func() {
+			fakeRuncAdapter.
+				EXPECT().
+				CreateJobPrerequisites(bpmCfg, procCfg, expectedUser).
+				Return(expectedStdout, expectedStderr, nil).
+				Times(1)
+
+			fakeRuncAdapter.
+				EXPECT().
+				BuildSpec(gomock.Any(), bpmCfg, procCfg, expectedUser).
+				Return(jobSpec, nil).
+				Times(1)
+
+			rootPath := filepath.Join(expectedSystemRoot, "data", "bpm", "bundles", expectedJobName, expectedProcName)
+			fakeRuncClient.
+				EXPECT().
+				CreateBundle(rootPath, jobSpec, expectedUser).
+				Times(1)
+
+			fakeRuncClient.
+				EXPECT().
+				RunContainer(
+					gomock.Any(),
+					bpmCfg.PidFile().External(),
+					rootPath,
+					expectedContainerID,
+					bpmCfg.RuncLog().External(),
+					false,
+					gomock.Any(), // We can't assert on these because the function wraps them in io.MultiWriters.
+					gomock.Any(),
+					nil,
+				).
+				Return(0, nil).
+				Times(1)
+
+			setupMockDefaults()
+
+			status, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(0))
+		})
+
+		Context("when running the container fails", func() {
+			BeforeEach(func() {
+				fakeRuncClient.
+					EXPECT().
+					RunContainer(
+						gomock.Any(),
+						gomock.Any(),
+						gomock.Any(),
+						gomock.Any(),
+						gomock.Any(),
+						gomock.Any(),
+						gomock.Any(),
+						gomock.Any(),
+						gomock.Any(),
+					).
+					Return(1, errors.New("fake test error"))
+			})
+
+			It("returns an error", func() {
+				setupMockDefaults()
+
+				status, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg)
+				Expect(err).To(HaveOccurred())
+				Expect(status).To(Equal(1))
+			})
+		})
+
+		Context("when the logs are filtered", func() {
+			var filterDir string
+
+			BeforeEach(func() {
+				var err error
+				filterDir, err = ioutil.TempDir("", "runc-lifecycle-filter")
+				Expect(err).NotTo(HaveOccurred())
+
+				filter := filepath.Join(filterDir, "filter")
+				Expect(ioutil.WriteFile(filter, []byte("#!/bin/bash\ntr a-z A-Z\n"), 0755)).To(Succeed())
+				procCfg.Logs = &config.Logs{Filter: filter}
+
+				fakeRuncClient.
+					EXPECT().
+					RunContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), false, gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _, _, _, _ string, _ bool, stdout, _ io.Writer, _ []*os.File) (int, error) {
+						_, err := stdout.Write([]byte("hello\n"))
+						return 0, err
+					})
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(filterDir)).To(Succeed())
+			})
+
+			It("writes the output of the filter to the log file", func() {
+				setupMockDefaults()
+
+				_, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(ioutil.ReadFile(expectedStdout.Name())).To(Equal([]byte("HELLO\n")))
+			})
+		})
+
+		ItSetsUpAndRunsAProcess(func(logger lager.Logger, bpmCfg *config.BPMConfig, procCfg *config.ProcessConfig) error {
+			setupMockDefaults()
+			// status is tested separately
+			_, err := runcLifecycle.RunProcess(logger, bpmCfg, procCfg)
+			return err
+		})
+	})
+
+	Describe("RunOneshotProcess", func() {
+		It("runs the container to completion and removes the bundle", func() {
+			rootPath := filepath.Join(expectedSystemRoot, "data", "bpm", "bundles", expectedJobName, expectedProcName)
+			gomock.InOrder(
+				fakeRuncClient.
+					EXPECT().
+					RunContainer(gomock.Any(), gomock.Any(), rootPath, expectedContainerID, gomock.Any(), false, gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(3, errors.New("exit status 3")),
+				fakeRuncClient.EXPECT().DestroyBundle(rootPath).Return(nil),
+			)
+
+			setupMockDefaults()
+
+			status, err := runcLifecycle.RunOneshotProcess(logger, bpmCfg, procCfg)
+			Expect(err).To(MatchError("exit status 3"))
+			Expect(status).To(Equal(3))
+		})
+	})
+
+	Describe("ProcessPids", func() {
+		It("lists the pids of the processes in the container", func() {
+			fakeRuncClient.EXPECT().ContainerPids(expectedContainerID).Return([]int{1234, 1240}, nil)
+
+			Expect(runcLifecycle.ProcessPids(bpmCfg)).To(Equal([]int{1234, 1240}))
+		})
+	})
+
+	Describe("ProcessStats", func() {
+		It("returns the stats runc reports for the container", func() {
+			stats := &client.ContainerStats{Memory: client.MemoryUsage{Usage: 1024}, CPUTotal: 5000, Pids: 2}
+			fakeRuncClient.EXPECT().ContainerStats(expectedContainerID).Return(stats, nil)
+
+			Expect(runcLifecycle.ProcessStats(expectedContainerID)).To(Equal(stats))
+		})
+	})
+
+	Describe("PauseProcess", func() {
+		It("pauses the container", func() {
+			fakeRuncClient.EXPECT().PauseContainer(expectedContainerID).Return(nil)
+
+			Expect(runcLifecycle.PauseProcess(logger, bpmCfg)).To(Succeed())
+		})
+
+		Context("when pausing the container fails", func() {
+			It("returns an error", func() {
+				fakeRuncClient.EXPECT().PauseContainer(expectedContainerID).Return(errors.New("no freezer"))
+
+				Expect(runcLifecycle.PauseProcess(logger, bpmCfg)).To(MatchError("no freezer"))
+			})
+		})
+	})
+
+	Describe("DrainProcess", func() {
+		var hooks *config.Hooks
+
+		BeforeEach(func() {
+			hooks = &config.Hooks{Drain: "/var/vcap/jobs/example/bin/drain"}
+		})
+
+		drainPrints := func(output string, command ...string) *gomock.Call {
+			return fakeRuncClient.
+				EXPECT().
+				ExecCommand(expectedContainerID, command, gomock.Any(), expectedStderr).
+				DoAndReturn(func(_ string, _ []string, stdout, _ io.Writer) error {
+					_, err := io.WriteString(stdout, output)
+					return err
+				})
+		}
+
+		It("runs the drain hook inside the container", func() {
+			drainPrints("", hooks.Drain)
+
+			Expect(runcLifecycle.DrainProcess(logger, bpmCfg, hooks, expectedStderr)).To(Succeed())
+		})
+
+		Context("when the drain hook asks to wait", func() {
+			It("waits for that many seconds", func() {
+				drainPrints("5\n", hooks.Drain)
+
+				done := make(chan error)
+				go func() {
+					done <- runcLifecycle.DrainProcess(logger, bpmCfg, hooks, expectedStderr)
+				}()
+
+				fakeClock.WaitForWatcherAndIncrement(4 * time.Second)
+				Consistently(done).ShouldNot(Receive())
+
+				fakeClock.Increment(time.Second)
+				Eventually(done).Should(Receive(BeNil()))
+			})
+		})
+
+		Context("when the drain hook asks to be checked on", func() {
+			It("runs the hook again with the check status argument until it has drained", func() {
+				gomock.InOrder(
+					drainPrints("-10", hooks.Drain),
+					drainPrints("-10", hooks.Drain, lifecycle.DrainCheckStatusArg).Do(func(string, []string, io.Writer, io.Writer) {
+						go fakeClock.WaitForWatcherAndIncrement(10 * time.Second)
+					}),
+					drainPrints("0", hooks.Drain, lifecycle.DrainCheckStatusArg),
+				)
+
+				go fakeClock.WaitForWatcherAndIncrement(10 * time.Second)
+				Expect(runcLifecycle.DrainProcess(logger, bpmCfg, hooks, expectedStderr)).To(Succeed())
+			})
+		})
+
+		Context("when the drain hook prints something other than a number", func() {
+			It("returns an error", func() {
+				drainPrints("done", hooks.Drain)
+
+				err := runcLifecycle.DrainProcess(logger, bpmCfg, hooks, expectedStderr)
+				Expect(err).To(MatchError(`drain hook printed "done", expected a number of seconds`))
+			})
+		})
+
+		Context("when the drain hook fails", func() {
+			It("returns an error", func() {
+				fakeRuncClient.
+					EXPECT().
+					ExecCommand(expectedContainerID, []string{hooks.Drain}, gomock.Any(), expectedStderr).
+					Return(errors.New("exit status 1"))
+
+				err := runcLifecycle.DrainProcess(logger, bpmCfg, hooks, expectedStderr)
+				Expect(err).To(MatchError("drain hook failed: exit status 1"))
+			})
+		})
+	})
+
+	Describe("ResumeProcess", func() {
+		It("resumes the container", func() {
+			fakeRuncClient.EXPECT().ResumeContainer(expectedContainerID).Return(nil)
+
+			Expect(runcLifecycle.ResumeProcess(logger, bpmCfg)).To(Succeed())
+		})
+	})
+
+	Describe("CheckpointProcess", func() {
+		It("checkpoints the container and then removes it", func() {
+			gomock.InOrder(
+				fakeRuncClient.
+					EXPECT().
+					CheckpointContainer(expectedContainerID, "/images/server").
+					Return(nil),
+				fakeRuncClient.
+					EXPECT().
+					DeleteContainer(expectedContainerID).
+					Return(nil),
+				fakeRuncClient.
+					EXPECT().
+					DestroyBundle(bpmCfg.BundlePath()).
+					Return(nil),
+			)
+
+			err := runcLifecycle.CheckpointProcess(logger, bpmCfg, procCfg, "/images/server")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeFileRemover.deletedFiles).To(ConsistOf(bpmCfg.PidFile().External()))
+		})
+
+		Context("when checkpointing the container fails", func() {
+			It("leaves the process alone and returns an error", func() {
+				fakeRuncClient.
+					EXPECT().
+					CheckpointContainer(expectedContainerID, "/images/server").
+					Return(errors.New("criu failed"))
+
+				fakeRuncClient.
+					EXPECT().
+					DeleteContainer(gomock.Any()).
+					Times(0)
+
+				err := runcLifecycle.CheckpointProcess(logger, bpmCfg, procCfg, "/images/server")
+				Expect(err).To(MatchError("failed to checkpoint process: criu failed"))
+				Expect(fakeFileRemover.deletedFiles).To(BeEmpty())
+			})
+		})
+
+		Context("when the logs of the process go through a log pump", func() {
+			BeforeEach(func() {
+				procCfg.Logs = &config.Logs{LineBuffered: true}
+			})
+
+			It("returns an error without checkpointing", func() {
+				fakeRuncClient.
+					EXPECT().
+					CheckpointContainer(gomock.Any(), gomock.Any()).
+					Times(0)
+
+				err := runcLifecycle.CheckpointProcess(logger, bpmCfg, procCfg, "/images/server")
+				Expect(err).To(MatchError(ContainSubstring("cannot be checkpointed")))
+			})
+		})
+	})
+
+	Describe("RestoreProcess", func() {
+		It("builds the bundle and restores the container from the checkpoint", func() {
+			gomock.InOrder(
+				fakeRuncClient.
+					EXPECT().
+					CreateBundle(bpmCfg.BundlePath(), jobSpec, expectedUser).
+					Return(nil),
+				fakeRuncClient.
+					EXPECT().
+					RestoreContainer(
+						bpmCfg.PidFile().External(),
+						bpmCfg.BundlePath(),
+						expectedContainerID,
+						"/images/server",
+						expectedStdout,
+						expectedStderr,
+					).
+					Return(nil),
+			)
+
+			setupMockDefaults()
+
+			err := runcLifecycle.RestoreProcess(logger, bpmCfg, procCfg, "/images/server")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when restoring the container fails", func() {
+			It("returns an error", func() {
+				fakeRuncClient.
+					EXPECT().
+					RestoreContainer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(errors.New("criu failed"))
+
+				setupMockDefaults()
+
+				err := runcLifecycle.RestoreProcess(logger, bpmCfg, procCfg, "/images/server")
+				Expect(err).To(MatchError("failed to restore process: criu failed"))
+			})
+		})
+	})
+
+	Describe("StopProcess", func() {
+		var exitTimeout time.Duration
+
+		BeforeEach(func() {
+			exitTimeout = 5 * time.Second
+		})
+
+		It("stops the container", func() {
+			fakeRuncClient.
+				EXPECT().
+				ContainerState(expectedContainerID).
+				Return(&specs.State{
+					Status: "stopped",
+				}, nil)
+
+			fakeRuncClient.
+				EXPECT().
+				SignalContainer(expectedContainerID, client.Term).
+				Times(1)
+
+			setupMockDefaults()
+			err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout, 0)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when the container does not stop immediately", func() {
+			It("polls the container state every second until it stops", func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						SignalContainer(expectedContainerID, client.Term).
+						Times(1),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						DoAndReturn(func(id string) (*specs.State, error) {
+							go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerStatePollInterval, 2)
+							return &specs.State{Status: "running"}, nil
+						}).
+						Times(1),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						DoAndReturn(func(id string) (*specs.State, error) {
+							go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerStatePollInterval, 2)
+							return &specs.State{Status: "running"}, nil
+						}).
+						Times(1),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						DoAndReturn(func(id string) (*specs.State, error) {
+							return &specs.State{Status: "stopped"}, nil
+						}).
+						Times(1),
+				)
+
+				setupMockDefaults()
+				err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout, 0)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			Context("and the exit timeout has passed", func() {
+				It("sends a SIGQUIT and returns a timeout error", func() {
+					gomock.InOrder(
+						fakeRuncClient.
+							EXPECT().
+							SignalContainer(expectedContainerID, client.Term).
+							Times(1),
+						fakeRuncClient.
+							EXPECT().
+							ContainerState(expectedContainerID).
+							DoAndReturn(func(id string) (*specs.State, error) {
+								go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerStatePollInterval, 2)
+								return &specs.State{Status: "running"}, nil
+							}).
+							Times(1),
+						fakeRuncClient.
+							EXPECT().
+							ContainerState(expectedContainerID).
+							DoAndReturn(func(id string) (*specs.State, error) {
+								go fakeClock.WaitForNWatchersAndIncrement(exitTimeout, 2)
+								return &specs.State{Status: "running"}, nil
+							}).
+							AnyTimes(),
+						fakeRuncClient.
+							EXPECT().
+							SignalContainer(expectedContainerID, client.Quit).
+							Do(func(id string, signal client.Signal) {
+								go fakeClock.WaitForWatcherAndIncrement(lifecycle.ContainerSigQuitGracePeriod)
+							}).
+							Times(1),
+					)
+
+					setupMockDefaults()
+					err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout, 0)
+					Expect(err).To(MatchError("failed to stop job within timeout"))
+					Expect(lifecycle.IsTimeout(err)).To(BeTrue())
+				})
+			})
+		})
+
+		Context("when fetching the container state fails", func() {
+			It("keeps attempting to fetch the state", func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						SignalContainer(expectedContainerID, client.Term).
+						Times(1),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						DoAndReturn(func(id string) (*specs.State, error) {
+							go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerStatePollInterval, 2)
+							return nil, errors.New("fake test error")
+						}).
+						Times(1),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						DoAndReturn(func(id string) (*specs.State, error) {
+							go fakeClock.WaitForNWatchersAndIncrement(exitTimeout, 2)
+							return nil, errors.New("fake test error")
+						}).
+						AnyTimes(),
+					fakeRuncClient.
+						EXPECT().
+						SignalContainer(expectedContainerID, client.Quit).
+						Do(func(id string, signal client.Signal) {
+							go fakeClock.WaitForWatcherAndIncrement(lifecycle.ContainerSigQuitGracePeriod)
+						}).
+						Times(1),
+				)
+
+				setupMockDefaults()
+				err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout, 0)
+				Expect(err).To(MatchError("failed to stop job within timeout"))
+			})
+		})
+
+		Context("when stopping a container fails", func() {
+			var expectedErr error
+
+			BeforeEach(func() {
+				expectedErr = errors.New("an error")
+				fakeRuncClient.
+					EXPECT().
+					SignalContainer(gomock.Any(), gomock.Any()).
+					Return(expectedErr)
+			})
+
+			It("returns an error", func() {
+				setupMockDefaults()
+				err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout, 0)
+				Expect(err).To(MatchError("failed to send SIGTERM after 1 attempt(s): an error"))
+			})
+		})
+
+		Context("when stopping a container fails transiently", func() {
+			It("retries sending the signal", func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						SignalContainer(expectedContainerID, client.Term).
+						DoAndReturn(func(id string, signal client.Signal) error {
+							go fakeClock.WaitForWatcherAndIncrement(lifecycle.ContainerStateRetryInterval)
+							return errors.New("container is transitioning")
+						}),
+					fakeRuncClient.
+						EXPECT().
+						SignalContainer(expectedContainerID, client.Term).
+						Return(nil),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						Return(&specs.State{Status: "stopped"}, nil),
+				)
+
+				setupMockDefaults()
+				err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout, 2)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			Context("when the failure persists", func() {
+				It("gives up after the retries and returns an error", func() {
+					gomock.InOrder(
+						fakeRuncClient.
+							EXPECT().
+							SignalContainer(expectedContainerID, client.Term).
+							DoAndReturn(func(id string, signal client.Signal) error {
+								go fakeClock.WaitForWatcherAndIncrement(lifecycle.ContainerStateRetryInterval)
+								return errors.New("container is transitioning")
+							}),
+						fakeRuncClient.
+							EXPECT().
+							SignalContainer(expectedContainerID, client.Term).
+							Return(errors.New("container is transitioning")),
+					)
+
+					setupMockDefaults()
+					err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout, 1)
+					Expect(err).To(MatchError("failed to send SIGTERM after 2 attempt(s): container is transitioning"))
+				})
+			})
+		})
+	})
+
+	Describe("KillProcess", func() {
+		It("sends SIGKILL to the container", func() {
+			fakeRuncClient.
+				EXPECT().
+				SignalContainer(expectedContainerID, client.Kill).
+				Times(1)
+
+			setupMockDefaults()
+			err := runcLifecycle.KillProcess(logger, bpmCfg, 0)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when signalling the container fails", func() {
+			It("returns an error", func() {
+				fakeRuncClient.
+					EXPECT().
+					SignalContainer(expectedContainerID, client.Kill).
+					Return(errors.New("fake test error"))
+
+				setupMockDefaults()
+				err := runcLifecycle.KillProcess(logger, bpmCfg, 0)
+				Expect(err).To(MatchError(ContainSubstring("failed to send SIGKILL")))
+			})
+		})
+	})
+
+	Describe("WatchHeartbeat", func() {
+		var (
+			heartbeatFile string
+			interval      time.Duration
+			polls         int
+		)
+
+		BeforeEach(func() {
+			heartbeatFile = filepath.Join(filepath.Dir(expectedStdout.Name()), fmt.Sprintf("heartbeat-%d", GinkgoParallelNode()))
+			interval = 3 * time.Second
+			polls = 0
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(heartbeatFile)).To(Succeed())
+		})
+
+		tick := func() {
+			go fakeClock.WaitForWatcherAndIncrement(lifecycle.HeartbeatPollInterval)
+		}
+
+		Context("when the heartbeat goes stale", func() {
+			It("kills the process once the interval has passed", func() {
+				var killed bool
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					DoAndReturn(func(string) (*specs.State, error) {
+						if killed {
+							return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "stopped"}, nil
+						}
+
+						polls++
+						tick()
+						return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "running"}, nil
+					}).
+					AnyTimes()
+
+				fakeRuncClient.
+					EXPECT().
+					SignalContainer(expectedContainerID, client.Kill).
+					DoAndReturn(func(string, client.Signal) error {
+						killed = true
+						return nil
+					})
+
+				setupMockDefaults()
+
+				tick()
+				stale, err := runcLifecycle.WatchHeartbeat(logger, bpmCfg, heartbeatFile, lifecycle.NewThresholds(0, interval), 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stale).To(BeTrue())
+				Expect(polls).To(Equal(4))
+			})
+		})
+
+		Context("when more than one failure is allowed", func() {
+			It("kills the process once the heartbeat has been missed that many times in a row", func() {
+				thresholds := lifecycle.NewThresholds(0, interval)
+				thresholds.SetHeartbeatFailures(2)
+
+				var killed bool
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					DoAndReturn(func(string) (*specs.State, error) {
+						if killed {
+							return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "stopped"}, nil
+						}
+
+						polls++
+						tick()
+						return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "running"}, nil
+					}).
+					AnyTimes()
+
+				fakeRuncClient.
+					EXPECT().
+					SignalContainer(expectedContainerID, client.Kill).
+					DoAndReturn(func(string, client.Signal) error {
+						killed = true
+						return nil
+					})
+
+				setupMockDefaults()
+
+				tick()
+				stale, err := runcLifecycle.WatchHeartbeat(logger, bpmCfg, heartbeatFile, thresholds, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stale).To(BeTrue())
+				Expect(polls).To(Equal(7))
+				Expect(logger).To(gbytes.Say("heartbeat-missed"))
+				Expect(logger).To(gbytes.Say("heartbeat-stale"))
+			})
+		})
+
+		Context("when the interval is shortened while watching", func() {
+			It("kills the process once the new interval has passed", func() {
+				thresholds := lifecycle.NewThresholds(0, time.Hour)
+
+				var killed bool
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					DoAndReturn(func(string) (*specs.State, error) {
+						if killed {
+							return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "stopped"}, nil
+						}
+
+						polls++
+						if polls == 2 {
+							thresholds.Set(0, interval)
+						}
+						tick()
+						return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "running"}, nil
+					}).
+					AnyTimes()
+
+				fakeRuncClient.
+					EXPECT().
+					SignalContainer(expectedContainerID, client.Kill).
+					DoAndReturn(func(string, client.Signal) error {
+						killed = true
+						return nil
+					})
+
+				setupMockDefaults()
+
+				tick()
+				stale, err := runcLifecycle.WatchHeartbeat(logger, bpmCfg, heartbeatFile, thresholds, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stale).To(BeTrue())
+				Expect(polls).To(Equal(4))
+			})
+		})
+
+		Context("when the heartbeat is kept up to date", func() {
+			It("watches until the process exits", func() {
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					DoAndReturn(func(string) (*specs.State, error) {
+						polls++
+						if polls > 10 {
+							return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "stopped"}, nil
+						}
+
+						now := fakeClock.Now()
+						Expect(ioutil.WriteFile(heartbeatFile, nil, 0600)).To(Succeed())
+						Expect(os.Chtimes(heartbeatFile, now, now)).To(Succeed())
+
+						tick()
+						return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "running"}, nil
+					}).
+					AnyTimes()
+
+				setupMockDefaults()
+
+				tick()
+				stale, err := runcLifecycle.WatchHeartbeat(logger, bpmCfg, heartbeatFile, lifecycle.NewThresholds(0, interval), 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stale).To(BeFalse())
+			})
+		})
+
+		Context("when the process is paused", func() {
+			It("does not let the heartbeat go stale", func() {
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					DoAndReturn(func(string) (*specs.State, error) {
+						polls++
+						if polls > 10 {
+							return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "stopped"}, nil
+						}
+
+						tick()
+						return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "paused"}, nil
+					}).
+					AnyTimes()
+
+				fakeRuncClient.
+					EXPECT().
+					SignalContainer(gomock.Any(), gomock.Any()).
+					Times(0)
+
+				setupMockDefaults()
+
+				tick()
+				stale, err := runcLifecycle.WatchHeartbeat(logger, bpmCfg, heartbeatFile, lifecycle.NewThresholds(0, interval), 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stale).To(BeFalse())
+				Expect(polls).To(Equal(11))
+			})
+		})
+
+		Context("when the process is restarted by someone else", func() {
+			It("stops watching", func() {
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					DoAndReturn(func(string) (*specs.State, error) {
+						polls++
+						tick()
+						return &specs.State{ID: expectedContainerID, Pid: 1000 + polls, Status: "running"}, nil
+					}).
+					AnyTimes()
+
+				setupMockDefaults()
+
+				tick()
+				stale, err := runcLifecycle.WatchHeartbeat(logger, bpmCfg, heartbeatFile, lifecycle.NewThresholds(0, interval), 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stale).To(BeFalse())
+				Expect(polls).To(Equal(2))
+			})
+		})
+	})
+
+	Describe("WatchMemory", func() {
+		var (
+			polls int
+			usage []uint64
+		)
+
+		BeforeEach(func() {
+			polls = 0
+			usage = []uint64{50, 85, 90, 40}
+		})
+
+		tick := func() {
+			go fakeClock.WaitForWatcherAndIncrement(lifecycle.MemoryPollInterval)
+		}
+
+		It("warns once when the usage crosses the threshold and again once it drops", func() {
+			fakeRuncClient.
+				EXPECT().
+				ContainerState(expectedContainerID).
+				DoAndReturn(func(string) (*specs.State, error) {
+					polls++
+					if polls > len(usage) {
+						return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "stopped"}, nil
+					}
+
+					return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "running"}, nil
+				}).
+				AnyTimes()
+
+			fakeRuncClient.
+				EXPECT().
+				ContainerMemory(expectedContainerID).
+				DoAndReturn(func(string) (*client.MemoryUsage, error) {
+					tick()
+					return &client.MemoryUsage{Usage: usage[polls-1], Limit: 100}, nil
+				}).
+				Times(len(usage))
+
+			setupMockDefaults()
+
+			tick()
+			runcLifecycle.WatchMemory(logger, bpmCfg, lifecycle.NewThresholds(80, 0), nil)
+			Expect(logger.LogMessages()).To(Equal([]string{"lifecycle.memory-high", "lifecycle.memory-normal"}))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("usage", BeNumerically("==", 85)))
+		})
+
+		It("does not warn while the usage is below the threshold", func() {
+			usage = []uint64{10, 79}
+
+			fakeRuncClient.
+				EXPECT().
+				ContainerState(expectedContainerID).
+				DoAndReturn(func(string) (*specs.State, error) {
+					polls++
+					if polls > len(usage) {
+						return nil, nil
+					}
+
+					return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "running"}, nil
+				}).
+				AnyTimes()
+
+			fakeRuncClient.
+				EXPECT().
+				ContainerMemory(expectedContainerID).
+				DoAndReturn(func(string) (*client.MemoryUsage, error) {
+					tick()
+					return &client.MemoryUsage{Usage: usage[polls-1], Limit: 100}, nil
+				}).
+				AnyTimes()
+
+			setupMockDefaults()
+
+			tick()
+			runcLifecycle.WatchMemory(logger, bpmCfg, lifecycle.NewThresholds(80, 0), nil)
+			Expect(logger.LogMessages()).To(BeEmpty())
+		})
+
+		It("stops watching once done is closed", func() {
+			done := make(chan struct{})
+			close(done)
+
+			runcLifecycle.WatchMemory(logger, bpmCfg, lifecycle.NewThresholds(80, 0), done)
+		})
+	})
+
+	Describe("StopProcessWithSignals", func() {
+		var (
+			signals     []lifecycle.StopSignal
+			sent        []client.Signal
+			stopsOnStep client.Signal
+		)
+
+		BeforeEach(func() {
+			signals = []lifecycle.StopSignal{
+				{Signal: client.Term, Wait: 3 * time.Second},
+				{Signal: client.Int, Wait: 5 * time.Second},
+			}
+			sent = nil
+			stopsOnStep = client.Int
+
+			fakeRuncClient.
+				EXPECT().
+				SignalContainer(expectedContainerID, gomock.Any()).
+				DoAndReturn(func(id string, signal client.Signal) error {
+					sent = append(sent, signal)
+					return nil
+				}).
+				AnyTimes()
+
+			fakeRuncClient.
+				EXPECT().
+				ContainerState(expectedContainerID).
+				DoAndReturn(func(id string) (*specs.State, error) {
+					last := sent[len(sent)-1]
+					if last == stopsOnStep {
+						return &specs.State{Status: "stopped"}, nil
+					}
+
+					for _, s := range signals {
+						if s.Signal == last {
+							go fakeClock.WaitForNWatchersAndIncrement(s.Wait, 2)
+						}
+					}
+					return &specs.State{Status: "running"}, nil
+				}).
+				AnyTimes()
+		})
+
+		It("escalates through the signals until the process exits", func() {
+			setupMockDefaults()
+			err := runcLifecycle.StopProcessWithSignals(logger, bpmCfg, signals, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sent).To(Equal([]client.Signal{client.Term, client.Int}))
+		})
 
+		Context("when the process exits after the first signal", func() {
+			BeforeEach(func() {
+				stopsOnStep = client.Term
+			})
+
+			It("does not send any further signals", func() {
 				setupMockDefaults()
-				err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout)
-				Expect(err).ToNot(HaveOccurred())
+				err := runcLifecycle.StopProcessWithSignals(logger, bpmCfg, signals, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sent).To(Equal([]client.Signal{client.Term}))
 			})
+		})
 
-			Context("and the exit timeout has passed", func() {
-				It("sends a SIGQUIT and returns a timeout error", func() {
-					gomock.InOrder(
-						fakeRuncClient.
-							EXPECT().
-							SignalContainer(expectedContainerID, client.Term).
-							Times(1),
-						fakeRuncClient.
-							EXPECT().
-							ContainerState(expectedContainerID).
-							DoAndReturn(func(id string) (*specs.State, error) {
-								go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerStatePollInterval, 2)
-								return &specs.State{Status: "running"}, nil
-							}).
-							Times(1),
-						fakeRuncClient.
-							EXPECT().
-							ContainerState(expectedContainerID).
-							DoAndReturn(func(id string) (*specs.State, error) {
-								go fakeClock.WaitForNWatchersAndIncrement(exitTimeout, 2)
-								return &specs.State{Status: "running"}, nil
-							}).
-							AnyTimes(),
-						fakeRuncClient.
-							EXPECT().
-							SignalContainer(expectedContainerID, client.Quit).
-							Do(func(id string, signal client.Signal) {
-								go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerSigQuitGracePeriod, 2)
-							}).
-							Times(1),
-					)
+		Context("when the process never exits", func() {
+			BeforeEach(func() {
+				stopsOnStep = client.Kill
+			})
 
-					setupMockDefaults()
-					err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout)
-					Expect(err).To(MatchError("failed to stop job within timeout"))
-				})
+			It("returns a timeout error after the last signal", func() {
+				setupMockDefaults()
+				err := runcLifecycle.StopProcessWithSignals(logger, bpmCfg, signals, 0)
+				Expect(lifecycle.IsTimeout(err)).To(BeTrue())
+				Expect(sent).To(Equal([]client.Signal{client.Term, client.Int}))
 			})
 		})
+	})
 
-		Context("when fetching the container state fails", func() {
-			It("keeps attempting to fetch the state", func() {
+	Describe("StopFrozenProcess", func() {
+		var signals []lifecycle.StopSignal
+
+		BeforeEach(func() {
+			signals = []lifecycle.StopSignal{{Signal: client.Term, Wait: 3 * time.Second}}
+		})
+
+		It("signals every process while the container is frozen", func() {
+			gomock.InOrder(
+				fakeRuncClient.EXPECT().PauseContainer(expectedContainerID).Return(nil),
+				fakeRuncClient.EXPECT().ContainerPids(expectedContainerID).Return([]int{1234, 1240}, nil),
+				fakeRuncClient.EXPECT().SignalProcesses([]int{1234, 1240}, client.Term).Return(nil),
+				fakeRuncClient.EXPECT().ResumeContainer(expectedContainerID).Return(nil),
+				fakeRuncClient.EXPECT().ContainerState(expectedContainerID).Return(&specs.State{Status: "stopped"}, nil),
+			)
+
+			setupMockDefaults()
+			err := runcLifecycle.StopFrozenProcess(logger, bpmCfg, signals, 0)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when the processes cannot be listed", func() {
+			It("thaws the container and returns an error", func() {
 				gomock.InOrder(
-					fakeRuncClient.
-						EXPECT().
-						SignalContainer(expectedContainerID, client.Term).
-						Times(1),
-					fakeRuncClient.
-						EXPECT().
-						ContainerState(expectedContainerID).
-						DoAndReturn(func(id string) (*specs.State, error) {
-							go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerStatePollInterval, 2)
-							return nil, errors.New("fake test error")
-						}).
-						Times(1),
-					fakeRuncClient.
-						EXPECT().
-						ContainerState(expectedContainerID).
-						DoAndReturn(func(id string) (*specs.State, error) {
-							go fakeClock.WaitForNWatchersAndIncrement(exitTimeout, 2)
-							return nil, errors.New("fake test error")
-						}).
-						AnyTimes(),
-					fakeRuncClient.
-						EXPECT().
-						SignalContainer(expectedContainerID, client.Quit).
-						Do(func(id string, signal client.Signal) {
-							go fakeClock.WaitForNWatchersAndIncrement(lifecycle.ContainerSigQuitGracePeriod, 2)
-						}).
-						Times(1),
+					fakeRuncClient.EXPECT().PauseContainer(expectedContainerID).Return(nil),
+					fakeRuncClient.EXPECT().ContainerPids(expectedContainerID).Return(nil, errors.New("boom")),
+					fakeRuncClient.EXPECT().ResumeContainer(expectedContainerID).Return(nil),
 				)
 
 				setupMockDefaults()
-				err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout)
-				Expect(err).To(MatchError("failed to stop job within timeout"))
+				err := runcLifecycle.StopFrozenProcess(logger, bpmCfg, signals, 0)
+				Expect(err).To(MatchError("failed to send SIGTERM after 1 attempt(s): failed to list container processes: boom"))
 			})
 		})
 
-		Context("when stopping a container fails", func() {
-			var expectedErr error
-
-			BeforeEach(func() {
-				expectedErr = errors.New("an error")
-				fakeRuncClient.
-					EXPECT().
-					SignalContainer(gomock.Any(), gomock.Any()).
-					Return(expectedErr)
-			})
+		Context("when the container cannot be frozen", func() {
+			It("does not signal the processes", func() {
+				fakeRuncClient.EXPECT().PauseContainer(expectedContainerID).Return(errors.New("no freezer"))
 
-			It("returns an error", func() {
 				setupMockDefaults()
-				err := runcLifecycle.StopProcess(logger, bpmCfg, exitTimeout)
-				Expect(err).To(Equal(expectedErr))
+				err := runcLifecycle.StopFrozenProcess(logger, bpmCfg, signals, 0)
+				Expect(err).To(MatchError("failed to send SIGTERM after 1 attempt(s): failed to freeze container: no freezer"))
 			})
 		})
 	})
 
+	Describe("RemoveContainer", func() {
+		BeforeEach(func() {
+			bpmCfg = config.NewBPMConfig(boshEnv, "", "")
+			bpmCfg.SetContainerID("custom")
+		})
+
+		It("only deletes the container", func() {
+			fakeRuncClient.
+				EXPECT().
+				DeleteContainer("custom").
+				Times(1)
+
+			err := runcLifecycle.RemoveContainer(logger, bpmCfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeFileRemover.deletedFiles).To(BeEmpty())
+		})
+	})
+
 	Describe("RemoveProcess", func() {
 		It("deletes the container", func() {
 			fakeRuncClient.
@@ -683,6 +2122,7 @@ var _ = Describe("RuncJobLifecycle", func() {
 
 	Describe("ListProcesses", func() {
 		It("returns a list of bpm jobs", func() {
+			created := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
 			containerStates := []client.ContainerState{
 				{
 					ID:             "job-process-2",
@@ -693,6 +2133,7 @@ var _ = Describe("RuncJobLifecycle", func() {
 					ID:             "job-process-1",
 					InitProcessPid: 34567,
 					Status:         "running",
+					Created:        created,
 				},
 				{
 					ID:             "job-process-3",
@@ -711,11 +2152,28 @@ var _ = Describe("RuncJobLifecycle", func() {
 
 			Expect(bpmJobs).To(ConsistOf([]*models.Process{
 				{Name: "job-process-2", Pid: 23456, Status: "created"},
-				{Name: "job-process-1", Pid: 34567, Status: "running"},
+				{Name: "job-process-1", Pid: 34567, Status: "running", Created: created},
 				{Name: "job-process-3", Pid: 0, Status: "failed"},
 			}))
 		})
 
+		It("includes the annotations of the containers", func() {
+			annotations := map[string]string{"org.cloudfoundry.bosh.deployment": "cf"}
+			fakeRuncClient.
+				EXPECT().
+				ListContainers().
+				Return([]client.ContainerState{
+					{ID: "job-process-1", InitProcessPid: 34567, Status: "running", Annotations: annotations},
+				}, nil)
+
+			setupMockDefaults()
+			bpmJobs, err := runcLifecycle.ListProcesses()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(bpmJobs).To(HaveLen(1))
+			Expect(bpmJobs[0].Annotations).To(Equal(annotations))
+		})
+
 		Context("when listing jobs fails", func() {
 			It("returns an error", func() {
 				expectedErr := errors.New("list jobs error")
@@ -768,6 +2226,23 @@ var _ = Describe("RuncJobLifecycle", func() {
 					Status: "failed",
 				}))
 			})
+
+			Context("when the process was killed for running out of memory", func() {
+				BeforeEach(func() {
+					fakeRuncClient.
+						EXPECT().
+						ContainerOOMKilled(expectedContainerID).
+						Return(true, nil)
+				})
+
+				It("marks the process as oom killed", func() {
+					setupMockDefaults()
+					process, err := runcLifecycle.StatProcess(bpmCfg)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(process.OOMKilled).To(BeTrue())
+					Expect(process.DisplayStatus()).To(Equal("oom-killed"))
+				})
+			})
 		})
 
 		Context("when the process name is the same as the job name", func() {
@@ -826,6 +2301,190 @@ var _ = Describe("RuncJobLifecycle", func() {
 		})
 	})
 
+	Describe("StatProcessWithRetries", func() {
+		Context("when fetching the container state fails transiently", func() {
+			BeforeEach(func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						DoAndReturn(func(id string) (*specs.State, error) {
+							go fakeClock.WaitForWatcherAndIncrement(lifecycle.ContainerStateRetryInterval)
+							return nil, errors.New("fake test error")
+						}),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						Return(&specs.State{ID: expectedContainerID, Pid: 1234, Status: "running"}, nil),
+				)
+			})
+
+			It("retries and returns the process", func() {
+				setupMockDefaults()
+				process, err := runcLifecycle.StatProcessWithRetries(bpmCfg, 2)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(process).To(Equal(&models.Process{
+					Name:   expectedContainerID,
+					Pid:    1234,
+					Status: "running",
+				}))
+			})
+		})
+
+		Context("when fetching the container state keeps failing", func() {
+			expectedErr := errors.New("fake test error")
+
+			BeforeEach(func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						DoAndReturn(func(id string) (*specs.State, error) {
+							go fakeClock.WaitForWatcherAndIncrement(lifecycle.ContainerStateRetryInterval)
+							return nil, expectedErr
+						}),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						DoAndReturn(func(id string) (*specs.State, error) {
+							go fakeClock.WaitForWatcherAndIncrement(2 * lifecycle.ContainerStateRetryInterval)
+							return nil, expectedErr
+						}),
+					fakeRuncClient.
+						EXPECT().
+						ContainerState(expectedContainerID).
+						Return(nil, expectedErr),
+				)
+			})
+
+			It("gives up after the retries and returns the error", func() {
+				setupMockDefaults()
+				_, err := runcLifecycle.StatProcessWithRetries(bpmCfg, 2)
+				Expect(err).To(Equal(expectedErr))
+			})
+		})
+
+		Context("when the container does not exist", func() {
+			BeforeEach(func() {
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					Return(nil, nil).
+					Times(1)
+			})
+
+			It("does not retry", func() {
+				setupMockDefaults()
+				_, err := runcLifecycle.StatProcessWithRetries(bpmCfg, 2)
+				Expect(lifecycle.IsNotExist(err)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("ListProcessesWithRetries", func() {
+		Context("when listing the containers fails transiently", func() {
+			BeforeEach(func() {
+				gomock.InOrder(
+					fakeRuncClient.
+						EXPECT().
+						ListContainers().
+						DoAndReturn(func() ([]client.ContainerState, error) {
+							go fakeClock.WaitForWatcherAndIncrement(lifecycle.ContainerStateRetryInterval)
+							return nil, errors.New("fake test error")
+						}),
+					fakeRuncClient.
+						EXPECT().
+						ListContainers().
+						Return([]client.ContainerState{
+							{ID: "job-process", InitProcessPid: 1234, Status: "running"},
+						}, nil),
+				)
+			})
+
+			It("retries and returns the processes", func() {
+				setupMockDefaults()
+				processes, err := runcLifecycle.ListProcessesWithRetries(1)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(processes).To(ConsistOf([]*models.Process{
+					{Name: "job-process", Pid: 1234, Status: "running"},
+				}))
+			})
+		})
+
+		Context("when there are no retries", func() {
+			expectedErr := errors.New("fake test error")
+
+			BeforeEach(func() {
+				fakeRuncClient.
+					EXPECT().
+					ListContainers().
+					Return(nil, expectedErr).
+					Times(1)
+			})
+
+			It("returns the first error", func() {
+				setupMockDefaults()
+				_, err := runcLifecycle.ListProcessesWithRetries(0)
+				Expect(err).To(Equal(expectedErr))
+			})
+		})
+	})
+
+	Describe("FollowEvents", func() {
+		var (
+			events *gbytes.Buffer
+			done   chan struct{}
+		)
+
+		BeforeEach(func() {
+			events = gbytes.NewBuffer()
+			done = make(chan struct{})
+
+			gomock.InOrder(
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					Return(&specs.State{ID: expectedContainerID, Pid: 1234, Status: "running"}, nil),
+				fakeRuncClient.
+					EXPECT().
+					Events(expectedContainerID, events, gomock.Any()).
+					DoAndReturn(func(id string, stdout io.Writer, _ <-chan struct{}) error {
+						fmt.Fprintln(stdout, `{"type":"stats"}`)
+						return errors.New("container is not running")
+					}),
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					DoAndReturn(func(id string) (*specs.State, error) {
+						go fakeClock.WaitForWatcherAndIncrement(lifecycle.ContainerStatePollInterval)
+						return &specs.State{ID: expectedContainerID, Pid: 1234, Status: "stopped"}, nil
+					}),
+				fakeRuncClient.
+					EXPECT().
+					ContainerState(expectedContainerID).
+					Return(&specs.State{ID: expectedContainerID, Pid: 5678, Status: "running"}, nil),
+				fakeRuncClient.
+					EXPECT().
+					Events(expectedContainerID, events, gomock.Any()).
+					DoAndReturn(func(id string, stdout io.Writer, _ <-chan struct{}) error {
+						fmt.Fprintln(stdout, `{"type":"oom"}`)
+						close(done)
+						return nil
+					}),
+			)
+		})
+
+		It("reattaches when the container comes back and marks the restart", func() {
+			setupMockDefaults()
+			err := runcLifecycle.FollowEvents(logger, bpmCfg, events, done)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(events).To(gbytes.Say(`{"type":"stats"}`))
+			Expect(events).To(gbytes.Say(fmt.Sprintf(`{"type":"restart","id":"%s"}`, expectedContainerID)))
+			Expect(events).To(gbytes.Say(`{"type":"oom"}`))
+		})
+	})
+
 	Describe("OpenShell", func() {
 		var expectedStdin *gbytes.Buffer
 
@@ -836,14 +2495,57 @@ var _ = Describe("RuncJobLifecycle", func() {
 		It("execs /bin/bash inside the container", func() {
 			fakeRuncClient.
 				EXPECT().
-				Exec(expectedContainerID, "/bin/bash", expectedStdin, expectedStdout, expectedStderr).
+				Exec(expectedContainerID, []string{"/bin/bash"}, []string{"TERM=xterm"}, expectedStdin, expectedStdout, expectedStderr).
 				Times(1)
 
 			setupMockDefaults()
-			err := runcLifecycle.OpenShell(bpmCfg, expectedStdin, expectedStdout, expectedStderr)
+			err := runcLifecycle.OpenShell(bpmCfg, procCfg, "xterm", expectedStdin, expectedStdout, expectedStderr)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		Context("when the process has sensitive environment variables", func() {
+			BeforeEach(func() {
+				procCfg.SensitiveEnv = []string{"SECRET", "TOKEN"}
+			})
+
+			It("leaves them out of the environment of the shell", func() {
+				rootPath := filepath.Join(expectedSystemRoot, "data", "bpm", "bundles", expectedJobName, expectedProcName)
+				fakeRuncClient.
+					EXPECT().
+					BundleSpec(rootPath).
+					Return(specs.Spec{Process: &specs.Process{
+						Args: []string{"/bin/server"},
+						Env:  []string{"SECRET=hunter2", "PORT=8080", "TOKEN=abc"},
+						Cwd:  "/var/vcap/jobs/example",
+					}}, nil)
+
+				fakeRuncClient.
+					EXPECT().
+					ExecProcess(
+						expectedContainerID,
+						specs.Process{
+							Args: []string{"/bin/bash"},
+							Env:  []string{"PORT=8080", "TERM=xterm"},
+							Cwd:  "/var/vcap/jobs/example",
+						},
+						expectedStdin, expectedStdout, expectedStderr,
+					).
+					Times(1)
+
+				setupMockDefaults()
+				err := runcLifecycle.OpenShell(bpmCfg, procCfg, "xterm", expectedStdin, expectedStdout, expectedStderr)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error when the bundle cannot be read", func() {
+				fakeRuncClient.EXPECT().BundleSpec(gomock.Any()).Return(specs.Spec{}, errors.New("fake test error"))
+
+				setupMockDefaults()
+				err := runcLifecycle.OpenShell(bpmCfg, procCfg, "xterm", expectedStdin, expectedStdout, expectedStderr)
+				Expect(err).To(MatchError("fake test error"))
+			})
+		})
+
 		Context("when the process name is the same as the job name", func() {
 			BeforeEach(func() {
 				bpmCfg = config.NewBPMConfig(boshEnv, expectedJobName, expectedJobName)
@@ -852,10 +2554,10 @@ var _ = Describe("RuncJobLifecycle", func() {
 			It("simplifies the container id", func() {
 				fakeRuncClient.
 					EXPECT().
-					Exec(jobid.Encode(expectedJobName), "/bin/bash", expectedStdin, expectedStdout, expectedStderr).
+					Exec(jobid.Encode(expectedJobName), []string{"/bin/bash"}, []string{"TERM=xterm"}, expectedStdin, expectedStdout, expectedStderr).
 					Times(1)
 				setupMockDefaults()
-				err := runcLifecycle.OpenShell(bpmCfg, expectedStdin, expectedStdout, expectedStderr)
+				err := runcLifecycle.OpenShell(bpmCfg, procCfg, "xterm", expectedStdin, expectedStdout, expectedStderr)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
@@ -864,13 +2566,13 @@ var _ = Describe("RuncJobLifecycle", func() {
 			BeforeEach(func() {
 				fakeRuncClient.
 					EXPECT().
-					Exec(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Exec(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 					Return(errors.New("fake test error"))
 			})
 
 			It("returns an error", func() {
 				setupMockDefaults()
-				err := runcLifecycle.OpenShell(bpmCfg, expectedStdin, expectedStdout, expectedStderr)
+				err := runcLifecycle.OpenShell(bpmCfg, procCfg, "xterm", expectedStdin, expectedStdout, expectedStderr)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -23,6 +23,7 @@ package mock_lifecycle
 import (
 	config "bpm/config"
 	client "bpm/runc/client"
+	context "context"
 	io "io"
 	os "os"
 	exec "os/exec"
@@ -108,6 +109,20 @@ func (mr *MockCommandRunnerMockRecorder) Run(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockCommandRunner)(nil).Run), arg0)
 }
 
+// Start mocks base method
+func (m *MockCommandRunner) Start(arg0 *exec.Cmd) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Start", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Start indicates an expected call of Start
+func (mr *MockCommandRunnerMockRecorder) Start(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockCommandRunner)(nil).Start), arg0)
+}
+
 // MockRuncAdapter is a mock of RuncAdapter interface
 type MockRuncAdapter struct {
 	ctrl     *gomock.Controller
@@ -185,6 +200,80 @@ func (m *MockRuncClient) EXPECT() *MockRuncClientMockRecorder {
 	return m.recorder
 }
 
+// BundleSpec mocks base method
+func (m *MockRuncClient) BundleSpec(arg0 string) (specs.Spec, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BundleSpec", arg0)
+	ret0, _ := ret[0].(specs.Spec)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BundleSpec indicates an expected call of BundleSpec
+func (mr *MockRuncClientMockRecorder) BundleSpec(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BundleSpec", reflect.TypeOf((*MockRuncClient)(nil).BundleSpec), arg0)
+}
+
+// CheckpointContainer mocks base method
+func (m *MockRuncClient) CheckpointContainer(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckpointContainer", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckpointContainer indicates an expected call of CheckpointContainer
+func (mr *MockRuncClientMockRecorder) CheckpointContainer(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckpointContainer", reflect.TypeOf((*MockRuncClient)(nil).CheckpointContainer), arg0, arg1)
+}
+
+// ContainerMemory mocks base method
+func (m *MockRuncClient) ContainerMemory(arg0 string) (*client.MemoryUsage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ContainerMemory", arg0)
+	ret0, _ := ret[0].(*client.MemoryUsage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerMemory indicates an expected call of ContainerMemory
+func (mr *MockRuncClientMockRecorder) ContainerMemory(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerMemory", reflect.TypeOf((*MockRuncClient)(nil).ContainerMemory), arg0)
+}
+
+// ContainerOOMKilled mocks base method
+func (m *MockRuncClient) ContainerOOMKilled(arg0 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ContainerOOMKilled", arg0)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerOOMKilled indicates an expected call of ContainerOOMKilled
+func (mr *MockRuncClientMockRecorder) ContainerOOMKilled(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerOOMKilled", reflect.TypeOf((*MockRuncClient)(nil).ContainerOOMKilled), arg0)
+}
+
+// ContainerPids mocks base method
+func (m *MockRuncClient) ContainerPids(arg0 string) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ContainerPids", arg0)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerPids indicates an expected call of ContainerPids
+func (mr *MockRuncClientMockRecorder) ContainerPids(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerPids", reflect.TypeOf((*MockRuncClient)(nil).ContainerPids), arg0)
+}
+
 // ContainerState mocks base method
 func (m *MockRuncClient) ContainerState(arg0 string) (*specs.State, error) {
 	m.ctrl.T.Helper()
@@ -200,6 +289,21 @@ func (mr *MockRuncClientMockRecorder) ContainerState(arg0 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerState", reflect.TypeOf((*MockRuncClient)(nil).ContainerState), arg0)
 }
 
+// ContainerStats mocks base method
+func (m *MockRuncClient) ContainerStats(arg0 string) (*client.ContainerStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ContainerStats", arg0)
+	ret0, _ := ret[0].(*client.ContainerStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerStats indicates an expected call of ContainerStats
+func (mr *MockRuncClientMockRecorder) ContainerStats(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerStats", reflect.TypeOf((*MockRuncClient)(nil).ContainerStats), arg0)
+}
+
 // CreateBundle mocks base method
 func (m *MockRuncClient) CreateBundle(arg0 string, arg1 specs.Spec, arg2 specs.User) error {
 	m.ctrl.T.Helper()
@@ -242,18 +346,60 @@ func (mr *MockRuncClientMockRecorder) DestroyBundle(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DestroyBundle", reflect.TypeOf((*MockRuncClient)(nil).DestroyBundle), arg0)
 }
 
+// Events mocks base method
+func (m *MockRuncClient) Events(arg0 string, arg1 io.Writer, arg2 <-chan struct{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Events", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Events indicates an expected call of Events
+func (mr *MockRuncClientMockRecorder) Events(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Events", reflect.TypeOf((*MockRuncClient)(nil).Events), arg0, arg1, arg2)
+}
+
 // Exec mocks base method
-func (m *MockRuncClient) Exec(arg0, arg1 string, arg2 io.Reader, arg3, arg4 io.Writer) error {
+func (m *MockRuncClient) Exec(arg0 string, arg1, arg2 []string, arg3 io.Reader, arg4, arg5 io.Writer) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Exec", arg0, arg1, arg2, arg3, arg4)
+	ret := m.ctrl.Call(m, "Exec", arg0, arg1, arg2, arg3, arg4, arg5)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Exec indicates an expected call of Exec
-func (mr *MockRuncClientMockRecorder) Exec(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+func (mr *MockRuncClientMockRecorder) Exec(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockRuncClient)(nil).Exec), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// ExecCommand mocks base method
+func (m *MockRuncClient) ExecCommand(arg0 string, arg1 []string, arg2, arg3 io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExecCommand", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExecCommand indicates an expected call of ExecCommand
+func (mr *MockRuncClientMockRecorder) ExecCommand(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecCommand", reflect.TypeOf((*MockRuncClient)(nil).ExecCommand), arg0, arg1, arg2, arg3)
+}
+
+// ExecProcess mocks base method
+func (m *MockRuncClient) ExecProcess(arg0 string, arg1 specs.Process, arg2 io.Reader, arg3, arg4 io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExecProcess", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExecProcess indicates an expected call of ExecProcess
+func (mr *MockRuncClientMockRecorder) ExecProcess(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockRuncClient)(nil).Exec), arg0, arg1, arg2, arg3, arg4)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecProcess", reflect.TypeOf((*MockRuncClient)(nil).ExecProcess), arg0, arg1, arg2, arg3, arg4)
 }
 
 // ListContainers mocks base method
@@ -271,19 +417,61 @@ func (mr *MockRuncClientMockRecorder) ListContainers() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListContainers", reflect.TypeOf((*MockRuncClient)(nil).ListContainers))
 }
 
+// PauseContainer mocks base method
+func (m *MockRuncClient) PauseContainer(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PauseContainer", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PauseContainer indicates an expected call of PauseContainer
+func (mr *MockRuncClientMockRecorder) PauseContainer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PauseContainer", reflect.TypeOf((*MockRuncClient)(nil).PauseContainer), arg0)
+}
+
+// RestoreContainer mocks base method
+func (m *MockRuncClient) RestoreContainer(arg0, arg1, arg2, arg3 string, arg4, arg5 io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreContainer", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreContainer indicates an expected call of RestoreContainer
+func (mr *MockRuncClientMockRecorder) RestoreContainer(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreContainer", reflect.TypeOf((*MockRuncClient)(nil).RestoreContainer), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// ResumeContainer mocks base method
+func (m *MockRuncClient) ResumeContainer(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResumeContainer", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResumeContainer indicates an expected call of ResumeContainer
+func (mr *MockRuncClientMockRecorder) ResumeContainer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResumeContainer", reflect.TypeOf((*MockRuncClient)(nil).ResumeContainer), arg0)
+}
+
 // RunContainer mocks base method
-func (m *MockRuncClient) RunContainer(arg0, arg1, arg2 string, arg3 bool, arg4, arg5 io.Writer) (int, error) {
+func (m *MockRuncClient) RunContainer(arg0 context.Context, arg1, arg2, arg3, arg4 string, arg5 bool, arg6, arg7 io.Writer, arg8 []*os.File) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RunContainer", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret := m.ctrl.Call(m, "RunContainer", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // RunContainer indicates an expected call of RunContainer
-func (mr *MockRuncClientMockRecorder) RunContainer(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+func (mr *MockRuncClientMockRecorder) RunContainer(arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunContainer", reflect.TypeOf((*MockRuncClient)(nil).RunContainer), arg0, arg1, arg2, arg3, arg4, arg5)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunContainer", reflect.TypeOf((*MockRuncClient)(nil).RunContainer), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
 }
 
 // SignalContainer mocks base method
@@ -299,3 +487,17 @@ func (mr *MockRuncClientMockRecorder) SignalContainer(arg0, arg1 interface{}) *g
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignalContainer", reflect.TypeOf((*MockRuncClient)(nil).SignalContainer), arg0, arg1)
 }
+
+// SignalProcesses mocks base method
+func (m *MockRuncClient) SignalProcesses(arg0 []int, arg1 client.Signal) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SignalProcesses", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SignalProcesses indicates an expected call of SignalProcesses
+func (mr *MockRuncClientMockRecorder) SignalProcesses(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignalProcesses", reflect.TypeOf((*MockRuncClient)(nil).SignalProcesses), arg0, arg1)
+}
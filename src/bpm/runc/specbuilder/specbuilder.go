@@ -16,6 +16,10 @@
 package specbuilder
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 
 	"bpm/sysfeat"
@@ -119,6 +123,26 @@ func WithNamespace(namespace specs.LinuxNamespaceType) SpecOption {
 	}
 }
 
+func WithHostname(hostname string) SpecOption {
+	return func(spec *specs.Spec) {
+		spec.Hostname = hostname
+	}
+}
+
+// WithAnnotations adds annotations to the container, replacing any which are
+// already set with the same key.
+func WithAnnotations(annotations map[string]string) SpecOption {
+	return func(spec *specs.Spec) {
+		if spec.Annotations == nil {
+			spec.Annotations = map[string]string{}
+		}
+
+		for key, value := range annotations {
+			spec.Annotations[key] = value
+		}
+	}
+}
+
 func WithUser(user specs.User) SpecOption {
 	return func(spec *specs.Spec) {
 		spec.Process.User = user
@@ -155,6 +179,26 @@ func WithMounts(mounts []specs.Mount) SpecOption {
 	}
 }
 
+// WithShmSize replaces the size of the /dev/shm tmpfs mount.
+func WithShmSize(size uint64) SpecOption {
+	return func(spec *specs.Spec) {
+		for i, mount := range spec.Mounts {
+			if mount.Destination != "/dev/shm" {
+				continue
+			}
+
+			var opts []string
+			for _, opt := range mount.Options {
+				if !strings.HasPrefix(opt, "size=") {
+					opts = append(opts, opt)
+				}
+			}
+
+			spec.Mounts[i].Options = append(opts, fmt.Sprintf("size=%d", size))
+		}
+	}
+}
+
 func WithMemoryLimit(limit int64, features sysfeat.Features) SpecOption {
 	return func(spec *specs.Spec) {
 		spec.Linux.Resources.Memory = &specs.LinuxMemory{
@@ -167,6 +211,31 @@ func WithMemoryLimit(limit int64, features sysfeat.Features) SpecOption {
 	}
 }
 
+// WithMemorySwapLimit sets the combined memory and swap limit of the
+// container. It must be applied after WithMemoryLimit as it overrides the
+// swap limit derived from the memory limit.
+func WithMemorySwapLimit(limit int64) SpecOption {
+	return func(spec *specs.Spec) {
+		if spec.Linux.Resources.Memory == nil {
+			spec.Linux.Resources.Memory = &specs.LinuxMemory{}
+		}
+
+		spec.Linux.Resources.Memory.Swap = &limit
+	}
+}
+
+// WithKernelMemoryLimit sets the limit on the kernel memory, such as socket
+// buffers, the container may use on top of its other memory.
+func WithKernelMemoryLimit(limit int64) SpecOption {
+	return func(spec *specs.Spec) {
+		if spec.Linux.Resources.Memory == nil {
+			spec.Linux.Resources.Memory = &specs.LinuxMemory{}
+		}
+
+		spec.Linux.Resources.Memory.Kernel = &limit
+	}
+}
+
 func WithPidLimit(limit int64) SpecOption {
 	return func(spec *specs.Spec) {
 		spec.Linux.Resources.Pids = &specs.LinuxPids{
@@ -175,6 +244,60 @@ func WithPidLimit(limit int64) SpecOption {
 	}
 }
 
+// WithCpuset pins the container to the CPUs and memory nodes in the lists.
+// An empty list leaves the corresponding cpuset unchanged.
+func WithCpuset(cpus, mems string) SpecOption {
+	return func(spec *specs.Spec) {
+		cpu := linuxCPU(spec)
+		cpu.Cpus = cpus
+		cpu.Mems = mems
+	}
+}
+
+// WithCPUQuota limits the process to the time of cpus CPUs in each CFS
+// period. Fractions of a CPU are allowed.
+func WithCPUQuota(cpus float64) SpecOption {
+	return func(spec *specs.Spec) {
+		period := uint64(cfsPeriod)
+		quota := int64(cpus * cfsPeriod)
+
+		cpu := linuxCPU(spec)
+		cpu.Period = &period
+		cpu.Quota = &quota
+	}
+}
+
+// cfsPeriod is the length of a CFS period in microseconds. It is the kernel
+// default.
+const cfsPeriod = 100000
+
+func linuxCPU(spec *specs.Spec) *specs.LinuxCPU {
+	if spec.Linux.Resources.CPU == nil {
+		spec.Linux.Resources.CPU = &specs.LinuxCPU{}
+	}
+
+	return spec.Linux.Resources.CPU
+}
+
+// WithNetClassID places the container in the net_cls class classID so that
+// its traffic can be shaped on the host.
+func WithNetClassID(classID uint32) SpecOption {
+	return func(spec *specs.Spec) {
+		spec.Linux.Resources.Network = &specs.LinuxNetwork{
+			ClassID: &classID,
+		}
+	}
+}
+
+// WithSELinuxLabel runs the process with the SELinux label and gives the
+// mounts of the container the same label.
+func WithSELinuxLabel(label string) SpecOption {
+	return func(spec *specs.Spec) {
+		spec.Process.SelinuxLabel = label
+		spec.Linux.MountLabel = label
+	}
+}
+
 func WithOpenFileLimit(limit uint64) SpecOption {
 	return func(spec *specs.Spec) {
 		spec.Process.Rlimits = append(spec.Process.Rlimits, specs.POSIXRlimit{
@@ -185,11 +308,96 @@ func WithOpenFileLimit(limit uint64) SpecOption {
 	}
 }
 
+// WithFileSizeLimit stops the process from growing a file beyond limit bytes.
+// A write past the limit fails with EFBIG and raises SIGXFSZ.
+func WithFileSizeLimit(limit uint64) SpecOption {
+	return func(spec *specs.Spec) {
+		spec.Process.Rlimits = append(spec.Process.Rlimits, specs.POSIXRlimit{
+			Type: "RLIMIT_FSIZE",
+			Hard: limit,
+			Soft: limit,
+		})
+	}
+}
+
+// WithCoreDumpLimit sets the largest core dump the process may produce.
+func WithCoreDumpLimit(limit uint64) SpecOption {
+	return func(spec *specs.Spec) {
+		spec.Process.Rlimits = append(spec.Process.Rlimits, specs.POSIXRlimit{
+			Type: "RLIMIT_CORE",
+			Hard: limit,
+			Soft: limit,
+		})
+	}
+}
+
+// The character device through which the kernel talks to the daemons of
+// FUSE filesystems.
+const (
+	fuseDevicePath  = "/dev/fuse"
+	fuseDeviceMajor = 10
+	fuseDeviceMinor = 229
+)
+
+// WithFuse lets the process mount FUSE filesystems inside its mount
+// namespace. The capability needed to mount them is granted separately.
+func WithFuse() SpecOption {
+	return func(spec *specs.Spec) {
+		var (
+			mode  os.FileMode = 0666
+			root  uint32
+			major int64 = fuseDeviceMajor
+			minor int64 = fuseDeviceMinor
+		)
+
+		spec.Linux.Devices = append(spec.Linux.Devices, specs.LinuxDevice{
+			Path:     fuseDevicePath,
+			Type:     "c",
+			Major:    major,
+			Minor:    minor,
+			FileMode: &mode,
+			UID:      &root,
+			GID:      &root,
+		})
+		spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, specs.LinuxDeviceCgroup{
+			Allow:  true,
+			Type:   "c",
+			Major:  &major,
+			Minor:  &minor,
+			Access: "rwm",
+		})
+
+		if spec.Linux.Seccomp != nil {
+			spec.Linux.Seccomp.Syscalls = append(spec.Linux.Seccomp.Syscalls,
+				AllowSyscall("mount"),
+				AllowSyscall("umount"),
+				AllowSyscall("umount2"),
+			)
+		}
+	}
+}
+
 var RootUser = specs.User{
 	UID: 0,
 	GID: 0,
 }
 
+// WithUserNamespace runs the process as root in a user namespace of its own
+// whose ids from zero upwards are those of the host from hostUID and hostGID
+// upwards.
+func WithUserNamespace(hostUID, hostGID, size uint32) SpecOption {
+	return func(spec *specs.Spec) {
+		Apply(spec, WithNamespace("user"), WithUser(RootUser))
+
+		spec.Linux.UIDMappings = []specs.LinuxIDMapping{
+			{ContainerID: 0, HostID: hostUID, Size: size},
+		}
+		spec.Linux.GIDMappings = []specs.LinuxIDMapping{
+			{ContainerID: 0, HostID: hostGID, Size: size},
+		}
+	}
+}
+
 func WithPrivileged() SpecOption {
 	return func(spec *specs.Spec) {
 		Apply(spec, WithCapabilities(DefaultPrivilegedCapabilities()))
@@ -18,20 +18,40 @@
 package sysfeat
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/cgroups"
 )
 
 const (
-	swapPath = "memory.memsw.limit_in_bytes"
+	swapPath        = "memory.memsw.limit_in_bytes"
+	kernelMemPath   = "memory.kmem.limit_in_bytes"
+	corePatternPath = "/proc/sys/kernel/core_pattern"
+	selinuxEnforce  = "/sys/fs/selinux/enforce"
 )
 
 // Features contains information about what features the host system supports.
 type Features struct {
 	// Whether the system supports limiting the swap space of a process or not.
 	SwapLimitSupported bool
+
+	// Whether the system supports limiting the kernel memory of a process
+	// separately from its memory or not.
+	KernelMemoryLimitSupported bool
+
+	// Whether the net_cls cgroup, which is used to limit the network
+	// bandwidth of a process, is available or not.
+	NetClsSupported bool
+
+	// The kernel core_pattern which decides where core dumps are written.
+	CorePattern string
+
+	// Whether SELinux is enabled, in which case processes can be given an
+	// SELinux label.
+	SELinuxEnabled bool
 }
 
 func Fetch() (*Features, error) {
@@ -41,7 +61,11 @@ func Fetch() (*Features, error) {
 	}
 
 	return &Features{
-		SwapLimitSupported: swapLimitSupported(mountpoint),
+		SwapLimitSupported:         swapLimitSupported(mountpoint),
+		KernelMemoryLimitSupported: kernelMemoryLimitSupported(mountpoint),
+		NetClsSupported:            netClsSupported(),
+		CorePattern:                corePattern(),
+		SELinuxEnabled:             selinuxEnabled(),
 	}, nil
 }
 
@@ -49,3 +73,27 @@ func swapLimitSupported(mount string) bool {
 	_, err := os.Stat(filepath.Join(mount, swapPath))
 	return err == nil
 }
+
+func kernelMemoryLimitSupported(mount string) bool {
+	_, err := os.Stat(filepath.Join(mount, kernelMemPath))
+	return err == nil
+}
+
+func netClsSupported() bool {
+	_, err := cgroups.FindCgroupMountpoint("", "net_cls")
+	return err == nil
+}
+
+func corePattern() string {
+	data, err := ioutil.ReadFile(corePatternPath)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func selinuxEnabled() bool {
+	_, err := os.Stat(selinuxEnforce)
+	return err == nil
+}